@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// runCommand runs a management command instead of starting the server.
+// Supported commands:
+//   - force-password-change --users alice,bob: make the given users change their password at next login
+//   - force-password-change --since 2024-10-31: the same for all users that have not changed their password since the date
+//   - set-role <username> <user|admin>: change the role of a user
+//   - migrate up|down|status|to <version>: apply, roll back or list the schema migrations
+//
+// The commands work on the database db, and are logged with ctx.
+func runCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	db = db.WithContext(ctx)
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(db, args[1:])
+	case "force-password-change":
+		return forcePasswordChangeCommand(db, args[1:])
+	case "set-role":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: set-role <username> <user|admin>")
+		}
+		if err := services.SetUserRole(db, services.CommandAuditActor, args[1], args[2]); err != nil {
+			return err
+		}
+		utils.LogInfoContext(db.Statement.Context, "Role updated", logrus.Fields{"message": args[2]})
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// migrateCommand runs the migrate subcommands
+func migrateCommand(db *gorm.DB, args []string) error {
+	usage := fmt.Errorf("usage: migrate up|down|status|to <version>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	var steps int
+	var err error
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		steps, err = database.MigrateUp(db)
+	case args[0] == "down" && len(args) == 1:
+		steps, err = database.MigrateDown(db)
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		steps, err = database.MigrateTo(db, version)
+	case args[0] == "status" && len(args) == 1:
+		return printMigrationStatus(db)
+	default:
+		return usage
+	}
+	if err != nil {
+		return err
+	}
+
+	utils.LogInfoContext(db.Statement.Context, "Migrations completed", logrus.Fields{"message": fmt.Sprintf("%d migrations run", steps)})
+	return printMigrationStatus(db)
+}
+
+// printMigrationStatus prints every migration and when it was applied
+func printMigrationStatus(db *gorm.DB) error {
+	states, err := database.MigrationStatus(db)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		applied := "pending"
+		if state.AppliedAt != nil {
+			applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-30s  %s\n", state.Version, state.Name, applied)
+	}
+	return nil
+}
+
+// forcePasswordChangeCommand parses the flags of the force-password-change command and runs it
+func forcePasswordChangeCommand(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("force-password-change", flag.ContinueOnError)
+	users := flags.String("users", "", "comma separated usernames")
+	since := flags.String("since", "", "flag all users that have not changed their password since this date (YYYY-MM-DD or RFC 3339)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if (*users == "") == (*since == "") {
+		return fmt.Errorf("exactly one of --users and --since is required")
+	}
+
+	var usernames []string
+	var notChangedSince *time.Time
+	if *users != "" {
+		for _, username := range strings.Split(*users, ",") {
+			if username = strings.TrimSpace(username); username != "" {
+				usernames = append(usernames, username)
+			}
+		}
+	} else {
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, *since)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid --since date %q", *since)
+		}
+		notChangedSince = &t
+	}
+
+	flagged, err := services.ForcePasswordChange(db, services.CommandAuditActor, usernames, notChangedSince)
+	if err != nil {
+		return err
+	}
+
+	utils.LogInfoContext(db.Statement.Context, "Password change forced", logrus.Fields{"message": fmt.Sprintf("%d users flagged", flagged)})
+	return nil
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+)
+
+type ForcePasswordChangeRequest struct {
+	// Usernames lists the users to flag. Either Usernames or NotChangedSince must be set.
+	Usernames []string `json:"usernames" validate:"required_without=NotChangedSince,excluded_with=NotChangedSince,dive,required"`
+	// NotChangedSince flags every user that has not changed their password since this time
+	NotChangedSince *time.Time `json:"not_changed_since" validate:"required_without=Usernames" example:"2024-10-31T00:00:00Z"`
+}
+
+type ForcePasswordChangeResponse struct {
+	Status  string `json:"status"`
+	Flagged int64  `json:"flagged"`
+}
+
+type AuditEventResponse struct {
+	ID        uint      `json:"id"`
+	Type      string    `json:"type" example:"login.failure"`
+	ActorID   *uint     `json:"actor_id"`
+	Actor     string    `json:"actor"`
+	TargetID  *uint     `json:"target_id"`
+	Target    string    `json:"target"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditLogResponse struct {
+	Events []AuditEventResponse `json:"events"`
+	Total  int64                `json:"total"`
+	Limit  int                  `json:"limit"`
+	Offset int                  `json:"offset"`
+}
+
+// Audit log page sizes
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// ForcePasswordChangeHandler forces a set of users to change their password
+//
+//	@Summary Force users to change their password
+//	@Description Flags the given users, or all users that have not changed their password since a given time, so they must change their password at their next login. Their current sessions are revoked. Requires the admin role, or an API key with the admin scope.
+//	@Tags Admin
+//	@Security Bearer
+//	@Security ApiKey
+//	@Accept json
+//	@Produce json
+//	@Param forcePasswordChangeRequest body handlers.ForcePasswordChangeRequest true "Users to flag"
+//	@Success 200 {object} handlers.ForcePasswordChangeResponse "Number of users flagged"
+//	@Failure 400 {object} map[string]string "Invalid input data"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 403 {object} map[string]string "Forbidden"
+//	@Failure 500 {object} map[string]string "Failed to force password change"
+//	@Router /api/admin/force-password-change [post]
+func (h *Handler) ForcePasswordChangeHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing force password change request", nil)
+
+	var request ForcePasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	admin, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	flagged, err := services.ForcePasswordChange(h.db(r), h.auditActor(r, admin), request.Usernames, request.NotChangedSince)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to force password change", nil)
+		utils.WriteJSONError(w, "Failed to force password change", http.StatusInternalServerError)
+		return
+	}
+
+	utils.LogInfoContext(r.Context(), "Password change forced", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"flagged": flagged,
+	}, http.StatusOK)
+}
+
+// AuditLogHandler lists security audit events
+//
+//	@Summary List audit events
+//	@Description Lists security audit events, newest first: logins, logouts, password changes and resets, token revocations, role changes and account deletions. Requires the admin role, or an API key with the admin scope.
+//	@Tags Admin
+//	@Security Bearer
+//	@Security ApiKey
+//	@Produce json
+//	@Param type query string false "Event type, e.g. login.failure"
+//	@Param user query string false "Username of the actor or target"
+//	@Param actor_id query int false "User ID of the actor"
+//	@Param target_id query int false "User ID of the target"
+//	@Param ip query string false "Client IP"
+//	@Param since query string false "Only events at or after this time (RFC 3339)"
+//	@Param until query string false "Only events before this time (RFC 3339)"
+//	@Param limit query int false "Page size, default 50, at most 500"
+//	@Param offset query int false "Number of events to skip"
+//	@Success 200 {object} handlers.AuditLogResponse "Audit events"
+//	@Failure 400 {object} map[string]string "Invalid filter"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 403 {object} map[string]string "Forbidden"
+//	@Failure 500 {object} map[string]string "Failed to list audit events"
+//	@Router /api/admin/audit [get]
+func (h *Handler) AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing audit log request", nil)
+
+	filter, err := h.parseAuditFilter(r)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "Invalid audit log filter", nil)
+		utils.WriteJSONError(w, "Invalid filter", http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := services.ListAuditEvents(h.db(r), filter)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to list audit events", nil)
+		utils.WriteJSONError(w, "Failed to list audit events", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]AuditEventResponse, len(events))
+	for i, event := range events {
+		response[i] = AuditEventResponse{
+			ID:        event.ID,
+			Type:      event.Type,
+			ActorID:   event.ActorID,
+			Actor:     event.ActorName,
+			TargetID:  event.TargetID,
+			Target:    event.TargetName,
+			IP:        event.IP,
+			UserAgent: event.UserAgent,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"events": response,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}, http.StatusOK)
+}
+
+// parseAuditFilter reads the audit log filter and page from the query string
+func (h *Handler) parseAuditFilter(r *http.Request) (services.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		Type:     utils.SanitizeValue(query.Get("type")),
+		Username: utils.SanitizeValue(query.Get("user")),
+		IP:       utils.SanitizeValue(query.Get("ip")),
+		Limit:    h.Config.Pagination.Limit,
+	}
+	if filter.Limit <= 0 || filter.Limit > maxAuditPageSize {
+		filter.Limit = defaultAuditPageSize
+	}
+
+	for name, target := range map[string]**uint{"actor_id": &filter.ActorID, "target_id": &filter.TargetID} {
+		if value := query.Get(name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return filter, err
+			}
+			userID := uint(id)
+			*target = &userID
+		}
+	}
+
+	for name, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, err
+			}
+			*target = &t
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, errors.New("invalid offset")
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=search bookmarks admin"`
+	// RateLimit is the number of requests per minute, at most the configured default, which is also used when it is left out
+	RateLimit int `json:"rate_limit" validate:"omitempty,min=1"`
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix" example:"wk_1a2b3c4d"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	// Key is the API key itself. It is only shown once.
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyHandler creates an API key for the logged in user
+//
+//	@Summary Create an API key
+//	@Description Creates a named API key with the given scopes, to be sent in the X-API-Key header instead of a JWT. The key is only returned once. Only admins can create keys with the admin scope.
+//	@Tags API keys
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param createAPIKeyRequest body handlers.CreateAPIKeyRequest true "Name, scopes and rate limit"
+//	@Success 201 {object} handlers.CreateAPIKeyResponse "Created key"
+//	@Failure 400 {object} map[string]string "Invalid input data"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 403 {object} map[string]string "Admin scope requires the admin role"
+//	@Failure 409 {object} map[string]string "Too many API keys"
+//	@Failure 500 {object} map[string]string "Failed to create API key"
+//	@Router /api/me/api-keys [post]
+func (h *Handler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing create API key request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	maxRateLimit := h.Config.APIKeys.RateLimit
+	if request.RateLimit == 0 {
+		request.RateLimit = maxRateLimit
+	}
+	if request.RateLimit > maxRateLimit {
+		utils.WriteJSONError(w, "Rate limit can be at most "+strconv.Itoa(maxRateLimit)+" requests per minute", http.StatusBadRequest)
+		return
+	}
+
+	scopes := []string{}
+	for _, scope := range request.Scopes {
+		if scope == models.APIKeyScopeAdmin && user.Role != models.RoleAdmin {
+			utils.LogWarnContext(r.Context(), "Non-admin requested an admin API key", nil)
+			utils.WriteJSONError(w, "Only admins can create keys with the admin scope", http.StatusForbidden)
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	key, plainKey, err := services.CreateAPIKey(h.db(r), user, request.Name, scopes, request.RateLimit, h.Config.APIKeys.MaxPerUser)
+	if errors.Is(err, services.ErrTooManyAPIKeys) {
+		utils.WriteJSONError(w, "Too many API keys, revoke one first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to create API key", nil)
+		utils.WriteJSONError(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, user, models.AuditAPIKeyCreate, user, key.Prefix+" ("+key.Scopes+")")
+
+	utils.LogInfoContext(r.Context(), "API key created", nil)
+	response := apiKeyResponse(key)
+	response["key"] = plainKey
+	utils.JSONSuccess(w, response, http.StatusCreated)
+}
+
+// ListAPIKeysHandler lists the API keys of the logged in user
+//
+//	@Summary List your API keys
+//	@Description Lists the API keys of the logged in user, including revoked ones. The keys themselves are never shown again.
+//	@Tags API keys
+//	@Security Bearer
+//	@Produce json
+//	@Success 200 {array} handlers.APIKeyResponse "API keys"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 500 {object} map[string]string "Failed to list API keys"
+//	@Router /api/me/api-keys [get]
+func (h *Handler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing list API keys request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := services.ListAPIKeys(h.db(r), user.ID)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to list API keys", nil)
+		utils.WriteJSONError(w, "Failed to list API keys", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]interface{}, len(keys))
+	for i := range keys {
+		response[i] = apiKeyResponse(&keys[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeAPIKeyHandler revokes an API key of the logged in user
+//
+//	@Summary Revoke an API key
+//	@Tags API keys
+//	@Security Bearer
+//	@Produce json
+//	@Param id path int true "API key ID"
+//	@Success 200 {object} map[string]string "API key revoked"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 404 {object} map[string]string "API key not found"
+//	@Failure 500 {object} map[string]string "Failed to revoke API key"
+//	@Router /api/me/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing revoke API key request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		utils.WriteJSONError(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	key, err := services.RevokeAPIKey(h.db(r), user.ID, uint(keyID))
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		utils.WriteJSONError(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to revoke API key", nil)
+		utils.WriteJSONError(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, user, models.AuditAPIKeyRevoke, user, key.Prefix)
+
+	utils.LogInfoContext(r.Context(), "API key revoked", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "API key revoked",
+	}, http.StatusOK)
+}
+
+// apiKeyResponse describes an API key without its hash
+func apiKeyResponse(key *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           key.ID,
+		"name":         key.Name,
+		"prefix":       key.Prefix,
+		"scopes":       strings.Split(key.Scopes, ","),
+		"rate_limit":   key.RateLimit,
+		"last_used_at": key.LastUsedAt,
+		"revoked_at":   key.RevokedAt,
+		"created_at":   key.CreatedAt,
+	}
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
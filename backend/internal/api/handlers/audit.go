@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+)
+
+// auditActor describes who made a request for the audit log. The actor is nil for anonymous requests.
+func (h *Handler) auditActor(r *http.Request, actor *models.User) services.AuditActor {
+	auditActor := services.AuditActor{
+		IP:        utils.ClientIP(r, h.Config.Server.TrustProxyHeaders),
+		UserAgent: r.UserAgent(),
+	}
+	if actor != nil {
+		actorID := actor.ID
+		auditActor.UserID = &actorID
+		auditActor.Name = actor.Username
+	}
+	return auditActor
+}
+
+// audit records an audit event for a request. A failure is logged, but does not fail the request,
+// as the audited action has already happened.
+func (h *Handler) audit(r *http.Request, actor *models.User, eventType string, target *models.User, details string) {
+	_ = services.RecordAuditEvent(h.db(r), h.auditActor(r, actor), eventType, target, details)
+}
+
+// tokenOwner returns the user a stored session token of a request was issued to, or nil if it is unknown
+func (h *Handler) tokenOwner(r *http.Request, token string) *models.User {
+	var session models.JWT
+	if err := h.db(r).Select("user_id").Where("token = ?", token).First(&session).Error; err != nil {
+		return nil
+	}
+
+	user, err := h.Users.FindByID(r.Context(), session.UserID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// attemptKeys returns the brute-force protection keys for a request: the client IP
+// and, when known, the targeted username
+func (h *Handler) attemptKeys(r *http.Request, username string) []string {
+	keys := []string{bruteforce.IPKey(utils.ClientIP(r, h.Config.Server.TrustProxyHeaders))}
+	if username != "" {
+		keys = append(keys, bruteforce.UserKey(username))
+	}
+	return keys
+}
+
+// rejectIfLockedOut writes a 429 response with a Retry-After header when one of the keys
+// is locked out. If the attempt store is unavailable the request is let through.
+//
+// Returns:
+//   - bool: True if the request was rejected and the handler should return.
+func (h *Handler) rejectIfLockedOut(w http.ResponseWriter, r *http.Request, endpoint string, keys []string) bool {
+	retryAfter, blockedKey, err := h.Guard.Check(keys...)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to check login attempts", nil)
+		return false
+	}
+	if retryAfter <= 0 {
+		return false
+	}
+
+	utils.IncrementAuthBlockedAttempts(endpoint, bruteforce.Scope(blockedKey))
+	utils.LogWarnContext(r.Context(), "Attempt blocked by brute-force protection", logrus.Fields{
+		"message": endpoint,
+	})
+	utils.WriteTooManyRequests(w, "Too many failed attempts, try again later", retryAfter)
+	return true
+}
+
+// registerFailedAttempt counts a failed attempt against the keys
+func (h *Handler) registerFailedAttempt(r *http.Request, keys []string) {
+	if err := h.Guard.RegisterFailure(keys...); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to register failed attempt", nil)
+	}
+}
+
+// registerSuccessfulAttempt resets the failed attempts of the keys
+func (h *Handler) registerSuccessfulAttempt(r *http.Request, keys ...string) {
+	if err := h.Guard.RegisterSuccess(keys...); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to reset failed attempts", nil)
+	}
+}
@@ -3,12 +3,11 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"time"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
 	"github.com/CEM-KEA/whoknows/backend/internal/services"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type ChangePasswordRequest struct {
@@ -21,21 +20,23 @@ type ChangePasswordRequest struct {
 // ChangePasswordRequest represents the change password request payload
 //
 //	@Summary Change user password
-//	@Description Endpoint to change the password of a user
+//	@Description Endpoint to change the password of a user. All sessions of the user are revoked afterwards.
 //	@Tags Authentication
 //	@Accept json
 //	@Produce json
 //	@Param changePasswordRequest body handlers.ChangePasswordRequest true "Change password payload"
 //	@Success 200 {object} map[string]string "Password changed successfully"
 //	@Failure 400 {object} map[string]string "Validation error"
+//	@Failure 400 {object} handlers.PasswordPolicyErrorResponse "Password does not meet the password policy"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
 //	@Failure 500 {object} map[string]string "Failed to change password"
 //	@Router /api/change-password [post]
-func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing change password request", nil)
+func (h *Handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing change password request", nil)
 
 	var request ChangePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		utils.LogError(err, "Failed to decode request body", nil)
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
 		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
@@ -43,35 +44,116 @@ func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SanitizeStruct(&request)
 
 	if err := utils.Validate(request); err != nil {
-		utils.LogError(err, "Request validation failed", nil)
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
 		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
 		return
 	}
 
-	_, valid, err := services.CheckUserPassword(database.DB, request.Password, request.Username)
+	keys := h.attemptKeys(r, request.Username)
+	if h.rejectIfLockedOut(w, r, "/api/change-password", keys) {
+		return
+	}
+
+	user, valid, err := services.CheckUserPassword(h.db(r), request.Password, request.Username)
 	if err != nil || !valid {
-		utils.LogWarn("Invalid user credentials", nil)
+		h.registerFailedAttempt(r, keys)
+		utils.LogWarnContext(r.Context(), "Invalid user credentials", nil)
 		utils.WriteJSONError(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
-	if err != nil {
-		utils.LogError(err, "Password hashing failed", nil)
+	if h.rejectIfPasswordViolatesPolicy(w, r, user, request.NewPassword) {
+		return
+	}
+
+	if err := services.SetUserPassword(h.db(r), h.PasswordPolicy, user, request.NewPassword); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to change password", nil)
 		utils.WriteJSONError(w, "Failed to change password", http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, user, models.AuditPasswordChange, user, "")
+
+	if err := security.RevokeAllUserJWTs(r.Context(), h.Tokens, user.ID); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to revoke sessions after password change", nil)
+	} else {
+		h.audit(r, user, models.AuditTokenRevoke, user, "all sessions, password changed")
+	}
+
+	utils.LogInfoContext(r.Context(), "Password changed successfully", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Password changed successfully",
+	}, http.StatusOK)
+}
+
+type ChangeOwnPasswordRequest struct {
+	// Password is the current password, required if the account has one
+	Password          string `json:"old_password"`
+	NewPassword       string `json:"new_password" validate:"required"`
+	RepeatNewPassword string `json:"repeat_new_password" validate:"required,eqfield=NewPassword"`
+}
+
+// ChangeOwnPasswordHandler changes the password of the logged in user
+//
+//	@Summary Change the password of the logged in user
+//	@Description Changes the password of the logged in user. Accounts without a password can set one if they logged in within the last 5 minutes. Also accepts the limited token handed out at login to users that must change their password. All sessions are revoked afterwards, so the user has to log in again.
+//	@Tags Authentication
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param changeOwnPasswordRequest body handlers.ChangeOwnPasswordRequest true "Change password payload"
+//	@Success 200 {object} map[string]string "Password changed successfully"
+//	@Failure 400 {object} map[string]string "Validation error"
+//	@Failure 400 {object} handlers.PasswordPolicyErrorResponse "Password does not meet the password policy"
+//	@Failure 401 {object} map[string]string "Invalid password"
+//	@Failure 403 {object} map[string]string "The account has no password, log in again to confirm this change"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Failed to change password"
+//	@Router /api/password [post]
+func (h *Handler) ChangeOwnPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing change own password request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request ChangeOwnPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	if !h.confirmPassword(w, r, "/api/password", user, request.Password) {
+		return
+	}
+
+	if h.rejectIfPasswordViolatesPolicy(w, r, user, request.NewPassword) {
+		return
+	}
 
-	user, _ := services.GetUserByUsername(database.DB, request.Username)
-	user.PasswordHash = string(hash)
-	user.UpdatedAt = time.Now()
-	if err := services.UpdateUser(database.DB, user); err != nil {
-		utils.LogError(err, "Failed to update user in database", nil)
+	if err := services.SetUserPassword(h.db(r), h.PasswordPolicy, user, request.NewPassword); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to change password", nil)
 		utils.WriteJSONError(w, "Failed to change password", http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, user, models.AuditPasswordChange, user, "")
+
+	if err := security.RevokeAllUserJWTs(r.Context(), h.Tokens, user.ID); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to revoke sessions after password change", nil)
+	} else {
+		h.audit(r, user, models.AuditTokenRevoke, user, "all sessions, password changed")
+	}
 
-	utils.LogInfo("Password changed successfully", nil)
+	utils.LogInfoContext(r.Context(), "Password changed successfully", nil)
 	utils.JSONSuccess(w, map[string]interface{}{
 		"status":  "success",
 		"message": "Password changed successfully",
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+)
+
+// currentUser loads the user authenticated by the AuthMiddleware.
+// If the user can not be loaded, an error response is written and ok is false.
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := middlewares.GetUserIDFromContext(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := h.Users.FindByID(r.Context(), userID)
+	if err != nil {
+		utils.WriteJSONError(w, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return user, true
+}
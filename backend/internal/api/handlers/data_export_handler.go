@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type DataExportRequest struct {
+	// Format is "zip" (default) or "json"
+	Format string `json:"format" validate:"omitempty,oneof=zip json"`
+}
+
+type DataExportResponse struct {
+	ID          uint       `json:"id"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// RequestDataExportHandler queues an export of all data held about the logged in user
+//
+//	@Summary Request an export of your data
+//	@Description Queues a machine-readable export of the profile, search history, sessions and password resets of the logged in user. The export is built in the background; when it is ready a one-time download link is emailed, and also returned by the export status endpoint.
+//	@Tags Profile
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param dataExportRequest body handlers.DataExportRequest false "Archive format"
+//	@Success 202 {object} handlers.DataExportResponse "Export queued"
+//	@Failure 400 {object} map[string]string "Invalid input data"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 409 {object} map[string]string "An export is already in progress"
+//	@Failure 500 {object} map[string]string "Failed to request export"
+//	@Router /api/me/export [post]
+func (h *Handler) RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing data export request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request DataExportRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+			utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+	if request.Format == "" {
+		request.Format = models.DataExportFormatZIP
+	}
+
+	export, err := services.RequestDataExport(h.db(r), user, request.Format)
+	if errors.Is(err, services.ErrDataExportInProgress) {
+		utils.WriteJSONError(w, "A data export is already in progress", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to request data export", nil)
+		utils.WriteJSONError(w, "Failed to request export", http.StatusInternalServerError)
+		return
+	}
+
+	utils.LogInfoContext(r.Context(), "Data export queued", nil)
+	h.writeDataExport(w, export, http.StatusAccepted)
+}
+
+// GetDataExportHandler returns the status of a data export of the logged in user
+//
+//	@Summary Get the status of a data export
+//	@Description Returns the status of an export. Once it is ready, the response includes the one-time download link.
+//	@Tags Profile
+//	@Security Bearer
+//	@Produce json
+//	@Param id path int true "Export ID"
+//	@Success 200 {object} handlers.DataExportResponse "Export"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 404 {object} map[string]string "Export not found"
+//	@Failure 500 {object} map[string]string "Failed to get export"
+//	@Router /api/me/exports/{id} [get]
+func (h *Handler) GetDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing get data export request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	exportID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		utils.WriteJSONError(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	export, err := services.GetDataExport(h.db(r), user.ID, uint(exportID))
+	if errors.Is(err, services.ErrDataExportNotFound) {
+		utils.WriteJSONError(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to get data export", nil)
+		utils.WriteJSONError(w, "Failed to get export", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeDataExport(w, export, http.StatusOK)
+}
+
+// DownloadDataExportHandler serves a ready data export from a signed link
+//
+//	@Summary Download a data export
+//	@Description Downloads a ready export using the signed link from the export email. No login is needed, as the link itself grants access, but it only works once and expires with the export.
+//	@Tags Profile
+//	@Produce application/zip,application/json
+//	@Param id path int true "Export ID"
+//	@Param expires query int true "Link expiry as a Unix timestamp"
+//	@Param signature query string true "Link signature"
+//	@Success 200 {file} file "Export archive"
+//	@Failure 404 {object} map[string]string "Invalid, expired or already used download link"
+//	@Failure 500 {object} map[string]string "Failed to download export"
+//	@Router /api/exports/{id}/download [get]
+func (h *Handler) DownloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing data export download", nil)
+
+	exportID, idErr := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	expires, expiresErr := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	signature := utils.SanitizeValue(r.URL.Query().Get("signature"))
+	if idErr != nil || expiresErr != nil || !security.ValidateDataExportLink(h.Config.JWT, uint(exportID), expires, signature) {
+		utils.LogWarnContext(r.Context(), "Invalid data export download link", nil)
+		utils.WriteJSONError(w, "Invalid, expired or already used download link", http.StatusNotFound)
+		return
+	}
+
+	export, err := services.ConsumeDataExport(h.db(r), uint(exportID))
+	if errors.Is(err, services.ErrDataExportNotFound) {
+		utils.WriteJSONError(w, "Invalid, expired or already used download link", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to download data export", nil)
+		utils.WriteJSONError(w, "Failed to download export", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "application/zip"
+	if export.Format == models.DataExportFormatJSON {
+		contentType = "application/json"
+	}
+
+	utils.LogInfoContext(r.Context(), "Data export downloaded", nil)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="whoknows-export-%d.%s"`, export.ID, export.Format))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// writeDataExport writes an export as the response, with its download link once it is ready
+func (h *Handler) writeDataExport(w http.ResponseWriter, export *models.DataExport, status int) {
+	response := map[string]interface{}{
+		"id":         export.ID,
+		"status":     export.Status,
+		"format":     export.Format,
+		"created_at": export.CreatedAt,
+	}
+	if export.ExpiresAt != nil {
+		response["expires_at"] = export.ExpiresAt
+	}
+	if export.Status == models.DataExportReady {
+		response["download_url"] = services.DataExportDownloadLink(h.Config.JWT, h.Config.Server.PublicURL, export)
+	}
+
+	utils.JSONSuccess(w, response, status)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
+	"gorm.io/gorm"
+)
+
+// Handler serves the API endpoints. The handlers are methods on it, so they reach the
+// database, configuration, mailer and the rest of the App instead of package globals.
+type Handler struct {
+	*app.App
+}
+
+// New returns a Handler for the endpoints of the given App
+func New(a *app.App) *Handler {
+	return &Handler{App: a}
+}
+
+// db returns the database bound to the context of r, so the database operations of a
+// request are traced as part of it
+func (h *Handler) db(r *http.Request) *gorm.DB {
+	return h.DB.WithContext(r.Context())
+}
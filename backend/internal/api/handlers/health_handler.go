@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/lifecycle"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// cacheCheckTimeout bounds the cache check, which never leaves the process
+const cacheCheckTimeout = time.Second
+
+type HealthResponse struct {
+	Status      string `json:"status" example:"ok"`
+	Maintenance bool   `json:"maintenance"`
+}
+
+type ReadinessResponse struct {
+	Status      string                           `json:"status" example:"ready"`
+	Maintenance bool                             `json:"maintenance"`
+	Checks      map[string]lifecycle.CheckResult `json:"checks"`
+}
+
+// HealthHandler reports that the backend process is alive
+//
+//	@Summary Liveness probe
+//	@Description Returns 200 as long as the process can serve requests. It checks no dependencies, so a failing database does not get the backend restarted.
+//	@Tags Health
+//	@Produce json
+//	@Success 200 {object} handlers.HealthResponse "Alive"
+//	@Router /healthz [get]
+func (h *Handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":      "ok",
+		"maintenance": h.Config.Server.MaintenanceMode,
+	}, http.StatusOK)
+}
+
+// ReadinessHandler reports whether the backend is ready to receive traffic
+//
+//	@Summary Readiness probe
+//	@Description Checks the database connection and the migration state, and, when configured, the weather provider and the cache. Every check runs under its own timeout and is reported with its latency. Returns 503 when a critical check fails, before the backend has started, and as soon as it starts shutting down, so the proxy stops sending it new requests before they are drained.
+//	@Tags Health
+//	@Produce json
+//	@Success 200 {object} handlers.ReadinessResponse "Ready"
+//	@Failure 503 {object} handlers.ReadinessResponse "Not ready"
+//	@Router /readyz [get]
+func (h *Handler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	maintenance := h.Config.Server.MaintenanceMode
+
+	// Shutting down is not a dependency failure, so the dependencies are not checked
+	if !h.Readiness.Ready() {
+		utils.JSONSuccess(w, map[string]interface{}{
+			"status":      "not ready",
+			"maintenance": maintenance,
+			"checks":      map[string]lifecycle.CheckResult{},
+		}, http.StatusServiceUnavailable)
+		return
+	}
+
+	results, healthy := lifecycle.RunChecks(r.Context(), h.readinessChecks())
+	for name, result := range results {
+		if result.Cause != nil {
+			utils.LogWarnContext(r.Context(), "Readiness check failed", logrus.Fields{
+				"message": fmt.Sprintf("%s: %s", name, result.Cause),
+			})
+		}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !healthy {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":      status,
+		"maintenance": maintenance,
+		"checks":      results,
+	}, code)
+}
+
+// readinessChecks returns the checks run by the readiness probe. The weather provider is
+// only checked when a timeout is configured for it, as every check counts against its quota.
+func (h *Handler) readinessChecks() []lifecycle.Check {
+	healthConfig := h.Config.Health
+	checks := []lifecycle.Check{
+		{Name: "database", Critical: true, Timeout: healthConfig.DatabaseTimeout, Run: func(ctx context.Context) error {
+			return database.Ping(ctx, h.DB)
+		}},
+		{Name: "migrations", Critical: true, Timeout: healthConfig.DatabaseTimeout, Run: func(ctx context.Context) error {
+			return database.CheckMigrations(ctx, h.DB)
+		}},
+		{Name: "cache", Timeout: cacheCheckTimeout, Run: func(ctx context.Context) error {
+			return h.Cache.Check()
+		}},
+	}
+	if healthConfig.WeatherTimeout > 0 {
+		checks = append(checks, lifecycle.Check{Name: "weather", Timeout: healthConfig.WeatherTimeout, Run: func(ctx context.Context) error {
+			_, err := h.FetchWeatherData(ctx)
+			return err
+		}})
+	}
+	return checks
+}
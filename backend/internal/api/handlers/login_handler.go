@@ -5,7 +5,8 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
 	"github.com/CEM-KEA/whoknows/backend/internal/models"
 	"github.com/CEM-KEA/whoknows/backend/internal/security"
 	"github.com/CEM-KEA/whoknows/backend/internal/services"
@@ -19,30 +20,50 @@ type LoginRequest struct {
 
 type LoginResponse struct {
 	Status                string `json:"status"`
-	Token                 string `json:"token"`
+	Token                 string `json:"token,omitempty"`
+	CSRFToken             string `json:"csrf_token,omitempty"`
 	RequirePasswordChange bool   `json:"require_password_change"`
 }
 
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+type MFAChallengeResponse struct {
+	Status   string `json:"status" example:"mfa_required"`
+	MFAToken string `json:"mfa_token"`
+	// Methods are the second factors the user can complete the login with: "totp" and "passkey"
+	Methods []string `json:"methods" example:"totp,passkey"`
+}
+
 // Login handles the login request.
 //
 //	@Summary Login a user
 //	@Description Authenticate user and return a JWT token for further requests.
+//	@Description If the user has two-factor authentication enabled or registered a passkey, an "mfa_required" challenge token is returned instead,
+//	@Description to be completed with a TOTP code at /api/login/2fa or with a passkey at /api/login/2fa/passkey/begin.
+//	@Description If require_password_change is true, the token is only valid for 15 minutes and can only be used to change the password at /api/password.
 //	@Tags Authentication
 //	@Accept json
 //	@Produce json
 //	@Param loginRequest body handlers.LoginRequest true "Login request body"
 //	@Success 200 {object} handlers.LoginResponse "Successful login"
+//	@Success 202 {object} handlers.MFAChallengeResponse "Second factor required"
 //	@Failure 400 {object} map[string]string "Invalid request body"
 //	@Failure 401 {object} map[string]string "Invalid username or password"
+//	@Failure 403 {object} map[string]string "Email address not verified"
+//	@Failure 429 {object} map[string]string "Too many failed attempts or requests"
 //	@Failure 500 {object} map[string]string "Internal server error"
 //	@Router /login [post]
-func Login(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing login request", nil)
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing login request", nil)
 
 	// Decode and sanitize the request body
 	var request LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		utils.LogError(err, "Failed to decode request body", nil)
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
 		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
@@ -50,23 +71,173 @@ func Login(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the sanitized request
 	if err := utils.Validate(request); err != nil {
-		utils.LogError(err, "Request validation failed", nil)
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
 		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
 		return
 	}
 
+	// Refuse further guesses while the client or the username is locked out
+	keys := h.attemptKeys(r, request.Username)
+	if h.rejectIfLockedOut(w, r, "/api/login", keys) {
+		h.audit(r, nil, models.AuditLoginFailure, &models.User{Username: request.Username}, "locked out")
+		return
+	}
+
 	// Check user credentials
-	user, valid, err := services.CheckUserPassword(database.DB, request.Password, request.Username)
+	user, valid, err := services.CheckUserPassword(h.db(r), request.Password, request.Username)
 	if err != nil || !valid {
-		utils.LogWarn("Invalid user credentials", nil)
+		h.registerFailedAttempt(r, keys)
+		h.audit(r, nil, models.AuditLoginFailure, &models.User{Username: request.Username}, "invalid username or password")
+		utils.LogWarnContext(r.Context(), "Invalid user credentials", nil)
 		utils.WriteJSONError(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
+	// Optionally block users that have not verified their email yet
+	if h.emailVerificationRequired(user) {
+		h.audit(r, nil, models.AuditLoginFailure, user, "email not verified")
+		utils.LogWarnContext(r.Context(), "Login blocked, email not verified", nil)
+		utils.WriteJSONError(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	// Users with two-factor authentication or passkeys get a challenge instead of a session.
+	// Their failed attempts are only reset once the second factor is verified too.
+	if h.requireSecondFactor(w, r, user) {
+		return
+	}
+
+	h.registerSuccessfulAttempt(r, bruteforce.UserKey(user.Username))
+	h.issueLoginToken(w, r, user, "password")
+	utils.LogInfoContext(r.Context(), "User logged in successfully", nil)
+}
+
+// LoginMFAHandler handles the second login step for users with two-factor authentication.
+//
+//	@Summary Complete a two-factor login
+//	@Description Exchange the challenge token from /api/login and a TOTP code, or a recovery code, for a JWT token.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param loginMFARequest body handlers.LoginMFARequest true "Second factor"
+//	@Success 200 {object} handlers.LoginResponse "Successful login"
+//	@Failure 400 {object} map[string]string "Invalid request body"
+//	@Failure 401 {object} map[string]string "Invalid or expired challenge, or invalid code"
+//	@Failure 429 {object} map[string]string "Too many failed attempts or requests"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/login/2fa [post]
+func (h *Handler) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing two-factor login request", nil)
+
+	var request LoginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	if h.rejectIfLockedOut(w, r, "/api/login/2fa", h.attemptKeys(r, "")) {
+		return
+	}
+
+	userID, err := security.ValidateMFAChallengeToken(r.Context(), h.Config.JWT, request.MFAToken)
+	if err != nil {
+		h.registerFailedAttempt(r, h.attemptKeys(r, ""))
+		h.audit(r, nil, models.AuditLoginFailure, nil, "invalid two-factor challenge")
+		utils.LogWarnContext(r.Context(), "Invalid MFA challenge token", nil)
+		utils.WriteJSONError(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.Users.FindByID(r.Context(), userID)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "MFA challenge for unknown user", nil)
+		utils.WriteJSONError(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	keys := h.attemptKeys(r, user.Username)
+	if h.rejectIfLockedOut(w, r, "/api/login/2fa", keys) {
+		h.audit(r, nil, models.AuditLoginFailure, user, "locked out")
+		return
+	}
+
+	if err := services.VerifySecondFactor(h.db(r), user, request.Code, request.RecoveryCode); err != nil {
+		h.registerFailedAttempt(r, keys)
+		h.audit(r, nil, models.AuditLoginFailure, user, "invalid second factor")
+		utils.LogWarnContext(r.Context(), "Second factor verification failed", nil)
+		utils.WriteJSONError(w, "Invalid two-factor authentication code", http.StatusUnauthorized)
+		return
+	}
+
+	h.registerSuccessfulAttempt(r, bruteforce.UserKey(user.Username))
+	h.issueLoginToken(w, r, user, "two-factor")
+	utils.LogInfoContext(r.Context(), "User completed two-factor login", nil)
+}
+
+// requireSecondFactor tells the client that a second factor is required, handing out a
+// short-lived challenge token instead of a session JWT, if the user has a second factor.
+// It returns true if a response has been written.
+func (h *Handler) requireSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	methods, err := services.SecondFactorMethods(h.db(r), user)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to get second factors", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return true
+	}
+	if len(methods) == 0 {
+		return false
+	}
+
+	h.respondWithMFAChallenge(w, r, user, methods)
+	return true
+}
+
+// respondWithMFAChallenge tells the client that a second factor is required,
+// handing out a short-lived challenge token instead of a session JWT
+func (h *Handler) respondWithMFAChallenge(w http.ResponseWriter, r *http.Request, user *models.User, methods []string) {
+	challenge, err := security.GenerateMFAChallengeToken(r.Context(), h.Config.JWT, user.ID)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to generate MFA challenge", nil)
+		utils.WriteJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":    "mfa_required",
+		"mfa_token": challenge,
+		"methods":   methods,
+	}, http.StatusAccepted)
+
+	utils.LogInfoContext(r.Context(), "Password accepted, second factor required", nil)
+}
+
+// issueLoginToken generates and stores a session JWT for the user, updates their
+// last login timestamp, records a login.success audit event with the login method
+// and writes the login response. Users that must change their password only get a
+// short-lived token limited to changing the password.
+// With cookie sessions the token is also set as an HttpOnly cookie and the response
+// carries its CSRF token; in the "cookie" session mode the token is left out of the body.
+func (h *Handler) issueLoginToken(w http.ResponseWriter, r *http.Request, user *models.User, method string) {
 	// Generate JWT
-	token, err := security.GenerateJWT(user.ID, user.Username)
+	expiresAt := time.Now().Add(24 * time.Hour)
+	var token string
+	var err error
+	if user.MustChangePassword {
+		expiresAt = time.Now().Add(security.PasswordChangeTokenTTL)
+		token, err = security.GenerateScopedJWT(r.Context(), h.Config.JWT, user.ID, user.Username, security.ScopePasswordChange, expiresAt)
+	} else {
+		token, err = security.GenerateJWT(r.Context(), h.Config.JWT, user.ID, user.Username)
+	}
 	if err != nil {
-		utils.LogError(err, "Failed to generate token", nil)
+		utils.LogErrorContext(r.Context(), err, "Failed to generate token", nil)
 		utils.WriteJSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
@@ -75,34 +246,46 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	jwtModel := models.JWT{
 		UserID:    user.ID,
 		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		ExpiresAt: expiresAt,
 		CreatedAt: time.Now(),
 	}
-	if err := database.DB.Create(&jwtModel).Error; err != nil {
-		utils.LogError(err, "Failed to save token to database", nil)
+	if err := h.Tokens.Create(r.Context(), &jwtModel); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to save token to database", nil)
 		utils.WriteJSONError(w, "Failed to save token", http.StatusInternalServerError)
 		return
 	}
 
 	// Update last login timestamp
-	if err := services.UpdateLastLogin(database.DB, user); err != nil {
-		utils.LogError(err, "Failed to update last login", nil)
+	if err := services.UpdateLastLogin(h.db(r), user); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to update last login", nil)
 		utils.WriteJSONError(w, "Failed to update last login", http.StatusInternalServerError)
 		return
 	}
 
+	h.audit(r, user, models.AuditLoginSuccess, user, method)
+
 	// Prepare response
 	response := LoginResponse{
 		Token:                 token,
-		RequirePasswordChange: user.UpdatedAt.Before(time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC)), // Check if user changed password after incident on 31/10/2024
+		RequirePasswordChange: user.MustChangePassword,
+	}
+	if h.Config.Session.CookieSessions() {
+		response.CSRFToken = h.setSessionCookies(w, token, expiresAt)
+	}
+	if h.Config.Session.Mode == config.SessionModeCookie {
+		response.Token = ""
 	}
 
 	// Send success response
-	utils.JSONSuccess(w, map[string]interface{}{
+	body := map[string]interface{}{
 		"status":                  "success",
-		"token":                   response.Token,
 		"require_password_change": response.RequirePasswordChange,
-	}, http.StatusOK)
-
-	utils.LogInfo("User logged in successfully", nil)
+	}
+	if response.Token != "" {
+		body["token"] = response.Token
+	}
+	if response.CSRFToken != "" {
+		body["csrf_token"] = response.CSRFToken
+	}
+	utils.JSONSuccess(w, body, http.StatusOK)
 }
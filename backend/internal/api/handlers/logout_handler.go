@@ -2,51 +2,55 @@ package handlers
 
 import (
 	"net/http"
-	"strings"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
 	"github.com/CEM-KEA/whoknows/backend/internal/security"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 )
 
-// LogoutHandler logs out the user by revoking the jwt token
+// LogoutHandler logs out the user by revoking the jwt token. The token is taken from the
+// Authorization header or the session cookie, which is cleared.
 //
-//	@Description	Logs out the user by revoking the jwt token
+//	@Description	Logs out the user by revoking the jwt token, sent as a Bearer token or in the session cookie.
+//	@Description	With the session cookie the X-CSRF-Token header is required.
 //	@Tags Authentication
 //	@Security		Bearer
 //	@Success		200	{string}	string	"Logged out successfully"
 //	@Failure		401	{string}	string	"No Authorization header found"
 //	@Failure		401	{string}	string	"Invalid Authorization header format"
+//	@Failure		403	{string}	string	"Invalid CSRF token"
 //	@Failure		500	{string}	string	"Failed to revoke token"
 //	@Router			/api/logout [get]
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing logout request", nil)
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		utils.LogWarn("No Authorization header found", nil)
-		utils.WriteJSONError(w, "No Authorization header found", http.StatusUnauthorized)
+//	@Router			/api/logout [post]
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing logout request", nil)
+	token, fromCookie, ok := h.requestSessionToken(w, r)
+	if !ok {
 		return
 	}
-
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		utils.LogWarn("Invalid Authorization header format", nil)
-		utils.WriteJSONError(w, "Invalid Authorization header format", http.StatusUnauthorized)
+	// Logging out with the session cookie changes state even over GET, so it always needs the CSRF token
+	if fromCookie && !security.ValidateCSRFToken(h.Config.JWT, token, r.Header.Get(middlewares.CSRFHeader)) {
+		utils.LogWarnContext(r.Context(), "Missing or invalid CSRF token on logout", nil)
+		utils.WriteJSONError(w, "Invalid CSRF token", http.StatusForbidden)
 		return
 	}
-	token := parts[1]
 
-	err := security.RevokeJWT(database.DB, token)
+	err := security.RevokeJWT(r.Context(), h.Tokens, token)
 	if err != nil {
-		utils.LogError(err, "Failed to revoke token", nil)
+		utils.LogErrorContext(r.Context(), err, "Failed to revoke token", nil)
 		utils.WriteJSONError(w, "Failed to revoke token", http.StatusInternalServerError)
 		return
 	}
 
+	h.clearSessionCookies(w)
+	user := h.tokenOwner(r, token)
+	h.audit(r, user, models.AuditLogout, user, "")
+
 	utils.JSONSuccess(w, map[string]interface{}{
 		"status":  "success",
 		"message": "Logged out successfully",
 	}, http.StatusOK)
 
-	utils.LogInfo("User logged out successfully", nil)
+	utils.LogInfoContext(r.Context(), "User logged out successfully", nil)
 }
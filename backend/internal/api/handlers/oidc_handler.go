@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/oidc"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type OIDCProviderResponse struct {
+	Name        string `json:"name" example:"google"`
+	DisplayName string `json:"display_name" example:"Google"`
+}
+
+type OIDCAuthorizeResponse struct {
+	// AuthorizationURL is where to send the user to log in at the identity provider
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+type OIDCIdentityResponse struct {
+	Provider    string     `json:"provider" example:"google"`
+	DisplayName string     `json:"display_name" example:"Google"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ListOIDCProvidersHandler lists the identity providers users can log in with
+//
+//	@Summary List identity providers
+//	@Description Lists the OpenID Connect identity providers for the "Log in with" buttons.
+//	@Tags Authentication
+//	@Produce json
+//	@Success 200 {array} handlers.OIDCProviderResponse "Identity providers"
+//	@Router /api/oidc/providers [get]
+func (h *Handler) ListOIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing list identity providers request", nil)
+
+	response := []OIDCProviderResponse{}
+	for _, provider := range h.OIDC.All() {
+		response = append(response, OIDCProviderResponse{Name: provider.Name, DisplayName: provider.DisplayName})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// OIDCAuthorizeHandler starts a login at an identity provider
+//
+//	@Summary Start a login with an identity provider
+//	@Description Returns the URL to send the user to. The provider redirects back to the /oidc/callback page of the frontend, which completes the login at /api/oidc/{provider}/callback.
+//	@Tags Authentication
+//	@Produce json
+//	@Param provider path string true "Identity provider name"
+//	@Success 200 {object} handlers.OIDCAuthorizeResponse "Authorization URL"
+//	@Failure 404 {object} map[string]string "Unknown identity provider"
+//	@Failure 502 {object} map[string]string "Identity provider unavailable"
+//	@Router /api/oidc/{provider}/authorize [post]
+func (h *Handler) OIDCAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing identity provider login request", nil)
+	h.startOIDCFlow(w, r, nil)
+}
+
+// OIDCCallbackHandler completes a login at an identity provider
+//
+//	@Summary Complete a login with an identity provider
+//	@Description Exchanges the code and state the identity provider redirected back with for a JWT token, like /api/login.
+//	@Description An unknown provider account gets a new user, unless its email address belongs to an existing user, who must log in and link the provider instead.
+//	@Description Users with two-factor authentication or passkeys get an "mfa_required" challenge token, to be completed like after /api/login.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param provider path string true "Identity provider name"
+//	@Param oidcCallbackRequest body handlers.OIDCCallbackRequest true "Code and state from the redirect"
+//	@Success 200 {object} handlers.LoginResponse "Successful login"
+//	@Success 202 {object} handlers.MFAChallengeResponse "Second factor required"
+//	@Failure 400 {object} map[string]string "Invalid or expired state"
+//	@Failure 401 {object} map[string]string "Login at the identity provider failed"
+//	@Failure 403 {object} map[string]string "Email address not verified"
+//	@Failure 404 {object} map[string]string "Unknown identity provider"
+//	@Failure 409 {object} map[string]string "Email address belongs to an existing account"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/oidc/{provider}/callback [post]
+func (h *Handler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing identity provider callback", nil)
+
+	provider, claims, ok := h.finishOIDCFlow(w, r, nil)
+	if !ok {
+		return
+	}
+
+	user, created, err := services.OIDCLoginUser(h.db(r), provider.Name, claims)
+	switch {
+	case errors.Is(err, services.ErrOIDCEmailTaken):
+		h.audit(r, nil, models.AuditLoginFailure, &models.User{Username: claims.Email}, "oidc "+provider.Name+": email address belongs to an existing account")
+		utils.WriteJSONError(w, "An account with this email address already exists. Log in and link "+provider.DisplayName+" from your profile.", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrOIDCEmailMissing):
+		h.audit(r, nil, models.AuditLoginFailure, nil, "oidc "+provider.Name+": no email address")
+		utils.WriteJSONError(w, provider.DisplayName+" did not share an email address", http.StatusUnauthorized)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to log in with identity provider", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if created && user.VerifiedAt == nil {
+		if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+			utils.LogErrorContext(r.Context(), err, "Failed to send verification email", nil)
+		}
+	}
+
+	if h.emailVerificationRequired(user) {
+		h.audit(r, nil, models.AuditLoginFailure, user, "email not verified")
+		utils.LogWarnContext(r.Context(), "Login blocked, email not verified", nil)
+		utils.WriteJSONError(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	if h.requireSecondFactor(w, r, user) {
+		return
+	}
+
+	h.issueLoginToken(w, r, user, "oidc "+provider.Name)
+	utils.LogInfoContext(r.Context(), "User logged in with identity provider", nil)
+}
+
+// LinkOIDCAuthorizeHandler starts linking an identity provider to the logged in user
+//
+//	@Summary Start linking an identity provider
+//	@Description Returns the URL to send the user to. After the redirect back, the link is completed at /api/me/oidc/{provider}/callback.
+//	@Tags Profile
+//	@Security Bearer
+//	@Produce json
+//	@Param provider path string true "Identity provider name"
+//	@Success 200 {object} handlers.OIDCAuthorizeResponse "Authorization URL"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 404 {object} map[string]string "Unknown identity provider"
+//	@Failure 502 {object} map[string]string "Identity provider unavailable"
+//	@Router /api/me/oidc/{provider}/authorize [post]
+func (h *Handler) LinkOIDCAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing identity provider link request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	h.startOIDCFlow(w, r, &user.ID)
+}
+
+// LinkOIDCCallbackHandler links an identity provider account to the logged in user
+//
+//	@Summary Complete linking an identity provider
+//	@Tags Profile
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param provider path string true "Identity provider name"
+//	@Param oidcCallbackRequest body handlers.OIDCCallbackRequest true "Code and state from the redirect"
+//	@Success 201 {object} handlers.OIDCIdentityResponse "Linked identity"
+//	@Failure 400 {object} map[string]string "Invalid or expired state"
+//	@Failure 401 {object} map[string]string "Unauthorized, or login at the identity provider failed"
+//	@Failure 404 {object} map[string]string "Unknown identity provider"
+//	@Failure 409 {object} map[string]string "Account or provider already linked"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/me/oidc/{provider}/callback [post]
+func (h *Handler) LinkOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing identity provider link callback", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	provider, claims, ok := h.finishOIDCFlow(w, r, &user.ID)
+	if !ok {
+		return
+	}
+
+	identity, err := services.LinkOIDCIdentity(h.db(r), user, provider.Name, claims)
+	switch {
+	case errors.Is(err, services.ErrOIDCIdentityTaken):
+		utils.WriteJSONError(w, "This "+provider.DisplayName+" account is already linked to another user", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrOIDCProviderLinked):
+		utils.WriteJSONError(w, "Another "+provider.DisplayName+" account is already linked, unlink it first", http.StatusConflict)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to link identity provider", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, user, models.AuditOIDCLink, user, provider.Name)
+
+	utils.LogInfoContext(r.Context(), "Identity provider linked", nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h.oidcIdentityResponse(identity))
+}
+
+// ListOIDCIdentitiesHandler lists the identity providers linked to the logged in user
+//
+//	@Summary List linked identity providers
+//	@Tags Profile
+//	@Security Bearer
+//	@Produce json
+//	@Success 200 {array} handlers.OIDCIdentityResponse "Linked identities"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 500 {object} map[string]string "Failed to list linked identities"
+//	@Router /api/me/oidc [get]
+func (h *Handler) ListOIDCIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing list linked identities request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	identities, err := services.ListOIDCIdentities(h.db(r), user.ID)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to list linked identities", nil)
+		utils.WriteJSONError(w, "Failed to list linked identities", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]OIDCIdentityResponse, len(identities))
+	for i := range identities {
+		response[i] = h.oidcIdentityResponse(&identities[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// UnlinkOIDCIdentityHandler unlinks an identity provider from the logged in user
+//
+//	@Summary Unlink an identity provider
+//	@Description Users without a password can not unlink their last identity provider; they can set a password with a password reset first.
+//	@Tags Profile
+//	@Security Bearer
+//	@Produce json
+//	@Param provider path string true "Identity provider name"
+//	@Success 200 {object} map[string]string "Identity provider unlinked"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 404 {object} map[string]string "Identity provider not linked"
+//	@Failure 409 {object} map[string]string "Only way to log in"
+//	@Failure 500 {object} map[string]string "Failed to unlink identity provider"
+//	@Router /api/me/oidc/{provider} [delete]
+func (h *Handler) UnlinkOIDCIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing unlink identity provider request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	providerName := mux.Vars(r)["provider"]
+	err := services.UnlinkOIDCIdentity(h.db(r), user, providerName)
+	switch {
+	case errors.Is(err, services.ErrOIDCIdentityNotFound):
+		utils.WriteJSONError(w, "Identity provider not linked", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrOIDCLastLoginMethod):
+		utils.WriteJSONError(w, "This is your only way to log in. Set a password with a password reset first.", http.StatusConflict)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to unlink identity provider", nil)
+		utils.WriteJSONError(w, "Failed to unlink identity provider", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, user, models.AuditOIDCUnlink, user, providerName)
+
+	utils.LogInfoContext(r.Context(), "Identity provider unlinked", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Identity provider unlinked",
+	}, http.StatusOK)
+}
+
+// startOIDCFlow writes the authorization URL for a login, or for a link when userID is set
+func (h *Handler) startOIDCFlow(w http.ResponseWriter, r *http.Request, userID *uint) {
+	provider, ok := h.OIDC.Get(mux.Vars(r)["provider"])
+	if !ok {
+		utils.WriteJSONError(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	authURL, err := services.StartOIDCFlow(r.Context(), h.db(r), provider, userID, h.Config.OIDC.StateTTL)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to start identity provider login", nil)
+		utils.WriteJSONError(w, provider.DisplayName+" is not available right now", http.StatusBadGateway)
+		return
+	}
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"authorization_url": authURL,
+	}, http.StatusOK)
+}
+
+// finishOIDCFlow decodes a callback request and returns the verified claims of the user at the
+// provider. On failure the error response is written, a login failure is audited, and ok is false.
+func (h *Handler) finishOIDCFlow(w http.ResponseWriter, r *http.Request, userID *uint) (*oidc.Provider, *oidc.Claims, bool) {
+	provider, ok := h.OIDC.Get(mux.Vars(r)["provider"])
+	if !ok {
+		utils.WriteJSONError(w, "Unknown identity provider", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	var request OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	claims, err := services.FinishOIDCFlow(r.Context(), h.db(r), provider, request.State, request.Code, userID)
+	if errors.Is(err, services.ErrInvalidOIDCState) {
+		utils.LogWarnContext(r.Context(), "Invalid OIDC state", nil)
+		utils.WriteJSONError(w, "Invalid or expired login, please try again", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Identity provider login failed", nil)
+		h.audit(r, nil, models.AuditLoginFailure, nil, "oidc "+provider.Name+": "+err.Error())
+		utils.WriteJSONError(w, "Login with "+provider.DisplayName+" failed", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	return provider, claims, true
+}
+
+// oidcIdentityResponse describes a linked identity
+func (h *Handler) oidcIdentityResponse(identity *models.OIDCIdentity) OIDCIdentityResponse {
+	displayName := identity.Provider
+	if provider, ok := h.OIDC.Get(identity.Provider); ok {
+		displayName = provider.DisplayName
+	}
+	return OIDCIdentityResponse{
+		Provider:    identity.Provider,
+		DisplayName: displayName,
+		Email:       identity.Email,
+		LastLoginAt: identity.LastLoginAt,
+		CreatedAt:   identity.CreatedAt,
+	}
+}
@@ -0,0 +1,636 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/CEM-KEA/whoknows/backend/internal/webauthn"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type PasskeyCreationOptionsResponse struct {
+	// PublicKey is passed to navigator.credentials.create as the publicKey option
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+type PasskeyRequestOptionsResponse struct {
+	// PublicKey is passed to navigator.credentials.get as the publicKey option
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+type PasskeyRegisterRequest struct {
+	Name       string                        `json:"name" validate:"required,max=100"`
+	Credential webauthn.RegistrationResponse `json:"credential" validate:"required"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential webauthn.AssertionResponse `json:"credential" validate:"required"`
+}
+
+type PasskeyMFABeginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+type PasskeyMFARequest struct {
+	MFAToken   string                     `json:"mfa_token" validate:"required"`
+	Credential webauthn.AssertionResponse `json:"credential" validate:"required"`
+}
+
+type PasskeySecondFactorRequest struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+	// Password is the current password, required to turn the second factor off
+	Password string `json:"password"`
+}
+
+type PasskeyResponse struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	AAGUID         string     `json:"aaguid"`
+	BackupEligible bool       `json:"backup_eligible"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// PasskeyRegisterBeginHandler starts registering a passkey for the logged in user
+//
+//	@Summary Start registering a passkey
+//	@Description Returns the options for navigator.credentials.create. The new credential is then sent to /api/me/passkeys/register/finish.
+//	@Tags Passkeys
+//	@Security Bearer
+//	@Produce json
+//	@Success 200 {object} handlers.PasskeyCreationOptionsResponse "Creation options"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/me/passkeys/register/begin [post]
+func (h *Handler) PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey registration request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	rp, ok := h.relyingParty(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := services.PasskeyDescriptors(h.db(r), user.ID)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to list passkeys", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	challenge, err := services.StartPasskeyCeremony(h.db(r), models.WebAuthnCeremonyRegistration, &user.ID, h.Config.WebAuthn.ChallengeTTL)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to start passkey registration", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	options := rp.CreationOptions(challenge, webauthn.UserEntity{
+		Handle:      services.PasskeyUserHandle(user.ID),
+		Name:        user.Username,
+		DisplayName: user.Username,
+	}, existing)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"publicKey": options,
+	}, http.StatusOK)
+}
+
+// PasskeyRegisterFinishHandler stores a new passkey for the logged in user
+//
+//	@Summary Complete registering a passkey
+//	@Description Verifies the credential created with the options from /api/me/passkeys/register/begin and stores it.
+//	@Description The passkey can then be used to log in without a password, and as second factor after a password login.
+//	@Tags Passkeys
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param passkeyRegisterRequest body handlers.PasskeyRegisterRequest true "Name and new credential"
+//	@Success 201 {object} handlers.PasskeyResponse "Registered passkey"
+//	@Failure 400 {object} map[string]string "Invalid request body, passkey or challenge"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 409 {object} map[string]string "Passkey already registered, or too many passkeys"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/me/passkeys/register/finish [post]
+func (h *Handler) PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey registration response", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	rp, ok := h.relyingParty(w, r)
+	if !ok {
+		return
+	}
+
+	var request PasskeyRegisterRequest
+	if !decodePasskeyRequest(w, r, &request) {
+		return
+	}
+	request.Name = utils.SanitizeValue(request.Name)
+
+	challenge, err := request.Credential.Challenge()
+	if err != nil {
+		utils.WriteJSONError(w, "Invalid passkey", http.StatusBadRequest)
+		return
+	}
+	if !h.consumePasskeyChallenge(w, r, models.WebAuthnCeremonyRegistration, challenge, &user.ID) {
+		return
+	}
+
+	credential, err := rp.VerifyRegistration(&request.Credential, challenge, false)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "Passkey registration failed to verify", logrus.Fields{"message": err.Error()})
+		utils.WriteJSONError(w, "Invalid passkey", http.StatusBadRequest)
+		return
+	}
+
+	passkey, err := services.AddPasskey(h.db(r), user, request.Name, credential)
+	switch {
+	case errors.Is(err, services.ErrPasskeyExists):
+		utils.WriteJSONError(w, "This passkey is already registered", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrTooManyPasskeys):
+		utils.WriteJSONError(w, "You have too many passkeys, remove one first", http.StatusConflict)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to add passkey", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, user, models.AuditPasskeyAdd, user, passkey.Name)
+
+	utils.LogInfoContext(r.Context(), "Passkey registered", nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(passkeyResponse(passkey))
+}
+
+// ListPasskeysHandler lists the passkeys of the logged in user
+//
+//	@Summary List your passkeys
+//	@Tags Passkeys
+//	@Security Bearer
+//	@Produce json
+//	@Success 200 {array} handlers.PasskeyResponse "Passkeys"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 500 {object} map[string]string "Failed to list passkeys"
+//	@Router /api/me/passkeys [get]
+func (h *Handler) ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing list passkeys request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	passkeys, err := services.ListPasskeys(h.db(r), user.ID)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to list passkeys", nil)
+		utils.WriteJSONError(w, "Failed to list passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]PasskeyResponse, len(passkeys))
+	for i := range passkeys {
+		response[i] = passkeyResponse(&passkeys[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeletePasskeyHandler removes a passkey of the logged in user
+//
+//	@Summary Remove a passkey
+//	@Tags Passkeys
+//	@Security Bearer
+//	@Produce json
+//	@Param id path int true "Passkey ID"
+//	@Success 200 {object} map[string]string "Passkey removed"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 404 {object} map[string]string "Passkey not found"
+//	@Failure 409 {object} map[string]string "The passkey is the only way to log in"
+//	@Failure 500 {object} map[string]string "Failed to remove passkey"
+//	@Router /api/me/passkeys/{id} [delete]
+func (h *Handler) DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing remove passkey request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	passkeyID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		utils.WriteJSONError(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	err = services.DeletePasskey(h.db(r), user, uint(passkeyID))
+	switch {
+	case errors.Is(err, services.ErrPasskeyNotFound):
+		utils.WriteJSONError(w, "Passkey not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrPasskeyLastLoginMethod):
+		utils.WriteJSONError(w, "This is your only way to log in. Set a password with a password reset first.", http.StatusConflict)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to remove passkey", nil)
+		utils.WriteJSONError(w, "Failed to remove passkey", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, user, models.AuditPasskeyRemove, user, strconv.FormatUint(passkeyID, 10))
+
+	utils.LogInfoContext(r.Context(), "Passkey removed", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Passkey removed",
+	}, http.StatusOK)
+}
+
+// PasskeySecondFactorHandler chooses whether the passkeys of the logged in user are a second factor
+//
+//	@Summary Use passkeys as second factor
+//	@Description Chooses whether a password login of the logged in user needs one of their passkeys. Registering a passkey does not turn this on. Turning it off requires the current password; accounts without a password must have logged in within the last 5 minutes instead.
+//	@Tags Passkeys
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param passkeySecondFactorRequest body handlers.PasskeySecondFactorRequest true "Whether passkeys are a second factor, and the current password to turn it off"
+//	@Success 200 {object} map[string]string "Passkey second factor updated"
+//	@Failure 400 {object} map[string]string "Invalid input data, or no passkeys registered"
+//	@Failure 401 {object} map[string]string "Invalid password"
+//	@Failure 403 {object} map[string]string "The account has no password, log in again to confirm this change"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Failed to update passkey second factor"
+//	@Router /api/me/passkeys/second-factor [put]
+func (h *Handler) PasskeySecondFactorHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey second factor setting", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request PasskeySecondFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	enabled := *request.Enabled
+	if !enabled && user.PasskeySecondFactor && !h.confirmPassword(w, r, "/api/me/passkeys/second-factor", user, request.Password) {
+		return
+	}
+
+	err := services.SetPasskeySecondFactor(h.db(r), user, enabled)
+	switch {
+	case errors.Is(err, services.ErrNoPasskeys):
+		utils.WriteJSONError(w, "No passkeys registered", http.StatusBadRequest)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to update passkey second factor", nil)
+		utils.WriteJSONError(w, "Failed to update passkey second factor", http.StatusInternalServerError)
+		return
+	}
+
+	details := "off"
+	if enabled {
+		details = "on"
+	}
+	h.audit(r, user, models.AuditPasskeySecondFactor, user, details)
+
+	utils.LogInfoContext(r.Context(), "Passkey second factor updated", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Passkey second factor updated",
+	}, http.StatusOK)
+}
+
+// PasskeyLoginBeginHandler starts a passwordless login with a passkey
+//
+//	@Summary Start a passkey login
+//	@Description Returns the options for navigator.credentials.get. The browser lets the user pick one of their passkeys for this site, so no username is needed.
+//	@Description The credential is then sent to /api/login/passkey/finish.
+//	@Tags Authentication
+//	@Produce json
+//	@Success 200 {object} handlers.PasskeyRequestOptionsResponse "Request options"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/login/passkey/begin [post]
+func (h *Handler) PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey login request", nil)
+	h.startPasskeyLogin(w, r, models.WebAuthnCeremonyLogin, nil, []webauthn.CredentialDescriptor{}, webauthn.UserVerificationRequired)
+}
+
+// PasskeyLoginFinishHandler completes a passwordless login with a passkey
+//
+//	@Summary Complete a passkey login
+//	@Description Verifies the credential returned by navigator.credentials.get and returns a JWT token, like /api/login.
+//	@Description The authenticator must verify the user, e.g. with a PIN or biometrics, so no second factor is asked for.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param passkeyLoginRequest body handlers.PasskeyLoginRequest true "Credential"
+//	@Success 200 {object} handlers.LoginResponse "Successful login"
+//	@Failure 400 {object} map[string]string "Invalid request body or expired challenge"
+//	@Failure 401 {object} map[string]string "Invalid passkey"
+//	@Failure 403 {object} map[string]string "Email address not verified"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/login/passkey/finish [post]
+func (h *Handler) PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey login response", nil)
+
+	var request PasskeyLoginRequest
+	if !decodePasskeyRequest(w, r, &request) {
+		return
+	}
+
+	keys := h.attemptKeys(r, "")
+	if h.rejectIfLockedOut(w, r, "/api/login/passkey", keys) {
+		return
+	}
+
+	user, ok := h.verifyPasskeyLogin(w, r, &request.Credential, models.WebAuthnCeremonyLogin, nil, keys)
+	if !ok {
+		return
+	}
+
+	if h.emailVerificationRequired(user) {
+		h.audit(r, nil, models.AuditLoginFailure, user, "email not verified")
+		utils.LogWarnContext(r.Context(), "Login blocked, email not verified", nil)
+		utils.WriteJSONError(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	h.registerSuccessfulAttempt(r, bruteforce.UserKey(user.Username))
+	h.issueLoginToken(w, r, user, "passkey")
+	utils.LogInfoContext(r.Context(), "User logged in with passkey", nil)
+}
+
+// PasskeyMFABeginHandler starts completing a password login with a passkey as second factor
+//
+//	@Summary Start a passkey second factor
+//	@Description Returns the options for navigator.credentials.get for the user of a challenge token from /api/login, limited to their passkeys.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param passkeyMFABeginRequest body handlers.PasskeyMFABeginRequest true "Challenge token"
+//	@Success 200 {object} handlers.PasskeyRequestOptionsResponse "Request options"
+//	@Failure 400 {object} map[string]string "Invalid request body"
+//	@Failure 401 {object} map[string]string "Invalid or expired challenge, or no passkeys"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/login/2fa/passkey/begin [post]
+func (h *Handler) PasskeyMFABeginHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey second factor request", nil)
+
+	var request PasskeyMFABeginRequest
+	if !decodePasskeyRequest(w, r, &request) {
+		return
+	}
+
+	userID, err := security.ValidateMFAChallengeToken(r.Context(), h.Config.JWT, request.MFAToken)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "Invalid MFA challenge token", nil)
+		utils.WriteJSONError(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	allowed, err := services.PasskeyDescriptors(h.db(r), userID)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to list passkeys", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(allowed) == 0 {
+		utils.WriteJSONError(w, "No passkeys registered", http.StatusUnauthorized)
+		return
+	}
+
+	h.startPasskeyLogin(w, r, models.WebAuthnCeremonySecondFactor, &userID, allowed, webauthn.UserVerificationDiscouraged)
+}
+
+// PasskeyMFAFinishHandler completes a password login with a passkey as second factor
+//
+//	@Summary Complete a passkey second factor
+//	@Description Exchanges the challenge token from /api/login and a credential returned by navigator.credentials.get for a JWT token.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param passkeyMFARequest body handlers.PasskeyMFARequest true "Challenge token and credential"
+//	@Success 200 {object} handlers.LoginResponse "Successful login"
+//	@Failure 400 {object} map[string]string "Invalid request body or expired passkey challenge"
+//	@Failure 401 {object} map[string]string "Invalid or expired challenge, or invalid passkey"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Internal server error"
+//	@Router /api/login/2fa/passkey/finish [post]
+func (h *Handler) PasskeyMFAFinishHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing passkey second factor response", nil)
+
+	var request PasskeyMFARequest
+	if !decodePasskeyRequest(w, r, &request) {
+		return
+	}
+
+	if h.rejectIfLockedOut(w, r, "/api/login/2fa", h.attemptKeys(r, "")) {
+		return
+	}
+
+	userID, err := security.ValidateMFAChallengeToken(r.Context(), h.Config.JWT, request.MFAToken)
+	if err != nil {
+		h.registerFailedAttempt(r, h.attemptKeys(r, ""))
+		h.audit(r, nil, models.AuditLoginFailure, nil, "invalid two-factor challenge")
+		utils.LogWarnContext(r.Context(), "Invalid MFA challenge token", nil)
+		utils.WriteJSONError(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	challengeUser, err := h.Users.FindByID(r.Context(), userID)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "MFA challenge for unknown user", nil)
+		utils.WriteJSONError(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	keys := h.attemptKeys(r, challengeUser.Username)
+	if h.rejectIfLockedOut(w, r, "/api/login/2fa", keys) {
+		h.audit(r, nil, models.AuditLoginFailure, challengeUser, "locked out")
+		return
+	}
+
+	user, ok := h.verifyPasskeyLogin(w, r, &request.Credential, models.WebAuthnCeremonySecondFactor, &userID, keys)
+	if !ok {
+		return
+	}
+
+	h.registerSuccessfulAttempt(r, bruteforce.UserKey(user.Username))
+	h.issueLoginToken(w, r, user, "two-factor passkey")
+	utils.LogInfoContext(r.Context(), "User completed two-factor login with passkey", nil)
+}
+
+// startPasskeyLogin stores a challenge for a passkey login and writes the request options
+func (h *Handler) startPasskeyLogin(w http.ResponseWriter, r *http.Request, ceremony string, userID *uint, allowed []webauthn.CredentialDescriptor, userVerification string) {
+	rp, ok := h.relyingParty(w, r)
+	if !ok {
+		return
+	}
+
+	challenge, err := services.StartPasskeyCeremony(h.db(r), ceremony, userID, h.Config.WebAuthn.ChallengeTTL)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to start passkey login", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"publicKey": rp.RequestOptions(challenge, allowed, userVerification),
+	}, http.StatusOK)
+}
+
+// verifyPasskeyLogin verifies a passkey login response and returns the user the passkey belongs
+// to. userID is the user of a second factor login, and nil for a passwordless login, which
+// requires user verification. On failure the error response is written, a failed attempt is
+// registered for keys, and ok is false.
+func (h *Handler) verifyPasskeyLogin(w http.ResponseWriter, r *http.Request, response *webauthn.AssertionResponse, ceremony string, userID *uint, keys []string) (*models.User, bool) {
+	rp, ok := h.relyingParty(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	challenge, err := response.Challenge()
+	if err != nil {
+		utils.WriteJSONError(w, "Invalid passkey", http.StatusBadRequest)
+		return nil, false
+	}
+	if !h.consumePasskeyChallenge(w, r, ceremony, challenge, userID) {
+		return nil, false
+	}
+
+	reject := func(reason string, user *models.User) (*models.User, bool) {
+		h.registerFailedAttempt(r, keys)
+		h.audit(r, nil, models.AuditLoginFailure, user, "passkey: "+reason)
+		utils.LogWarnContext(r.Context(), "Passkey login failed", logrus.Fields{"message": reason})
+		utils.WriteJSONError(w, "Invalid passkey", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	passkey, err := services.FindPasskey(h.db(r), response.ID)
+	if errors.Is(err, services.ErrPasskeyNotFound) {
+		return reject("unknown passkey", nil)
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to get passkey", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	user, err := h.Users.FindByID(r.Context(), passkey.UserID)
+	if err != nil {
+		return reject("passkey of unknown user", nil)
+	}
+	if userID != nil && passkey.UserID != *userID {
+		return reject("passkey of another user", user)
+	}
+	if response.Response.UserHandle != "" {
+		handle, err := base64.RawURLEncoding.DecodeString(response.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, services.PasskeyUserHandle(passkey.UserID)) {
+			return reject("user handle does not match", user)
+		}
+	}
+
+	assertion, err := rp.VerifyAssertion(response, challenge, passkey.PublicKey, passkey.SignCount, userID == nil)
+	if err != nil {
+		return reject(err.Error(), user)
+	}
+	err = services.RecordPasskeyUse(h.db(r), passkey, assertion.SignCount)
+	if errors.Is(err, webauthn.ErrSignCountRegressed) {
+		return reject(err.Error(), user)
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to update passkey", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// consumePasskeyChallenge consumes the challenge of a passkey response. If it is not valid,
+// the error response is written and false is returned.
+func (h *Handler) consumePasskeyChallenge(w http.ResponseWriter, r *http.Request, ceremony, challenge string, userID *uint) bool {
+	err := services.FinishPasskeyCeremony(h.db(r), ceremony, challenge, userID)
+	if errors.Is(err, services.ErrInvalidPasskeyChallenge) {
+		utils.LogWarnContext(r.Context(), "Invalid passkey challenge", nil)
+		utils.WriteJSONError(w, "Invalid or expired passkey challenge, please try again", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to consume passkey challenge", nil)
+		utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// decodePasskeyRequest decodes and validates the body of a passkey request. The credential is
+// signed by the authenticator, so it is not sanitized. If the body is invalid, the error
+// response is written and false is returned.
+func decodePasskeyRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// relyingParty returns this application as WebAuthn relying party, or writes an error response
+// if WebAuthn is not configured
+func (h *Handler) relyingParty(w http.ResponseWriter, r *http.Request) (*webauthn.RelyingParty, bool) {
+	rp, err := webauthn.NewRelyingParty(h.Config.WebAuthn, h.Config.Server.PublicURL)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "WebAuthn is not configured", nil)
+		utils.WriteJSONError(w, "Passkeys are not available", http.StatusInternalServerError)
+		return nil, false
+	}
+	return rp, true
+}
+
+// passkeyResponse describes a passkey without its public key
+func passkeyResponse(passkey *models.WebAuthnCredential) PasskeyResponse {
+	return PasskeyResponse{
+		ID:             passkey.ID,
+		Name:           passkey.Name,
+		AAGUID:         passkey.AAGUID,
+		BackupEligible: passkey.BackupEligible,
+		LastUsedAt:     passkey.LastUsedAt,
+		CreatedAt:      passkey.CreatedAt,
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+)
+
+// PasswordPolicyErrorResponse is returned when a new password does not meet the password policy
+type PasswordPolicyErrorResponse struct {
+	Error      string                       `json:"error"`
+	Violations []security.PasswordViolation `json:"violations"`
+}
+
+// rejectIfPasswordViolatesPolicy checks a new password against the password policy and writes
+// a 400 response listing every violated rule if it does not comply. The error field also holds
+// all messages, for clients that only display it.
+//
+// Returns:
+//   - bool: True if the password was rejected, or the check failed, and the handler should return.
+func (h *Handler) rejectIfPasswordViolatesPolicy(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	violations, err := services.CheckPasswordPolicy(h.db(r), h.PasswordPolicy, user, password)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to check password policy", nil)
+		utils.WriteJSONError(w, "Failed to check password", http.StatusInternalServerError)
+		return true
+	}
+	if len(violations) == 0 {
+		return false
+	}
+
+	messages := make([]string, len(violations))
+	for i, violation := range violations {
+		messages[i] = violation.Message
+	}
+
+	utils.LogWarnContext(r.Context(), "Password rejected by password policy", nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(PasswordPolicyErrorResponse{
+		Error:      "Password does not meet the password policy: " + strings.Join(messages, "; "),
+		Violations: violations,
+	})
+	return true
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/mail"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+)
+
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token             string `json:"token" validate:"required"`
+	NewPassword       string `json:"new_password" validate:"required"`
+	RepeatNewPassword string `json:"repeat_new_password" validate:"required,eqfield=NewPassword"`
+}
+
+// passwordResetRequestedMessage is returned whether or not the email is registered,
+// so the endpoint cannot be used to find out which emails have an account
+const passwordResetRequestedMessage = "If the email is registered, a password reset link has been sent"
+
+// PasswordResetRequestHandler starts the password reset flow
+//
+//	@Summary Request a password reset
+//	@Description Sends a single-use password reset link to the email if it belongs to an account. The response is the same, and is sent before the email is looked up, whether or not the email is registered.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param passwordResetRequest body handlers.PasswordResetRequest true "Password reset request payload"
+//	@Success 202 {object} map[string]string "Password reset requested"
+//	@Failure 400 {object} map[string]string "Invalid request body"
+//	@Failure 429 {object} map[string]string "Too many requests"
+//	@Router /api/password-reset/request [post]
+func (h *Handler) PasswordResetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing password reset request", nil)
+
+	var request PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	// The lookup and the email happen in the background, so that the response time does not
+	// tell whether the email is registered either
+	ctx := context.WithoutCancel(r.Context())
+	h.Go(func() { h.sendPasswordResetEmail(ctx, request.Email) })
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": passwordResetRequestedMessage,
+	}, http.StatusAccepted)
+}
+
+// sendPasswordResetEmail creates a reset token and emails it if the email belongs to a user.
+// Failures are only logged, as they must not change the response.
+func (h *Handler) sendPasswordResetEmail(ctx context.Context, email string) {
+	user, err := h.Users.FindByEmail(ctx, email)
+	if err != nil {
+		utils.LogInfoContext(ctx, "Password reset requested for unknown email", nil)
+		return
+	}
+
+	ttl := h.Config.Auth.PasswordResetTokenTTL
+	token, err := services.CreatePasswordResetToken(h.DB.WithContext(ctx), user, ttl)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to create password reset token", nil)
+		return
+	}
+
+	resetLink := fmt.Sprintf("%s/reset-password?token=%s",
+		strings.TrimRight(h.Config.Server.PublicURL, "/"), url.QueryEscape(token))
+	if err := h.Mailer.Send(ctx, mail.PasswordResetMessage(user.Email, resetLink, ttl)); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to send password reset email", nil)
+		return
+	}
+
+	utils.LogInfoContext(ctx, "Password reset email sent", nil)
+}
+
+// PasswordResetConfirmHandler completes the password reset flow
+//
+//	@Summary Confirm a password reset
+//	@Description Sets a new password using a token from a password reset email. The token can only be used once, and all existing sessions of the user are revoked.
+//	@Tags Authentication
+//	@Accept json
+//	@Produce json
+//	@Param passwordResetConfirmRequest body handlers.PasswordResetConfirmRequest true "Password reset confirmation payload"
+//	@Success 200 {object} map[string]string "Password reset successfully"
+//	@Failure 400 {object} map[string]string "Invalid or expired reset token"
+//	@Failure 400 {object} handlers.PasswordPolicyErrorResponse "Password does not meet the password policy"
+//	@Failure 500 {object} map[string]string "Failed to reset password"
+//	@Router /api/password-reset/confirm [post]
+func (h *Handler) PasswordResetConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing password reset confirmation", nil)
+
+	var request PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	// Check the new password before using up the token, so the user can try another password
+	user, err := services.GetPasswordResetTokenUser(h.db(r), request.Token)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "Invalid password reset token", nil)
+		utils.WriteJSONError(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+
+	if h.rejectIfPasswordViolatesPolicy(w, r, user, request.NewPassword) {
+		return
+	}
+
+	user, err = services.ConsumePasswordResetToken(h.db(r), request.Token)
+	if err != nil {
+		utils.LogWarnContext(r.Context(), "Invalid password reset token", nil)
+		utils.WriteJSONError(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.SetUserPassword(h.db(r), h.PasswordPolicy, user, request.NewPassword); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to reset password", nil)
+		utils.WriteJSONError(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, nil, models.AuditPasswordReset, user, "")
+
+	if err := security.RevokeAllUserJWTs(r.Context(), h.Tokens, user.ID); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to revoke sessions after password reset", nil)
+	} else {
+		h.audit(r, nil, models.AuditTokenRevoke, user, "all sessions, password reset")
+	}
+
+	utils.LogInfoContext(r.Context(), "Password reset successfully", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Password reset successfully",
+	}, http.StatusOK)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// recentLoginWindow is how recently a user without a password must have logged in, with an
+// identity provider or a passkey, to confirm a sensitive change
+const recentLoginWindow = 5 * time.Minute
+
+// recentLoginRequiredMessage is the error for users without a password that did not log in recently
+const recentLoginRequiredMessage = "The account has no password, log in again to confirm this change"
+
+type ProfileResponse struct {
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	CreatedAt        time.Time `json:"created_at"`
+	LastLogin        time.Time `json:"last_login"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	// PasskeySecondFactor is whether a password login needs one of the user's passkeys
+	PasskeySecondFactor bool `json:"passkey_second_factor"`
+}
+
+type UpdateProfileRequest struct {
+	Username string `json:"username" validate:"omitempty,min=3,max=100"`
+	Email    string `json:"email" validate:"omitempty,email"`
+	// Password is the current password, required to confirm the change if the account has one
+	Password string `json:"password"`
+}
+
+type DeleteAccountRequest struct {
+	// Password is the current password, required if the account has one
+	Password string `json:"password"`
+}
+
+// GetProfileHandler returns the profile of the logged in user
+//
+//	@Summary Get the profile of the logged in user
+//	@Tags Profile
+//	@Security Bearer
+//	@Produce json
+//	@Success 200 {object} handlers.ProfileResponse "Profile"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Router /api/me [get]
+func (h *Handler) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing get profile request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	writeProfile(w, user)
+}
+
+// UpdateProfileHandler changes the username and/or email of the logged in user
+//
+//	@Summary Update the profile of the logged in user
+//	@Description Changes the username and/or email address. The current password is required; accounts without a password must have logged in within the last 5 minutes instead. A new email address has to be verified again, and a verification email is sent to it.
+//	@Tags Profile
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param updateProfileRequest body handlers.UpdateProfileRequest true "New username and/or email, and the current password"
+//	@Success 200 {object} handlers.ProfileResponse "Updated profile"
+//	@Failure 400 {object} map[string]string "Invalid input data"
+//	@Failure 401 {object} map[string]string "Invalid password"
+//	@Failure 403 {object} map[string]string "The account has no password, log in again to confirm this change"
+//	@Failure 409 {object} map[string]string "Username or email already in use"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Failed to update profile"
+//	@Router /api/me [patch]
+func (h *Handler) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing update profile request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	if !h.confirmPassword(w, r, "/api/me", user, request.Password) {
+		return
+	}
+
+	emailChanged, err := services.UpdateProfile(h.db(r), user, request.Username, request.Email)
+	switch {
+	case errors.Is(err, services.ErrUsernameTaken):
+		utils.WriteJSONError(w, "Username is already taken", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		utils.WriteJSONError(w, "Email is already in use", http.StatusConflict)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to update profile", nil)
+		utils.WriteJSONError(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	// The new address has to be verified; a failed email only means the user has to ask again later
+	if emailChanged {
+		_ = h.sendVerificationEmail(r.Context(), user)
+	}
+
+	utils.LogInfoContext(r.Context(), "Profile updated", nil)
+	writeProfile(w, user)
+}
+
+// DeleteAccountHandler deletes the account of the logged in user
+//
+//	@Summary Delete the account of the logged in user
+//	@Description Permanently deletes the account together with its sessions and search history. The current password is required; accounts without a password must have logged in within the last 5 minutes instead.
+//	@Tags Profile
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param deleteAccountRequest body handlers.DeleteAccountRequest true "Current password"
+//	@Success 200 {object} map[string]string "Account deleted"
+//	@Failure 400 {object} map[string]string "Invalid input data"
+//	@Failure 401 {object} map[string]string "Invalid password"
+//	@Failure 403 {object} map[string]string "The account has no password, log in again to confirm this change"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Failed to delete account"
+//	@Router /api/me [delete]
+func (h *Handler) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing delete account request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	if !h.confirmPassword(w, r, "/api/me", user, request.Password) {
+		return
+	}
+
+	if err := services.DeleteUser(h.db(r), user); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to delete account", nil)
+		utils.WriteJSONError(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, user, models.AuditAccountDelete, user, "")
+
+	utils.LogInfoContext(r.Context(), "Account deleted", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Account deleted",
+	}, http.StatusOK)
+}
+
+// confirmPassword checks the current password of the logged in user before a sensitive change,
+// with the same brute-force protection as the login. Accounts created with an identity provider
+// or a passkey have no password; for them a login within recentLoginWindow confirms the change
+// instead. On failure an error response is written.
+//
+// Returns:
+//   - bool: True if the password is correct, or the passwordless user logged in recently.
+func (h *Handler) confirmPassword(w http.ResponseWriter, r *http.Request, endpoint string, user *models.User, password string) bool {
+	if user.PasswordHash == "" {
+		if !middlewares.AuthenticatedSince(r.Context(), recentLoginWindow) {
+			utils.LogWarnContext(r.Context(), "Passwordless account did not log in recently", nil)
+			utils.WriteJSONError(w, recentLoginRequiredMessage, http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if password == "" {
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return false
+	}
+
+	keys := h.attemptKeys(r, user.Username)
+	if h.rejectIfLockedOut(w, r, endpoint, keys) {
+		return false
+	}
+
+	if !security.CheckPasswordHash(r.Context(), password, user.PasswordHash) {
+		h.registerFailedAttempt(r, keys)
+		utils.LogWarnContext(r.Context(), "Invalid password confirmation", nil)
+		utils.WriteJSONError(w, "Invalid password", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// writeProfile writes the profile of the user as the response
+func writeProfile(w http.ResponseWriter, user *models.User) {
+	utils.JSONSuccess(w, map[string]interface{}{
+		"username":              user.Username,
+		"email":                 user.Email,
+		"email_verified":        user.VerifiedAt != nil,
+		"created_at":            user.CreatedAt,
+		"last_login":            user.LastLogin,
+		"two_factor_enabled":    services.TOTPEnabled(user),
+		"passkey_second_factor": user.PasskeySecondFactor,
+	}, http.StatusOK)
+}
@@ -5,11 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
 	"github.com/CEM-KEA/whoknows/backend/internal/models"
 	"github.com/CEM-KEA/whoknows/backend/internal/security"
-	"github.com/CEM-KEA/whoknows/backend/internal/services"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/go-playground/validator/v10"
 	"github.com/sirupsen/logrus"
@@ -18,7 +17,7 @@ import (
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=100"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6"`
+	Password string `json:"password" validate:"required"`
 	// Password2 is used to confirm the password, it is optional, so it is omitted if it is not provided or an empty string
 	// If it is provided, it must be equal to the Password field
 	Password2 string `json:"password2" validate:"omitempty,eqfield=Password"`
@@ -33,20 +32,22 @@ type RegisterRequest struct {
 //	@Param			register	body		RegisterRequest	true	"User data"
 //	@Success		201			{string}	string			"User created successfully"
 //	@Failure		400			{string}	string			"Validation error"
+//	@Failure		400			{object}	handlers.PasswordPolicyErrorResponse	"Password does not meet the password policy"
+//	@Failure		429			{string}	string			"Too many requests"
 //	@Failure		500			{string}	string			"Failed to create user"
 //	@Router			/api/register [post]
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing register request", nil)
+func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing register request", nil)
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.LogError(err, "Failed to decode request body", nil)
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
 		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 	utils.SanitizeStruct(&req)
 
 	if err := utils.Validate(req); err != nil {
-		utils.LogWarn("Request validation failed", logrus.Fields{"error": err.Error()})
+		utils.LogWarnContext(r.Context(), "Request validation failed", logrus.Fields{"error": err.Error()})
 
 		var validationErrors []string
 		for _, err := range err.(validator.ValidationErrors) {
@@ -58,31 +59,41 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user := models.User{
+		Username: req.Username,
+		Email:    req.Email,
+	}
+
+	if h.rejectIfPasswordViolatesPolicy(w, r, &user, req.Password) {
+		return
+	}
+
 	hashedPassword, err := security.HashPassword(req.Password)
 	if err != nil {
-		utils.LogError(err, "Failed to hash password", nil)
+		utils.LogErrorContext(r.Context(), err, "Failed to hash password", nil)
 		utils.WriteJSONError(w, "Failed to process password", http.StatusInternalServerError)
 		return
 	}
 
-	user := models.User{
-		Username:     req.Username,
-		Email:        req.Email,
-		PasswordHash: hashedPassword,
-	}
+	now := time.Now()
+	user.PasswordHash = hashedPassword
+	user.PasswordChangedAt = &now
 
-	if err := services.CreateUser(database.DB, &user); err != nil {
-		utils.LogError(err, "Failed to create user in database", nil)
+	if err := h.Users.Create(r.Context(), &user); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to create user in database", nil)
 		utils.WriteJSONError(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
 	utils.IncrementUserRegistrations()
 
+	// The account is usable right away; a failed email only means the user has to verify later
+	_ = h.sendVerificationEmail(r.Context(), &user)
+
 	utils.JSONSuccess(w, map[string]interface{}{
 		"status":  "success",
 		"message": "User created successfully",
 	}, http.StatusCreated)
 
-	utils.LogInfo("User registered successfully", nil)
+	utils.LogInfoContext(r.Context(), "User registered successfully", nil)
 }
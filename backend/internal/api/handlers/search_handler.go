@@ -3,9 +3,8 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
 	"github.com/CEM-KEA/whoknows/backend/internal/models"
-	"github.com/CEM-KEA/whoknows/backend/internal/services"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/sirupsen/logrus"
 )
@@ -23,28 +22,33 @@ type RequestValidationError struct {
 
 // Search is the handler for the search API
 //
-//	@Description	Search for pages by content
+//	@Description	Search for pages by content. When a valid Bearer token or an API key with the search scope is sent, the query is saved to the user's search history.
 //	@Produce		json
+//	@Security		Bearer
+//	@Security		ApiKey
 //	@Param			q			query		string	true	"Search query"
 //	@Param			language	query		string	false	"Language filter"
 //	@Success		200			{object}	SearchResponse
 //	@Failure		400			{string}	string	"Search query (q) is required"
+//	@Failure		401			{string}	string	"Invalid API key"
+//	@Failure		403			{string}	string	"Insufficient API key scope"
+//	@Failure		429			{string}	string	"API key or search rate limit exceeded"
 //	@Failure		500			{string}	string	"Search query failed"
 //	@Router			/api/search [get]
-func Search(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing search request", nil)
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing search request", nil)
 	q := utils.SanitizeValue(r.URL.Query().Get("q"))
 	language := utils.SanitizeValue(r.URL.Query().Get("language"))
 
 	if q == "" {
-		utils.LogWarn("Search query validation failed", nil)
+		utils.LogWarnContext(r.Context(), "Search query validation failed", nil)
 		utils.WriteJSONError(w, "Search query (q) is required", http.StatusBadRequest)
 		return
 	}
 
-	searchLog := models.SearchLog{Query: q}
-	if err := services.CreateSearchLog(database.DB, &searchLog); err != nil {
-		utils.LogError(err, "Failed to log search query", nil)
+	searchLog := models.SearchLog{Query: q, UserID: h.searchHistoryUserID(r)}
+	if err := h.SearchLogs.Create(r.Context(), &searchLog); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to log search query", nil)
 		utils.WriteJSONError(w, "Failed to log search query", http.StatusInternalServerError)
 		return
 	}
@@ -55,13 +59,9 @@ func Search(w http.ResponseWriter, r *http.Request) {
 	}
 	utils.IncrementSearchQueries(queryType)
 
-	var pages []models.Page
-	query := database.DB.Where("content LIKE ?", "%"+q+"%").Order("title ASC")
-	if language != "" {
-		query = query.Where("language = ?", language)
-	}
-	if err := query.Find(&pages).Error; err != nil {
-		utils.LogError(err, "Search query execution failed", nil)
+	pages, err := h.Pages.Search(r.Context(), q, language)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Search query execution failed", nil)
 		utils.WriteJSONError(w, "Search query failed", http.StatusInternalServerError)
 		return
 	}
@@ -87,9 +87,34 @@ func Search(w http.ResponseWriter, r *http.Request) {
 		"results": len(response.Data),
 	}, http.StatusOK)
 
-	utils.LogInfo("Search query completed successfully", logrus.Fields{
+	utils.LogInfoContext(r.Context(), "Search query completed successfully", logrus.Fields{
 		"query":    q,
 		"language": language,
 		"results":  len(pages),
 	})
 }
+
+// searchHistoryUserID returns the ID of the user the search should be recorded for,
+// or nil for anonymous searches. Authentication is optional on the search endpoint:
+// the OptionalAuthMiddleware adds the user of a valid session to the context, and the
+// APIKeyMiddleware the owner of an API key, so searches with an API key are recorded for them.
+// Users that have not verified their email do not get a search history when
+// API_AUTH_REQUIRE_VERIFIED_EMAIL is enabled.
+func (h *Handler) searchHistoryUserID(r *http.Request) *uint {
+	userID, ok := r.Context().Value(middlewares.UserKey).(uint)
+	if !ok {
+		return nil
+	}
+
+	return h.searchHistoryUser(r, userID)
+}
+
+// searchHistoryUser returns the ID of the user if searches should be recorded for them
+func (h *Handler) searchHistoryUser(r *http.Request, userID uint) *uint {
+	user, err := h.Users.FindByID(r.Context(), userID)
+	if err != nil || h.emailVerificationRequired(user) {
+		return nil
+	}
+
+	return &user.ID
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// setSessionCookies stores a session token in the HttpOnly session cookie, and its CSRF
+// token in a cookie the frontend can read. Both expire with the token.
+//
+// Returns:
+//   - string: The CSRF token of the session.
+func (h *Handler) setSessionCookies(w http.ResponseWriter, token string, expiresAt time.Time) string {
+	csrfToken := security.CSRFToken(h.Config.JWT, token)
+	http.SetCookie(w, h.sessionCookie(middlewares.SessionCookieName, token, expiresAt, true))
+	http.SetCookie(w, h.sessionCookie(middlewares.CSRFCookieName, csrfToken, expiresAt, false))
+	return csrfToken
+}
+
+// clearSessionCookies removes the session cookies from the browser
+func (h *Handler) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{middlewares.SessionCookieName, middlewares.CSRFCookieName} {
+		cookie := h.sessionCookie(name, "", time.Unix(0, 0), name == middlewares.SessionCookieName)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// sessionCookie builds a session cookie with the attributes from the session configuration
+func (h *Handler) sessionCookie(name, value string, expiresAt time.Time, httpOnly bool) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch h.Config.Session.CookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   h.Config.Session.CookieDomain,
+		Expires:  expiresAt,
+		Secure:   h.Config.Session.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
+
+// requestSessionToken returns the session token of the request, from the Authorization
+// header or, with cookie sessions, the session cookie, and writes a 401 response if there is none.
+//
+// Returns:
+//   - string: The session token.
+//   - bool: Whether the token came from the session cookie.
+//   - bool: False if a response has been written.
+func (h *Handler) requestSessionToken(w http.ResponseWriter, r *http.Request) (string, bool, bool) {
+	token, fromCookie, err := middlewares.SessionToken(r, h.Config.Session.CookieSessions())
+	if errors.Is(err, middlewares.ErrNoSessionToken) {
+		utils.WriteJSONError(w, "No Authorization header found", http.StatusUnauthorized)
+		return "", false, false
+	}
+	if err != nil {
+		utils.WriteJSONError(w, err.Error(), http.StatusUnauthorized)
+		return "", false, false
+	}
+	return token, fromCookie, true
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+)
+
+type TOTPEnrollResponse struct {
+	Status     string `json:"status"`
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type TOTPConfirmResponse struct {
+	Status        string   `json:"status"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TOTPDisableRequest struct {
+	// Password is the current password, required if the account has one
+	Password string `json:"password"`
+}
+
+// TOTPEnrollHandler starts two-factor enrollment
+//
+//	@Summary Start two-factor enrollment
+//	@Description Generates a TOTP secret for the logged in user. Two-factor authentication is enabled once a first code is confirmed at /api/2fa/confirm.
+//	@Tags Two-factor authentication
+//	@Security Bearer
+//	@Produce json
+//	@Success 200 {object} handlers.TOTPEnrollResponse "Secret and otpauth URI"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 409 {object} map[string]string "Two-factor authentication is already enabled"
+//	@Failure 500 {object} map[string]string "Failed to start enrollment"
+//	@Router /api/2fa/enroll [post]
+func (h *Handler) TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing two-factor enrollment request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := services.BeginTOTPEnrollment(h.db(r), user)
+	if errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+		utils.WriteJSONError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to start two-factor enrollment", nil)
+		utils.WriteJSONError(w, "Failed to start enrollment", http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":      "success",
+		"secret":      secret,
+		"otpauth_uri": uri,
+	}, http.StatusOK)
+}
+
+// TOTPConfirmHandler completes two-factor enrollment
+//
+//	@Summary Confirm two-factor enrollment
+//	@Description Enables two-factor authentication after verifying a first code from the authenticator app, and returns single-use recovery codes. The recovery codes are only shown once.
+//	@Tags Two-factor authentication
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param totpConfirmRequest body handlers.TOTPConfirmRequest true "First TOTP code"
+//	@Success 200 {object} handlers.TOTPConfirmResponse "Recovery codes"
+//	@Failure 400 {object} map[string]string "Invalid code or enrollment not started"
+//	@Failure 401 {object} map[string]string "Unauthorized"
+//	@Failure 409 {object} map[string]string "Two-factor authentication is already enabled"
+//	@Failure 500 {object} map[string]string "Failed to enable two-factor authentication"
+//	@Router /api/2fa/confirm [post]
+func (h *Handler) TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing two-factor confirmation request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := services.ConfirmTOTPEnrollment(h.db(r), user, request.Code)
+	switch {
+	case errors.Is(err, services.ErrTOTPAlreadyEnabled):
+		utils.WriteJSONError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrTOTPNotEnrolled):
+		utils.WriteJSONError(w, "Two-factor enrollment has not been started", http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrInvalidSecondFactor):
+		utils.WriteJSONError(w, "Invalid two-factor authentication code", http.StatusBadRequest)
+		return
+	case err != nil:
+		utils.LogErrorContext(r.Context(), err, "Failed to enable two-factor authentication", nil)
+		utils.WriteJSONError(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":         "success",
+		"recovery_codes": recoveryCodes,
+	}, http.StatusOK)
+}
+
+// TOTPDisableHandler turns off two-factor authentication
+//
+//	@Summary Disable two-factor authentication
+//	@Description Disables two-factor authentication for the logged in user and deletes their recovery codes. The current password is required; accounts without a password must have logged in within the last 5 minutes instead.
+//	@Tags Two-factor authentication
+//	@Security Bearer
+//	@Accept json
+//	@Produce json
+//	@Param totpDisableRequest body handlers.TOTPDisableRequest true "Current password"
+//	@Success 200 {object} map[string]string "Two-factor authentication disabled"
+//	@Failure 400 {object} map[string]string "Two-factor authentication is not enabled"
+//	@Failure 401 {object} map[string]string "Invalid password"
+//	@Failure 403 {object} map[string]string "The account has no password, log in again to confirm this change"
+//	@Failure 429 {object} map[string]string "Too many failed attempts"
+//	@Failure 500 {object} map[string]string "Failed to disable two-factor authentication"
+//	@Router /api/2fa/disable [post]
+func (h *Handler) TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing two-factor disable request", nil)
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request TOTPDisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to decode request body", nil)
+		utils.WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	utils.SanitizeStruct(&request)
+
+	if err := utils.Validate(request); err != nil {
+		utils.LogErrorContext(r.Context(), err, "Request validation failed", nil)
+		utils.WriteJSONError(w, "Invalid input data", http.StatusBadRequest)
+		return
+	}
+
+	if !h.confirmPassword(w, r, "/api/2fa/disable", user, request.Password) {
+		return
+	}
+
+	err := services.DisableTOTP(h.db(r), user)
+	if errors.Is(err, services.ErrTOTPNotEnabled) {
+		utils.WriteJSONError(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), err, "Failed to disable two-factor authentication", nil)
+		utils.WriteJSONError(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	utils.LogInfoContext(r.Context(), "Two-factor authentication disabled", nil)
+	utils.JSONSuccess(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Two-factor authentication disabled",
+	}, http.StatusOK)
+}
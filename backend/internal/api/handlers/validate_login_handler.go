@@ -2,14 +2,12 @@ package handlers
 
 import (
 	"net/http"
-	"strings"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
 	"github.com/CEM-KEA/whoknows/backend/internal/security"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 )
 
-//	@Description	Validates the jwt token
+//	@Description	Validates the jwt token, sent as a Bearer token or in the session cookie
 //	@Tags Authentication
 //	@Security		Bearer
 //	@Success		200	{string}	string	"valid"
@@ -20,43 +18,32 @@ import (
 //	@Router			/api/validate-login [get]
 //
 // Handler for validating the jwt token
-func ValidateLoginHandler(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing validate login request", nil)
-
-	// Extract and validate Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		utils.LogWarn("Authorization header is missing", nil)
-		utils.WriteJSONError(w, "No Authorization header found", http.StatusUnauthorized)
-		return
-	}
+func (h *Handler) ValidateLoginHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing validate login request", nil)
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		utils.LogWarn("Invalid Authorization header format", nil)
-		utils.WriteJSONError(w, "Invalid Authorization header format", http.StatusUnauthorized)
+	tokenString, _, ok := h.requestSessionToken(w, r)
+	if !ok {
 		return
 	}
 
-	tokenString := parts[1]
-
-	_, err := security.ValidateJWT(tokenString)
+	claims, err := security.ValidateJWT(r.Context(), h.Config.JWT, tokenString)
 	if err != nil {
-		utils.LogWarn("Invalid JWT token", nil)
+		utils.LogWarnContext(r.Context(), "Invalid JWT token", nil)
 		utils.WriteJSONError(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
 
-	err = security.ValidateJWTRevoked(database.DB, tokenString)
+	err = security.ValidateJWTRevoked(r.Context(), h.Tokens, tokenString)
 	if err != nil {
-		utils.LogWarn("JWT token expired or revoked", nil)
+		utils.LogWarnContext(r.Context(), "JWT token expired or revoked", nil)
 		utils.WriteJSONError(w, "Token expired/revoked", http.StatusUnauthorized)
 		return
 	}
 
 	utils.JSONSuccess(w, map[string]interface{}{
-		"status": "valid",
+		"status":                  "valid",
+		"require_password_change": security.TokenScope(claims) == security.ScopePasswordChange,
 	}, http.StatusOK)
 
-	utils.LogInfo("Token validation successful - user is logged in", nil)
+	utils.LogInfoContext(r.Context(), "Token validation successful - user is logged in", nil)
 }
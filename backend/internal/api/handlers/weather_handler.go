@@ -1,21 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/cache"
-	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/tracing"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
-// We cache the weather data for 1 hour to reduce amount of calls to the API,
-// as we only have 1000 free calls per day
-var WeatherCache = cache.NewCache()
-
 type WeatherResponse struct {
 	Data map[string]interface{} `json:"data"`
 }
@@ -24,9 +20,15 @@ const (
 	fetchDataError       = "Failed to fetch weather data"
 	encodeResponseError  = "Failed to encode weather response"
 	weatherDataCacheKey  = "weatherData"
+	// We cache the weather data for 1 hour to reduce amount of calls to the API,
+	// as we only have 1000 free calls per day
 	weatherDataCacheTime = 1 * time.Hour
 )
 
+// weatherClient calls the OpenWeather API, tracing each call in a span of the request it is
+// made for
+var weatherClient = &http.Client{Transport: tracing.Transport(nil)}
+
 // WeatherResponse represents the weather response payload
 //	@Description	Get weather information
 //	@Produce		json
@@ -34,11 +36,11 @@ const (
 //	@Failure		500	{string}	string	"Failed to fetch weather data"
 //	@Router			/api/weather [get]
 // handler for GET request to /api/weather
-func WeatherHandler(w http.ResponseWriter, r *http.Request) {
-	utils.LogInfo("Processing weather request", nil)
-	data, err := GetWeatherData()
+func (h *Handler) WeatherHandler(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfoContext(r.Context(), "Processing weather request", nil)
+	data, err := h.FetchWeatherData(r.Context())
 	if err != nil {
-		utils.LogError(err, fetchDataError, nil)
+		utils.LogErrorContext(r.Context(), err, fetchDataError, nil)
 		utils.WriteJSONError(w, fetchDataError, http.StatusInternalServerError)
 		return
 	}
@@ -48,7 +50,7 @@ func WeatherHandler(w http.ResponseWriter, r *http.Request) {
 		"data":   data,
 	}, http.StatusOK)
 
-	utils.LogInfo("Weather data fetched and response sent successfully", nil)
+	utils.LogInfoContext(r.Context(), "Weather data fetched and response sent successfully", nil)
 }
 
 
@@ -72,31 +74,41 @@ func WeatherHandler(w http.ResponseWriter, r *http.Request) {
 // Returns:
 // - map[string]interface{}: The weather data fetched from the API.
 // - error: An error if any occurred during the process.
-func GetWeatherData() (map[string]interface{}, error) {
-	utils.LogInfo("Fetching weather data", nil)
+func (h *Handler) GetWeatherData() (map[string]interface{}, error) {
+	return h.FetchWeatherData(h.Context(context.Background()))
+}
+
+// FetchWeatherData is GetWeatherData with a context, so the request can be cancelled or given
+// a deadline, as the health check does.
+func (h *Handler) FetchWeatherData(ctx context.Context) (map[string]interface{}, error) {
+	utils.LogInfoContext(ctx, "Fetching weather data", nil)
 	baseURL := "https://api.openweathermap.org/data/2.5/weather"
-	queryParams := fmt.Sprintf("q=Copenhagen&appid=%s", config.AppConfig.WeatherAPI.OpenWeatherAPIKey)
+	queryParams := fmt.Sprintf("q=Copenhagen&appid=%s", h.Config.WeatherAPI.OpenWeatherAPIKey)
 	fullURL := fmt.Sprintf("%s?%s", baseURL, queryParams)
 
-	utils.LogInfo("Sending request to OpenWeather API", logrus.Fields{
+	utils.LogInfoContext(ctx, "Sending request to OpenWeather API", logrus.Fields{
 		"url": fmt.Sprintf("%s?q=Copenhagen", baseURL),
 	})
 
-	res, err := http.Get(fullURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		utils.LogError(err, "Failed to fetch weather data from API", logrus.Fields{"url": baseURL})
+		return nil, err
+	}
+	res, err := weatherClient.Do(req)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to fetch weather data from API", logrus.Fields{"url": baseURL})
 		return nil, err
 	}
 	defer res.Body.Close()
 
 	var weatherData map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&weatherData); err != nil {
-		utils.LogError(err, "Failed to decode weather API response", nil)
+		utils.LogErrorContext(ctx, err, "Failed to decode weather API response", nil)
 		return nil, err
 	}
 
-	WeatherCache.Set(weatherDataCacheKey, weatherData, weatherDataCacheTime)
-	utils.LogInfo("Weather data fetched and stored in cache", nil)
+	h.Cache.Set(ctx, weatherDataCacheKey, weatherData, weatherDataCacheTime)
+	utils.LogInfoContext(ctx, "Weather data fetched and stored in cache", nil)
 
 	return weatherData, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// AccessLogMiddleware logs a single line for every request once it has been served, with the
+// method, the template of the route in router that matched it, the status code, the latency in
+// milliseconds and the size of the response body. The request ID and the user the request is
+// made by, if any, are added from the request context, so the middleware must be applied inside
+// RequestIDMiddleware.
+//
+// The route template is logged rather than the path, as paths can hold tokens. The requests of
+// the health probes and the metrics scraper are logged at debug level only.
+//
+// Parameters:
+// - router: The router serving the requests, to find the route templates in.
+//
+// Returns:
+// - func(http.Handler) http.Handler: The middleware.
+func AccessLogMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(wrappedWriter, r)
+
+			fields := logrus.Fields{
+				"method":     utils.HTTPMethodLabel(r.Method),
+				"route":      RouteTemplate(router, r),
+				"status":     wrappedWriter.status,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"bytes":      wrappedWriter.size,
+			}
+			if utils.IsProbePath(r.URL.Path) {
+				utils.LogDebugContext(r.Context(), "Request served", fields)
+				return
+			}
+			utils.LogInfoContext(r.Context(), "Request served", fields)
+		})
+	}
+}
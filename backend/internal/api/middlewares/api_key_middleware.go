@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// APIKeyHeader is the request header API keys are sent in
+const APIKeyHeader = "X-API-Key"
+
+const APIKeyIDKey contextKey = "apiKeyID"
+
+// APIKeyRateKey holds the config.Rate of the API key of a request, which the RateLimitMiddleware
+// applies to it instead of the rate of the route
+const APIKeyRateKey contextKey = "apiKeyRate"
+
+// APIKeyMiddleware authenticates requests that carry an X-API-Key header. The key must be
+// active and grant the given scope; the user ID, key ID and the per minute rate limit of the key
+// are then added to the request context, like the AuthMiddleware does for JWTs. The limit is
+// applied by the RateLimitMiddleware.
+// Requests without the header are passed to fallback, for example the AuthMiddleware, or
+// straight to the handler if fallback is nil.
+//
+// Parameters:
+//   - db: A gorm.DB instance for database operations.
+//   - scope: The scope the key must have.
+//   - fallback: The middleware for requests without an API key, or nil to let them through.
+//
+// Returns:
+//   - func(http.Handler) http.Handler: A middleware that wraps an http.Handler and performs API key authentication.
+func APIKeyMiddleware(db *gorm.DB, scope string, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withoutKey := next
+		if fallback != nil {
+			withoutKey = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			plainKey := r.Header.Get(APIKeyHeader)
+			if plainKey == "" {
+				withoutKey.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := services.AuthenticateAPIKey(db.WithContext(r.Context()), plainKey)
+			if err != nil {
+				if !errors.Is(err, services.ErrInvalidAPIKey) {
+					utils.LogErrorContext(r.Context(), err, "Failed to authenticate API key", nil)
+				}
+				utils.LogWarnContext(r.Context(), "Invalid API key", nil)
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := services.GetUserByID(db.WithContext(r.Context()), key.UserID)
+			if err != nil {
+				http.Error(w, "User not found", http.StatusUnauthorized)
+				return
+			}
+			if user.MustChangePassword {
+				utils.LogWarnContext(r.Context(), "API key of a user that must change their password", nil)
+				http.Error(w, "Password change required", http.StatusForbidden)
+				return
+			}
+
+			if !services.APIKeyHasScope(key, scope) {
+				utils.LogWarnContext(r.Context(), "API key scope does not allow this request", nil)
+				http.Error(w, "Insufficient API key scope", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserKey, key.UserID)
+			ctx = context.WithValue(ctx, APIKeyIDKey, key.ID)
+			ctx = context.WithValue(ctx, APIKeyRateKey, config.Rate{Requests: key.RateLimit, Period: time.Minute})
+			utils.SetLogUserID(ctx, key.UserID)
+			utils.LogDebugContext(ctx, "API key authenticated successfully", nil)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
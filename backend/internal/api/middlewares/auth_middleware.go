@@ -4,8 +4,10 @@ import (
 	"context"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
 	"github.com/CEM-KEA/whoknows/backend/internal/services"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/pkg/errors"
@@ -16,115 +18,211 @@ type contextKey string
 
 const UserKey contextKey = "userID"
 
+// AuthTimeKey holds the time the session token of a request was issued, that is when the user logged in
+const AuthTimeKey contextKey = "authTime"
+
 // AuthMiddleware is a middleware function for handling authentication.
 // It extracts the JWT token from the request, validates it, and retrieves the user ID from the token claims.
+// The token is taken from a Bearer Authorization header or, if cookieSessions is set, from the session cookie;
+// state-changing requests with the cookie must also carry the CSRF token of the session.
 // If the token is valid and the user exists in the database, the request is allowed to proceed with the user ID added to the context.
 // Otherwise, it responds with an appropriate error message and status code.
 //
 // Parameters:
 //   - db: A gorm.DB instance for database operations.
-//   - validateJWT: A function that takes a JWT token string and returns the token claims as a map and an error, if any.
+//   - validateJWT: A function that takes the request context and a JWT token string and returns the token claims as a map and an error, if any.
+//   - jwtConfig: The JWT configuration, whose secret the CSRF tokens are derived with.
+//   - cookieSessions: Whether the session cookie is accepted besides the Authorization header.
 //
 // Returns:
 //   A middleware function that wraps an http.Handler and performs authentication.
-func AuthMiddleware(db *gorm.DB, validateJWT func(token string) (map[string]interface{}, error)) func(http.Handler) http.Handler {
-	utils.LogInfo("Setting up authentication middleware", nil)
+func AuthMiddleware(db *gorm.DB, validateJWT func(ctx context.Context, token string) (map[string]interface{}, error), jwtConfig config.JWTConfig, cookieSessions bool) func(http.Handler) http.Handler {
+	return ScopedAuthMiddleware(db, validateJWT, jwtConfig, "", cookieSessions)
+}
 
+// ScopedAuthMiddleware works like AuthMiddleware, but besides tokens with full access it also accepts
+// limited tokens whose "scope" claim equals allowedScope. Limited tokens with any other scope are
+// rejected with 403 Forbidden; AuthMiddleware rejects all of them.
+//
+// Parameters:
+//   - db: A gorm.DB instance for database operations.
+//   - validateJWT: A function that takes the request context and a JWT token string and returns the token claims as a map and an error, if any.
+//   - jwtConfig: The JWT configuration, whose secret the CSRF tokens are derived with.
+//   - allowedScope: The limited token scope accepted in addition to full access, or an empty string for none.
+//   - cookieSessions: Whether the session cookie is accepted besides the Authorization header.
+//
+// Returns:
+//   A middleware function that wraps an http.Handler and performs authentication.
+func ScopedAuthMiddleware(db *gorm.DB, validateJWT func(ctx context.Context, token string) (map[string]interface{}, error), jwtConfig config.JWTConfig, allowedScope string, cookieSessions bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token, err := extractToken(r)
+			token, fromCookie, err := SessionToken(r, cookieSessions)
 			if err != nil {
-				utils.LogWarn("Failed to extract token", logSanitizedError(err))
+				utils.LogWarnContext(r.Context(), "Failed to extract token", logSanitizedError(err))
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
+			if fromCookie && !CSRFValid(r, jwtConfig, token) {
+				utils.LogWarnContext(r.Context(), "Missing or invalid CSRF token", nil)
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
 
-			claims, err := validateJWT(token)
+			claims, err := validateJWT(r.Context(), token)
 			if err != nil {
-				utils.LogWarn("Invalid token", logSanitizedError(err))
+				utils.LogWarnContext(r.Context(), "Invalid token", logSanitizedError(err))
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			userID, err := extractUserID(claims)
+			if scope := security.TokenScope(claims); scope != "" && scope != allowedScope {
+				utils.LogWarnContext(r.Context(), "Token scope does not allow this request", nil)
+				if scope == security.ScopePasswordChange {
+					http.Error(w, "Password change required", http.StatusForbidden)
+				} else {
+					http.Error(w, "Insufficient token scope", http.StatusForbidden)
+				}
+				return
+			}
+
+			userID, err := extractUserID(r.Context(), claims)
 			if err != nil {
-				utils.LogWarn("Invalid user ID in token", logSanitizedError(err))
+				utils.LogWarnContext(r.Context(), "Invalid user ID in token", logSanitizedError(err))
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			_, err = services.GetUserByID(db, uint(userID))
+			_, err = services.GetUserByID(db.WithContext(r.Context()), uint(userID))
 			if err != nil {
-				utils.LogWarn("User not found", utils.SanitizeFields(map[string]interface{}{"userID": userID, "error": err.Error()}))
+				utils.LogWarnContext(r.Context(), "User not found", utils.SanitizeFields(map[string]interface{}{"userID": userID, "error": err.Error()}))
 				http.Error(w, "User not found", http.StatusUnauthorized)
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), UserKey, uint(userID))
-			utils.LogInfo("User authenticated successfully", utils.SanitizeFields(map[string]interface{}{"userID": userID}))
+			if issuedAt, ok := claims["iat"].(float64); ok {
+				ctx = context.WithValue(ctx, AuthTimeKey, time.Unix(int64(issuedAt), 0))
+			}
+			utils.SetLogUserID(ctx, uint(userID))
+			utils.LogDebugContext(ctx, "User authenticated successfully", nil)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// extractToken extracts the token from the Authorization header of the given HTTP request.
-// It expects the Authorization header to be in the format "Bearer <token>".
-// If the header is missing or not in the correct format, it returns an error.
+// OptionalAuthMiddleware adds the user ID to the request context like AuthMiddleware, for routes
+// that anonymous clients may also use. Requests without a token, or with a token that is
+// invalid, limited to a scope or not accompanied by a valid CSRF token, continue anonymously.
 //
 // Parameters:
-//   - r: The HTTP request from which to extract the token.
+//   - db: A gorm.DB instance for database operations.
+//   - validateJWT: A function that takes the request context and a JWT token string and returns the token claims as a map and an error, if any.
+//   - jwtConfig: The JWT configuration, whose secret the CSRF tokens are derived with.
+//   - cookieSessions: Whether the session cookie is accepted besides the Authorization header.
 //
 // Returns:
-//   - string: The extracted token.
-//   - error: An error if the Authorization header is missing or not in the correct format.
-func extractToken(r *http.Request) (string, error) {
-	utils.LogInfo("Extracting token from Authorization header", nil)
-
-	authHeader := utils.SanitizeValue(r.Header.Get("Authorization"))
-	if authHeader == "" {
-		utils.LogWarn("Authorization header is missing", nil)
-		return "", errors.New("Authorization header is required")
-	}
+//   A middleware function that wraps an http.Handler and performs optional authentication.
+func OptionalAuthMiddleware(db *gorm.DB, validateJWT func(ctx context.Context, token string) (map[string]interface{}, error), jwtConfig config.JWTConfig, cookieSessions bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, fromCookie, err := SessionToken(r, cookieSessions)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		utils.LogWarn("Invalid Authorization header format", nil)
-		return "", errors.New("Invalid Authorization header format")
-	}
+			claims, err := validateJWT(r.Context(), token)
+			if err != nil || (fromCookie && !CSRFValid(r, jwtConfig, token)) || security.TokenScope(claims) != "" {
+				utils.LogInfoContext(r.Context(), "Ignoring invalid token on optionally authenticated request", nil)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, err := extractUserID(r.Context(), claims)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if _, err := services.GetUserByID(db.WithContext(r.Context()), uint(userID)); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-	utils.LogInfo("Token extracted successfully", nil)
-	return parts[1], nil
+			ctx := context.WithValue(r.Context(), UserKey, uint(userID))
+			utils.SetLogUserID(ctx, uint(userID))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 // extractUserID extracts the user ID from the given JWT claims.
-// It expects the user ID to be stored under the "sub" key as a string.
+// It expects the user ID to be stored under the "sub" key, either as a string or,
+// as in the session tokens issued by security.GenerateJWT, as a number.
 // If the user ID is not found or cannot be parsed, it returns an error.
 //
 // Parameters:
+//   - ctx: The context of the request, which failures are logged with.
 //   - claims: A map containing JWT claims.
 //
 // Returns:
 //   - uint64: The extracted user ID.
 //   - error: An error if the user ID is not found or cannot be parsed.
-func extractUserID(claims map[string]interface{}) (uint64, error) {
-	utils.LogInfo("Extracting user ID from JWT claims", nil)
-
-	userIDStr, ok := claims["sub"].(string)
-	if !ok {
-		utils.LogWarn("User ID not found in claims", nil)
+func extractUserID(ctx context.Context, claims map[string]interface{}) (uint64, error) {
+	var userIDStr string
+	switch sub := claims["sub"].(type) {
+	case string:
+		userIDStr = sub
+	case float64:
+		userIDStr = strconv.FormatFloat(sub, 'f', -1, 64)
+	default:
+		utils.LogWarnContext(ctx, "User ID not found in claims", nil)
 		return 0, errors.New("User ID not found in token claims")
 	}
 
 	userID, err := strconv.ParseUint(userIDStr, 10, 32)
 	if err != nil {
-		utils.LogWarn("Failed to parse user ID", utils.SanitizeFields(map[string]interface{}{"userIDStr": userIDStr, "error": err.Error()}))
+		utils.LogWarnContext(ctx, "Failed to parse user ID", utils.SanitizeFields(map[string]interface{}{"userIDStr": userIDStr, "error": err.Error()}))
 		return 0, errors.Wrap(err, "Failed to parse user ID")
 	}
 
-	utils.LogInfo("User ID extracted successfully", utils.SanitizeFields(map[string]interface{}{"userID": userID}))
 	return userID, nil
 }
 
+// RequireRole is a middleware that only lets users with the given role through.
+// It must be used after the AuthMiddleware, which puts the user ID in the request context.
+//
+// Parameters:
+//   - db: A gorm.DB instance for database operations.
+//   - role: The role the user must have.
+//
+// Returns:
+//   A middleware function that wraps an http.Handler and performs the role check.
+func RequireRole(db *gorm.DB, role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := GetUserIDFromContext(r.Context())
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := services.GetUserByID(db.WithContext(r.Context()), userID)
+			if err != nil {
+				http.Error(w, "User not found", http.StatusUnauthorized)
+				return
+			}
+
+			if user.Role != role {
+				utils.LogWarnContext(r.Context(), "User does not have the required role", nil)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // GetUserIDFromContext retrieves the user ID from the given context.
 // It expects the user ID to be stored in the context with the key UserKey.
 // If the user ID is found, it returns the user ID and a nil error.
@@ -139,18 +237,29 @@ func extractUserID(claims map[string]interface{}) (uint64, error) {
 //	uint: The user ID retrieved from the context.
 //	error: An error if the user ID is not found in the context.
 func GetUserIDFromContext(ctx context.Context) (uint, error) {
-	utils.LogInfo("Getting user ID from request context", nil)
-
 	userID, ok := ctx.Value(UserKey).(uint)
 	if !ok {
-		utils.LogWarn("User ID not found in context", nil)
+		utils.LogWarnContext(ctx, "User ID not found in context", nil)
 		return 0, errors.New("User ID not found in context")
 	}
 
-	utils.LogInfo("User ID retrieved successfully", utils.SanitizeFields(map[string]interface{}{"userID": userID}))
 	return userID, nil
 }
 
+// AuthenticatedSince reports whether the user of the request logged in, with the session token
+// the request carries, no longer than maxAge ago.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - maxAge: How long ago the login may have been.
+//
+// Returns:
+//   - bool: True if the login is recent enough.
+func AuthenticatedSince(ctx context.Context, maxAge time.Duration) bool {
+	authTime, ok := ctx.Value(AuthTimeKey).(time.Time)
+	return ok && time.Since(authTime) <= maxAge
+}
+
 // logSanitizedError takes an error as input, sanitizes its message using utils.SanitizeValue,
 // and returns a map containing the sanitized error message with the key "error".
 func logSanitizedError(err error) map[string]interface{} {
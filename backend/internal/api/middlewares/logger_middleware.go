@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// LoggerMiddleware puts logger in the request context, so that every entry logged with the
+// context, by the handlers, services and the other middlewares, is written to it. It must
+// wrap all other middlewares.
+//
+// Parameters:
+// - logger: The logger of the App serving the request.
+//
+// Returns:
+// - func(http.Handler) http.Handler: A middleware that attaches the logger and then delegates to the next handler.
+func LoggerMiddleware(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(utils.WithLogger(r.Context(), logger)))
+		})
+	}
+}
@@ -5,68 +5,98 @@ import (
 	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/gorilla/mux"
 )
 
 // MetricsMiddleware is a middleware that collects and records various metrics
-// about HTTP requests and responses. It tracks the number of active requests,
-// the duration of each request, the size of the request body, and the size of
-// the response body.
+// about HTTP requests and responses. It tracks the number of requests in flight,
+// the number of requests and errors by status code, the duration of each request,
+// the size of the request body, and the size of the response body.
+//
+// The metrics are labelled with the template of the route in router that matches the
+// request, such as /api/me/exports/{id:[0-9]+}, rather than the path, and with the method.
+// Requests that match no route are labelled utils.HTTPRouteUnmatched, and methods outside the
+// standard ones utils.HTTPMethodOther, so that the number of series stays bounded.
 //
 // It uses the following utility functions from the utils package:
-// - IncrementActiveRequests: increments the count of active requests for a given method and path.
-// - DecrementActiveRequests: decrements the count of active requests for a given method and path.
-// - ObserveHTTPRequestDuration: records the duration of a completed request for a given method and path.
-// - ObserveRequestSize: records the size of the request body for a given method and path.
-// - ObserveResponseSize: records the size of the response body for a given method and path.
+// - IncrementRequestsInFlight: increments the count of requests in flight for a given method and route.
+// - DecrementRequestsInFlight: decrements the count of requests in flight for a given method and route.
+// - IncrementHTTPRequest: counts a completed request, and errors, for a given method, route and status code.
+// - ObserveHTTPRequestDuration: records the duration of a completed request for a given method, route and status code.
+// - ObserveRequestSize: records the size of the request body for a given method and route.
+// - ObserveResponseSize: records the size of the response body for a given method and route.
 //
-// The middleware wraps the response writer to capture the size of the response body.
-func MetricsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		path := utils.SanitizeValue(r.URL.Path) // Ensure the path is sanitized for logging and metrics.
-		method := utils.SanitizeValue(r.Method)
+// The middleware wraps the response writer to capture the status code and the size of the response body.
+func MetricsMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			method := utils.HTTPMethodLabel(r.Method)
+			route := RouteTemplate(router, r)
 
-		utils.LogInfo("Incoming request", map[string]interface{}{
-			"method": method,
-			"path":   path,
-		})
+			utils.IncrementRequestsInFlight(method, route)
+
+			wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
 
-		utils.IncrementActiveRequests(method, path)
-		
-		wrappedWriter := &responseWriter{ResponseWriter: w}
+			defer func() {
+				utils.DecrementRequestsInFlight(method, route)
 
-		defer func() {
-			utils.DecrementActiveRequests(method, path)
+				duration := time.Since(start).Seconds()
+				utils.IncrementHTTPRequest(method, route, wrappedWriter.status)
+				utils.ObserveHTTPRequestDuration(method, route, wrappedWriter.status, duration)
+			}()
 
-			duration := time.Since(start).Seconds()
-			utils.ObserveHTTPRequestDuration(method, path, duration)
+			if r.ContentLength > 0 {
+				utils.ObserveRequestSize(method, route, float64(r.ContentLength))
+			}
 
-			utils.LogInfo("Request completed", map[string]interface{}{
-				"method":       method,
-				"path":         path,
-				"duration_sec": duration,
-				"response_size": wrappedWriter.size,
-			})
-		}()
+			next.ServeHTTP(wrappedWriter, r)
+			utils.ObserveResponseSize(method, route, float64(wrappedWriter.size))
+		})
+	}
+}
 
-		if r.ContentLength > 0 {
-			utils.ObserveRequestSize(method, path, float64(r.ContentLength))
-		}
+// RouteTemplate returns the path template of the route in router matching r, or
+// utils.HTTPRouteUnmatched if no route matches, including a path served with another method.
+func RouteTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.MatchErr != nil || match.Route == nil {
+		return utils.HTTPRouteUnmatched
+	}
 
-		next.ServeHTTP(wrappedWriter, r)
-		utils.ObserveResponseSize(method, path, float64(wrappedWriter.size))
-	})
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return utils.HTTPRouteUnmatched
+	}
+	return template
 }
 
-// responseWriter wraps http.ResponseWriter to capture response size.
+// responseWriter wraps http.ResponseWriter to capture the status code and response size.
 type responseWriter struct {
 	http.ResponseWriter
-	size int
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+// WriteHeader intercepts the status code of the response.
+func (rw *responseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
 }
 
 // Write intercepts the response body write to track the size.
 func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
 	size, err := rw.ResponseWriter.Write(b)
 	rw.size += size
 	return size, err
 }
+
+// Unwrap returns the wrapped http.ResponseWriter, for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/ratelimit"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimitHeaders are the response headers describing the rate limit of a request
+var RateLimitHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"}
+
+// RateLimitMiddleware limits the requests to a route with token buckets. Requests with an API
+// key in the context, put there by the APIKeyMiddleware, are counted per key at the rate of the
+// key, in one bucket for all routes. Requests with a user ID in the context, put there by an
+// auth middleware, are counted per user at the authenticated rate; other requests are counted
+// per client IP at the anonymous rate. When authenticated is off, users are counted per client IP.
+// Every response carries the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers, and requests over the limit get 429 Too Many Requests with a
+// Retry-After header. If the bucket store is unavailable the request is let through.
+//
+// Parameters:
+//   - limiter: The limiter holding the buckets.
+//   - route: The name of the route, used in the bucket keys and the metrics.
+//   - anonymous: The rate for requests counted per client IP.
+//   - authenticated: The rate for requests counted per user.
+//   - trustProxyHeaders: Whether the client IP is taken from the headers set by the reverse proxy.
+//
+// Returns:
+//   - func(http.Handler) http.Handler: A middleware that wraps an http.Handler and applies the rate limit.
+func RateLimitMiddleware(limiter *ratelimit.Limiter, route string, anonymous, authenticated config.Rate, trustProxyHeaders bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucket := route
+			scope, id, rate := "ip", utils.ClientIP(r, trustProxyHeaders), anonymous
+			if keyID, ok := r.Context().Value(APIKeyIDKey).(uint); ok {
+				// The limit of an API key covers all the routes it is used on
+				bucket = "all"
+				scope, id = "apikey", strconv.FormatUint(uint64(keyID), 10)
+				rate, _ = r.Context().Value(APIKeyRateKey).(config.Rate)
+			} else if userID, ok := r.Context().Value(UserKey).(uint); ok && !authenticated.Off() {
+				scope, id, rate = "user", strconv.FormatUint(uint64(userID), 10), authenticated
+			}
+			if rate.Off() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), bucket+":"+scope+":"+id, rate)
+			if err != nil {
+				utils.LogErrorContext(r.Context(), err, "Failed to check the rate limit", nil)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rate.Requests, ceilSeconds(rate.Period)))
+
+			if !result.Allowed {
+				utils.IncrementRateLimitedRequests(route, scope)
+				utils.LogWarnContext(r.Context(), "Request rejected by the rate limit", logrus.Fields{
+					"message": route,
+				})
+				utils.WriteTooManyRequests(w, "Too many requests, try again later", result.RetryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds, as used in the rate limit headers
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is the header a request ID is accepted from and returned in
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern matches the request IDs accepted from clients and proxies, such as UUIDs.
+// Anything else is replaced, so that the ID is safe to log and to return.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware gives every request an ID, to find its log entries by. The ID in the
+// X-Request-ID header is used if the request has a valid one, such as one set by the reverse
+// proxy, and a random one is generated otherwise.
+//
+// The ID is returned in the X-Request-ID response header, recorded on the span of the request,
+// and put in the request context, so that every entry logged with the context has it.
+//
+// Parameters:
+// - next: The next http.Handler in the middleware chain.
+//
+// Returns:
+// - http.Handler: A handler that sets the request ID and then delegates to the next handler.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			generated, err := security.GenerateRandomToken(16)
+			if err != nil {
+				utils.LogErrorContext(r.Context(), err, "Failed to generate request ID", nil)
+				utils.WriteJSONError(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			requestID = generated
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request.id", requestID))
+		next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), requestID)))
+	})
+}
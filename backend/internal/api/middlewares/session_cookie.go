@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SessionCookieName is the HttpOnly cookie holding the session JWT in cookie session mode
+	SessionCookieName = "whoknows_session"
+	// CSRFCookieName is the cookie the frontend reads the CSRF token of the session from
+	CSRFCookieName = "whoknows_csrf"
+	// CSRFHeader is the request header the CSRF token is sent back in
+	CSRFHeader = "X-CSRF-Token"
+)
+
+var (
+	// ErrNoSessionToken is returned when a request has neither an Authorization header nor a session cookie
+	ErrNoSessionToken = errors.New("Authorization header is required")
+	// ErrInvalidAuthorizationHeader is returned when the Authorization header is not a Bearer token
+	ErrInvalidAuthorizationHeader = errors.New("Invalid Authorization header format")
+)
+
+// SessionToken returns the session token of a request. A Bearer token in the Authorization
+// header takes precedence; otherwise the session cookie is used, if there is one and cookie
+// sessions are enabled.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - cookieSessions: Whether logins set a session cookie, and the cookie is accepted.
+//
+// Returns:
+//   - string: The session token.
+//   - bool: Whether the token came from the session cookie, and state-changing requests need a CSRF token.
+//   - error: ErrNoSessionToken if there is no token, or ErrInvalidAuthorizationHeader if the header is malformed.
+func SessionToken(r *http.Request, cookieSessions bool) (string, bool, error) {
+	authHeader := utils.SanitizeValue(r.Header.Get("Authorization"))
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			utils.LogWarnContext(r.Context(), "Invalid Authorization header format", nil)
+			return "", false, ErrInvalidAuthorizationHeader
+		}
+		return parts[1], false, nil
+	}
+
+	if cookieSessions {
+		if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+			return cookie.Value, true, nil
+		}
+	}
+
+	utils.LogWarnContext(r.Context(), "Authorization header is missing", nil)
+	return "", false, ErrNoSessionToken
+}
+
+// CSRFValid reports whether a request authenticated with the session cookie may go ahead.
+// Safe methods always may; other requests must send the CSRF token of the session in the
+// X-CSRF-Token header, which other sites can not read or set.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - jwtConfig: The JWT configuration, whose secret the CSRF token is derived with.
+//   - sessionToken: The session token from the session cookie.
+//
+// Returns:
+//   - bool: True if the request may go ahead.
+func CSRFValid(r *http.Request, jwtConfig config.JWTConfig, sessionToken string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return security.ValidateCSRFToken(jwtConfig, sessionToken, r.Header.Get(CSRFHeader))
+}
@@ -1,12 +1,18 @@
 package api
 
 import (
+	"context"
 	"net/http"
 
 	_ "github.com/CEM-KEA/whoknows/backend/docs" // docs is generated by Swag CLI
 	"github.com/CEM-KEA/whoknows/backend/internal/api/handlers"
 	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
 	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/repositories"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/tracing"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -16,21 +22,34 @@ import (
 
 // NewRouter initializes and returns a new HTTP router with all the necessary routes and middlewares configured.
 // It sets up static file routes, redirects, Swagger documentation, and API routes.
-// Additionally, it applies CORS and other middlewares such as metrics and no-cache.
+// Additionally, it applies CORS and other middlewares such as metrics and no-cache. The metrics
+// are labelled with the route templates of the router, and every request is traced in a span
+// named by its route template. Every request gets an X-Request-ID, which its log entries are
+// tagged with, and is logged in one access log line once served. Everything is logged to the
+// logger of a, which is put in the context of every request.
+// The handlers and middlewares get their dependencies from a.
 // Returns an http.Handler that can be used to handle HTTP requests.
-func NewRouter() http.Handler {
-	utils.LogInfo("Initializing router", nil)
+func NewRouter(a *app.App) http.Handler {
+	ctx := a.Context(context.Background())
+	utils.LogInfoContext(ctx, "Initializing router", nil)
 
 	router := mux.NewRouter()
-	setupStaticFileRoutes(router)
-	setupRedirects(router)
-	setupSwaggerDocs(router)
-	setupAPIRoutes(router)
+	setupStaticFileRoutes(ctx, router)
+	setupRedirects(ctx, router)
+	setupSwaggerDocs(ctx, router)
+	h := handlers.New(a)
+	setupProbeRoutes(ctx, router, h)
+	setupAPIRoutes(ctx, router, a, h)
 
 	// Apply CORS and other middlewares
-	corsHandler := setupCORS()
-	utils.LogInfo("Middlewares applied successfully", nil)
-	return middlewares.MetricsMiddleware(middlewares.NoCacheMiddleware(corsHandler(router)))
+	corsHandler := setupCORS(ctx, a.Config)
+	utils.LogInfoContext(ctx, "Middlewares applied successfully", nil)
+	handler := middlewares.MetricsMiddleware(router)(middlewares.NoCacheMiddleware(corsHandler(router)))
+	handler = middlewares.RequestIDMiddleware(middlewares.AccessLogMiddleware(router)(handler))
+	handler = tracing.Handler(handler, func(r *http.Request) string {
+		return middlewares.RouteTemplate(router, r)
+	})
+	return middlewares.LoggerMiddleware(a.Logger)(handler)
 }
 
 
@@ -38,9 +57,10 @@ func NewRouter() http.Handler {
 // It sets up handlers for serving the robots.txt and sitemap.xml files.
 //
 // Parameters:
+//   - ctx: The context the setup is logged with.
 //   - router: a pointer to the mux.Router where the routes will be configured.
-func setupStaticFileRoutes(router *mux.Router) {
-	utils.LogInfo("Configuring static file routes", nil)
+func setupStaticFileRoutes(ctx context.Context, router *mux.Router) {
+	utils.LogInfoContext(ctx, "Configuring static file routes", nil)
 	router.HandleFunc("/api/robots.txt", serveStaticFile("./static/robots.txt", "text/plain"))
 	router.HandleFunc("/api/sitemap.xml", serveStaticFile("./static/sitemap.xml", "application/xml"))
 }
@@ -58,7 +78,7 @@ func setupStaticFileRoutes(router *mux.Router) {
 //   An HTTP handler function that serves the specified static file.
 func serveStaticFile(filePath string, contentType string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		utils.LogInfo("Serving static file", map[string]interface{}{
+		utils.LogInfoContext(r.Context(), "Serving static file", map[string]interface{}{
 			"file": filePath,
 		})
 		w.Header().Set("Content-Type", contentType)
@@ -71,9 +91,10 @@ func serveStaticFile(filePath string, contentType string) func(http.ResponseWrit
 // The redirects for /robots.txt and /sitemap.xml are permanent (HTTP 301).
 //
 // Parameters:
+//   - ctx: The context the setup is logged with.
 //   - router: The mux.Router instance to configure with redirects.
-func setupRedirects(router *mux.Router) {
-	utils.LogInfo("Configuring redirects", nil)
+func setupRedirects(ctx context.Context, router *mux.Router) {
+	utils.LogInfoContext(ctx, "Configuring redirects", nil)
 	redirectHandler := RedirectToSwaggerHandler()
 	router.Handle("/", redirectHandler)
 	router.Handle("/api", redirectHandler)
@@ -84,80 +105,212 @@ func setupRedirects(router *mux.Router) {
 }
 
 
+// setupProbeRoutes configures the probe routes used by the container runtime and the proxy.
+// They live outside /api, as they are only reached on the backend port directly.
+//
+// Parameters:
+//   - ctx: The context the setup is logged with.
+//   - router: The mux.Router instance to configure with the probe routes.
+//   - h: The handlers serving the routes.
+func setupProbeRoutes(ctx context.Context, router *mux.Router, h *handlers.Handler) {
+	utils.LogInfoContext(ctx, "Configuring probe routes", nil)
+	router.HandleFunc("/healthz", h.HealthHandler).Methods("GET")
+	router.HandleFunc("/readyz", h.ReadinessHandler).Methods("GET")
+}
+
 // setupSwaggerDocs configures the Swagger documentation route for the provided router.
 // It logs the setup process and sets up the route to serve Swagger UI.
 //
 // Parameters:
+//   - ctx (context.Context): The context the setup is logged with.
 //   - router (*mux.Router): The router to which the Swagger documentation route will be added.
-func setupSwaggerDocs(router *mux.Router) {
-	utils.LogInfo("Setting up Swagger documentation route", nil)
+func setupSwaggerDocs(ctx context.Context, router *mux.Router) {
+	utils.LogInfoContext(ctx, "Setting up Swagger documentation route", nil)
 	router.PathPrefix("/api/swagger/").Handler(httpSwagger.WrapHandler)
 }
 
 
 // setupAPIRoutes configures the API routes for the application.
 // It sets up the following routes:
-// - GET /api/search: handled by handlers.Search
+// - GET /api/search: handled by handlers.Search (optionally authenticated, or with an API key with the search scope)
 // - GET /api/weather: handled by handlers.WeatherHandler
 // - POST /api/register: handled by handlers.RegisterHandler
 // - POST /api/login: handled by handlers.Login
 // - GET /api/logout: handled by handlers.LogoutHandler
+// - POST /api/logout: handled by handlers.LogoutHandler
 // - GET /api/validate-login: handled by handlers.ValidateLoginHandler
 // - POST /api/change-password: handled by handlers.ChangePasswordHandler
-func setupAPIRoutes(router *mux.Router) {
-	utils.LogInfo("Configuring API routes", nil)
-	router.HandleFunc("/api/search", handlers.Search).Methods("GET")
-	router.HandleFunc("/api/weather", handlers.WeatherHandler).Methods("GET")
-	router.HandleFunc("/api/register", handlers.RegisterHandler).Methods("POST")
-	router.HandleFunc("/api/login", handlers.Login).Methods("POST")
-	router.HandleFunc("/api/logout", handlers.LogoutHandler).Methods("GET")
-	router.HandleFunc("/api/validate-login", handlers.ValidateLoginHandler).Methods("GET")
-	router.HandleFunc("/api/change-password", handlers.ChangePasswordHandler).Methods("POST")
+// - POST /api/password-reset/request: handled by handlers.PasswordResetRequestHandler
+// - POST /api/password-reset/confirm: handled by handlers.PasswordResetConfirmHandler
+// - GET /api/verify-email: handled by handlers.VerifyEmailHandler
+// - POST /api/login/2fa: handled by handlers.LoginMFAHandler
+// - POST /api/2fa/enroll: handled by handlers.TOTPEnrollHandler (authenticated)
+// - POST /api/2fa/confirm: handled by handlers.TOTPConfirmHandler (authenticated)
+// - POST /api/2fa/disable: handled by handlers.TOTPDisableHandler (authenticated)
+// - POST /api/password: handled by handlers.ChangeOwnPasswordHandler (authenticated, also with a password change token)
+// - POST /api/admin/force-password-change: handled by handlers.ForcePasswordChangeHandler (admin)
+// - GET /api/admin/audit: handled by handlers.AuditLogHandler (admin, or an API key with the admin scope)
+// - GET /api/me: handled by handlers.GetProfileHandler (authenticated)
+// - PATCH /api/me: handled by handlers.UpdateProfileHandler (authenticated)
+// - DELETE /api/me: handled by handlers.DeleteAccountHandler (authenticated)
+// - POST /api/me/export: handled by handlers.RequestDataExportHandler (authenticated)
+// - GET /api/me/exports/{id}: handled by handlers.GetDataExportHandler (authenticated)
+// - GET /api/exports/{id}/download: handled by handlers.DownloadDataExportHandler (signed link)
+// - POST /api/me/api-keys: handled by handlers.CreateAPIKeyHandler (authenticated)
+// - GET /api/me/api-keys: handled by handlers.ListAPIKeysHandler (authenticated)
+// - DELETE /api/me/api-keys/{id}: handled by handlers.RevokeAPIKeyHandler (authenticated)
+// - GET /api/oidc/providers: handled by handlers.ListOIDCProvidersHandler
+// - POST /api/oidc/{provider}/authorize: handled by handlers.OIDCAuthorizeHandler
+// - POST /api/oidc/{provider}/callback: handled by handlers.OIDCCallbackHandler
+// - GET /api/me/oidc: handled by handlers.ListOIDCIdentitiesHandler (authenticated)
+// - POST /api/me/oidc/{provider}/authorize: handled by handlers.LinkOIDCAuthorizeHandler (authenticated)
+// - POST /api/me/oidc/{provider}/callback: handled by handlers.LinkOIDCCallbackHandler (authenticated)
+// - DELETE /api/me/oidc/{provider}: handled by handlers.UnlinkOIDCIdentityHandler (authenticated)
+// - POST /api/login/passkey/begin: handled by handlers.PasskeyLoginBeginHandler
+// - POST /api/login/passkey/finish: handled by handlers.PasskeyLoginFinishHandler
+// - POST /api/login/2fa/passkey/begin: handled by handlers.PasskeyMFABeginHandler
+// - POST /api/login/2fa/passkey/finish: handled by handlers.PasskeyMFAFinishHandler
+// - GET /api/me/passkeys: handled by handlers.ListPasskeysHandler (authenticated)
+// - POST /api/me/passkeys/register/begin: handled by handlers.PasskeyRegisterBeginHandler (authenticated)
+// - POST /api/me/passkeys/register/finish: handled by handlers.PasskeyRegisterFinishHandler (authenticated)
+// - DELETE /api/me/passkeys/{id}: handled by handlers.DeletePasskeyHandler (authenticated)
+// - PUT /api/me/passkeys/second-factor: handled by handlers.PasskeySecondFactorHandler (authenticated)
+//
+// The setup is logged with ctx. The middlewares use the database and configuration of a, and
+// the routes are served by h. Searches, registration, login and password reset requests are
+// rate limited as configured in API_RATE_LIMIT_*.
+func setupAPIRoutes(ctx context.Context, router *mux.Router, a *app.App, h *handlers.Handler) {
+	utils.LogInfoContext(ctx, "Configuring API routes", nil)
+	validateSessionToken := sessionTokenValidator(a.Config.JWT, a.Tokens)
+	cookieSessions := a.Config.Session.CookieSessions()
+	authenticated := middlewares.AuthMiddleware(a.DB, validateSessionToken, a.Config.JWT, cookieSessions)
+	passwordChange := middlewares.ScopedAuthMiddleware(a.DB, validateSessionToken, a.Config.JWT, security.ScopePasswordChange, cookieSessions)
+	admin := func(next http.Handler) http.Handler {
+		return authenticated(middlewares.RequireRole(a.DB, models.RoleAdmin)(next))
+	}
+	optionalAuth := middlewares.OptionalAuthMiddleware(a.DB, validateSessionToken, a.Config.JWT, cookieSessions)
+	limits := a.Config.RateLimit
+	rateLimit := func(route string, anonymous, authenticated config.Rate) func(http.Handler) http.Handler {
+		if !limits.Enabled {
+			// API keys keep their own limits
+			anonymous, authenticated = config.Rate{}, config.Rate{}
+		}
+		return middlewares.RateLimitMiddleware(a.RateLimiter, route, anonymous, authenticated, a.Config.Server.TrustProxyHeaders)
+	}
+	searchAPIKey := middlewares.APIKeyMiddleware(a.DB, models.APIKeyScopeSearch, optionalAuth)
+	adminAPIKey := func(next http.Handler) http.Handler {
+		withRole := middlewares.RequireRole(a.DB, models.RoleAdmin)(next)
+		return middlewares.APIKeyMiddleware(a.DB, models.APIKeyScopeAdmin, authenticated)(rateLimit("admin", config.Rate{}, config.Rate{})(withRole))
+	}
+	router.Handle("/api/search", searchAPIKey(rateLimit("search", limits.Search, limits.SearchAuthenticated)(http.HandlerFunc(h.Search)))).Methods("GET")
+	router.HandleFunc("/api/weather", h.WeatherHandler).Methods("GET")
+	router.Handle("/api/register", rateLimit("register", limits.Register, config.Rate{})(http.HandlerFunc(h.RegisterHandler))).Methods("POST")
+	router.Handle("/api/login", rateLimit("login", limits.Login, config.Rate{})(http.HandlerFunc(h.Login))).Methods("POST")
+	router.HandleFunc("/api/logout", h.LogoutHandler).Methods("GET", "POST")
+	router.HandleFunc("/api/validate-login", h.ValidateLoginHandler).Methods("GET")
+	router.HandleFunc("/api/change-password", h.ChangePasswordHandler).Methods("POST")
+	router.Handle("/api/password-reset/request", rateLimit("password_reset", limits.PasswordReset, config.Rate{})(http.HandlerFunc(h.PasswordResetRequestHandler))).Methods("POST")
+	router.HandleFunc("/api/password-reset/confirm", h.PasswordResetConfirmHandler).Methods("POST")
+	router.HandleFunc("/api/verify-email", h.VerifyEmailHandler).Methods("GET")
+	router.Handle("/api/login/2fa", rateLimit("login", limits.Login, config.Rate{})(http.HandlerFunc(h.LoginMFAHandler))).Methods("POST")
+	router.Handle("/api/2fa/enroll", authenticated(http.HandlerFunc(h.TOTPEnrollHandler))).Methods("POST")
+	router.Handle("/api/2fa/confirm", authenticated(http.HandlerFunc(h.TOTPConfirmHandler))).Methods("POST")
+	router.Handle("/api/2fa/disable", authenticated(http.HandlerFunc(h.TOTPDisableHandler))).Methods("POST")
+	router.Handle("/api/password", passwordChange(http.HandlerFunc(h.ChangeOwnPasswordHandler))).Methods("POST")
+	router.Handle("/api/admin/force-password-change", admin(http.HandlerFunc(h.ForcePasswordChangeHandler))).Methods("POST")
+	router.Handle("/api/admin/audit", adminAPIKey(http.HandlerFunc(h.AuditLogHandler))).Methods("GET")
+	router.Handle("/api/me", authenticated(http.HandlerFunc(h.GetProfileHandler))).Methods("GET")
+	router.Handle("/api/me", authenticated(http.HandlerFunc(h.UpdateProfileHandler))).Methods("PATCH")
+	router.Handle("/api/me", authenticated(http.HandlerFunc(h.DeleteAccountHandler))).Methods("DELETE")
+	router.Handle("/api/me/export", authenticated(http.HandlerFunc(h.RequestDataExportHandler))).Methods("POST")
+	router.Handle("/api/me/exports/{id:[0-9]+}", authenticated(http.HandlerFunc(h.GetDataExportHandler))).Methods("GET")
+	router.HandleFunc("/api/exports/{id:[0-9]+}/download", h.DownloadDataExportHandler).Methods("GET")
+	router.Handle("/api/me/api-keys", authenticated(http.HandlerFunc(h.CreateAPIKeyHandler))).Methods("POST")
+	router.Handle("/api/me/api-keys", authenticated(http.HandlerFunc(h.ListAPIKeysHandler))).Methods("GET")
+	router.Handle("/api/me/api-keys/{id:[0-9]+}", authenticated(http.HandlerFunc(h.RevokeAPIKeyHandler))).Methods("DELETE")
+	router.HandleFunc("/api/oidc/providers", h.ListOIDCProvidersHandler).Methods("GET")
+	router.HandleFunc("/api/oidc/{provider}/authorize", h.OIDCAuthorizeHandler).Methods("POST")
+	router.HandleFunc("/api/oidc/{provider}/callback", h.OIDCCallbackHandler).Methods("POST")
+	router.Handle("/api/me/oidc", authenticated(http.HandlerFunc(h.ListOIDCIdentitiesHandler))).Methods("GET")
+	router.Handle("/api/me/oidc/{provider}/authorize", authenticated(http.HandlerFunc(h.LinkOIDCAuthorizeHandler))).Methods("POST")
+	router.Handle("/api/me/oidc/{provider}/callback", authenticated(http.HandlerFunc(h.LinkOIDCCallbackHandler))).Methods("POST")
+	router.Handle("/api/me/oidc/{provider}", authenticated(http.HandlerFunc(h.UnlinkOIDCIdentityHandler))).Methods("DELETE")
+	router.HandleFunc("/api/login/passkey/begin", h.PasskeyLoginBeginHandler).Methods("POST")
+	router.HandleFunc("/api/login/passkey/finish", h.PasskeyLoginFinishHandler).Methods("POST")
+	router.HandleFunc("/api/login/2fa/passkey/begin", h.PasskeyMFABeginHandler).Methods("POST")
+	router.HandleFunc("/api/login/2fa/passkey/finish", h.PasskeyMFAFinishHandler).Methods("POST")
+	router.Handle("/api/me/passkeys", authenticated(http.HandlerFunc(h.ListPasskeysHandler))).Methods("GET")
+	router.Handle("/api/me/passkeys/register/begin", authenticated(http.HandlerFunc(h.PasskeyRegisterBeginHandler))).Methods("POST")
+	router.Handle("/api/me/passkeys/register/finish", authenticated(http.HandlerFunc(h.PasskeyRegisterFinishHandler))).Methods("POST")
+	router.Handle("/api/me/passkeys/{id:[0-9]+}", authenticated(http.HandlerFunc(h.DeletePasskeyHandler))).Methods("DELETE")
+	router.Handle("/api/me/passkeys/second-factor", authenticated(http.HandlerFunc(h.PasskeySecondFactorHandler))).Methods("PUT")
 	router.Handle("/api/probe", promhttp.Handler())
 	router.Handle("/metrics", promhttp.Handler())
 }
 
 
-// setupCORS configures Cross-Origin Resource Sharing (CORS) settings based on the application's environment.
+// sessionTokenValidator returns the function validating session JWTs for the AuthMiddleware.
+// Besides the signature, checked with the secret of jwtConfig, and the expiry, the token must be
+// stored in tokens and not be revoked, which also rules out single-purpose tokens such as MFA challenges.
+func sessionTokenValidator(jwtConfig config.JWTConfig, tokens repositories.TokenRepo) func(ctx context.Context, token string) (map[string]interface{}, error) {
+	return func(ctx context.Context, token string) (map[string]interface{}, error) {
+		claims, err := security.ValidateJWT(ctx, jwtConfig, token)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := security.ValidateJWTRevoked(ctx, tokens, token); err != nil {
+			return nil, err
+		}
+
+		return claims, nil
+	}
+}
+
+
+// setupCORS configures Cross-Origin Resource Sharing (CORS) settings.
 // It returns a middleware handler function that applies the CORS settings to incoming HTTP requests.
 //
-// The allowed origins are determined by the environment:
+// The allowed origins are read from cfg.CORSOrigins on every request, so they follow a
+// configuration reload. Unless configured in API_SERVER_CORS_ORIGINS, they depend on the environment:
 // - "development": allows all origins ("*").
 // - "test": allows "http://localhost" and "https://localhost".
 // - Other environments: allows "http://cemdev.dk" and "https://cemdev.dk".
 //
 // The CORS settings include:
-// - Allowed methods: GET, POST, PUT, DELETE, OPTIONS.
-// - Allowed headers: Authorization, Content-Type.
+// - Allowed methods: GET, POST, PUT, PATCH, DELETE, OPTIONS.
+// - Allowed headers: Authorization, Content-Type, X-API-Key, X-CSRF-Token.
+// - Exposed headers: the RateLimit-* and Retry-After headers.
 // - Allow credentials: true.
 //
-// The function also logs the CORS configuration for informational purposes.
-func setupCORS() func(http.Handler) http.Handler {
-	env := config.AppConfig.Environment.Environment
-	var allowedOrigins []string
-
-	switch env {
-	case "development":
-		allowedOrigins = []string{"*"}
-	case "test":
-		allowedOrigins = []string{"http://localhost", "https://localhost"}
-	default:
-		allowedOrigins = []string{"http://cemdev.dk", "https://cemdev.dk"}
-	}
-
-	utils.LogInfo("CORS configuration set", map[string]interface{}{
-		"environment":    env,
-		"allowedOrigins": allowedOrigins,
+// The function also logs the CORS configuration of cfg with ctx for informational purposes.
+func setupCORS(ctx context.Context, cfg *config.Config) func(http.Handler) http.Handler {
+	utils.LogInfoContext(ctx, "CORS configuration set", map[string]interface{}{
+		"environment":    cfg.Environment.Environment,
+		"allowedOrigins": cfg.CORSOrigins(),
 	})
 
 	return cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowOriginFunc: func(origin string) bool {
+			return allowedOrigin(cfg, origin)
+		},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", middlewares.APIKeyHeader, middlewares.CSRFHeader, middlewares.RequestIDHeader},
+		ExposedHeaders:   append([]string{middlewares.RequestIDHeader}, middlewares.RateLimitHeaders...),
 		AllowCredentials: true,
 	}).Handler
 }
 
+// allowedOrigin reports whether the origin is allowed to call the API under cfg
+func allowedOrigin(cfg *config.Config, origin string) bool {
+	for _, allowed := range cfg.CORSOrigins() {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
 // RedirectToSwaggerHandler redirects root requests to Swagger documentation.
 func RedirectToSwaggerHandler() http.Handler {
 	return http.RedirectHandler("/api/swagger/", http.StatusMovedPermanently)
@@ -0,0 +1,100 @@
+// Package app holds the configuration, logger, database, cache, rate limiter, mailer,
+// brute-force guard, password policy, identity providers and repositories of a running
+// backend in one place, so handlers and services get them passed in rather than reaching into
+// package globals.
+package app
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
+	"github.com/CEM-KEA/whoknows/backend/internal/cache"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/lifecycle"
+	"github.com/CEM-KEA/whoknows/backend/internal/mail"
+	"github.com/CEM-KEA/whoknows/backend/internal/oidc"
+	"github.com/CEM-KEA/whoknows/backend/internal/ratelimit"
+	"github.com/CEM-KEA/whoknows/backend/internal/repositories"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// App is one instance of the backend. Several Apps can share one process, for example in
+// tests, each with its own configuration.
+type App struct {
+	Config *config.Config
+	// Logger is attached to the context of every request, and used by everything logging for it
+	Logger *logrus.Logger
+	// DB is used by the services that have no repository yet
+	DB *gorm.DB
+	// Cache holds the weather data, as the weather API only allows 1000 free calls per day
+	Cache *cache.Cache
+	// RateLimiter holds the token buckets of the per-route rate limits
+	RateLimiter *ratelimit.Limiter
+	// Mailer sends the password reset, email verification and data export emails
+	Mailer mail.Mailer
+	// Guard locks out clients and usernames after repeated failed logins
+	Guard *bruteforce.Guard
+	// PasswordPolicy holds the rules new passwords must satisfy
+	PasswordPolicy *security.PasswordPolicy
+	// OIDC holds the identity providers users can log in with
+	OIDC *oidc.Providers
+	// Readiness reports whether the backend is ready to receive traffic, for the health probe
+	Readiness lifecycle.Readiness
+
+	Users      repositories.UserRepo
+	Pages      repositories.PageRepo
+	Tokens     repositories.TokenRepo
+	SearchLogs repositories.SearchLogRepo
+
+	// Tasks tracks the work started with Go, so a shutdown can wait for it to finish
+	Tasks sync.WaitGroup
+}
+
+// New creates an App that stores its data in db through the GORM repositories. It logs emails
+// instead of sending them, tracks failed logins in memory, and uses the default password
+// policy and no identity providers until the caller replaces them with the configured ones.
+//
+// Parameters:
+//   - cfg: The configuration of the App.
+//   - db: The database connection.
+//   - logger: The logger of the App.
+//
+// Returns:
+//   - *App: The App.
+func New(cfg *config.Config, db *gorm.DB, logger *logrus.Logger) *App {
+	return &App{
+		Config:         cfg,
+		Logger:         logger,
+		DB:             db,
+		Cache:          cache.NewCache(),
+		RateLimiter:    ratelimit.New(cfg.RateLimit, db),
+		Mailer:         mail.NewLogMailer(),
+		Guard:          bruteforce.NewGuard(bruteforce.NewMemoryStore(), bruteforce.DefaultPolicy()),
+		PasswordPolicy: security.DefaultPasswordPolicy(),
+		OIDC:           &oidc.Providers{},
+		Users:          repositories.NewUserRepo(db),
+		Pages:          repositories.NewPageRepo(db),
+		Tokens:         repositories.NewTokenRepo(db),
+		SearchLogs:     repositories.NewSearchLogRepo(db),
+	}
+}
+
+// Context returns ctx with the logger of the App attached, for work done outside a request,
+// such as startup, commands and background jobs.
+func (a *App) Context(ctx context.Context) context.Context {
+	return utils.WithLogger(ctx, a.Logger)
+}
+
+// Go runs task in the background, for work a response must not wait for, such as sending an
+// email. Shutdowns and tests wait for the tasks with a.Tasks.Wait.
+func (a *App) Go(task func()) {
+	a.Tasks.Add(1)
+	go func() {
+		defer a.Tasks.Done()
+		task()
+	}()
+}
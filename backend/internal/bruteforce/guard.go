@@ -0,0 +1,196 @@
+package bruteforce
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"gorm.io/gorm"
+)
+
+// Policy controls how failed attempts are punished
+type Policy struct {
+	// FreeAttempts is the number of failures allowed before any lockout
+	FreeAttempts int
+	// BaseDelay is the lockout after the first failure past FreeAttempts; it doubles with every further failure
+	BaseDelay time.Duration
+	// MaxLockout caps the lockout duration
+	MaxLockout time.Duration
+	// ResetAfter forgets all failures when there has been no failure for this long
+	ResetAfter time.Duration
+}
+
+// DefaultPolicy returns the policy used when nothing is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxLockout:   15 * time.Minute,
+		ResetAfter:   24 * time.Hour,
+	}
+}
+
+// Guard tracks failed attempts per key, such as "ip:203.0.113.7" or "user:alice",
+// and locks keys out with exponential backoff
+type Guard struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+// NewGuard creates a guard that keeps its records in store
+func NewGuard(store Store, policy Policy) *Guard {
+	return &Guard{store: store, policy: policy, now: time.Now}
+}
+
+// SetClock replaces the clock used by the guard. It is meant for tests.
+func (g *Guard) SetClock(now func() time.Time) {
+	g.now = now
+}
+
+// New creates a guard using the store and policy from the configuration.
+//
+// Parameters:
+//   - cfg: The brute-force protection configuration.
+//   - db: The database connection, used by the "database" store.
+//
+// Returns:
+//   - *Guard: The guard.
+//   - error: An error if the configured store is unknown.
+func New(cfg config.BruteForceConfig, db *gorm.DB) (*Guard, error) {
+	var store Store
+	switch cfg.Store {
+	case "memory", "":
+		store = NewMemoryStore()
+	case "database":
+		store = NewDBStore(db)
+	default:
+		return nil, fmt.Errorf("unknown brute-force store %q", cfg.Store)
+	}
+
+	return NewGuard(store, Policy{
+		FreeAttempts: cfg.FreeAttempts,
+		BaseDelay:    cfg.BaseDelay,
+		MaxLockout:   cfg.MaxLockout,
+		ResetAfter:   cfg.ResetAfter,
+	}), nil
+}
+
+// Check reports whether any of the keys is locked out.
+//
+// Parameters:
+//   - keys: The keys to check.
+//
+// Returns:
+//   - time.Duration: The longest remaining lockout, or zero if no key is locked.
+//   - string: The key with the longest lockout, or an empty string.
+//   - error: An error if the store could not be read.
+func (g *Guard) Check(keys ...string) (time.Duration, string, error) {
+	now := g.now()
+	var retryAfter time.Duration
+	var blockedKey string
+
+	for _, key := range keys {
+		record, err := g.store.Get(key)
+		if err != nil {
+			return 0, "", err
+		}
+		if remaining := record.LockedUntil.Sub(now); remaining > retryAfter {
+			retryAfter = remaining
+			blockedKey = key
+		}
+	}
+
+	return retryAfter, blockedKey, nil
+}
+
+// RegisterFailure counts a failed attempt for each key, locking keys that have used up their free attempts.
+//
+// Parameters:
+//   - keys: The keys the failed attempt belongs to.
+//
+// Returns:
+//   - error: An error if the store could not be updated.
+func (g *Guard) RegisterFailure(keys ...string) error {
+	now := g.now()
+	for _, key := range keys {
+		_, err := g.store.Update(key, func(record Record) Record {
+			if !record.LastFailure.IsZero() && now.Sub(record.LastFailure) > g.policy.ResetAfter {
+				record = Record{}
+			}
+			record.Failures++
+			record.LastFailure = now
+			if lockout := g.lockout(record.Failures); lockout > 0 {
+				record.LockedUntil = now.Add(lockout)
+			}
+			return record
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterSuccess forgets the failed attempts of each key.
+//
+// Parameters:
+//   - keys: The keys to reset.
+//
+// Returns:
+//   - error: An error if the store could not be updated.
+func (g *Guard) RegisterSuccess(keys ...string) error {
+	for _, key := range keys {
+		if err := g.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune deletes the records of keys that have had no failure for the ResetAfter period of the
+// policy, and are not locked, as their failures would be forgotten on the next attempt anyway.
+// Without it, spraying many usernames or IPs would grow the store without limit.
+//
+// Returns:
+//   - error: An error if the store could not be updated.
+func (g *Guard) Prune() error {
+	return g.store.DeleteBefore(g.now().Add(-g.policy.ResetAfter))
+}
+
+// lockout returns the lockout duration after the given number of consecutive failures
+func (g *Guard) lockout(failures int) time.Duration {
+	excess := failures - g.policy.FreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+
+	lockout := g.policy.BaseDelay
+	for i := 1; i < excess; i++ {
+		lockout *= 2
+		if lockout >= g.policy.MaxLockout {
+			return g.policy.MaxLockout
+		}
+	}
+	if lockout > g.policy.MaxLockout {
+		return g.policy.MaxLockout
+	}
+	return lockout
+}
+
+// IPKey returns the key used to track attempts from a client IP
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// UserKey returns the key used to track attempts against a username
+func UserKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// Scope returns the kind of a key, such as "ip" or "user"
+func Scope(key string) string {
+	scope, _, _ := strings.Cut(key, ":")
+	return scope
+}
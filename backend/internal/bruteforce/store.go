@@ -0,0 +1,154 @@
+package bruteforce
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Record is the failed attempt state tracked for a single key
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Store persists attempt records. Update must apply fn atomically, so that concurrent
+// failures for the same key are all counted.
+type Store interface {
+	Get(key string) (Record, error)
+	Update(key string, fn func(Record) Record) (Record, error)
+	Delete(key string) error
+	// DeleteBefore removes the records whose last failure and lockout both ended before t
+	DeleteBefore(t time.Time) error
+}
+
+// MemoryStore keeps records in process memory. It is only suitable for a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Get returns the record for key, or an empty record
+func (s *MemoryStore) Get(key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+// Update applies fn to the record for key and stores the result
+func (s *MemoryStore) Update(key string, fn func(Record) Record) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := fn(s.records[key])
+	s.records[key] = record
+	return record, nil
+}
+
+// Delete removes the record for key
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// DeleteBefore removes the records whose last failure and lockout both ended before t
+func (s *MemoryStore) DeleteBefore(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, record := range s.records {
+		if record.LastFailure.Before(t) && record.LockedUntil.Before(t) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// DBStore keeps records in the login_attempts table, so all replicas share them
+type DBStore struct {
+	db *gorm.DB
+}
+
+// NewDBStore creates a store backed by the login_attempts table
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+// Get returns the record for key, or an empty record
+func (s *DBStore) Get(key string) (Record, error) {
+	var attempt models.LoginAttempt
+	err := s.db.Where(map[string]interface{}{"key": key}).Limit(1).Find(&attempt).Error
+	if err != nil {
+		return Record{}, errors.Wrap(err, "failed to load login attempts")
+	}
+	return toRecord(attempt), nil
+}
+
+// Update applies fn to the record for key inside a transaction. On Postgres the row is
+// locked with SELECT ... FOR UPDATE, so concurrent updates from other replicas wait.
+func (s *DBStore) Update(key string, fn func(Record) Record) (Record, error) {
+	var record Record
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists, so there is something to lock
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginAttempt{Key: key}).Error; err != nil {
+			return err
+		}
+
+		query := tx.Where(map[string]interface{}{"key": key})
+		if tx.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+
+		var attempt models.LoginAttempt
+		if err := query.First(&attempt).Error; err != nil {
+			return err
+		}
+
+		record = fn(toRecord(attempt))
+		attempt.Failures = record.Failures
+		attempt.LastFailure = record.LastFailure
+		attempt.LockedUntil = record.LockedUntil
+		return tx.Save(&attempt).Error
+	})
+	if err != nil {
+		return Record{}, errors.Wrap(err, "failed to update login attempts")
+	}
+
+	return record, nil
+}
+
+// Delete removes the record for key
+func (s *DBStore) Delete(key string) error {
+	if err := s.db.Where(map[string]interface{}{"key": key}).Delete(&models.LoginAttempt{}).Error; err != nil {
+		return errors.Wrap(err, "failed to delete login attempts")
+	}
+	return nil
+}
+
+// DeleteBefore removes the records whose last failure and lockout both ended before t
+func (s *DBStore) DeleteBefore(t time.Time) error {
+	if err := s.db.Where("last_failure < ? AND locked_until < ?", t, t).Delete(&models.LoginAttempt{}).Error; err != nil {
+		return errors.Wrap(err, "failed to delete old login attempts")
+	}
+	return nil
+}
+
+func toRecord(attempt models.LoginAttempt) Record {
+	return Record{
+		Failures:    attempt.Failures,
+		LastFailure: attempt.LastFailure,
+		LockedUntil: attempt.LockedUntil,
+	}
+}
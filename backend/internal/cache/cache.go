@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
@@ -26,11 +28,12 @@ func NewCache() *Cache {
 // It logs the action with the key and expiration details.
 //
 // Parameters:
+//   - ctx: The context of the request, which the action is logged with.
 //   - key: The key under which the value will be stored.
 //   - value: The value to be stored in the cache.
 //   - expiration: The duration for which the item should remain in the cache.
-func (c *Cache) Set(key string, value interface{}, expiration time.Duration) {
-	utils.LogInfo("Adding item to cache", logrus.Fields{
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) {
+	utils.LogInfoContext(ctx, "Adding item to cache", logrus.Fields{
 		"key":        key,
 		"expiration": expiration,
 	})
@@ -42,13 +45,14 @@ func (c *Cache) Set(key string, value interface{}, expiration time.Duration) {
 // It logs the operation and updates Prometheus metrics for cache hits and misses.
 //
 // Parameters:
+//   - ctx: The context of the request, which the operation is logged with.
 //   - key: The key of the item to retrieve from the cache.
 //
 // Returns:
 //   - value: The value associated with the key, if found.
 //   - found: A boolean indicating whether the item was found in the cache.
-func (c *Cache) Get(key string) (interface{}, bool) {
-	utils.LogInfo("Fetching item from cache", logrus.Fields{
+func (c *Cache) Get(ctx context.Context, key string) (interface{}, bool) {
+	utils.LogInfoContext(ctx, "Fetching item from cache", logrus.Fields{
 		"key": key,
 	})
 	value, found := c.cache.Get(key)
@@ -57,15 +61,31 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	cacheName := "default"
 	if found {
 		utils.IncrementCacheHit(cacheName)
-		utils.LogInfo("Cache hit", logrus.Fields{
+		utils.LogInfoContext(ctx, "Cache hit", logrus.Fields{
 			"key": key,
 		})
 	} else {
 		utils.IncrementCacheMiss(cacheName)
-		utils.LogInfo("Cache miss", logrus.Fields{
+		utils.LogInfoContext(ctx, "Cache miss", logrus.Fields{
 			"key": key,
 		})
 	}
 
 	return value, found
 }
+
+
+// Check stores and reads back a probe item, without the logging and metrics of Set and Get,
+// so the readiness probe can check the cache without skewing the hit ratio.
+//
+// Returns an error if the probe item cannot be read back.
+func (c *Cache) Check() error {
+	const probeKey = "health:probe"
+	c.cache.Set(probeKey, true, time.Minute)
+	defer c.cache.Delete(probeKey)
+
+	if _, found := c.cache.Get(probeKey); !found {
+		return errors.New("probe item not found in cache")
+	}
+	return nil
+}
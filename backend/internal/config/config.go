@@ -1,138 +1,201 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
-// LoadEnv loads the environment configuration for the application.
-// It first checks if an environment file path is specified in the ENV_FILE_PATH
-// environment variable. If specified, it attempts to load the configuration
-// from the .env file at that path. If the .env file cannot be read, an error
-// is logged and returned.
+// CommandLine holds what is left on the command line once the configuration flags are parsed
+type CommandLine struct {
+	// PrintConfig is set by --print-config
+	PrintConfig bool
+	// Args are the command and its arguments, empty when the server is to be started
+	Args []string
+}
+
+var (
+	// mu guards the keys that are reloaded, and the layers they are reloaded from
+	mu            sync.RWMutex
+	loadedArgs    []string
+	loadedEnvFile *envFile
+)
+
+// Load loads the configuration for the application.
 //
-// If no .env file path is specified, it loads the configuration directly from
-// the environment variables.
+// The configuration is layered: every key starts from its default, and is overridden by the
+// YAML configuration file given by --config or API_CONFIG_FILE, then by the environment
+// variables, then by the command line flags. If an environment file path is specified in the
+// ENV_FILE_PATH environment variable, its variables are loaded into the environment first,
+// without overriding the ones already set.
 //
-// The function also populates the AppConfig from the environment variables.
-// If there is an error during this process, it logs the error and returns it.
+// Every missing or invalid key is reported at once, in a *ValidationError. The layers are
+// remembered, so Reload can read them again.
 //
-// Returns an error if there is any issue loading the configuration.
-func LoadEnv() error {
-	utils.LogInfo("Loading environment configuration", nil)
-
-	envFilePath := os.Getenv("ENV_FILE_PATH")
-
-	// Load .env file if specified
-	if envFilePath != "" {
-		if err := godotenv.Load(envFilePath); err != nil {
-			utils.LogError(err, "Error reading .env file", logrus.Fields{
-				"path": envFilePath,
+// Parameters:
+//   - ctx: The context the loading is logged with.
+//   - args: The command line arguments, without the program name.
+//
+// Returns:
+//   - *Config: The loaded configuration.
+//   - *CommandLine: The options and arguments left on the command line.
+//   - error: An error if the configuration cannot be loaded.
+func Load(ctx context.Context, args []string) (*Config, *CommandLine, error) {
+	utils.LogInfoContext(ctx, "Loading environment configuration", nil)
+	mu.Lock()
+	defer mu.Unlock()
+
+	file := newEnvFile(os.Getenv("ENV_FILE_PATH"))
+	if file.path != "" {
+		if err := file.apply(); err != nil {
+			utils.LogErrorContext(ctx, err, "Error reading .env file", logrus.Fields{
+				"message": fmt.Sprintf("path %s", file.path),
 			})
-			return fmt.Errorf("error reading .env file: %w", err)
+			return nil, nil, fmt.Errorf("error reading .env file: %w", err)
 		}
-		utils.LogInfo("Loaded configuration from .env file", logrus.Fields{
-			"path": envFilePath,
+		utils.LogInfoContext(ctx, "Loaded configuration from .env file", logrus.Fields{
+			"message": fmt.Sprintf("path %s", file.path),
 		})
-	} else {
-		utils.LogInfo("Loading configuration from environment variables", nil)
 	}
 
-	// Populate AppConfig from environment variables
-	if err := loadConfigFromEnv(); err != nil {
-		utils.LogError(err, "Error loading configuration from environment variables", nil)
-		return fmt.Errorf("error loading configuration: %w", err)
+	cfg, commandLine, err := buildConfig(args)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			utils.LogErrorContext(ctx, err, "Error loading configuration", nil)
+		}
+		return nil, nil, fmt.Errorf("error loading configuration: %w", err)
 	}
 
-	utils.LogInfo("Configuration loaded successfully", nil)
-	return nil
+	loadedArgs = args
+	loadedEnvFile = file
+	utils.LogInfoContext(ctx, "Configuration loaded successfully", nil)
+	return &cfg, commandLine, nil
 }
 
+// LoadEnv loads the configuration for the application without command line flags. See Load.
+//
+// Returns the configuration, or an error if there is any issue loading it.
+func LoadEnv(ctx context.Context) (*Config, error) {
+	cfg, _, err := Load(ctx, nil)
+	return cfg, err
+}
 
-// loadConfigFromEnv loads the application configuration from environment variables.
-// It maps environment variable keys to their respective configuration fields and
-// attempts to load each one using helper functions such as getEnv, getEnvAsInt, and getEnvAsBool.
-// If any environment variable fails to load, it logs the error and returns it.
-// If all environment variables are loaded successfully, it logs a success message.
+// Reload loads the configuration again from the same layers, reading the configuration file
+// and the .env file again, and applies the keys tagged reload to cfg. Other keys that changed
+// are logged, as they only take effect on a restart.
+//
+// Parameters:
+//   - ctx: The context the changes are logged with.
+//   - cfg: The configuration in use, which is updated in place.
 //
-// Returns an error if any environment variable fails to load.
-func loadConfigFromEnv() error {
-	var err error
-
-	loadConfig := map[string]func() error{
-		// General Config
-		"API_SERVER_PORT": func() error { AppConfig.Server.Port, err = getEnvAsInt("API_SERVER_PORT"); return err },
-
-		// Database Configuration
-		"API_DATABASE_HOST":     func() error { AppConfig.Database.Host, err = getEnv("API_DATABASE_HOST"); return err },
-		"API_DATABASE_PORT":     func() error { AppConfig.Database.Port, err = getEnvAsInt("API_DATABASE_PORT"); return err },
-		"API_DATABASE_USER":     func() error { AppConfig.Database.User, err = getEnv("API_DATABASE_USER"); return err },
-		"API_DATABASE_PASSWORD": func() error { AppConfig.Database.Password, err = getEnv("API_DATABASE_PASSWORD"); return err },
-		"API_DATABASE_NAME":     func() error { AppConfig.Database.Name, err = getEnv("API_DATABASE_NAME"); return err },
-		"API_DATABASE_SSL_MODE": func() error { AppConfig.Database.SSLMode, err = getEnv("API_DATABASE_SSL_MODE"); return err },
-		"API_DATABASE_MIGRATE":  func() error { AppConfig.Database.Migrate, err = getEnvAsBool("API_DATABASE_MIGRATE"); return err },
-
-		// JWT Configuration
-		"API_JWT_SECRET":     func() error { AppConfig.JWT.Secret, err = getEnv("API_JWT_SECRET"); return err },
-		"API_JWT_EXPIRATION": func() error { AppConfig.JWT.Expiration, err = getEnvAsInt("API_JWT_EXPIRATION"); return err },
-
-		// Environment Configuration
-		"API_ENVIRONMENT": func() error { AppConfig.Environment.Environment, err = getEnv("API_ENVIRONMENT"); return err },
-
-		// Pagination Configuration
-		"API_PAGINATION_LIMIT":  func() error { AppConfig.Pagination.Limit, err = getEnvAsInt("API_PAGINATION_LIMIT"); return err },
-		"API_PAGINATION_OFFSET": func() error { AppConfig.Pagination.Offset, err = getEnvAsInt("API_PAGINATION_OFFSET"); return err },
-
-		// Log Configuration
-		"API_LOG_LEVEL":  func() error { AppConfig.Log.Level, err = getEnv("API_LOG_LEVEL"); return err },
-		"API_LOG_FORMAT": func() error { AppConfig.Log.Format, err = getEnv("API_LOG_FORMAT"); return err },
-
-		// Weather API Configuration
-		"API_WEATHER_API_KEY": func() error { AppConfig.WeatherAPI.OpenWeatherAPIKey, err = getEnv("API_WEATHER_API_KEY"); return err },
+// Returns:
+//   - []string: The names of the reloaded keys that changed.
+//   - error: An error if the configuration is invalid, in which case nothing is applied.
+func Reload(ctx context.Context, cfg *Config) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := loadedEnvFile.apply(); err != nil {
+		return nil, fmt.Errorf("error reading .env file: %w", err)
+	}
+	loaded, _, err := buildConfig(loadedArgs)
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration: %w", err)
 	}
 
-	for key, fn := range loadConfig {
-		if err := fn(); err != nil {
-			utils.LogError(err, "Error loading configuration", logrus.Fields{"key": key})
-			return fmt.Errorf("error loading %s: %v", key, err)
+	current := reflect.ValueOf(cfg).Elem()
+	next := reflect.ValueOf(&loaded).Elem()
+	var changed []string
+	for _, key := range configKeys() {
+		currentValue, nextValue := current.FieldByIndex(key.index), next.FieldByIndex(key.index)
+		if reflect.DeepEqual(currentValue.Interface(), nextValue.Interface()) {
+			continue
+		}
+		if key.field.Tag.Get("reload") != "true" {
+			utils.LogWarnContext(ctx, "Configuration change takes effect on restart", logrus.Fields{
+				"message": key.name(),
+			})
+			continue
 		}
+		currentValue.Set(nextValue)
+		changed = append(changed, key.name())
 	}
-	
-	utils.LogInfo("All environment variables loaded successfully", nil)
-	return nil
+	return changed, nil
 }
 
-// Helper function to get a string environment variable
-func getEnv(key string) (string, error) {
-	if value, exists := os.LookupEnv(key); exists {
-		return value, nil
+// PrintConfig writes cfg as YAML, with the secrets redacted. The output can be used as a
+// configuration file once the secrets are filled in.
+//
+// Returns an error if the configuration cannot be written.
+func PrintConfig(w io.Writer, cfg *Config) error {
+	mu.RLock()
+	printed := *cfg
+	mu.RUnlock()
+	redact(reflect.ValueOf(&printed).Elem())
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(printed); err != nil {
+		return err
 	}
-	return "", fmt.Errorf("%s environment variable is required", key)
+	return encoder.Close()
 }
 
-// Helper function to get an integer environment variable
-func getEnvAsInt(key string) (int, error) {
-	valueStr, err := getEnv(key)
-	if err != nil {
-		return 0, err
+// CORSOrigins returns the origins allowed to call the API. Unless they are configured, any
+// origin is allowed in development, localhost in test, and cemdev.dk otherwise.
+func (c *Config) CORSOrigins() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if len(c.Server.CORSOrigins) > 0 {
+		return append([]string(nil), c.Server.CORSOrigins...)
 	}
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return 0, fmt.Errorf("invalid %s value", key)
+	switch c.Environment.Environment {
+	case "development":
+		return []string{"*"}
+	case "test":
+		return []string{"http://localhost", "https://localhost"}
+	default:
+		return []string{"http://cemdev.dk", "https://cemdev.dk"}
 	}
-	return value, nil
 }
 
-// Helper function to get a boolean environment variable
-func getEnvAsBool(key string) (bool, error) {
-	valueStr, err := getEnv(key)
-	if err != nil {
-		return false, err
+// getOIDCProviders reads the identity providers named in the comma separated API_OIDC_PROVIDERS.
+// Each provider is configured with API_OIDC_<NAME>_ISSUER, _CLIENT_ID and _CLIENT_SECRET, and
+// optionally _DISPLAY_NAME and _SCOPES (space separated, default "openid email profile").
+// Missing variables are reported when the configuration is validated.
+func getOIDCProviders(names string) []OIDCProviderConfig {
+	providers := []OIDCProviderConfig{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := oidcEnvPrefix(name)
+		providers = append(providers, OIDCProviderConfig{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		})
 	}
-	return valueStr == "true" || valueStr == "1", nil
+	return providers
+}
+
+// oidcEnvPrefix returns the prefix of the variables of an identity provider
+func oidcEnvPrefix(name string) string {
+	return "API_OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
 }
@@ -1,58 +1,272 @@
 package config
 
-// Config is the struct that holds the application configuration
+import "time"
+
+// Config is the struct that holds the application configuration.
+//
+// Every key is read, in increasing order of precedence, from its default tag, from the
+// configuration file by its yaml name, from the environment variable in its env tag, and from
+// the command line flag named by its dotted yaml path, e.g. --server.port. The validate tag is
+// checked once all layers are applied. Keys tagged secret are redacted when the configuration is
+// printed, and keys tagged reload are picked up again on SIGHUP.
 type Config struct {
-	Environment Environment
-	JWT         JWTConfig
-	Server      ServerConfig
-	Database    DatabaseConfig
-	Pagination  PaginationConfig
-	Log         LogConfig
-	WeatherAPI  WeatherAPIConfig
+	Environment  Environment          `yaml:",inline"`
+	JWT          JWTConfig            `yaml:"jwt"`
+	Server       ServerConfig         `yaml:"server"`
+	Database     DatabaseConfig       `yaml:"database"`
+	Pagination   PaginationConfig     `yaml:"pagination"`
+	Log          LogConfig            `yaml:"log"`
+	WeatherAPI   WeatherAPIConfig     `yaml:"weather"`
+	Mail         MailConfig           `yaml:"mail"`
+	Auth         AuthConfig           `yaml:"auth"`
+	BruteForce   BruteForceConfig     `yaml:"bruteforce"`
+	Password     PasswordPolicyConfig `yaml:"password"`
+	PasswordHash PasswordHashConfig   `yaml:"password_hash"`
+	Export       ExportConfig         `yaml:"export"`
+	APIKeys      APIKeyConfig         `yaml:"api_keys"`
+	OIDC         OIDCConfig           `yaml:"oidc"`
+	Session      SessionConfig        `yaml:"session"`
+	WebAuthn     WebAuthnConfig       `yaml:"webauthn"`
+	Health       HealthConfig         `yaml:"health"`
+	RateLimit    RateLimitConfig      `yaml:"rate_limit"`
+	Tracing      TracingConfig        `yaml:"tracing"`
 }
 
 // Environment is the struct that holds the environment configuration
 type Environment struct {
-	Environment string
+	Environment string `yaml:"environment" env:"API_ENVIRONMENT" validate:"required"`
 }
 
 // JWTConfig is the struct that holds the JWT configuration
 type JWTConfig struct {
-	Secret     string
-	Expiration int
+	Secret             string        `yaml:"secret" env:"API_JWT_SECRET" validate:"required" secret:"true"`
+	Expiration         int           `yaml:"expiration" env:"API_JWT_EXPIRATION" default:"3600" validate:"min=1"`
+	CleanupInterval    time.Duration `yaml:"cleanup_interval" env:"API_JWT_CLEANUP_INTERVAL" default:"1h"`
+	CleanupGracePeriod time.Duration `yaml:"cleanup_grace_period" env:"API_JWT_CLEANUP_GRACE_PERIOD" default:"24h" validate:"min=0"`
 }
 
 // ServerConfig is the struct that holds the server configuration
 type ServerConfig struct {
-	Port int
+	Port int `yaml:"port" env:"API_SERVER_PORT" default:"8080" validate:"min=1,max=65535"`
+	// PublicURL is the externally reachable base URL of the frontend, used to build links in emails
+	PublicURL string `yaml:"public_url" env:"API_SERVER_PUBLIC_URL" default:"http://localhost" validate:"url"`
+	// TrustProxyHeaders makes the client IP come from X-Real-IP / X-Forwarded-For, set by the nginx proxy
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers" env:"API_SERVER_TRUST_PROXY_HEADERS" default:"false"`
+	// ShutdownDelay is how long the backend keeps serving after it is marked not ready, so the
+	// proxy notices before the drain begins
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"API_SERVER_SHUTDOWN_DELAY" default:"2s" validate:"min=0"`
+	// ShutdownTimeout is how long in-flight requests get to finish before the server is closed,
+	// and then how long the background jobs get to stop before the database is closed
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"API_SERVER_SHUTDOWN_TIMEOUT" default:"15s" validate:"min=0"`
+	// MaintenanceMode is reported by the health endpoints
+	MaintenanceMode bool `yaml:"maintenance_mode" env:"API_SERVER_MAINTENANCE_MODE" default:"false"`
+	// CORSOrigins are the origins allowed to call the API, "*" for any. When empty, they depend
+	// on the environment; read them with CORSOrigins, as they are reloaded on SIGHUP.
+	CORSOrigins []string `yaml:"cors_origins" env:"API_SERVER_CORS_ORIGINS" reload:"true"`
 }
 
 // DatabaseConfig is the struct that holds the database configuration for Postgres
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Name     string
-	SSLMode  string
-	Migrate  bool
+	Host     string `yaml:"host" env:"API_DATABASE_HOST" validate:"required"`
+	Port     int    `yaml:"port" env:"API_DATABASE_PORT" default:"5432" validate:"min=1,max=65535"`
+	User     string `yaml:"user" env:"API_DATABASE_USER" validate:"required"`
+	Password string `yaml:"password" env:"API_DATABASE_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"API_DATABASE_NAME" validate:"required"`
+	SSLMode  string `yaml:"ssl_mode" env:"API_DATABASE_SSL_MODE" default:"prefer" validate:"oneof=disable allow prefer require verify-ca verify-full"`
+	Migrate  bool   `yaml:"migrate" env:"API_DATABASE_MIGRATE" default:"false"`
+	// SlowQueryThreshold is how long a database operation may take before it is logged as a
+	// slow query, with its SQL but without parameter values; 0 turns the logging off
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env:"API_DATABASE_SLOW_QUERY_THRESHOLD" default:"200ms" validate:"gte=0"`
 }
 
 // PaginationConfig holds the pagination-related configuration
 type PaginationConfig struct {
-	Limit  int
-	Offset int
+	Limit  int `yaml:"limit" env:"API_PAGINATION_LIMIT" default:"10" validate:"min=1"`
+	Offset int `yaml:"offset" env:"API_PAGINATION_OFFSET" default:"0" validate:"min=0"`
 }
 
-// LogConfig holds the logging configuration
+// LogConfig holds the logging configuration. Both keys are reloaded on SIGHUP.
 type LogConfig struct {
-	Level  string
-	Format string
+	Level  string `yaml:"level" env:"API_LOG_LEVEL" default:"info" validate:"oneof=trace debug info warn warning error fatal panic" reload:"true"`
+	Format string `yaml:"format" env:"API_LOG_FORMAT" default:"json" validate:"oneof=json JSON text" reload:"true"`
 }
 
 type WeatherAPIConfig struct {
-	OpenWeatherAPIKey string
+	OpenWeatherAPIKey string `yaml:"api_key" env:"API_WEATHER_API_KEY" secret:"true"`
+}
+
+// MailConfig holds the outgoing email configuration
+type MailConfig struct {
+	// Driver selects the mailer implementation: "smtp", "file" or "log". The "log" driver is
+	// only accepted in development.
+	Driver       string `yaml:"driver" env:"API_MAIL_DRIVER" default:"log" validate:"oneof=smtp file log"`
+	From         string `yaml:"from" env:"API_MAIL_FROM" default:"no-reply@whoknows.local" validate:"email"`
+	SMTPHost     string `yaml:"smtp_host" env:"API_MAIL_SMTP_HOST" default:"localhost"`
+	SMTPPort     int    `yaml:"smtp_port" env:"API_MAIL_SMTP_PORT" default:"25" validate:"min=1,max=65535"`
+	SMTPUsername string `yaml:"smtp_username" env:"API_MAIL_SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"API_MAIL_SMTP_PASSWORD" secret:"true"`
+	// FileDir is the directory the "file" driver writes messages to
+	FileDir string `yaml:"file_dir" env:"API_MAIL_FILE_DIR" default:"./mail"`
+}
+
+// AuthConfig holds configuration for account recovery, email verification and related authentication flows
+type AuthConfig struct {
+	PasswordResetTokenTTL time.Duration `yaml:"password_reset_ttl" env:"API_AUTH_PASSWORD_RESET_TTL" default:"1h" validate:"gt=0"`
+	EmailVerificationTTL  time.Duration `yaml:"email_verification_ttl" env:"API_AUTH_EMAIL_VERIFICATION_TTL" default:"48h" validate:"gt=0"`
+	// RequireVerifiedEmail blocks login, and with it search history, until the user has verified their email
+	RequireVerifiedEmail bool `yaml:"require_verified_email" env:"API_AUTH_REQUIRE_VERIFIED_EMAIL" default:"false"`
+}
+
+// BruteForceConfig holds the login attempt throttling configuration
+type BruteForceConfig struct {
+	// Store selects where attempts are tracked: "memory" for a single instance, "database" for multiple replicas
+	Store string `yaml:"store" env:"API_BRUTEFORCE_STORE" default:"memory" validate:"oneof=memory database"`
+	// FreeAttempts is the number of failed attempts allowed before backoff starts
+	FreeAttempts int `yaml:"free_attempts" env:"API_BRUTEFORCE_FREE_ATTEMPTS" default:"5" validate:"min=0"`
+	// BaseDelay is the first lockout; every further failure doubles it
+	BaseDelay time.Duration `yaml:"base_delay" env:"API_BRUTEFORCE_BASE_DELAY" default:"1s" validate:"min=0"`
+	// MaxLockout caps the lockout duration
+	MaxLockout time.Duration `yaml:"max_lockout" env:"API_BRUTEFORCE_MAX_LOCKOUT" default:"15m" validate:"min=0"`
+	// ResetAfter forgets failures when there has been no failure for this long
+	ResetAfter time.Duration `yaml:"reset_after" env:"API_BRUTEFORCE_RESET_AFTER" default:"24h" validate:"min=0"`
+}
+
+// PasswordPolicyConfig holds the rules new passwords must satisfy
+type PasswordPolicyConfig struct {
+	MinLength int `yaml:"min_length" env:"API_PASSWORD_MIN_LENGTH" default:"8" validate:"min=1"`
+	// MinEntropyBits is the minimum estimated entropy of a password
+	MinEntropyBits int `yaml:"min_entropy" env:"API_PASSWORD_MIN_ENTROPY" default:"40" validate:"min=0"`
+	// HistorySize is the number of most recent passwords, including the current one, that can not be reused
+	HistorySize int `yaml:"history_size" env:"API_PASSWORD_HISTORY_SIZE" default:"5" validate:"min=0"`
+	// BreachedListFile is a file of breached passwords, one per line, either plain text or SHA-1 hex
+	BreachedListFile string `yaml:"breached_list_file" env:"API_PASSWORD_BREACHED_LIST_FILE"`
+	// BreachedPrefixDir is a directory of k-anonymity range files named by the first 5 hex characters of the SHA-1 hash
+	BreachedPrefixDir string `yaml:"breached_prefix_dir" env:"API_PASSWORD_BREACHED_PREFIX_DIR"`
+}
+
+// PasswordHashConfig selects how new password hashes are made. The bcrypt cost is read from BCRYPT_COST.
+type PasswordHashConfig struct {
+	// Algorithm is "argon2id" or "bcrypt"
+	Algorithm string `yaml:"algorithm" env:"API_PASSWORD_HASH_ALGORITHM" default:"argon2id" validate:"oneof=argon2id bcrypt"`
+	// Argon2Memory is the argon2id memory cost in KiB
+	Argon2Memory      int `yaml:"argon2_memory" env:"API_PASSWORD_ARGON2_MEMORY" default:"65536" validate:"min=1"`
+	Argon2Iterations  int `yaml:"argon2_iterations" env:"API_PASSWORD_ARGON2_ITERATIONS" default:"3" validate:"min=1"`
+	Argon2Parallelism int `yaml:"argon2_parallelism" env:"API_PASSWORD_ARGON2_PARALLELISM" default:"2" validate:"min=1,max=255"`
+}
+
+// ExportConfig holds the configuration of personal data exports
+type ExportConfig struct {
+	// WorkerInterval is how often pending exports are built. A non-positive interval disables the worker.
+	WorkerInterval time.Duration `yaml:"worker_interval" env:"API_EXPORT_WORKER_INTERVAL" default:"30s"`
+	// LinkTTL is how long a finished export can be downloaded before it is deleted
+	LinkTTL time.Duration `yaml:"link_ttl" env:"API_EXPORT_LINK_TTL" default:"24h" validate:"gt=0"`
+}
+
+// APIKeyConfig holds the configuration of personal API keys
+type APIKeyConfig struct {
+	// RateLimit is the default, and highest, number of requests per minute allowed for a key
+	RateLimit int `yaml:"rate_limit" env:"API_APIKEY_RATE_LIMIT" default:"60" validate:"min=1"`
+	// MaxPerUser is the number of active keys a user can have
+	MaxPerUser int `yaml:"max_per_user" env:"API_APIKEY_MAX_PER_USER" default:"10" validate:"min=0"`
 }
 
-// AppConfig is the variable that holds the application configuration
-var AppConfig Config
+// OIDCConfig holds the OpenID Connect identity providers users can log in with
+type OIDCConfig struct {
+	Providers []OIDCProviderConfig `yaml:"providers" env:"-" validate:"dive"`
+	// StateTTL is how long a user has to complete a login at the identity provider
+	StateTTL time.Duration `yaml:"state_ttl" env:"API_OIDC_STATE_TTL" default:"10m" validate:"gt=0"`
+}
+
+// OIDCProviderConfig holds the client registration at one OpenID Connect identity provider
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities, e.g. "google"
+	Name string `yaml:"name" validate:"required"`
+	// DisplayName is shown on the "Log in with" button
+	DisplayName  string   `yaml:"display_name"`
+	Issuer       string   `yaml:"issuer" validate:"required,url"`
+	ClientID     string   `yaml:"client_id" validate:"required"`
+	ClientSecret string   `yaml:"client_secret" validate:"required" secret:"true"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Session modes select how login sessions are handed to clients
+const (
+	// SessionModeBearer returns the session token in the login response, to be sent as a Bearer token
+	SessionModeBearer = "bearer"
+	// SessionModeCookie keeps the session token in an HttpOnly cookie, out of reach of scripts
+	SessionModeCookie = "cookie"
+	// SessionModeBoth sets the cookie and also returns the token, for a mix of browsers and API clients
+	SessionModeBoth = "both"
+)
+
+// SessionConfig holds the configuration of login sessions. Bearer tokens in the Authorization
+// header are accepted in every mode.
+type SessionConfig struct {
+	// Mode is one of SessionModeBearer, SessionModeCookie or SessionModeBoth
+	Mode string `yaml:"mode" env:"API_SESSION_MODE" default:"bearer" validate:"oneof=bearer cookie both"`
+	// CookieSecure only sends the session cookies over HTTPS
+	CookieSecure bool `yaml:"cookie_secure" env:"API_SESSION_COOKIE_SECURE" default:"true"`
+	// CookieSameSite is the SameSite attribute of the session cookies: "lax", "strict" or "none"
+	CookieSameSite string `yaml:"cookie_samesite" env:"API_SESSION_COOKIE_SAMESITE" default:"lax" validate:"oneof=lax strict none"`
+	// CookieDomain is the Domain attribute of the session cookies, empty for the host of the API
+	CookieDomain string `yaml:"cookie_domain" env:"API_SESSION_COOKIE_DOMAIN"`
+}
+
+// CookieSessions reports whether logins set a session cookie
+func (c SessionConfig) CookieSessions() bool {
+	return c.Mode == SessionModeCookie || c.Mode == SessionModeBoth
+}
+
+// WebAuthnConfig holds the relying party settings for passkeys
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are bound to, by default the host of the public URL.
+	// Changing it makes all registered passkeys unusable.
+	RPID string `yaml:"rp_id" env:"API_WEBAUTHN_RP_ID"`
+	// RPName is shown by the browser when a passkey is created
+	RPName string `yaml:"rp_name" env:"API_WEBAUTHN_RP_NAME" default:"WhoKnows"`
+	// Origins are the origins the frontend is served from, by default the origin of the public URL
+	Origins []string `yaml:"origins" env:"API_WEBAUTHN_ORIGINS"`
+	// ChallengeTTL is how long a user has to complete a passkey registration or login
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" env:"API_WEBAUTHN_CHALLENGE_TTL" default:"5m" validate:"gt=0"`
+}
+
+// HealthConfig holds the timeouts of the readiness checks
+type HealthConfig struct {
+	// DatabaseTimeout bounds the database connectivity and migration checks
+	DatabaseTimeout time.Duration `yaml:"database_timeout" env:"API_HEALTH_DATABASE_TIMEOUT" default:"2s" validate:"gt=0"`
+	// WeatherTimeout bounds the weather provider check, which is skipped when it is zero
+	WeatherTimeout time.Duration `yaml:"weather_timeout" env:"API_HEALTH_WEATHER_TIMEOUT" default:"0s" validate:"min=0"`
+}
+
+// RateLimitConfig holds the request rate limits of the routes that are open to anonymous
+// clients. Requests made with an API key or a session are counted per key or user, other
+// requests per client IP; routes without an authenticated limit count everyone per client IP.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" env:"API_RATE_LIMIT_ENABLED" default:"true"`
+	// Store selects where the buckets are kept: "memory" for a single instance, "database" for multiple replicas
+	Store string `yaml:"store" env:"API_RATE_LIMIT_STORE" default:"memory" validate:"oneof=memory database"`
+	// Search limits anonymous searches, SearchAuthenticated the searches of users; API keys have their own limit
+	Search              Rate `yaml:"search" env:"API_RATE_LIMIT_SEARCH" default:"30/1m"`
+	SearchAuthenticated Rate `yaml:"search_authenticated" env:"API_RATE_LIMIT_SEARCH_AUTHENTICATED" default:"120/1m"`
+	Register            Rate `yaml:"register" env:"API_RATE_LIMIT_REGISTER" default:"5/1h"`
+	// Login limits the login and second factor requests; failed attempts are also throttled by the brute-force protection
+	Login         Rate `yaml:"login" env:"API_RATE_LIMIT_LOGIN" default:"30/1m"`
+	PasswordReset Rate `yaml:"password_reset" env:"API_RATE_LIMIT_PASSWORD_RESET" default:"5/1h"`
+}
+
+// TracingConfig selects where the OpenTelemetry traces of requests, database operations and
+// outbound calls are exported to. The OTLP exporter also reads the standard OTEL_EXPORTER_OTLP_*
+// environment variables, e.g. for headers.
+type TracingConfig struct {
+	// Exporter is "none" to only propagate trace context, "otlp" to send spans to a collector
+	// over OTLP/HTTP, or "stdout" or "file" to write them as JSON for local runs
+	Exporter string `yaml:"exporter" env:"API_TRACING_EXPORTER" default:"none" validate:"oneof=none otlp stdout file"`
+	// Endpoint is the URL of the OTLP collector, e.g. http://otel-collector:4318; when empty the
+	// exporter uses OTEL_EXPORTER_OTLP_ENDPOINT, or localhost
+	Endpoint string `yaml:"endpoint" env:"API_TRACING_ENDPOINT" validate:"omitempty,url"`
+	// File is the file spans are appended to by the file exporter
+	File string `yaml:"file" env:"API_TRACING_FILE" default:"traces.json" validate:"required_if=Exporter file"`
+	// SampleRatio is the share of new traces that are recorded; traces started upstream keep their decision
+	SampleRatio float64 `yaml:"sample_ratio" env:"API_TRACING_SAMPLE_RATIO" default:"1" validate:"gte=0,lte=1"`
+	ServiceName string  `yaml:"service_name" env:"API_TRACING_SERVICE_NAME" default:"whoknows-backend" validate:"required"`
+}
@@ -0,0 +1,512 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Problem is a configuration key that is missing or invalid
+type Problem struct {
+	// Key is the environment variable of the key, or its dotted path when it has none
+	Key     string
+	Message string
+}
+
+// ValidationError lists every missing or invalid configuration key, in the order they are
+// declared in Config, so they can all be fixed at once
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Key + " " + problem.Message
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// configKey is a field of Config that holds a value, as opposed to a section
+type configKey struct {
+	// path is the dotted yaml path of the key, which is also the name of its flag
+	path string
+	// env is the environment variable of the key, empty if it is not read from the environment
+	env string
+	// namespace is the path of the field as reported by the validator, e.g. "Config.Server.Port"
+	namespace string
+	index     []int
+	field     reflect.StructField
+}
+
+// name is how the key is referred to in errors and logs
+func (k configKey) name() string {
+	if k.env != "" {
+		return k.env
+	}
+	return k.path
+}
+
+// configKeys returns the keys of Config in the order they are declared
+func configKeys() []configKey {
+	var keys []configKey
+	var walk func(t reflect.Type, path, namespace string, index []int)
+	walk = func(t reflect.Type, path, namespace string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			fieldPath := path
+			if options != "inline" {
+				fieldPath = strings.TrimPrefix(path+"."+name, ".")
+			}
+			fieldIndex := append(append([]int{}, index...), i)
+
+			if field.Type.Kind() == reflect.Struct && !reflect.PointerTo(field.Type).Implements(textUnmarshalerType) {
+				walk(field.Type, fieldPath, namespace+"."+field.Name, fieldIndex)
+				continue
+			}
+			env := field.Tag.Get("env")
+			if env == "-" {
+				env = ""
+			}
+			keys = append(keys, configKey{
+				path:      fieldPath,
+				env:       env,
+				namespace: namespace + "." + field.Name,
+				index:     fieldIndex,
+				field:     field,
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", "Config", nil)
+	return keys
+}
+
+// problemList collects problems with the position of their key, so they can be reported in
+// declaration order whichever layer found them
+type problemList struct {
+	problems []Problem
+	order    []int
+	reported map[string]bool
+}
+
+func (l *problemList) add(order int, key, message string) {
+	if l.reported == nil {
+		l.reported = map[string]bool{}
+	}
+	// A value that could not be parsed is not validated again
+	if l.reported[key] {
+		return
+	}
+	l.reported[key] = true
+	l.problems = append(l.problems, Problem{Key: key, Message: message})
+	l.order = append(l.order, order)
+}
+
+// err returns the problems sorted by key position, or nil if there are none
+func (l *problemList) err() error {
+	if len(l.problems) == 0 {
+		return nil
+	}
+	positions := make([]int, len(l.problems))
+	for i := range positions {
+		positions[i] = i
+	}
+	sort.SliceStable(positions, func(i, j int) bool { return l.order[positions[i]] < l.order[positions[j]] })
+
+	sorted := make([]Problem, len(positions))
+	for i, position := range positions {
+		sorted[i] = l.problems[position]
+	}
+	return &ValidationError{Problems: sorted}
+}
+
+// flagValue holds the text of a command line flag until it is applied over the other layers
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string   { return f.value }
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+func (f *flagValue) Set(value string) error {
+	f.value, f.set = value, true
+	return nil
+}
+
+// buildConfig loads the configuration from its layers: the defaults, the configuration file,
+// the environment and the command line flags. It does not remember the layers for Reload.
+//
+// Parameters:
+//   - args: The command line arguments, without the program name.
+//
+// Returns:
+//   - Config: The loaded configuration.
+//   - *CommandLine: The options and arguments left on the command line.
+//   - error: A *ValidationError listing every missing or invalid key, or an error if the
+//     command line or the configuration file cannot be read.
+func buildConfig(args []string) (Config, *CommandLine, error) {
+	var cfg Config
+	var problems problemList
+	root := reflect.ValueOf(&cfg).Elem()
+	keys := configKeys()
+
+	flags := flag.NewFlagSet("whoknows", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: whoknows [flags] [migrate up|down|status|to <version> | <command>]")
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", "", "`path` of a YAML configuration file, also read from API_CONFIG_FILE")
+	printConfig := flags.Bool("print-config", false, "print the configuration with secrets redacted, and exit")
+	flagValues := make([]*flagValue, len(keys))
+	for i, key := range keys {
+		if !canParse(key.field.Type) {
+			continue
+		}
+		flagValues[i] = &flagValue{isBool: key.field.Type.Kind() == reflect.Bool}
+		usage := "overrides the configuration file"
+		if key.env != "" {
+			usage = "overrides " + key.env
+		}
+		flags.Var(flagValues[i], key.path, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	// Defaults
+	for i, key := range keys {
+		if value, ok := key.field.Tag.Lookup("default"); ok {
+			if err := setValue(root.FieldByIndex(key.index), value); err != nil {
+				problems.add(i, key.name(), "has an invalid default: "+err.Error())
+			}
+		}
+	}
+
+	// Configuration file
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("API_CONFIG_FILE")
+	}
+	if path != "" {
+		if err := readConfigFile(path, &cfg, &problems); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	// Environment
+	for i, key := range keys {
+		if key.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(key.env); ok && value != "" {
+			if err := setValue(root.FieldByIndex(key.index), value); err != nil {
+				problems.add(i, key.env, err.Error())
+			}
+		}
+	}
+	providersFromEnv := false
+	if names := os.Getenv("API_OIDC_PROVIDERS"); names != "" {
+		cfg.OIDC.Providers = getOIDCProviders(names)
+		providersFromEnv = true
+	}
+
+	// Command line flags. A key set by a flag is named by the flag in errors.
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.name()
+		if flagValues[i] != nil && flagValues[i].set {
+			names[i] = "--" + key.path
+			if err := setValue(root.FieldByIndex(key.index), flagValues[i].value); err != nil {
+				problems.add(i, names[i], err.Error())
+			}
+		}
+	}
+
+	normalize(&cfg)
+	validateConfig(cfg, keys, names, providersFromEnv, &problems)
+
+	if err := problems.err(); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, &CommandLine{PrintConfig: *printConfig, Args: flags.Args()}, nil
+}
+
+// readConfigFile decodes a YAML configuration file over cfg. Keys that are not known, and
+// values of the wrong type, are added to problems.
+func readConfigFile(path string, cfg *Config, problems *problemList) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("unsupported configuration file %s, only YAML is supported", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading configuration file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(cfg)
+	var typeErr *yaml.TypeError
+	switch {
+	case errors.As(err, &typeErr):
+		for _, message := range typeErr.Errors {
+			problems.add(-1, filepath.Base(path), message)
+		}
+	case err != nil && err != io.EOF:
+		return fmt.Errorf("error reading configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// canParse reports whether a key of the given type can be set from text
+func canParse(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setValue parses text into a key. Lists are separated by commas or spaces, and types such
+// as Rate parse themselves.
+func setValue(v reflect.Value, text string) error {
+	switch {
+	case v.Addr().Type().Implements(textUnmarshalerType):
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return errors.New("must be a duration, e.g. 30s or 1h")
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(text)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		values := strings.Fields(strings.ReplaceAll(text, ",", " "))
+		if len(values) == 0 {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(values))
+		}
+	default:
+		return fmt.Errorf("can not be set from %q", text)
+	}
+	return nil
+}
+
+// normalize fills in the defaults of the identity providers, and lower cases the keys that
+// are matched case-insensitively
+func normalize(cfg *Config) {
+	cfg.Session.Mode = strings.ToLower(cfg.Session.Mode)
+	cfg.Session.CookieSameSite = strings.ToLower(cfg.Session.CookieSameSite)
+
+	for i := range cfg.OIDC.Providers {
+		provider := &cfg.OIDC.Providers[i]
+		provider.Name = strings.ToLower(strings.TrimSpace(provider.Name))
+		if provider.DisplayName == "" {
+			provider.DisplayName = provider.Name
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+}
+
+// validateConfig checks the validate tags of cfg and adds a problem for every failed key,
+// named by names, which has the name of every key
+func validateConfig(cfg Config, keys []configKey, names []string, providersFromEnv bool, problems *problemList) {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		return name
+	})
+
+	positions := make(map[string]int, len(keys))
+	providersPosition := 0
+	for i, key := range keys {
+		positions[key.namespace] = i
+		if key.namespace == "Config.OIDC.Providers" {
+			providersPosition = i
+		}
+	}
+
+	// Mail links carry reset and verification tokens, which must not end up in the log
+	if cfg.Mail.Driver == "log" && cfg.Environment.Environment != "development" {
+		position := positions["Config.Mail.Driver"]
+		problems.add(position, names[position], "must not be log outside development")
+	}
+
+	err := validate.Struct(cfg)
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return
+	}
+	for _, fieldError := range fieldErrors {
+		if position, ok := positions[fieldError.StructNamespace()]; ok {
+			problems.add(position, names[position], validationMessage(fieldError))
+			continue
+		}
+
+		// A field of an identity provider is named by its variable when the provider is
+		// configured in the environment, otherwise by its path in the configuration file
+		name := strings.TrimPrefix(fieldError.Namespace(), "Config.")
+		if providersFromEnv {
+			if provider, ok := providerAt(cfg.OIDC.Providers, fieldError.StructNamespace()); ok {
+				name = oidcEnvPrefix(provider.Name) + strings.ToUpper(fieldError.Field())
+			}
+		}
+		problems.add(providersPosition, name, validationMessage(fieldError))
+	}
+}
+
+// providerAt returns the identity provider a namespace such as
+// "Config.OIDC.Providers[1].Issuer" points into
+func providerAt(providers []OIDCProviderConfig, namespace string) (OIDCProviderConfig, bool) {
+	rest, ok := strings.CutPrefix(namespace, "Config.OIDC.Providers[")
+	if !ok {
+		return OIDCProviderConfig{}, false
+	}
+	index, _, _ := strings.Cut(rest, "]")
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(providers) {
+		return OIDCProviderConfig{}, false
+	}
+	return providers[i], true
+}
+
+// validationMessage describes a failed validate tag
+func validationMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fieldError.Param()), ", ")
+	case "min":
+		return "must be at least " + fieldError.Param()
+	case "max":
+		return "must be at most " + fieldError.Param()
+	case "gt":
+		return "must be greater than " + fieldError.Param()
+	case "url":
+		return "must be a URL"
+	case "email":
+		return "must be an email address"
+	}
+	return fmt.Sprintf("failed the %s check", fieldError.Tag())
+}
+
+// redact replaces the non-empty keys tagged secret in v, a struct, with a placeholder. Slices
+// are copied before their elements are redacted, as they share memory with the original.
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		switch {
+		case field.Tag.Get("secret") == "true":
+			if value.Kind() == reflect.String && value.Len() > 0 {
+				value.SetString("<REDACTED>")
+			}
+		case value.Kind() == reflect.Struct:
+			redact(value)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			elements := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+			reflect.Copy(elements, value)
+			for j := 0; j < elements.Len(); j++ {
+				redact(elements.Index(j))
+			}
+			value.Set(elements)
+		}
+	}
+}
+
+// envFile is the .env file named by ENV_FILE_PATH. Its variables never override the ones set
+// in the environment of the process, and are read again when the configuration is reloaded.
+type envFile struct {
+	path string
+	// external are the variables set in the environment before the file was read
+	external map[string]bool
+	// loaded are the variables set from the file
+	loaded map[string]bool
+}
+
+// newEnvFile remembers which variables are set in the environment, before reading the file
+func newEnvFile(path string) *envFile {
+	external := map[string]bool{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		external[name] = true
+	}
+	return &envFile{path: path, external: external, loaded: map[string]bool{}}
+}
+
+// apply sets the variables of the file in the environment, and unsets the ones removed from
+// it since it was last applied
+func (f *envFile) apply() error {
+	if f == nil || f.path == "" {
+		return nil
+	}
+	values, err := godotenv.Read(f.path)
+	if err != nil {
+		return err
+	}
+
+	for name := range f.loaded {
+		if _, ok := values[name]; !ok {
+			os.Unsetenv(name)
+			delete(f.loaded, name)
+		}
+	}
+	for name, value := range values {
+		if !f.external[name] {
+			os.Setenv(name, value)
+			f.loaded[name] = true
+		}
+	}
+	return nil
+}
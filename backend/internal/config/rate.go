@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of requests allowed per period. It is written as <requests>/<period>,
+// such as 30/1m or 5/1h, or as "off" for no limit, which is also its zero value.
+type Rate struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Off reports whether the rate does not limit anything
+func (r Rate) Off() bool {
+	return r.Requests == 0
+}
+
+// String returns the rate as it is written in the configuration
+func (r Rate) String() string {
+	if r.Off() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, formatPeriod(r.Period))
+}
+
+// MarshalText writes the rate as it is written in the configuration
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText parses a rate written as <requests>/<period> or "off"
+func (r *Rate) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "" || strings.EqualFold(value, "off") {
+		*r = Rate{}
+		return nil
+	}
+
+	invalid := errors.New("must be a rate, e.g. 30/1m, or off")
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return invalid
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n < 1 {
+		return invalid
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return invalid
+	}
+
+	*r = Rate{Requests: n, Period: d}
+	return nil
+}
+
+// formatPeriod writes whole hours, minutes and seconds without the trailing zero units
+// time.Duration.String adds, e.g. 1m instead of 1m0s
+func formatPeriod(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
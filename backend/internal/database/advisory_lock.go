@@ -0,0 +1,53 @@
+package database
+
+import (
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Advisory lock keys used by background jobs and migrations. Every replica uses the same
+// key for the same job, so only one of them does the work at a time.
+const (
+	TokenCleanupLockKey int64 = 7_310_001
+	MigrationLockKey    int64 = 7_310_002
+)
+
+// WithAdvisoryLock runs fn inside a transaction guarded by a Postgres transaction-level
+// advisory lock. The lock is released automatically when the transaction ends, so a
+// crashed replica can never hold it forever.
+//
+// If another session already holds the lock, fn is not run and acquired is false.
+// On databases without advisory locks (the SQLite test database), fn always runs.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//   - key: The advisory lock key.
+//   - fn: The function to run while the lock is held.
+//
+// Returns:
+//   - bool: Whether the lock was acquired and fn was run.
+//   - error: An error if acquiring the lock or running fn fails.
+func WithAdvisoryLock(db *gorm.DB, key int64, fn func(tx *gorm.DB) error) (bool, error) {
+	acquired := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", key).Scan(&acquired).Error; err != nil {
+				return errors.Wrap(err, "failed to acquire advisory lock")
+			}
+			if !acquired {
+				utils.LogInfoContext(db.Statement.Context, "Advisory lock is held by another session", nil)
+				return nil
+			}
+		}
+
+		acquired = true
+		return fn(tx)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return acquired, nil
+}
@@ -1,108 +1,129 @@
 package database
 
 import (
+	"context"
 	"fmt"
-	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/config"
-	"github.com/CEM-KEA/whoknows/backend/internal/models"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
-	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 
-// DB is a global variable that holds the database connection instance
-// managed by GORM. It is used throughout the application to interact
-// with the database.
-var DB *gorm.DB
-
-
-// InitDatabase initializes the database connection using the configuration
-// specified in the application configuration. It sets up the Data Source Name (DSN)
-// for Postgres, opens the database connection using GORM, registers database
-// callbacks for Prometheus metrics, and performs schema migrations if enabled
-// in the configuration.
+// InitDatabase opens a connection to the Postgres database described by cfg, registers the
+// database callbacks for Prometheus metrics and slow query logging, and performs schema
+// migrations if enabled in cfg. The connection and the migrations are logged with ctx.
 //
-// Returns an error if the database connection fails or if there is an error
-// during schema migration.
-func InitDatabase() error {
-	utils.LogInfo("Setting up database connection", nil)
+// Returns the connection, or an error if the database connection fails or if there is an
+// error during schema migration.
+func InitDatabase(ctx context.Context, cfg config.DatabaseConfig) (*gorm.DB, error) {
+	utils.LogInfoContext(ctx, "Setting up database connection", nil)
 	dsn := fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
-		config.AppConfig.Database.Host,
-		config.AppConfig.Database.User,
-		config.AppConfig.Database.Password,
-		config.AppConfig.Database.Name,
-		config.AppConfig.Database.Port,
-		config.AppConfig.Database.SSLMode,
+		cfg.Host,
+		cfg.User,
+		cfg.Password,
+		cfg.Name,
+		cfg.Port,
+		cfg.SSLMode,
 	)
 
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
-		utils.LogError(err, "Failed to connect to Postgres database", nil)
-		return fmt.Errorf("error connecting to Postgres database: %s", err)
+		utils.LogErrorContext(ctx, err, "Failed to connect to Postgres database", nil)
+		return nil, fmt.Errorf("error connecting to Postgres database: %s", err)
 	}
 
-	// Register the database callbacks for Prometheus metrics
-	RegisterCallbacks(DB)
+	// Register the database callbacks for Prometheus metrics and slow query logging
+	RegisterCallbacks(db, cfg.SlowQueryThreshold)
 
-	utils.LogInfo("Database connection established", nil)
+	utils.LogInfoContext(ctx, "Database connection established", nil)
 
-	if config.AppConfig.Database.Migrate {
-		return autoMigrate()
+	if cfg.Migrate {
+		return db, migrateSchema(db.WithContext(ctx))
 	}
 
-	utils.LogInfo("Database schema migration skipped", nil)
-	return nil
+	utils.LogInfoContext(ctx, "Database schema migration skipped", nil)
+	return db, nil
 }
 
-// InitTestDatabase initializes an in-memory SQLite database for testing purposes.
-// It sets up the database connection using GORM and performs auto-migration.
+// InitTestDatabase opens an in-memory SQLite database for testing purposes and applies the
+// schema migrations. Every call returns a new, empty database. The connection and the
+// migrations are logged with ctx.
 //
-// Returns an error if the database connection or migration fails.
-func InitTestDatabase() error {
-	utils.LogInfo("Setting up SQLite in-memory test database", nil)
-	var err error
-	DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+// Returns the connection, or an error if the database connection or migration fails.
+func InitTestDatabase(ctx context.Context) (*gorm.DB, error) {
+	utils.LogInfoContext(ctx, "Setting up SQLite in-memory test database", nil)
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
-		utils.LogError(err, "Failed to connect to SQLite in-memory database", nil)
-		return fmt.Errorf("error connecting to SQLite in-memory database: %s", err)
+		utils.LogErrorContext(ctx, err, "Failed to connect to SQLite in-memory database", nil)
+		return nil, fmt.Errorf("error connecting to SQLite in-memory database: %s", err)
 	}
 
-	utils.LogInfo("SQLite in-memory test database connection established", nil)
+	utils.LogInfoContext(ctx, "SQLite in-memory test database connection established", nil)
 
-	return autoMigrate()
+	return db, migrateSchema(db.WithContext(ctx))
 }
 
-// autoMigrate handles the automatic migration of the database schema.
-// It logs the start and end of the migration process, and performs the migration
-// using gormigrate. If the migration fails, it logs the error and returns it.
+// CloseDatabase closes the connection pool of db. It is called last when the backend shuts
+// down, after the HTTP server and the background jobs have stopped using the database.
 //
-// Returns an error if the migration fails.
-func autoMigrate() error {
-	utils.LogInfo("Migrating database schema", nil)
-	m := gormigrate.New(DB, gormigrate.DefaultOptions, []*gormigrate.Migration{
-		{
-			ID: time.Now().Format("20060102150405"),
-			Migrate: func(tx *gorm.DB) error {
-				return tx.AutoMigrate(&models.User{}, &models.Page{}, &models.JWT{}, &models.SearchLog{})
-			},
-			Rollback: func(tx *gorm.DB) error {
-				return tx.Migrator().DropTable(&models.User{}, &models.Page{}, &models.JWT{}, &models.SearchLog{})
-			},
-		},
-	})
+// Returns an error if the pool cannot be closed.
+func CloseDatabase(ctx context.Context, db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("error getting the database connection pool: %s", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		return fmt.Errorf("error closing the database connection pool: %s", err)
+	}
+
+	utils.LogInfoContext(ctx, "Database connection closed", nil)
+	return nil
+}
 
-	err := m.Migrate()
+// migrateSchema applies the pending schema migrations to db.
+//
+// Returns an error if a migration fails.
+func migrateSchema(db *gorm.DB) error {
+	utils.LogInfoContext(db.Statement.Context, "Migrating database schema", nil)
+	applied, err := MigrateUp(db)
 	if err != nil {
-		utils.LogError(err, "Failed to migrate database schema", nil)
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to migrate database schema", nil)
 		return fmt.Errorf("error migrating database: %s", err)
 	}
 
-	utils.LogInfo("Database schema migration successful", nil)
+	utils.LogInfoContext(db.Statement.Context, "Database schema migration successful", logrus.Fields{
+		"message": fmt.Sprintf("%d migrations applied", applied),
+	})
 	return nil
 }
+
+// RegisterPoolMetrics registers the Prometheus metrics of the connection pool of db.
+//
+// Returns an error if the pool cannot be reached or the metrics cannot be registered.
+func RegisterPoolMetrics(db *gorm.DB, name string) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("error getting the database connection pool: %s", err)
+	}
+	return utils.RegisterDBPoolMetrics(sqlDB, name)
+}
+
+// Ping checks that the database answers within the deadline of ctx.
+//
+// Returns an error if the database cannot be reached.
+func Ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("error getting the database connection pool: %s", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/database/migrations"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrUnknownMigration is returned when a migration version is not known to this release
+var ErrUnknownMigration = errors.New("unknown migration version")
+
+// legacyMigrationTable is the table gormigrate recorded its migrations in, before
+// versioned migrations. The IDs of the migrations that ran there map to their versions.
+const legacyMigrationTable = "migrations"
+
+var legacyMigrationIDs = map[string]int{
+	"20241031000000_must_change_password":     2,
+	"20241101000000_audit_events_append_only": 3,
+}
+
+// MigrationState is a known migration and when it was applied, nil if it is pending
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// MigrateUp applies every pending migration. Migrations from a newer release that has
+// already migrated the database are left alone.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//
+// Returns:
+//   - int: The number of migrations applied.
+//   - error: An error if a migration fails, in which case none of them are applied.
+func MigrateUp(db *gorm.DB) (int, error) {
+	return migrateTo(db, migrations.Latest(), false)
+}
+
+// MigrateDown rolls back the last applied migration.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//
+// Returns:
+//   - int: The number of migrations rolled back, 0 if none was applied.
+//   - error: An error if the rollback fails.
+func MigrateDown(db *gorm.DB) (int, error) {
+	steps := 0
+	err := withMigrationLock(db, func(tx *gorm.DB) error {
+		applied, err := appliedMigrations(tx)
+		if err != nil || len(applied) == 0 {
+			return err
+		}
+
+		last := applied[len(applied)-1]
+		migration, ok := findMigration(last.Version)
+		if !ok {
+			return errors.Wrapf(ErrUnknownMigration, "cannot roll back migration %d", last.Version)
+		}
+		if err := rollBack(tx, migration); err != nil {
+			return err
+		}
+		steps++
+		return nil
+	})
+	return steps, err
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at the given version.
+// Version 0 rolls back every migration. All steps run in one transaction, under an advisory
+// lock on Postgres, so replicas starting at the same time do not migrate twice, and a failed
+// step leaves the schema as it was.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//   - version: The version to migrate to.
+//
+// Returns:
+//   - int: The number of migrations applied or rolled back.
+//   - error: An error if the version is unknown or a step fails.
+func MigrateTo(db *gorm.DB, version int) (int, error) {
+	if version < 0 || version > migrations.Latest() {
+		return 0, errors.Wrapf(ErrUnknownMigration, "version %d", version)
+	}
+	return migrateTo(db, version, true)
+}
+
+// migrateTo applies the pending migrations up to version, and, if rollBackNewer is set,
+// rolls back the applied migrations after it
+func migrateTo(db *gorm.DB, version int, rollBackNewer bool) (int, error) {
+	steps := 0
+	err := withMigrationLock(db, func(tx *gorm.DB) error {
+		if err := adoptLegacyMigrations(tx); err != nil {
+			return err
+		}
+		applied, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+
+		isApplied := make(map[int]bool, len(applied))
+		for _, row := range applied {
+			isApplied[row.Version] = true
+		}
+
+		// Roll back from the newest applied migration down to version
+		for i := len(applied) - 1; i >= 0 && rollBackNewer; i-- {
+			if applied[i].Version <= version {
+				break
+			}
+			migration, ok := findMigration(applied[i].Version)
+			if !ok {
+				return errors.Wrapf(ErrUnknownMigration, "cannot roll back migration %d", applied[i].Version)
+			}
+			if err := rollBack(tx, migration); err != nil {
+				return err
+			}
+			steps++
+		}
+
+		for _, migration := range migrations.All() {
+			if migration.Version > version || isApplied[migration.Version] {
+				continue
+			}
+			if err := apply(tx, migration); err != nil {
+				return err
+			}
+			steps++
+		}
+		return nil
+	})
+	return steps, err
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//
+// Returns:
+//   - []MigrationState: The migrations in version order.
+//   - error: An error if the applied migrations cannot be read.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	applied := map[int]models.SchemaMigration{}
+	if db.Migrator().HasTable(&models.SchemaMigration{}) {
+		var rows []models.SchemaMigration
+		if err := db.Find(&rows).Error; err != nil {
+			return nil, errors.Wrap(err, "failed to read applied migrations")
+		}
+		for _, row := range rows {
+			applied[row.Version] = row
+		}
+	}
+
+	var states []MigrationState
+	for _, migration := range migrations.All() {
+		state := MigrationState{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// CheckMigrations checks that the schema is migrated. Migrations are applied in order, so
+// the schema is up to date when the last migration of this release has been recorded. A newer
+// release may have applied later migrations already, which does not make the schema outdated.
+//
+// Returns an error if the last migration has not been applied.
+func CheckMigrations(ctx context.Context, db *gorm.DB) error {
+	latest := migrations.Latest()
+
+	var applied int64
+	if err := db.WithContext(ctx).Model(&models.SchemaMigration{}).
+		Where("version = ?", latest).
+		Count(&applied).Error; err != nil {
+		return fmt.Errorf("error reading the applied migrations: %s", err)
+	}
+	if applied == 0 {
+		return fmt.Errorf("migration %d has not been applied", latest)
+	}
+	return nil
+}
+
+// withMigrationLock runs fn in a transaction that holds the migration advisory lock on
+// Postgres, waiting for another replica to finish migrating first
+func withMigrationLock(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", MigrationLockKey).Error; err != nil {
+				return errors.Wrap(err, "failed to acquire migration lock")
+			}
+		}
+		if err := tx.AutoMigrate(&models.SchemaMigration{}); err != nil {
+			return errors.Wrap(err, "failed to create the schema_migrations table")
+		}
+		return fn(tx)
+	})
+}
+
+// appliedMigrations returns the applied migrations in version order. A recorded name that
+// differs from the known migration means the migration was changed after it was released.
+func appliedMigrations(tx *gorm.DB) ([]models.SchemaMigration, error) {
+	var applied []models.SchemaMigration
+	if err := tx.Order("version").Find(&applied).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read applied migrations")
+	}
+
+	for _, row := range applied {
+		migration, ok := findMigration(row.Version)
+		if !ok {
+			utils.LogWarnContext(tx.Statement.Context, "Database has a migration unknown to this release", logrus.Fields{
+				"message": fmt.Sprintf("%d_%s", row.Version, row.Name),
+			})
+			continue
+		}
+		if migration.Name != row.Name {
+			return nil, fmt.Errorf("migration %d was applied as %q but is now %q; applied migrations must not be changed", row.Version, row.Name, migration.Name)
+		}
+	}
+	return applied, nil
+}
+
+// adoptLegacyMigrations records the migrations gormigrate applied before versioned
+// migrations, the first time the schema is migrated. The baseline is not recorded, as older
+// releases may not have created all of its tables; it is safe to run on such a database.
+func adoptLegacyMigrations(tx *gorm.DB) error {
+	var recorded int64
+	if err := tx.Model(&models.SchemaMigration{}).Count(&recorded).Error; err != nil {
+		return errors.Wrap(err, "failed to read applied migrations")
+	}
+	if recorded > 0 || !tx.Migrator().HasTable(legacyMigrationTable) {
+		return nil
+	}
+
+	var legacyIDs []string
+	if err := tx.Table(legacyMigrationTable).Pluck("id", &legacyIDs).Error; err != nil {
+		return errors.Wrap(err, "failed to read legacy migrations")
+	}
+
+	var adopted []models.SchemaMigration
+	for _, id := range legacyIDs {
+		if version, ok := legacyMigrationIDs[id]; ok {
+			migration, _ := findMigration(version)
+			adopted = append(adopted, models.SchemaMigration{Version: version, Name: migration.Name, AppliedAt: time.Now()})
+		}
+	}
+	if len(adopted) == 0 {
+		return nil
+	}
+	sort.Slice(adopted, func(i, j int) bool { return adopted[i].Version < adopted[j].Version })
+
+	utils.LogInfoContext(tx.Statement.Context, "Adopting migrations applied before versioned migrations", logrus.Fields{
+		"message": fmt.Sprintf("%d migrations adopted", len(adopted)),
+	})
+	return tx.Create(&adopted).Error
+}
+
+// apply runs a migration and records it
+func apply(tx *gorm.DB, migration migrations.Migration) error {
+	utils.LogInfoContext(tx.Statement.Context, "Applying migration", logrus.Fields{
+		"message": fmt.Sprintf("%d_%s", migration.Version, migration.Name),
+	})
+	if err := migration.Up(tx); err != nil {
+		return errors.Wrapf(err, "failed to apply migration %d_%s", migration.Version, migration.Name)
+	}
+	row := models.SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+	return errors.Wrap(tx.Create(&row).Error, "failed to record migration")
+}
+
+// rollBack reverts a migration and removes its record
+func rollBack(tx *gorm.DB, migration migrations.Migration) error {
+	utils.LogInfoContext(tx.Statement.Context, "Rolling back migration", logrus.Fields{
+		"message": fmt.Sprintf("%d_%s", migration.Version, migration.Name),
+	})
+	if err := migration.Down(tx); err != nil {
+		return errors.Wrapf(err, "failed to roll back migration %d_%s", migration.Version, migration.Name)
+	}
+	return errors.Wrap(tx.Delete(&models.SchemaMigration{}, migration.Version).Error, "failed to remove migration record")
+}
+
+// findMigration returns the known migration with the given version
+func findMigration(version int) (migrations.Migration, bool) {
+	for _, migration := range migrations.All() {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return migrations.Migration{}, false
+}
@@ -0,0 +1,223 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The tables as they were when versioned migrations were introduced. Before that, the schema
+// was kept up to date by running AutoMigrate on the models at every boot, so the baseline also
+// uses AutoMigrate: on a database created that way it only adds what an older release had not
+// created yet, and leaves the data alone.
+
+type baselineUser struct {
+	ID                 uint      `gorm:"primaryKey"`
+	Username           string    `gorm:"type:varchar(100);uniqueIndex;not null"`
+	Email              string    `gorm:"type:varchar(100);uniqueIndex;not null"`
+	PasswordHash       string    `gorm:"not null"`
+	LastLogin          time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	VerifiedAt         *time.Time
+	TOTPSecret         string `gorm:"type:varchar(64)"`
+	TOTPEnabledAt      *time.Time
+	TOTPLastStep       int64
+	MustChangePassword bool `gorm:"not null;default:false"`
+	PasswordChangedAt  *time.Time
+	Role               string `gorm:"type:varchar(20);not null;default:'user'"`
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselinePage struct {
+	ID        uint   `gorm:"primaryKey"`
+	Title     string `gorm:"type:varchar(255);not null"`
+	Url       string `gorm:"type:varchar(255);uniqueIndex;not null"`
+	Language  string `gorm:"type:varchar(2);not null;CHECK (language IN ('en', 'da')) DEFAULT 'en'"`
+	Content   string `gorm:"type:text;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselinePage) TableName() string { return "pages" }
+
+type baselineJWT struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Token     string `gorm:"type:text;not null"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+func (baselineJWT) TableName() string { return "jwts" }
+
+type baselineSearchLog struct {
+	ID        uint      `gorm:"primaryKey"`
+	Query     string    `gorm:"type:text;not null"`
+	UserID    *uint     `gorm:"index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ScrapedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (baselineSearchLog) TableName() string { return "search_logs" }
+
+type baselinePasswordResetToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (baselinePasswordResetToken) TableName() string { return "password_reset_tokens" }
+
+type baselineRecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (baselineRecoveryCode) TableName() string { return "recovery_codes" }
+
+type baselineLoginAttempt struct {
+	Key         string `gorm:"primaryKey;type:varchar(255)"`
+	Failures    int    `gorm:"not null;default:0"`
+	LastFailure time.Time
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
+
+func (baselineLoginAttempt) TableName() string { return "login_attempts" }
+
+type baselinePasswordHistory struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index;not null"`
+	PasswordHash string `gorm:"type:varchar(255);not null"`
+	CreatedAt    time.Time
+}
+
+func (baselinePasswordHistory) TableName() string { return "password_histories" }
+
+type baselineDataExport struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index;not null"`
+	Status       string `gorm:"type:varchar(20);index;not null"`
+	Format       string `gorm:"type:varchar(10);not null"`
+	Archive      []byte
+	ExpiresAt    *time.Time
+	DownloadedAt *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (baselineDataExport) TableName() string { return "data_exports" }
+
+type baselineAuditEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	Type       string    `gorm:"type:varchar(50);index;not null"`
+	ActorID    *uint     `gorm:"index"`
+	ActorName  string    `gorm:"type:varchar(100)"`
+	TargetID   *uint     `gorm:"index"`
+	TargetName string    `gorm:"type:varchar(100)"`
+	IP         string    `gorm:"type:varchar(45)"`
+	UserAgent  string    `gorm:"type:varchar(255)"`
+	Details    string    `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (baselineAuditEvent) TableName() string { return "audit_events" }
+
+type baselineAPIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"type:varchar(100);not null"`
+	Prefix     string `gorm:"type:varchar(16);uniqueIndex;not null"`
+	KeyHash    string `gorm:"type:varchar(64);not null"`
+	Scopes     string `gorm:"type:varchar(100);not null"`
+	RateLimit  int    `gorm:"not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (baselineAPIKey) TableName() string { return "api_keys" }
+
+type baselineOIDCIdentity struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_oidc_identities_user_provider"`
+	Provider    string `gorm:"type:varchar(50);not null;uniqueIndex:idx_oidc_identities_provider_subject;uniqueIndex:idx_oidc_identities_user_provider"`
+	Subject     string `gorm:"type:varchar(255);not null;uniqueIndex:idx_oidc_identities_provider_subject"`
+	Email       string `gorm:"type:varchar(100)"`
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+}
+
+// GORM splits "OIDC" when it derives the table name from the model; the tables keep that name
+func (baselineOIDCIdentity) TableName() string { return "o_id_c_identities" }
+
+type baselineOIDCState struct {
+	ID           uint   `gorm:"primaryKey"`
+	StateHash    string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Provider     string `gorm:"type:varchar(50);not null"`
+	Nonce        string `gorm:"type:varchar(100);not null"`
+	CodeVerifier string `gorm:"type:varchar(100);not null"`
+	UserID       *uint  `gorm:"index"`
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+func (baselineOIDCState) TableName() string { return "o_id_c_states" }
+
+type baselineWebAuthnCredential struct {
+	ID             uint   `gorm:"primaryKey"`
+	UserID         uint   `gorm:"index;not null"`
+	Name           string `gorm:"type:varchar(100);not null"`
+	CredentialID   string `gorm:"type:varchar(1400);uniqueIndex;not null"`
+	PublicKey      []byte `gorm:"not null"`
+	Algorithm      int64  `gorm:"not null"`
+	SignCount      uint32 `gorm:"not null;default:0"`
+	AAGUID         string `gorm:"column:aaguid;type:varchar(36)"`
+	Transports     string `gorm:"type:varchar(255)"`
+	BackupEligible bool   `gorm:"not null;default:false"`
+	LastUsedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+func (baselineWebAuthnCredential) TableName() string { return "web_authn_credentials" }
+
+type baselineWebAuthnChallenge struct {
+	ID            uint   `gorm:"primaryKey"`
+	ChallengeHash string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Ceremony      string `gorm:"type:varchar(20);not null"`
+	UserID        *uint  `gorm:"index"`
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+func (baselineWebAuthnChallenge) TableName() string { return "web_authn_challenges" }
+
+// baselineTables returns the baseline tables in the order they are created
+func baselineTables() []interface{} {
+	return []interface{}{
+		&baselineUser{}, &baselinePage{}, &baselineJWT{}, &baselineSearchLog{},
+		&baselinePasswordResetToken{}, &baselineRecoveryCode{}, &baselineLoginAttempt{},
+		&baselinePasswordHistory{}, &baselineDataExport{}, &baselineAuditEvent{}, &baselineAPIKey{},
+		&baselineOIDCIdentity{}, &baselineOIDCState{}, &baselineWebAuthnCredential{}, &baselineWebAuthnChallenge{},
+	}
+}
+
+var baseline = Migration{
+	Version: 1,
+	Name:    "baseline",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(baselineTables()...)
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(baselineTables()...)
+	},
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Users that have not changed their password since the 31/10/2024 incident used to be
+// detected by comparing UpdatedAt with the incident date. Carry them over to the
+// must_change_password flag, and use UpdatedAt as a best guess of when the others last
+// changed their password.
+var mustChangePassword = Migration{
+	Version: 2,
+	Name:    "must_change_password",
+	Up: func(tx *gorm.DB) error {
+		incident := time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC)
+		if err := tx.Table("users").
+			Where("updated_at < ?", incident).
+			UpdateColumn("must_change_password", true).Error; err != nil {
+			return err
+		}
+		return tx.Table("users").
+			Where("password_changed_at IS NULL AND updated_at >= ?", incident).
+			UpdateColumn("password_changed_at", gorm.Expr("updated_at")).Error
+	},
+	// The previous password state is not kept, so there is nothing to restore
+	Down: func(tx *gorm.DB) error {
+		return nil
+	},
+}
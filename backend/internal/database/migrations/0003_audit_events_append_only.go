@@ -0,0 +1,35 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// The model hooks keep the application from changing audit events; the trigger
+// also refuses updates and deletes made directly in the database
+var auditEventsAppendOnly = Migration{
+	Version: 3,
+	Name:    "audit_events_append_only",
+	Up: func(tx *gorm.DB) error {
+		if tx.Dialector.Name() != "postgres" {
+			return nil
+		}
+		return tx.Exec(`
+			CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql;
+
+			DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+			CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+		`).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		if tx.Dialector.Name() != "postgres" {
+			return nil
+		}
+		return tx.Exec(`
+			DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+			DROP FUNCTION IF EXISTS audit_events_append_only();
+		`).Error
+	},
+}
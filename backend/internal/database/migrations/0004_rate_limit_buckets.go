@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type rateLimitBucket struct {
+	Key        string    `gorm:"primaryKey;type:varchar(255)"`
+	Tokens     float64   `gorm:"not null"`
+	RefilledAt time.Time `gorm:"index;not null"`
+}
+
+func (rateLimitBucket) TableName() string { return "rate_limit_buckets" }
+
+// The token buckets of the request rate limits, shared by the replicas when the rate limits
+// use the database store
+var rateLimitBuckets = Migration{
+	Version: 4,
+	Name:    "rate_limit_buckets",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&rateLimitBucket{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&rateLimitBucket{})
+	},
+}
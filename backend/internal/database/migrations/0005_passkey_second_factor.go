@@ -0,0 +1,27 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type passkeySecondFactorUser struct {
+	PasskeySecondFactor bool `gorm:"not null;default:false"`
+}
+
+func (passkeySecondFactorUser) TableName() string { return "users" }
+
+// Passkeys used to become a second factor of the password login as soon as one was
+// registered; now users opt in. Users with a password and a passkey keep needing it.
+var passkeySecondFactor = Migration{
+	Version: 5,
+	Name:    "passkey_second_factor",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&passkeySecondFactorUser{}, "PasskeySecondFactor"); err != nil {
+			return err
+		}
+		return tx.Table("users").
+			Where("password_hash <> '' AND id IN (?)", tx.Table("web_authn_credentials").Select("user_id")).
+			UpdateColumn("passkey_second_factor", true).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropColumn(&passkeySecondFactorUser{}, "PasskeySecondFactor")
+	},
+}
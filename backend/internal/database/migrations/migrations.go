@@ -0,0 +1,37 @@
+// Package migrations holds the versioned schema migrations, one file per version.
+//
+// Applied migrations are immutable: never edit or renumber a migration once it has been
+// released, add a new one instead. Migrations must not use the types from the models
+// package, as those change with the application; they declare the tables as they were at
+// their version, so they build the same schema whenever they run. They run on Postgres
+// and on the SQLite test database, so anything dialect specific has to check the dialect.
+package migrations
+
+import "gorm.io/gorm"
+
+// Migration is a single versioned schema change
+type Migration struct {
+	// Version orders the migrations, starting at 1 without gaps
+	Version int
+	// Name describes the migration; it is recorded, and must not change once released
+	Name string
+	Up   func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error
+}
+
+// All returns every migration in version order
+func All() []Migration {
+	return []Migration{
+		baseline,
+		mustChangePassword,
+		auditEventsAppendOnly,
+		rateLimitBuckets,
+		passkeySecondFactor,
+	}
+}
+
+// Latest returns the version of the last migration
+func Latest() int {
+	all := All()
+	return all[len(all)-1].Version
+}
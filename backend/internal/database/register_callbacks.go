@@ -1,58 +1,176 @@
 package database
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
-// RegisterCallbacks registers GORM callbacks for Prometheus metrics collection.
-// It sets up the following callbacks:
-// - After querying the database, the "prometheus:after_query" callback is triggered.
-// - After creating a record in the database, the "prometheus:after_create" callback is triggered.
-// - After updating a record in the database, the "prometheus:after_update" callback is triggered.
-// - After deleting a record from the database, the "prometheus:after_delete" callback is triggered.
+// startTimeKey is the statement setting holding the time a database operation started
+const startTimeKey = "prometheus:start_time"
+
+// spanKey is the statement setting holding the span of a database operation
+const spanKey = "tracing:span"
+
+// tracer creates the spans of database operations, as children of the span in the context of
+// the statement, such as the span of the request running it
+var tracer = otel.Tracer("github.com/CEM-KEA/whoknows/backend/internal/database")
+
+// maxSlowQueryLength is the length at which the SQL of a slow query is cut off in the log
+const maxSlowQueryLength = 2000
+
+var (
+	// stringLiteral and numberLiteral match values written into the SQL itself, such as in raw
+	// queries. Postgres placeholders like $1 are left alone.
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberLiteral = regexp.MustCompile(`(^|[^\w$.])-?\d+(?:\.\d+)?\b`)
+	whitespace    = regexp.MustCompile(`\s+`)
+)
+
+// RegisterCallbacks registers GORM callbacks for Prometheus metrics collection and slow query logging.
+// For every kind of operation GORM runs, a "prometheus:before_<operation>" callback records
+// the start time on the statement before the operation, and a "prometheus:after_<operation>"
+// callback observes the duration when it has finished. The operations are query, create,
+// update, delete, row (raw queries returning rows) and raw (raw statements).
 //
-// These callbacks are used to collect and report metrics to Prometheus.
-func RegisterCallbacks(db *gorm.DB) {
-	db.Callback().Query().After("gorm:query").Register("prometheus:after_query", afterQuery)
-	db.Callback().Create().After("gorm:create").Register("prometheus:after_create", afterCreate)
-	db.Callback().Update().After("gorm:update").Register("prometheus:after_update", afterUpdate)
-	db.Callback().Delete().After("gorm:delete").Register("prometheus:after_delete", afterDelete)
+// The metrics are labelled with the table and the operation, and failed operations are
+// counted. Operations taking at least slowQueryThreshold are logged with their normalized
+// SQL; a slowQueryThreshold of 0 turns the logging off.
+//
+// Every operation is also traced in a "gorm.<operation>" span, a child of the span in the
+// context the operation runs with, as set by db.WithContext.
+func RegisterCallbacks(db *gorm.DB, slowQueryThreshold time.Duration) {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			beforeOperation(operation, tx)
+		}
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			observeGormOperation(operation, tx, slowQueryThreshold)
+		}
+	}
+
+	callbacks := db.Callback()
+	callbacks.Query().Before("gorm:query").Register("prometheus:before_query", before("query"))
+	callbacks.Query().After("gorm:after_query").Register("prometheus:after_query", after("query"))
+	callbacks.Create().Before("gorm:begin_transaction").Register("prometheus:before_create", before("create"))
+	callbacks.Create().After("gorm:commit_or_rollback_transaction").Register("prometheus:after_create", after("create"))
+	callbacks.Update().Before("gorm:begin_transaction").Register("prometheus:before_update", before("update"))
+	callbacks.Update().After("gorm:commit_or_rollback_transaction").Register("prometheus:after_update", after("update"))
+	callbacks.Delete().Before("gorm:begin_transaction").Register("prometheus:before_delete", before("delete"))
+	callbacks.Delete().After("gorm:commit_or_rollback_transaction").Register("prometheus:after_delete", after("delete"))
+	callbacks.Row().Before("gorm:row").Register("prometheus:before_row", before("row"))
+	callbacks.Row().After("gorm:row").Register("prometheus:after_row", after("row"))
+	callbacks.Raw().Before("gorm:raw").Register("prometheus:before_raw", before("raw"))
+	callbacks.Raw().After("gorm:raw").Register("prometheus:after_raw", after("raw"))
+}
+
+// beforeOperation starts the span of a database operation, and records it and the start time
+// on its statement
+func beforeOperation(operation string, db *gorm.DB) {
+	ctx, span := tracer.Start(db.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient))
+	db.Statement.Context = ctx
+	db.InstanceSet(spanKey, span)
+	db.InstanceSet(startTimeKey, time.Now())
 }
 
-// Callback function to observe query duration and failures
-func afterQuery(db *gorm.DB) {
-	observeGormOperation("query", db)
+// observeGormOperation observes the duration of a GORM database operation, from the start
+// time recorded by beforeOperation, and records it with the ObserveDBQueryDuration utility
+// function. If the operation results in an error, it increments the failed queries counter.
+// Operations taking at least slowQueryThreshold are logged as slow queries.
+func observeGormOperation(operation string, db *gorm.DB, slowQueryThreshold time.Duration) {
+	value, ok := db.InstanceGet(startTimeKey)
+	if !ok {
+		return
+	}
+	start, ok := value.(time.Time)
+	if !ok {
+		return
+	}
+	duration := time.Since(start)
+
+	table := operationTable(db)
+	failed := db.Error != nil && db.Error != gorm.ErrRecordNotFound
+	utils.ObserveDBQueryDuration(table, operation, duration.Seconds())
+	if failed {
+		utils.IncrementDBFailedQuery(table, operation)
+	}
+	endSpan(operation, table, db, failed)
+
+	if slowQueryThreshold > 0 && duration >= slowQueryThreshold {
+		utils.LogWarnContext(db.Statement.Context, "Slow database query", logrus.Fields{
+			"message":     fmt.Sprintf("%s on %s took %s", operation, table, duration.Round(time.Millisecond)),
+			"table":       table,
+			"operation":   operation,
+			"duration_ms": duration.Milliseconds(),
+			"sql":         NormalizeSQL(db.Statement.SQL.String()),
+		})
+	}
 }
 
-func afterCreate(db *gorm.DB) {
-	observeGormOperation("create", db)
+// endSpan ends the span started by beforeOperation, describing the operation with its
+// normalized SQL and recording the error if it failed
+func endSpan(operation, table string, db *gorm.DB, failed bool) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.DBSystemKey.String(databaseSystem(db)),
+		semconv.DBCollectionName(table),
+		semconv.DBOperationName(operation),
+		semconv.DBQueryText(NormalizeSQL(db.Statement.SQL.String())),
+	)
+	if failed {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+	span.End()
 }
 
-func afterUpdate(db *gorm.DB) {
-	observeGormOperation("update", db)
+// databaseSystem returns the name of the database system for spans, such as "postgresql"
+func databaseSystem(db *gorm.DB) string {
+	if db.Dialector == nil {
+		return "other_sql"
+	}
+	if name := db.Dialector.Name(); name != "postgres" {
+		return name
+	}
+	return "postgresql"
 }
 
-func afterDelete(db *gorm.DB) {
-	observeGormOperation("delete", db)
+// operationTable returns the table of an operation, or "raw" for raw SQL without a model
+func operationTable(db *gorm.DB) string {
+	if db.Statement.Table != "" {
+		return db.Statement.Table
+	}
+	return "raw"
 }
 
-// observeGormOperation observes the duration of a GORM database operation and records metrics.
-// It takes the operation name as a string and a GORM database instance as parameters.
-// The function measures the time taken for the operation to complete and records the duration
-// using the ObserveDBQueryDuration utility function. If the operation results in an error,
-// it increments the failed queries counter using the DBFailedQueries utility.
-func observeGormOperation(operation string, db *gorm.DB) {
-	start := time.Now()
-
-	// Defer to calculate duration after the operation
-	defer func() {
-		duration := time.Since(start).Seconds()
-		utils.ObserveDBQueryDuration(operation, duration)
-		if db.Error != nil {
-			utils.DBFailedQueries.WithLabelValues(operation).Inc()
-		}
-	}()
+// NormalizeSQL prepares SQL for the log: it collapses whitespace, and replaces string and
+// number literals with ?, so that no values end up in the log. Bound parameters are not part
+// of the SQL, and their placeholders are kept.
+func NormalizeSQL(sql string) string {
+	sql = stringLiteral.ReplaceAllString(sql, "?")
+	sql = numberLiteral.ReplaceAllString(sql, "${1}?")
+	sql = strings.TrimSpace(whitespace.ReplaceAllString(sql, " "))
+	if len(sql) > maxSlowQueryLength {
+		sql = sql[:maxSlowQueryLength] + "..."
+	}
+	return sql
 }
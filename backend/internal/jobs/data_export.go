@@ -0,0 +1,187 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/mail"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// stuckExportTimeout is how long an export can stay "processing" before it is assumed that
+// the replica building it died, and it is queued again
+const stuckExportTimeout = 15 * time.Minute
+
+// StartDataExportWorker starts a background goroutine that builds requested data exports,
+// emails their download links and deletes archives that were never downloaded. The job runs
+// once right away and then on every tick of interval until ctx is cancelled.
+//
+// Parameters:
+//   - ctx: The context that stops the job when cancelled.
+//   - wg: Tracks the job, so a shutdown can wait for a run in progress to finish.
+//   - db: A pointer to the gorm.DB instance.
+//   - mailer: The mailer the download links are sent with.
+//   - jwtConfig: The JWT configuration, whose secret signs the download links.
+//   - interval: How often the job runs. A non-positive interval disables the job.
+//   - linkTTL: How long a finished export can be downloaded.
+//   - publicURL: The public base URL used to build download links.
+func StartDataExportWorker(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB, mailer mail.Mailer, jwtConfig config.JWTConfig, interval, linkTTL time.Duration, publicURL string) {
+	if interval <= 0 {
+		utils.LogInfoContext(ctx, "Data export worker disabled", nil)
+		return
+	}
+
+	utils.LogInfoContext(ctx, "Starting data export worker", logrus.Fields{
+		"message": fmt.Sprintf("running every %s, download links valid for %s", interval, linkTTL),
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// A run in progress is finished rather than cut short when ctx is cancelled
+		runDB := db.WithContext(context.WithoutCancel(ctx))
+
+		for {
+			RunDataExports(runDB, mailer, jwtConfig, linkTTL, publicURL)
+
+			select {
+			case <-ctx.Done():
+				utils.LogInfoContext(ctx, "Data export worker stopped", nil)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunDataExports performs a single run of the data export worker: exports past their link TTL
+// are expired, exports stuck in processing are queued again, and every pending export is built.
+// Exports are claimed with a conditional update, so several replicas can run the worker at once
+// without building the same export twice.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//   - mailer: The mailer the download links are sent with.
+//   - jwtConfig: The JWT configuration, whose secret signs the download links.
+//   - linkTTL: How long a finished export can be downloaded.
+//   - publicURL: The public base URL used to build download links.
+//
+// Returns:
+//   - int: The number of exports built.
+//   - error: An error if the pending exports could not be read.
+func RunDataExports(db *gorm.DB, mailer mail.Mailer, jwtConfig config.JWTConfig, linkTTL time.Duration, publicURL string) (int, error) {
+	if _, err := ExpireDataExports(db); err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to expire data exports", nil)
+	}
+
+	if err := db.Model(&models.DataExport{}).
+		Where("status = ? AND updated_at < ?", models.DataExportProcessing, time.Now().Add(-stuckExportTimeout)).
+		Update("status", models.DataExportPending).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to requeue stuck data exports", nil)
+	}
+
+	var pending []models.DataExport
+	if err := db.Omit("archive").Where("status = ?", models.DataExportPending).Order("created_at").Find(&pending).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to read pending data exports", nil)
+		return 0, errors.Wrap(err, "failed to read pending data exports")
+	}
+
+	built := 0
+	for i := range pending {
+		export := &pending[i]
+
+		result := db.Model(&models.DataExport{}).
+			Where("id = ? AND status = ?", export.ID, models.DataExportPending).
+			Update("status", models.DataExportProcessing)
+		if result.Error != nil {
+			utils.LogErrorContext(db.Statement.Context, result.Error, "Failed to claim data export", nil)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := buildDataExport(db, mailer, jwtConfig, export, linkTTL, publicURL); err != nil {
+			utils.IncrementDataExportsBuilt("error")
+			utils.LogErrorContext(db.Statement.Context, err, "Failed to build data export", nil)
+			if err := db.Model(&models.DataExport{}).Where("id = ?", export.ID).Update("status", models.DataExportFailed).Error; err != nil {
+				utils.LogErrorContext(db.Statement.Context, err, "Failed to mark data export as failed", nil)
+			}
+			continue
+		}
+
+		utils.IncrementDataExportsBuilt("success")
+		built++
+	}
+
+	if built > 0 {
+		utils.LogInfoContext(db.Statement.Context, "Data export worker completed", logrus.Fields{
+			"message": fmt.Sprintf("%d data exports built", built),
+		})
+	}
+	return built, nil
+}
+
+// ExpireDataExports deletes the archives of ready exports whose download link has expired.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//
+// Returns:
+//   - int64: The number of exports expired.
+//   - error: An error if the update fails.
+func ExpireDataExports(db *gorm.DB) (int64, error) {
+	result := db.Model(&models.DataExport{}).
+		Where("status = ? AND expires_at < ?", models.DataExportReady, time.Now()).
+		Updates(map[string]interface{}{
+			"status":  models.DataExportExpired,
+			"archive": nil,
+		})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "failed to expire data exports")
+	}
+
+	return result.RowsAffected, nil
+}
+
+// buildDataExport builds the archive of a claimed export, stores it and emails the download link
+func buildDataExport(db *gorm.DB, mailer mail.Mailer, jwtConfig config.JWTConfig, export *models.DataExport, linkTTL time.Duration, publicURL string) error {
+	user, err := services.GetUserByID(db, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := services.BuildDataExport(db, export.UserID, export.Format)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(linkTTL)
+	if err := db.Model(&models.DataExport{}).Where("id = ?", export.ID).Updates(map[string]interface{}{
+		"status":     models.DataExportReady,
+		"archive":    archive,
+		"expires_at": expiresAt,
+	}).Error; err != nil {
+		return errors.Wrap(err, "failed to store data export")
+	}
+	export.Status = models.DataExportReady
+	export.ExpiresAt = &expiresAt
+
+	// The export can still be downloaded from the link shown in the profile, so a failed email is not fatal
+	link := services.DataExportDownloadLink(jwtConfig, publicURL, export)
+	if err := mailer.Send(db.Statement.Context, mail.DataExportReadyMessage(user.Email, link, linkTTL)); err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to send data export email", nil)
+	}
+
+	return nil
+}
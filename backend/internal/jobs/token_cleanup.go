@@ -0,0 +1,138 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// StartTokenCleanup starts a background goroutine that periodically deletes JWT rows
+// that expired (or were revoked) more than gracePeriod ago, and prunes the login attempts
+// of guard. The job runs once right away and then on every tick of interval until ctx is
+// cancelled.
+//
+// Parameters:
+//   - ctx: The context that stops the job when cancelled.
+//   - wg: Tracks the job, so a shutdown can wait for a run in progress to finish.
+//   - db: A pointer to the gorm.DB instance.
+//   - guard: The brute-force guard whose old login attempts are pruned.
+//   - interval: How often the job runs. A non-positive interval disables the job.
+//   - gracePeriod: How long expired or revoked tokens are kept before they are purged.
+func StartTokenCleanup(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB, guard *bruteforce.Guard, interval, gracePeriod time.Duration) {
+	if interval <= 0 {
+		utils.LogInfoContext(ctx, "JWT cleanup job disabled", nil)
+		return
+	}
+
+	utils.LogInfoContext(ctx, "Starting JWT cleanup job", logrus.Fields{
+		"message": fmt.Sprintf("running every %s, purging tokens expired or revoked more than %s ago", interval, gracePeriod),
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// A run in progress is finished rather than cut short when ctx is cancelled
+		runDB := db.WithContext(context.WithoutCancel(ctx))
+
+		for {
+			RunTokenCleanup(runDB, gracePeriod)
+			// Every replica prunes, as the memory store is kept per replica
+			if err := guard.Prune(); err != nil {
+				utils.LogErrorContext(ctx, err, "Failed to prune login attempts", nil)
+			}
+
+			select {
+			case <-ctx.Done():
+				utils.LogInfoContext(ctx, "JWT cleanup job stopped", nil)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunTokenCleanup performs a single cleanup run under the token cleanup advisory lock,
+// so that multiple replicas never purge at the same time. The outcome is recorded in the
+// jwt_cleanup_runs_total metric as "success", "skipped" or "error".
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//   - gracePeriod: How long expired or revoked tokens are kept before they are purged.
+//
+// Returns:
+//   - int64: The number of rows purged.
+//   - error: An error if the cleanup failed.
+func RunTokenCleanup(db *gorm.DB, gracePeriod time.Duration) (int64, error) {
+	var purged int64
+
+	acquired, err := database.WithAdvisoryLock(db, database.TokenCleanupLockKey, func(tx *gorm.DB) error {
+		var err error
+		if purged, err = PurgeExpiredTokens(tx, gracePeriod); err != nil {
+			return err
+		}
+		// Used and expired reset tokens and abandoned OpenID Connect and passkey logins are
+		// cleaned up along with the JWTs
+		if _, err = services.PurgePasswordResetTokens(tx, gracePeriod); err != nil {
+			return err
+		}
+		if _, err = services.PurgeExpiredOIDCStates(tx); err != nil {
+			return err
+		}
+		_, err = services.PurgeExpiredPasskeyChallenges(tx)
+		return err
+	})
+	if err != nil {
+		utils.IncrementJWTCleanupRuns("error")
+		utils.LogErrorContext(db.Statement.Context, err, "JWT cleanup job failed", nil)
+		return 0, err
+	}
+
+	if !acquired {
+		utils.IncrementJWTCleanupRuns("skipped")
+		utils.LogInfoContext(db.Statement.Context, "JWT cleanup skipped, another replica holds the lock", nil)
+		return 0, nil
+	}
+
+	utils.IncrementJWTCleanupRuns("success")
+	utils.AddJWTTokensPurged(purged)
+	utils.LogInfoContext(db.Statement.Context, "JWT cleanup job completed", logrus.Fields{
+		"purged": purged,
+	})
+	return purged, nil
+}
+
+// PurgeExpiredTokens deletes every JWT row whose ExpiresAt, or RevokedAt if set,
+// lies further in the past than gracePeriod.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance.
+//   - gracePeriod: How long expired or revoked tokens are kept before they are purged.
+//
+// Returns:
+//   - int64: The number of rows deleted.
+//   - error: An error if the delete fails.
+func PurgeExpiredTokens(db *gorm.DB, gracePeriod time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-gracePeriod)
+
+	result := db.
+		Where("expires_at < ?", cutoff).
+		Or("revoked_at IS NOT NULL AND revoked_at < ?", cutoff).
+		Delete(&models.JWT{})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "failed to purge expired tokens")
+	}
+
+	return result.RowsAffected, nil
+}
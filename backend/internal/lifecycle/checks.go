@@ -0,0 +1,105 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Check status values
+const (
+	CheckUp   = "up"
+	CheckDown = "down"
+)
+
+// Check is a dependency checked by the readiness probe
+type Check struct {
+	Name string
+	// Critical checks must pass for the backend to be ready; the others are only reported
+	Critical bool
+	// Timeout bounds how long the check may take, so one slow dependency cannot hang the probe
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	// Error is "timed out" or "failed"; the cause is only logged, as the probe is unauthenticated
+	Error string `json:"error,omitempty"`
+	// Cause is the error returned by the check, for logging
+	Cause error `json:"-"`
+}
+
+// RunChecks runs the checks concurrently, each under its own timeout.
+//
+// Parameters:
+//   - ctx: The context of the probe request.
+//   - checks: The checks to run.
+//
+// Returns:
+//   - map[string]CheckResult: The result of every check by name.
+//   - bool: Whether every critical check passed.
+func RunChecks(ctx context.Context, checks []Check) (map[string]CheckResult, bool) {
+	results := make(map[string]CheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := runCheck(ctx, check)
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, check := range checks {
+		if check.Critical && results[check.Name].Status != CheckUp {
+			healthy = false
+		}
+	}
+	return results, healthy
+}
+
+// runCheck runs a single check under its timeout. A check that ignores its context is
+// abandoned when the timeout passes, and reported as timed out.
+func runCheck(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:    CheckUp,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = CheckDown
+		result.Error = "failed"
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timed out"
+		}
+		result.Cause = err
+	}
+	return result
+}
@@ -0,0 +1,21 @@
+// Package lifecycle tracks whether the backend is ready to receive traffic. The backend is
+// marked ready once it has started, and not ready as soon as it starts shutting down, so the
+// proxy stops sending it new requests before the in-flight ones are drained.
+package lifecycle
+
+import "sync/atomic"
+
+// Readiness reports whether the backend is ready to receive traffic. The zero value is not ready.
+type Readiness struct {
+	ready atomic.Bool
+}
+
+// SetReady marks the backend as ready or not ready to receive traffic
+func (r *Readiness) SetReady(value bool) {
+	r.ready.Store(value)
+}
+
+// Ready reports whether the backend is ready to receive traffic
+func (r *Readiness) Ready() bool {
+	return r.ready.Load()
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// FileMailer writes every message to its own .eml file in a directory.
+// It is meant for local development and tests.
+type FileMailer struct {
+	dir  string
+	from string
+
+	mu      sync.Mutex
+	counter int
+}
+
+// NewFileMailer creates a mailer that writes messages to dir, creating it if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.Wrap(err, "failed to create mail directory")
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to a new file in the mail directory
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	m.counter++
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102150405"), m.counter)
+	m.mu.Unlock()
+
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, formatMessage(m.from, msg), 0o640); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to write email to file", nil)
+		return errors.Wrap(err, "failed to write email")
+	}
+
+	utils.LogInfoContext(ctx, "Email written to file", nil)
+	return nil
+}
@@ -0,0 +1,27 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// LogMailer writes messages to the application log instead of sending them.
+// It is meant for local development only. Message bodies contain links with one-time tokens,
+// so only the recipient and the subject are logged.
+type LogMailer struct{}
+
+// NewLogMailer creates a mailer that logs messages
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send logs the recipient and subject of the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	utils.LogInfoContext(ctx, "Email not sent, the log mail driver is configured", logrus.Fields{
+		"message": fmt.Sprintf("to %s: %s", msg.To, msg.Subject),
+	})
+	return nil
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+)
+
+// Message is a plain text email message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages. Implementations must be safe for concurrent use.
+// The context is the one of the request or job the message is sent for, and is used for logging.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailer creates the Mailer implementation selected by cfg.Driver:
+//   - "smtp": sends messages through the configured SMTP server.
+//   - "file": writes each message to a file in cfg.FileDir.
+//   - "log": writes each message to the application log.
+//
+// Parameters:
+//   - cfg: The mail configuration.
+//
+// Returns:
+//   - Mailer: The configured mailer.
+//   - error: An error if the driver is unknown or cannot be initialized.
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "file":
+		return NewFileMailer(cfg.FileDir, cfg.From)
+	case "log", "":
+		return NewLogMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
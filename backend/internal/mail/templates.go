@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// PasswordResetMessage builds the email sent when a user requests a password reset
+func PasswordResetMessage(to, resetLink string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your WhoKnows password",
+		Body: fmt.Sprintf(
+			"Someone requested a password reset for your WhoKnows account.\n\n"+
+				"Use the link below to choose a new password. The link can be used once and expires in %s.\n\n"+
+				"%s\n\n"+
+				"If you did not request a password reset, you can ignore this email.\n",
+			ttl, resetLink,
+		),
+	}
+}
+
+// EmailVerificationMessage builds the email sent to confirm that a user owns their email address
+func EmailVerificationMessage(to, verificationLink string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your WhoKnows email address",
+		Body: fmt.Sprintf(
+			"Welcome to WhoKnows!\n\n"+
+				"Please confirm your email address by opening the link below. The link expires in %s.\n\n"+
+				"%s\n\n"+
+				"If you did not create an account, you can ignore this email.\n",
+			ttl, verificationLink,
+		),
+	}
+}
+
+// DataExportReadyMessage builds the email sent when a requested data export can be downloaded
+func DataExportReadyMessage(to, downloadLink string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Your WhoKnows data export is ready",
+		Body: fmt.Sprintf(
+			"The export of your WhoKnows data that you requested is ready.\n\n"+
+				"Download it with the link below. The link can be used once and expires in %s.\n\n"+
+				"%s\n\n"+
+				"If you did not request an export, please change your password.\n",
+			ttl, downloadLink,
+		),
+	}
+}
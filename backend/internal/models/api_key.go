@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// API key scopes
+const (
+	APIKeyScopeSearch    = "search"
+	APIKeyScopeBookmarks = "bookmarks"
+	APIKeyScopeAdmin     = "admin"
+)
+
+// APIKey is a named personal key for programmatic access, sent in the X-API-Key header.
+// Only the SHA-256 hash of the key is stored; Prefix is the public part of the key used to
+// find it, and to tell keys apart in listings. Scopes is a comma separated list, and
+// RateLimit is the number of requests allowed per minute.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"type:varchar(100);not null"`
+	Prefix     string `gorm:"type:varchar(16);uniqueIndex;not null"`
+	KeyHash    string `gorm:"type:varchar(64);not null"`
+	Scopes     string `gorm:"type:varchar(100);not null"`
+	RateLimit  int    `gorm:"not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
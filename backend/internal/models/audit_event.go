@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Audit event types
+const (
+	AuditLoginSuccess         = "login.success"
+	AuditLoginFailure         = "login.failure"
+	AuditLogout               = "logout"
+	AuditPasswordChange       = "password.change"
+	AuditPasswordReset        = "password.reset"
+	AuditPasswordChangeForced = "password.force_change"
+	AuditTokenRevoke          = "token.revoke"
+	AuditRoleChange           = "role.change"
+	AuditAccountDelete        = "account.delete"
+	AuditAPIKeyCreate         = "api_key.create"
+	AuditAPIKeyRevoke         = "api_key.revoke"
+	AuditOIDCLink             = "oidc.link"
+	AuditOIDCUnlink           = "oidc.unlink"
+	AuditPasskeyAdd           = "passkey.add"
+	AuditPasskeyRemove        = "passkey.remove"
+	AuditPasskeySecondFactor  = "passkey.second_factor"
+)
+
+// ErrAuditEventImmutable is returned when an audit event is updated or deleted
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent is a security relevant event. The table is append-only: events are never
+// updated or deleted, also not when the actor or target account is deleted, so the names
+// are stored alongside the IDs. An actor without an ID is anonymous, or a management command.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	Type       string    `gorm:"type:varchar(50);index;not null"`
+	ActorID    *uint     `gorm:"index"`
+	ActorName  string    `gorm:"type:varchar(100)"`
+	TargetID   *uint     `gorm:"index"`
+	TargetName string    `gorm:"type:varchar(100)"`
+	IP         string    `gorm:"type:varchar(45)"`
+	UserAgent  string    `gorm:"type:varchar(255)"`
+	Details    string    `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+// BeforeUpdate refuses to change an audit event
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete refuses to delete an audit event
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Data export statuses
+const (
+	DataExportPending    = "pending"
+	DataExportProcessing = "processing"
+	DataExportReady      = "ready"
+	DataExportDownloaded = "downloaded"
+	DataExportExpired    = "expired"
+	DataExportFailed     = "failed"
+)
+
+// Data export formats
+const (
+	DataExportFormatJSON = "json"
+	DataExportFormatZIP  = "zip"
+)
+
+// DataExport is a request from a user for an archive of all the data held about them.
+// The archive is built by a background job and kept until it is downloaded once or
+// ExpiresAt has passed, after which it is deleted.
+type DataExport struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index;not null"`
+	Status       string `gorm:"type:varchar(20);index;not null"`
+	Format       string `gorm:"type:varchar(10);not null"`
+	Archive      []byte
+	ExpiresAt    *time.Time
+	DownloadedAt *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
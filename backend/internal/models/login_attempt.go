@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempt tracks failed authentication attempts for one key, such as a client IP
+// or a username, when brute-force protection uses the database store.
+type LoginAttempt struct {
+	Key         string `gorm:"primaryKey;type:varchar(255)"`
+	Failures    int    `gorm:"not null;default:0"`
+	LastFailure time.Time
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
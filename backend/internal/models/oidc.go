@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// OIDCIdentity links a user to their account at an OpenID Connect identity provider.
+// Subject is the "sub" claim of the provider, which is stable for the account, unlike the email.
+// A user can link one account per provider.
+type OIDCIdentity struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_oidc_identities_user_provider"`
+	Provider    string `gorm:"type:varchar(50);not null;uniqueIndex:idx_oidc_identities_provider_subject;uniqueIndex:idx_oidc_identities_user_provider"`
+	Subject     string `gorm:"type:varchar(255);not null;uniqueIndex:idx_oidc_identities_provider_subject"`
+	Email       string `gorm:"type:varchar(100)"`
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+}
+
+// OIDCState is a login or link in progress at an identity provider. It is deleted as soon as
+// the provider redirects back, so a state can only be used once. Only the SHA-256 hash of the
+// state is stored; the nonce and the PKCE code verifier are needed in plain text to finish the flow.
+// UserID is set when an existing user links a provider, and nil for a login.
+type OIDCState struct {
+	ID           uint   `gorm:"primaryKey"`
+	StateHash    string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Provider     string `gorm:"type:varchar(50);not null"`
+	Nonce        string `gorm:"type:varchar(100);not null"`
+	CodeVerifier string `gorm:"type:varchar(100);not null"`
+	UserID       *uint  `gorm:"index"`
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
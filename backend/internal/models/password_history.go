@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PasswordHistory keeps the hash of a password a user had before changing it,
+// so recently used passwords can be refused.
+type PasswordHistory struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index;not null"`
+	PasswordHash string `gorm:"type:varchar(255);not null"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RateLimitBucket is the token bucket of one client on one rate limited route, when the rate
+// limits use the database store. RefilledAt is when Tokens was last brought up to date.
+type RateLimitBucket struct {
+	Key        string    `gorm:"primaryKey;type:varchar(255)"`
+	Tokens     float64   `gorm:"not null"`
+	RefilledAt time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// SchemaMigration records a schema migration that has been applied to the database.
+// The row is deleted again when the migration is rolled back.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(100);not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
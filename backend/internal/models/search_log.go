@@ -5,6 +5,7 @@ import "time"
 type SearchLog struct {
     ID        uint      `gorm:"primaryKey"`
     Query     string    `gorm:"type:text;not null"`
+    UserID    *uint     `gorm:"index"`
     CreatedAt time.Time `gorm:"autoCreateTime"`
     ScrapedAt time.Time `gorm:"autoCreateTime"`
 }
\ No newline at end of file
@@ -4,12 +4,33 @@ import (
 	"time"
 )
 
+// User is a registered account.
+// Two-factor authentication is active once TOTPEnabledAt is set; TOTPSecret is already set
+// during enrollment, and TOTPLastStep holds the time step of the last accepted code so it
+// can not be replayed.
+// MustChangePassword forces the user to pick a new password before they get a full session,
+// PasskeySecondFactor makes a password login require one of the user's passkeys, and Role
+// is "user" or "admin".
 type User struct {
-	ID           uint      `gorm:"primaryKey"`
-	Username     string    `gorm:"type:varchar(100);uniqueIndex;not null"`
-	Email        string    `gorm:"type:varchar(100);uniqueIndex;not null"`
-	PasswordHash string    `gorm:"not null"`
-	LastLogin    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID                  uint      `gorm:"primaryKey"`
+	Username            string    `gorm:"type:varchar(100);uniqueIndex;not null"`
+	Email               string    `gorm:"type:varchar(100);uniqueIndex;not null"`
+	PasswordHash        string    `gorm:"not null"`
+	LastLogin           time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	VerifiedAt          *time.Time
+	TOTPSecret          string `gorm:"type:varchar(64)"`
+	TOTPEnabledAt       *time.Time
+	TOTPLastStep        int64
+	MustChangePassword  bool `gorm:"not null;default:false"`
+	PasskeySecondFactor bool `gorm:"not null;default:false"`
+	PasswordChangedAt   *time.Time
+	Role                string `gorm:"type:varchar(20);not null;default:'user'"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
 }
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
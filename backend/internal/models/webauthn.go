@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// WebAuthnCredential is a passkey a user registered. CredentialID is the base64url encoded ID
+// the authenticator assigned; PublicKey is the COSE encoded public key. SignCount is the
+// signature counter of the last login, used to detect cloned authenticators.
+type WebAuthnCredential struct {
+	ID             uint   `gorm:"primaryKey"`
+	UserID         uint   `gorm:"index;not null"`
+	Name           string `gorm:"type:varchar(100);not null"`
+	CredentialID   string `gorm:"type:varchar(1400);uniqueIndex;not null"`
+	PublicKey      []byte `gorm:"not null"`
+	Algorithm      int64  `gorm:"not null"`
+	SignCount      uint32 `gorm:"not null;default:0"`
+	AAGUID         string `gorm:"column:aaguid;type:varchar(36)"`
+	Transports     string `gorm:"type:varchar(255)"`
+	BackupEligible bool   `gorm:"not null;default:false"`
+	LastUsedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+// WebAuthnChallenge is a passkey registration or login in progress. It is deleted when the
+// browser returns the credential, so a challenge can only be used once. Only the SHA-256 hash
+// of the challenge is stored. UserID is set for a registration and for a login as second
+// factor, and nil for a passwordless login.
+type WebAuthnChallenge struct {
+	ID            uint   `gorm:"primaryKey"`
+	ChallengeHash string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Ceremony      string `gorm:"type:varchar(20);not null"`
+	UserID        *uint  `gorm:"index"`
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+// Passkey ceremonies a WebAuthnChallenge is issued for
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+	WebAuthnCeremonySecondFactor = "second-factor"
+)
@@ -0,0 +1,248 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/tracing"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Metadata is the part of the OpenID Provider discovery document used by the client
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// TokenResponse is the response of the token endpoint to an authorization code
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Provider is an OpenID Connect identity provider the application is registered at as a
+// confidential client. The discovery document and signing keys are fetched on first use
+// and cached, so the application starts even if a provider is unreachable.
+type Provider struct {
+	Name        string
+	DisplayName string
+	RedirectURL string
+
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	httpClient   *http.Client
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// NewProvider creates a provider from its configuration.
+//
+// Parameters:
+//   - cfg: The client registration at the provider.
+//   - redirectURL: The URL the provider sends the user back to.
+//
+// Returns:
+//   - *Provider: The provider.
+func NewProvider(cfg config.OIDCProviderConfig, redirectURL string) *Provider {
+	return &Provider{
+		Name:         cfg.Name,
+		DisplayName:  cfg.DisplayName,
+		RedirectURL:  redirectURL,
+		issuer:       cfg.Issuer,
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		scopes:       cfg.Scopes,
+		httpClient:   &http.Client{Timeout: 10 * time.Second, Transport: tracing.Transport(nil)},
+	}
+}
+
+// Discover returns the discovery document of the provider, fetching it on first use.
+// The issuer in the document must match the configured issuer exactly.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//
+// Returns:
+//   - *Metadata: The discovery document.
+//   - error: An error if the document can not be fetched or is invalid.
+func (p *Provider) Discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	utils.LogInfoContext(ctx, "Fetching OpenID Connect discovery document", logrus.Fields{
+		"message": p.Name,
+	})
+
+	var metadata Metadata
+	discoveryURL := strings.TrimRight(p.issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &metadata); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch discovery document")
+	}
+	if metadata.Issuer != p.issuer {
+		return nil, errors.Errorf("discovery document is for issuer %q, expected %q", metadata.Issuer, p.issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthorizationURL returns the URL to send the user to for the authorization code flow with PKCE.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - state: The state, returned unchanged with the code, that ties the callback to this request.
+//   - nonce: The nonce the ID token must contain.
+//   - codeVerifier: The PKCE code verifier; only its S256 challenge is sent.
+//
+// Returns:
+//   - string: The authorization URL.
+//   - error: An error if the discovery document can not be fetched.
+func (p *Provider) AuthorizationURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid authorization endpoint")
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange exchanges an authorization code for tokens at the token endpoint.
+// The client authenticates with client_secret_basic, unless the provider only supports client_secret_post.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - code: The authorization code from the callback.
+//   - codeVerifier: The PKCE code verifier the authorization URL was made with.
+//
+// Returns:
+//   - *TokenResponse: The tokens.
+//   - error: An error if the provider rejects the code or does not return an ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	usePost := !containsString(metadata.TokenEndpointAuthMethodsSupported, "client_secret_basic") &&
+		containsString(metadata.TokenEndpointAuthMethodsSupported, "client_secret_post")
+	if usePost {
+		form.Set("client_id", p.clientID)
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !usePost {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "token request failed")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read token response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		var tokenError struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &tokenError)
+		return nil, errors.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tokenError.Error, tokenError.Description)
+	}
+
+	var tokens TokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, errors.Wrap(err, "invalid token response")
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	return &tokens, nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (p *Provider) getJSON(ctx context.Context, documentURL string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s returned %d", documentURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/pkg/errors"
+)
+
+// CallbackPath is the frontend route identity providers redirect back to. The frontend
+// passes the code and state on to the callback endpoint of the API.
+const CallbackPath = "/oidc/callback"
+
+// Providers are the configured identity providers, in configuration order
+type Providers struct {
+	providers []*Provider
+}
+
+// NewProviders creates the configured identity providers.
+//
+// Parameters:
+//   - cfg: The OpenID Connect configuration.
+//   - publicURL: The public base URL of the frontend, used to build the redirect URL.
+//
+// Returns:
+//   - *Providers: The identity providers.
+//   - error: An error if two providers have the same name or a provider is incomplete.
+func NewProviders(cfg config.OIDCConfig, publicURL string) (*Providers, error) {
+	redirectURL := strings.TrimRight(publicURL, "/") + CallbackPath
+	configured := []*Provider{}
+	seen := map[string]bool{}
+	for _, providerConfig := range cfg.Providers {
+		if seen[providerConfig.Name] {
+			return nil, errors.Errorf("OIDC provider %q is configured twice", providerConfig.Name)
+		}
+		if providerConfig.Issuer == "" || providerConfig.ClientID == "" {
+			return nil, errors.Errorf("OIDC provider %q needs an issuer and a client ID", providerConfig.Name)
+		}
+		if !containsString(providerConfig.Scopes, "openid") {
+			providerConfig.Scopes = append([]string{"openid"}, providerConfig.Scopes...)
+		}
+		seen[providerConfig.Name] = true
+		configured = append(configured, NewProvider(providerConfig, redirectURL))
+	}
+	return &Providers{providers: configured}, nil
+}
+
+// All returns the identity providers
+func (p *Providers) All() []*Provider {
+	return p.providers
+}
+
+// Get returns the identity provider with the given name
+func (p *Providers) Get(name string) (*Provider, bool) {
+	for _, provider := range p.providers {
+		if provider.Name == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of a code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
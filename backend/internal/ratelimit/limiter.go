@@ -0,0 +1,141 @@
+// Package ratelimit limits how often clients may call a route with token buckets. Every key,
+// such as a client IP on a route, has a bucket holding up to the number of requests of its
+// rate; a request takes a token, and the bucket refills evenly over the period of the rate.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"gorm.io/gorm"
+)
+
+// pruneInterval is how often buckets that have filled up again are deleted from the store
+const pruneInterval = 10 * time.Minute
+
+// Result is the outcome of a request and the state of its bucket afterwards
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next token, if the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from the buckets in its store
+type Limiter struct {
+	store Store
+	now   func() time.Time
+
+	mu sync.Mutex
+	// longestPeriod is the longest period seen; a bucket unused for that long is full again
+	longestPeriod time.Duration
+	lastPrune     time.Time
+}
+
+// NewLimiter creates a limiter that keeps its buckets in store
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// New creates a limiter using the store from the configuration: the database for the
+// "database" store, and process memory otherwise.
+//
+// Parameters:
+//   - cfg: The rate limit configuration.
+//   - db: The database connection, used by the "database" store.
+//
+// Returns:
+//   - *Limiter: The limiter.
+func New(cfg config.RateLimitConfig, db *gorm.DB) *Limiter {
+	if cfg.Store == "database" {
+		return NewLimiter(NewDBStore(db))
+	}
+	return NewLimiter(NewMemoryStore())
+}
+
+// SetClock replaces the clock used by the limiter. It is meant for tests.
+func (l *Limiter) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+// Allow takes a token from the bucket of key, refilled at rate.
+//
+// Parameters:
+//   - ctx: The context of the request, which a failure to prune the buckets is logged with.
+//   - key: The key of the bucket, e.g. "search:ip:203.0.113.7".
+//   - rate: The number of requests allowed per period.
+//
+// Returns:
+//   - Result: Whether the request is allowed, and the state of the bucket.
+//   - error: An error if the store could not be updated.
+func (l *Limiter) Allow(ctx context.Context, key string, rate config.Rate) (Result, error) {
+	now := l.now()
+	l.prune(ctx, now, rate.Period)
+
+	capacity := float64(rate.Requests)
+	perSecond := capacity / rate.Period.Seconds()
+	allowed := false
+
+	bucket, err := l.store.Update(key, func(bucket Bucket) Bucket {
+		tokens := capacity
+		if !bucket.RefilledAt.IsZero() {
+			elapsed := now.Sub(bucket.RefilledAt).Seconds()
+			tokens = math.Min(capacity, bucket.Tokens+math.Max(0, elapsed)*perSecond)
+		}
+		allowed = tokens >= 1
+		if allowed {
+			tokens--
+		}
+		return Bucket{Tokens: tokens, RefilledAt: now}
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     rate.Requests,
+		Remaining: int(math.Floor(bucket.Tokens)),
+		Reset:     seconds((capacity - bucket.Tokens) / perSecond),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - bucket.Tokens) / perSecond)
+	}
+	return result, nil
+}
+
+// prune deletes the buckets that have not been used for the longest period seen, as they are
+// full again and the same as a missing bucket. It runs at most once per pruneInterval.
+func (l *Limiter) prune(ctx context.Context, now time.Time, period time.Duration) {
+	l.mu.Lock()
+	if period > l.longestPeriod {
+		l.longestPeriod = period
+	}
+	if l.lastPrune.IsZero() {
+		l.lastPrune = now
+	}
+	if now.Sub(l.lastPrune) < pruneInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.lastPrune = now
+	before := now.Add(-l.longestPeriod)
+	l.mu.Unlock()
+
+	if err := l.store.DeleteRefilledBefore(before); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to prune rate limit buckets", nil)
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
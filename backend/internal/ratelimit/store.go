@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Bucket is the token bucket of a single key. A bucket that has never been used has no
+// RefilledAt, and is full.
+type Bucket struct {
+	Tokens     float64
+	RefilledAt time.Time
+}
+
+// Store persists buckets. Update must apply fn atomically, so that concurrent requests
+// with the same key each take their own token.
+type Store interface {
+	Update(key string, fn func(Bucket) Bucket) (Bucket, error)
+	// DeleteRefilledBefore removes the buckets that have not been used since t
+	DeleteRefilledBefore(t time.Time) error
+}
+
+// MemoryStore keeps buckets in process memory. It is only suitable for a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]Bucket
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]Bucket)}
+}
+
+// Update applies fn to the bucket for key and stores the result
+func (s *MemoryStore) Update(key string, fn func(Bucket) Bucket) (Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket := fn(s.buckets[key])
+	s.buckets[key] = bucket
+	return bucket, nil
+}
+
+// DeleteRefilledBefore removes the buckets that have not been used since t
+func (s *MemoryStore) DeleteRefilledBefore(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, bucket := range s.buckets {
+		if bucket.RefilledAt.Before(t) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
+
+// DBStore keeps buckets in the rate_limit_buckets table, so all replicas share them
+type DBStore struct {
+	db *gorm.DB
+}
+
+// NewDBStore creates a store backed by the rate_limit_buckets table
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+// Update applies fn to the bucket for key inside a transaction. On Postgres the row is
+// locked with SELECT ... FOR UPDATE, so concurrent updates from other replicas wait.
+func (s *DBStore) Update(key string, fn func(Bucket) Bucket) (Bucket, error) {
+	var bucket Bucket
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists, so there is something to lock
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RateLimitBucket{Key: key}).Error; err != nil {
+			return err
+		}
+
+		query := tx.Where(map[string]interface{}{"key": key})
+		if tx.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+
+		var row models.RateLimitBucket
+		if err := query.First(&row).Error; err != nil {
+			return err
+		}
+
+		bucket = fn(Bucket{Tokens: row.Tokens, RefilledAt: row.RefilledAt})
+		row.Tokens = bucket.Tokens
+		row.RefilledAt = bucket.RefilledAt
+		return tx.Save(&row).Error
+	})
+	if err != nil {
+		return Bucket{}, errors.Wrap(err, "failed to update rate limit bucket")
+	}
+
+	return bucket, nil
+}
+
+// DeleteRefilledBefore removes the buckets that have not been used since t
+func (s *DBStore) DeleteRefilledBefore(t time.Time) error {
+	if err := s.db.Where("refilled_at < ?", t).Delete(&models.RateLimitBucket{}).Error; err != nil {
+		return errors.Wrap(err, "failed to delete rate limit buckets")
+	}
+	return nil
+}
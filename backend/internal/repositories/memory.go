@@ -0,0 +1,200 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+)
+
+// The in-memory repositories keep copies of the records, so a caller changing a record it was
+// given does not change the stored one, as with a database.
+var (
+	_ UserRepo      = (*MemoryUserRepo)(nil)
+	_ PageRepo      = (*MemoryPageRepo)(nil)
+	_ TokenRepo     = (*MemoryTokenRepo)(nil)
+	_ SearchLogRepo = (*MemorySearchLogRepo)(nil)
+)
+
+// MemoryUserRepo is a UserRepo that keeps users in memory
+type MemoryUserRepo struct {
+	mu     sync.RWMutex
+	users  map[uint]models.User
+	nextID uint
+}
+
+// NewMemoryUserRepo returns an empty MemoryUserRepo
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{users: map[uint]models.User{}, nextID: 1}
+}
+
+func (r *MemoryUserRepo) Create(_ context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Username == user.Username || existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+
+	now := time.Now()
+	user.ID = r.nextID
+	user.CreatedAt, user.UpdatedAt = now, now
+	if user.LastLogin.IsZero() {
+		user.LastLogin = now
+	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	r.users[user.ID] = *user
+	r.nextID++
+	return nil
+}
+
+func (r *MemoryUserRepo) FindByID(_ context.Context, id uint) (*models.User, error) {
+	return r.find(func(user models.User) bool { return user.ID == id })
+}
+
+func (r *MemoryUserRepo) FindByUsername(_ context.Context, username string) (*models.User, error) {
+	return r.find(func(user models.User) bool { return user.Username == username })
+}
+
+func (r *MemoryUserRepo) FindByEmail(_ context.Context, email string) (*models.User, error) {
+	return r.find(func(user models.User) bool { return user.Email == email })
+}
+
+func (r *MemoryUserRepo) find(match func(models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// MemoryPageRepo is a PageRepo that searches a fixed set of pages
+type MemoryPageRepo struct {
+	pages []models.Page
+}
+
+// NewMemoryPageRepo returns a MemoryPageRepo with the given pages
+func NewMemoryPageRepo(pages ...models.Page) *MemoryPageRepo {
+	return &MemoryPageRepo{pages: append([]models.Page(nil), pages...)}
+}
+
+func (r *MemoryPageRepo) Search(_ context.Context, query, language string) ([]models.Page, error) {
+	var pages []models.Page
+	for _, page := range r.pages {
+		if strings.Contains(page.Content, query) && (language == "" || page.Language == language) {
+			pages = append(pages, page)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Title < pages[j].Title })
+	return pages, nil
+}
+
+// MemoryTokenRepo is a TokenRepo that keeps tokens in memory
+type MemoryTokenRepo struct {
+	mu     sync.RWMutex
+	tokens map[string]models.JWT
+	nextID uint
+}
+
+// NewMemoryTokenRepo returns an empty MemoryTokenRepo
+func NewMemoryTokenRepo() *MemoryTokenRepo {
+	return &MemoryTokenRepo{tokens: map[string]models.JWT{}, nextID: 1}
+}
+
+func (r *MemoryTokenRepo) Create(_ context.Context, token *models.JWT) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = r.nextID
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	r.tokens[token.Token] = *token
+	r.nextID++
+	return nil
+}
+
+func (r *MemoryTokenRepo) FindByToken(_ context.Context, token string) (*models.JWT, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	jwt, ok := r.tokens[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &jwt, nil
+}
+
+func (r *MemoryTokenRepo) Revoke(_ context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jwt, ok := r.tokens[token]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	jwt.RevokedAt = &now
+	r.tokens[token] = jwt
+	return nil
+}
+
+func (r *MemoryTokenRepo) RevokeAllForUser(_ context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, jwt := range r.tokens {
+		if jwt.UserID == userID && jwt.RevokedAt == nil {
+			jwt.RevokedAt = &now
+			r.tokens[key] = jwt
+		}
+	}
+	return nil
+}
+
+// MemorySearchLogRepo is a SearchLogRepo that keeps search logs in memory
+type MemorySearchLogRepo struct {
+	mu         sync.RWMutex
+	searchLogs []models.SearchLog
+}
+
+// NewMemorySearchLogRepo returns an empty MemorySearchLogRepo
+func NewMemorySearchLogRepo() *MemorySearchLogRepo {
+	return &MemorySearchLogRepo{}
+}
+
+func (r *MemorySearchLogRepo) Create(_ context.Context, searchLog *models.SearchLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	searchLog.ID = uint(len(r.searchLogs) + 1)
+	searchLog.CreatedAt, searchLog.ScrapedAt = now, now
+	r.searchLogs = append(r.searchLogs, *searchLog)
+	return nil
+}
+
+func (r *MemorySearchLogRepo) ListByUser(_ context.Context, userID uint) ([]models.SearchLog, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var searchLogs []models.SearchLog
+	for _, searchLog := range r.searchLogs {
+		if searchLog.UserID != nil && *searchLog.UserID == userID {
+			searchLogs = append(searchLogs, searchLog)
+		}
+	}
+	return searchLogs, nil
+}
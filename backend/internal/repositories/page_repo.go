@@ -0,0 +1,36 @@
+package repositories
+
+import (
+	"context"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// PageRepo reads the pages that are searched
+type PageRepo interface {
+	// Search returns the pages whose content contains query, ordered by title. An empty
+	// language matches every language.
+	Search(ctx context.Context, query, language string) ([]models.Page, error)
+}
+
+type gormPageRepo struct {
+	db *gorm.DB
+}
+
+// NewPageRepo returns a PageRepo backed by db
+func NewPageRepo(db *gorm.DB) PageRepo {
+	return &gormPageRepo{db: db}
+}
+
+func (r *gormPageRepo) Search(ctx context.Context, query, language string) ([]models.Page, error) {
+	var pages []models.Page
+	tx := r.db.WithContext(ctx).Where("content LIKE ?", "%"+query+"%").Order("title ASC")
+	if language != "" {
+		tx = tx.Where("language = ?", language)
+	}
+	if err := tx.Find(&pages).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to search pages")
+	}
+	return pages, nil
+}
@@ -0,0 +1,14 @@
+// Package repositories stores and loads the core models. Every repository is an interface
+// with a GORM implementation, used by the backend, and an in-memory implementation, used
+// where a test does not need a database. The methods take the context of the request, which
+// the database operations are traced in.
+package repositories
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when no record matches
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a record would break a unique constraint
+	ErrDuplicate = errors.New("duplicate record")
+)
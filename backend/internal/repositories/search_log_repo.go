@@ -0,0 +1,37 @@
+package repositories
+
+import (
+	"context"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// SearchLogRepo stores the search queries, which make up the search history of a user
+type SearchLogRepo interface {
+	// Create stores a search query and sets its ID
+	Create(ctx context.Context, searchLog *models.SearchLog) error
+	// ListByUser returns the search history of a user, oldest first
+	ListByUser(ctx context.Context, userID uint) ([]models.SearchLog, error)
+}
+
+type gormSearchLogRepo struct {
+	db *gorm.DB
+}
+
+// NewSearchLogRepo returns a SearchLogRepo backed by db
+func NewSearchLogRepo(db *gorm.DB) SearchLogRepo {
+	return &gormSearchLogRepo{db: db}
+}
+
+func (r *gormSearchLogRepo) Create(ctx context.Context, searchLog *models.SearchLog) error {
+	return errors.Wrap(r.db.WithContext(ctx).Create(searchLog).Error, "failed to store search log")
+}
+
+func (r *gormSearchLogRepo) ListByUser(ctx context.Context, userID uint) ([]models.SearchLog, error) {
+	var searchLogs []models.SearchLog
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at, id").Find(&searchLogs).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to list search logs")
+	}
+	return searchLogs, nil
+}
@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// TokenRepo stores the session JWTs issued at login, so they can be revoked
+type TokenRepo interface {
+	// Create stores an issued token and sets its ID
+	Create(ctx context.Context, token *models.JWT) error
+	// FindByToken returns the stored token, or ErrNotFound
+	FindByToken(ctx context.Context, token string) (*models.JWT, error)
+	// Revoke marks a token as revoked, or returns ErrNotFound
+	Revoke(ctx context.Context, token string) error
+	// RevokeAllForUser revokes every token of a user that is not revoked yet
+	RevokeAllForUser(ctx context.Context, userID uint) error
+}
+
+type gormTokenRepo struct {
+	db *gorm.DB
+}
+
+// NewTokenRepo returns a TokenRepo backed by db
+func NewTokenRepo(db *gorm.DB) TokenRepo {
+	return &gormTokenRepo{db: db}
+}
+
+func (r *gormTokenRepo) Create(ctx context.Context, token *models.JWT) error {
+	return errors.Wrap(r.db.WithContext(ctx).Create(token).Error, "failed to store token")
+}
+
+func (r *gormTokenRepo) FindByToken(ctx context.Context, token string) (*models.JWT, error) {
+	jwt := &models.JWT{}
+	if err := r.db.WithContext(ctx).Where("token = ?", token).First(jwt).Error; err != nil {
+		return nil, notFound(err, "failed to find token")
+	}
+	return jwt, nil
+}
+
+func (r *gormTokenRepo) Revoke(ctx context.Context, token string) error {
+	result := r.db.WithContext(ctx).Model(&models.JWT{}).Where("token = ?", token).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to revoke token")
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormTokenRepo) RevokeAllForUser(ctx context.Context, userID uint) error {
+	err := r.db.WithContext(ctx).Model(&models.JWT{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	return errors.Wrap(err, "failed to revoke user tokens")
+}
@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"context"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// UserRepo stores user accounts
+type UserRepo interface {
+	// Create stores a new user and sets its ID, or returns ErrDuplicate if the username or email is taken
+	Create(ctx context.Context, user *models.User) error
+	// FindByID returns the user with the given ID, or ErrNotFound
+	FindByID(ctx context.Context, id uint) (*models.User, error)
+	// FindByUsername returns the user with the given username, or ErrNotFound
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	// FindByEmail returns the user with the given email address, or ErrNotFound
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+}
+
+type gormUserRepo struct {
+	db *gorm.DB
+}
+
+// NewUserRepo returns a UserRepo backed by db
+func NewUserRepo(db *gorm.DB) UserRepo {
+	return &gormUserRepo{db: db}
+}
+
+func (r *gormUserRepo) Create(ctx context.Context, user *models.User) error {
+	err := r.db.WithContext(ctx).Create(user).Error
+	if translator, ok := r.db.Dialector.(gorm.ErrorTranslator); ok && errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey) {
+		return ErrDuplicate
+	}
+	return errors.Wrap(err, "failed to create user")
+}
+
+func (r *gormUserRepo) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	return r.findWhere(ctx, "id = ?", id)
+}
+
+func (r *gormUserRepo) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.findWhere(ctx, "username = ?", username)
+}
+
+func (r *gormUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findWhere(ctx, "email = ?", email)
+}
+
+func (r *gormUserRepo) findWhere(ctx context.Context, query string, value interface{}) (*models.User, error) {
+	user := &models.User{}
+	if err := r.db.WithContext(ctx).Where(query, value).First(user).Error; err != nil {
+		return nil, notFound(err, "failed to find user")
+	}
+	return user, nil
+}
+
+// notFound translates gorm.ErrRecordNotFound to ErrNotFound, and wraps other errors
+func notFound(err error, message string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return errors.Wrap(err, message)
+}
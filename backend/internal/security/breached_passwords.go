@@ -0,0 +1,135 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BreachedPasswordList reports whether a password is known from data breaches
+type BreachedPasswordList interface {
+	Contains(password string) (bool, error)
+}
+
+// BreachedPasswordSet is an in-memory set of SHA-1 hashes of breached passwords
+type BreachedPasswordSet map[string]struct{}
+
+// LoadBreachedPasswordFile loads a breached password list with one entry per line. Entries are
+// either plain text passwords or upper- or lowercase SHA-1 hex hashes, optionally followed by
+// ":count" as in the Have I Been Pwned downloads. Empty lines and lines starting with # are skipped.
+//
+// Parameters:
+//   - path: The path to the list.
+//
+// Returns:
+//   - BreachedPasswordSet: The loaded list.
+//   - error: An error if the file could not be read.
+func LoadBreachedPasswordFile(path string) (BreachedPasswordSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open breached password list")
+	}
+	defer file.Close()
+
+	set := BreachedPasswordSet{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			set[strings.ToUpper(hash)] = struct{}{}
+		} else {
+			set[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read breached password list")
+	}
+
+	return set, nil
+}
+
+// Contains reports whether the password is in the set
+func (s BreachedPasswordSet) Contains(password string) (bool, error) {
+	_, ok := s[sha1Hex(password)]
+	return ok, nil
+}
+
+// BreachedPasswordPrefixDir looks passwords up in a directory of k-anonymity range files, as
+// served by the Have I Been Pwned range API. The file <dir>/<PREFIX>.txt holds one
+// "SUFFIX:count" line for each breached hash starting with the 5 character PREFIX, so only
+// one small file is read per lookup.
+type BreachedPasswordPrefixDir struct {
+	dir string
+}
+
+// NewBreachedPasswordPrefixDir creates a lookup over the range files in dir.
+//
+// Parameters:
+//   - dir: The directory holding the range files.
+//
+// Returns:
+//   - *BreachedPasswordPrefixDir: The lookup.
+//   - error: An error if dir is not a directory.
+func NewBreachedPasswordPrefixDir(dir string) (*BreachedPasswordPrefixDir, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open breached password directory")
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("breached password path %q is not a directory", dir)
+	}
+	return &BreachedPasswordPrefixDir{dir: dir}, nil
+}
+
+// Contains reports whether the password hash is listed in its range file. A missing range
+// file means no breached password has that prefix.
+func (d *BreachedPasswordPrefixDir) Contains(password string) (bool, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open breached password range file")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(entry, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, errors.Wrap(err, "failed to read breached password range file")
+	}
+
+	return false, nil
+}
+
+// sha1Hex returns the uppercase hex SHA-1 hash of s
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// isSHA1Hex reports whether s looks like a hex encoded SHA-1 hash
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
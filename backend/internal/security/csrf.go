@@ -0,0 +1,42 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+)
+
+// CSRFToken derives the CSRF token of a cookie session from the session token. The frontend
+// reads it from a cookie and sends it back in a header with every state-changing request.
+// As it is bound to the session, a token planted by another site, or taken from another
+// session, is useless.
+//
+// Parameters:
+//   - cfg: The JWT configuration, whose secret the token is derived with.
+//   - sessionToken: The session JWT stored in the session cookie.
+//
+// Returns:
+//   - string: The base64url encoded HMAC-SHA256 of the session token.
+func CSRFToken(cfg config.JWTConfig, sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.Secret))
+	mac.Write([]byte("csrf:" + sessionToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateCSRFToken checks a CSRF token sent with a request against the session token.
+//
+// Parameters:
+//   - cfg: The JWT configuration, whose secret the token is derived with.
+//   - sessionToken: The session JWT from the session cookie.
+//   - csrfToken: The CSRF token from the request header.
+//
+// Returns:
+//   - bool: True if the CSRF token belongs to the session.
+func ValidateCSRFToken(cfg config.JWTConfig, sessionToken, csrfToken string) bool {
+	if csrfToken == "" {
+		return false
+	}
+	return hmac.Equal([]byte(CSRFToken(cfg, sessionToken)), []byte(csrfToken))
+}
@@ -1,15 +1,51 @@
 package security
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
-	"os"
-	"strconv"
 )
 
+// Supported password hashing algorithms
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+// Argon2Params are the tunable argon2id parameters
+type Argon2Params struct {
+	// Memory is the memory cost in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
 var bcryptCost int
 
+// hashAlgorithm is the algorithm used for new hashes
+var hashAlgorithm = HashAlgorithmArgon2id
+
+// argon2Params are the parameters used for new argon2id hashes
+var argon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
 
 // init initializes the bcrypt cost value from the environment variable "BCRYPT_COST".
 // If the environment variable is not set or contains an invalid value, it falls back to the default cost.
@@ -22,8 +58,36 @@ func init() {
 	bcryptCost = cost
 }
 
-// HashPassword hashes the given password using bcrypt.
-// It returns the hashed password as a string and an error if the hashing fails.
+// InitPasswordHashing sets the algorithm and parameters used for new password hashes.
+// Existing hashes in any supported format keep working, and are upgraded on the next login.
+//
+// Parameters:
+//   - cfg: The password hashing configuration.
+//
+// Returns:
+//   - error: An error if the algorithm is unknown or a parameter is out of range.
+func InitPasswordHashing(cfg config.PasswordHashConfig) error {
+	switch cfg.Algorithm {
+	case HashAlgorithmArgon2id, HashAlgorithmBcrypt:
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+
+	if cfg.Argon2Memory < 8*cfg.Argon2Parallelism || cfg.Argon2Iterations < 1 || cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > 255 {
+		return errors.New("invalid argon2id parameters")
+	}
+
+	hashAlgorithm = cfg.Algorithm
+	argon2Params.Memory = uint32(cfg.Argon2Memory)
+	argon2Params.Iterations = uint32(cfg.Argon2Iterations)
+	argon2Params.Parallelism = uint8(cfg.Argon2Parallelism)
+	return nil
+}
+
+// HashPassword hashes the given password with the configured algorithm.
+// Argon2id hashes are stored in the PHC string format, "$argon2id$v=19$m=...,t=...,p=...$salt$hash",
+// and bcrypt hashes in their standard "$2a$" format, so the algorithm and its parameters
+// can always be read back from the stored hash.
 //
 // Parameters:
 //   - password: The plain text password to be hashed.
@@ -33,34 +97,116 @@ func init() {
 //   - An error if the password is empty or hashing fails.
 func HashPassword(password string) (string, error) {
 	if password == "" {
-		utils.LogWarn("Password cannot be empty", nil)
 		return "", errors.New("password cannot be empty")
 	}
 
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
-	if err != nil {
-		utils.LogError(err, "Failed to hash password", nil)
-		return "", errors.Wrap(err, "failed to hash password")
+	if hashAlgorithm == HashAlgorithmBcrypt {
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to hash password")
+		}
+		return string(hashedBytes), nil
+	}
+
+	salt := make([]byte, argon2Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "failed to generate salt")
 	}
 
-	return string(hashedBytes), nil
+	key := argon2.IDKey([]byte(password), salt, argon2Params.Iterations, argon2Params.Memory, argon2Params.Parallelism, argon2Params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Params.Memory, argon2Params.Iterations, argon2Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
 }
 
 // CheckPasswordHash compares a plaintext password with a hashed password.
+// The algorithm is detected from the hash, so both argon2id and bcrypt hashes are supported.
 // It returns true if they match, otherwise false.
 //
 // Parameters:
+//   - ctx: The context of the request, which a mismatch is logged with.
 //   - password: The plaintext password to compare.
 //   - hash: The hashed password to compare against.
 //
 // Returns:
 //   - bool: true if the password matches the hash, false otherwise.
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+func CheckPasswordHash(ctx context.Context, password, hash string) bool {
+	var match bool
+	if strings.HasPrefix(hash, "$argon2id$") {
+		match = checkArgon2idHash(password, hash)
+	} else {
+		match = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	if !match {
+		utils.LogWarnContext(ctx, "Password hash comparison failed", nil)
+	}
+	return match
+}
+
+// NeedsRehash reports whether a hash was made with another algorithm or other parameters
+// than the ones currently configured, and should be replaced the next time the password is known.
+//
+// Parameters:
+//   - hash: The stored password hash.
+//
+// Returns:
+//   - bool: true if the hash should be upgraded.
+func NeedsRehash(hash string) bool {
+	if hashAlgorithm == HashAlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != bcryptCost
+	}
+
+	params, _, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != argon2Params.Memory ||
+		params.Iterations != argon2Params.Iterations ||
+		params.Parallelism != argon2Params.Parallelism ||
+		uint32(len(key)) != argon2Params.KeyLength
+}
+
+// checkArgon2idHash compares a password with an argon2id PHC string in constant time
+func checkArgon2idHash(password, hash string) bool {
+	params, salt, key, err := parseArgon2idHash(hash)
 	if err != nil {
-		utils.LogWarn("Password hash comparison failed", nil)
 		return false
 	}
 
-	return true
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1
+}
+
+// parseArgon2idHash splits an argon2id PHC string into its parameters, salt and key
+func parseArgon2idHash(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errors.Wrap(err, "invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errors.Wrap(err, "invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, errors.Wrap(err, "invalid argon2id key")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
 }
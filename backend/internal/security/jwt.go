@@ -1,29 +1,31 @@
 package security
 
 import (
+	"context"
 	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/config"
-	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/repositories"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/pkg/errors"
-	"gorm.io/gorm"
 )
 
 // GenerateJWT generates a JSON Web Token (JWT) for a given user ID and username.
 // The token includes claims such as issuer, subject, audience, username, role, issued at, and expiration time.
-// The token is signed using the HS256 signing method and a secret key from the application configuration.
+// The token is signed using the HS256 signing method and the secret key of cfg.
 //
 // Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret signs the token.
 //   - userID: The unique identifier of the user.
 //   - username: The username of the user.
 //
 // Returns:
 //   - A signed JWT token as a string.
 //   - An error if there is a failure in signing the token.
-func GenerateJWT(userID uint, username string) (string, error) {
-	utils.LogInfo("Starting JWT generation process", nil)
+func GenerateJWT(ctx context.Context, cfg config.JWTConfig, userID uint, username string) (string, error) {
+	utils.LogInfoContext(ctx, "Starting JWT generation process", nil)
 
 	claims := jwt.MapClaims{
 		"iss":      "whoknows",
@@ -36,15 +38,13 @@ func GenerateJWT(userID uint, username string) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := config.AppConfig.JWT.Secret
-
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := token.SignedString([]byte(cfg.Secret))
 	if err != nil {
-		utils.LogError(err, "Failed to sign JWT", nil)
+		utils.LogErrorContext(ctx, err, "Failed to sign JWT", nil)
 		return "", err
 	}
 
-	utils.LogInfo("JWT generation completed successfully", nil)
+	utils.LogInfoContext(ctx, "JWT generation completed successfully", nil)
 	return tokenString, nil
 }
 
@@ -52,6 +52,8 @@ func GenerateJWT(userID uint, username string) (string, error) {
 // It takes the user ID, username, and expiration time as parameters and returns the signed JWT token string or an error.
 //
 // Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret signs the token.
 //   - userID: The unique identifier of the user.
 //   - username: The username of the user.
 //   - expTime: The custom expiration time for the JWT token.
@@ -59,8 +61,8 @@ func GenerateJWT(userID uint, username string) (string, error) {
 // Returns:
 //   - string: The signed JWT token string.
 //   - error: An error if the token signing process fails.
-func GenerateJWTWithCustomExpiration(userID uint, username string, expTime time.Time) (string, error) {
-	utils.LogInfo("Starting JWT generation with custom expiration", nil)
+func GenerateJWTWithCustomExpiration(ctx context.Context, cfg config.JWTConfig, userID uint, username string, expTime time.Time) (string, error) {
+	utils.LogInfoContext(ctx, "Starting JWT generation with custom expiration", nil)
 
 	claims := jwt.MapClaims{
 		"iss":      "whoknows",
@@ -73,109 +75,168 @@ func GenerateJWTWithCustomExpiration(userID uint, username string, expTime time.
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := config.AppConfig.JWT.Secret
+	tokenString, err := token.SignedString([]byte(cfg.Secret))
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to sign JWT with custom expiration", nil)
+		return "", err
+	}
+
+	utils.LogInfoContext(ctx, "JWT with custom expiration generated successfully", nil)
+	return tokenString, nil
+}
+
+// ScopePasswordChange is the scope of a session token that only allows changing the password,
+// issued to users that must change their password
+const ScopePasswordChange = "password_change"
+
+// PasswordChangeTokenTTL is how long a password change token is valid
+const PasswordChangeTokenTTL = 15 * time.Minute
+
+// GenerateScopedJWT generates a JWT like GenerateJWTWithCustomExpiration, with an added "scope" claim
+// that limits what the token can be used for. The AuthMiddleware rejects scoped tokens on routes
+// that do not allow that scope.
+//
+// Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret signs the token.
+//   - userID: The unique identifier of the user.
+//   - username: The username of the user.
+//   - scope: The scope the token is limited to, such as ScopePasswordChange.
+//   - expTime: The expiration time for the JWT token.
+//
+// Returns:
+//   - string: The signed JWT token string.
+//   - error: An error if the token signing process fails.
+func GenerateScopedJWT(ctx context.Context, cfg config.JWTConfig, userID uint, username, scope string, expTime time.Time) (string, error) {
+	utils.LogInfoContext(ctx, "Starting scoped JWT generation", nil)
 
-	tokenString, err := token.SignedString([]byte(secret))
+	claims := jwt.MapClaims{
+		"iss":      "whoknows",
+		"sub":      userID,
+		"aud":      "whoknows",
+		"username": username,
+		"role":     "user",
+		"scope":    scope,
+		"iat":      time.Now().Unix(),
+		"exp":      expTime.Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(cfg.Secret))
 	if err != nil {
-		utils.LogError(err, "Failed to sign JWT with custom expiration", nil)
+		utils.LogErrorContext(ctx, err, "Failed to sign scoped JWT", nil)
 		return "", err
 	}
 
-	utils.LogInfo("JWT with custom expiration generated successfully", nil)
 	return tokenString, nil
 }
 
+// TokenScope returns the "scope" claim of a token, or an empty string for a token with full access
+func TokenScope(claims map[string]interface{}) string {
+	scope, _ := claims["scope"].(string)
+	return scope
+}
+
 // ValidateJWT validates a given JWT token string and returns the claims if the token is valid.
 // It logs the validation process and any errors encountered.
 //
 // Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret the token must be signed with.
 //   - tokenString: The JWT token string to be validated.
 //
 // Returns:
 //   - jwt.MapClaims: The claims extracted from the token if it is valid.
 //   - error: An error if the token is invalid or if there was an issue during parsing.
-func ValidateJWT(tokenString string) (jwt.MapClaims, error) {
-	utils.LogInfo("Starting JWT validation", nil)
+func ValidateJWT(ctx context.Context, cfg config.JWTConfig, tokenString string) (jwt.MapClaims, error) {
+	utils.LogInfoContext(ctx, "Starting JWT validation", nil)
 
 	claims := jwt.MapClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.AppConfig.JWT.Secret), nil
+		return []byte(cfg.Secret), nil
 	})
 	if err != nil {
-		utils.LogError(err, "JWT validation failed during parsing", nil)
+		utils.LogErrorContext(ctx, err, "JWT validation failed during parsing", nil)
 		return nil, errors.Wrap(err, "failed to parse JWT")
 	}
 
 	if !token.Valid {
-		utils.LogInfo("JWT validation failed: token is invalid", nil)
+		utils.LogInfoContext(ctx, "JWT validation failed: token is invalid", nil)
 		return nil, errors.New("invalid JWT token")
 	}
 
-	utils.LogInfo("JWT validation succeeded", nil)
+	utils.LogInfoContext(ctx, "JWT validation succeeded", nil)
 	return claims, nil
 }
 
-// ValidateJWTRevoked checks if the token is revoked
-// ValidateJWTRevoked checks if a given JWT token is revoked by querying the database.
-// It logs the process of checking and any errors encountered during the query.
+// ValidateJWTRevoked checks if a given JWT token is revoked by looking it up in the token repository.
+// It logs the process of checking and any errors encountered during the lookup.
 // If the token is found and is revoked, it returns an error indicating the token is revoked.
 //
 // Parameters:
-//   - db: A pointer to the gorm.DB instance used to query the database.
+//   - ctx: The context of the request, which the lookup is traced and logged in.
+//   - tokens: The repository the issued tokens are stored in.
 //   - jwt: The JWT token string to be validated.
 //
 // Returns:
-//   - error: An error if the token is revoked or if there is a failure in querying the database.
-func ValidateJWTRevoked(db *gorm.DB, jwt string) error {
-	utils.LogInfo("Checking if JWT is revoked", nil)
+//   - error: An error if the token is revoked, unknown, or if the lookup fails.
+func ValidateJWTRevoked(ctx context.Context, tokens repositories.TokenRepo, jwt string) error {
+	utils.LogInfoContext(ctx, "Checking if JWT is revoked", nil)
 
-	var jwtModel models.JWT
-	err := db.Where("token = ?", jwt).First(&jwtModel).Error
+	jwtModel, err := tokens.FindByToken(ctx, jwt)
 	if err != nil {
-		utils.LogError(err, "Database query for JWT revocation check failed", nil)
+		utils.LogErrorContext(ctx, err, "Database query for JWT revocation check failed", nil)
 		return errors.Wrap(err, "failed to query token revocation status")
 	}
 
 	if jwtModel.RevokedAt != nil {
-		utils.LogInfo("JWT is revoked", nil)
+		utils.LogInfoContext(ctx, "JWT is revoked", nil)
 		return errors.New("JWT token is revoked")
 	}
 
-	utils.LogInfo("JWT revocation check completed: token is not revoked", nil)
+	utils.LogInfoContext(ctx, "JWT revocation check completed: token is not revoked", nil)
 	return nil
 }
 
-// RevokeJWT revokes a given JWT token by marking it as revoked in the database.
+// RevokeJWT revokes a given JWT token by marking it as revoked in the token repository.
 //
 // Parameters:
-//   - db: A gorm.DB instance representing the database connection.
+//   - ctx: The context of the request, which the lookup is traced and logged in.
+//   - tokens: The repository the issued tokens are stored in.
 //   - jwt: A string representing the JWT token to be revoked.
 //
 // Returns:
-//   - error: An error object if any error occurs during the process, otherwise nil.
-//
-// The function performs the following steps:
-//  1. Logs the intention to revoke the JWT token.
-//  2. Queries the database to find the JWT token.
-//  3. If the token is found, it updates the token's revoked status.
-//  4. Logs the success or failure of the revocation process.
-func RevokeJWT(db *gorm.DB, jwt string) error {
-	utils.LogInfo("Starting JWT revocation process", nil)
-
-	var jwtModel models.JWT
-	err := db.Where("token = ?", jwt).First(&jwtModel).Error
-	if err != nil {
-		utils.LogError(err, "Database query for JWT revocation failed", nil)
-		return errors.Wrap(err, "failed to query token for revocation")
+//   - error: An error object if the token is unknown or cannot be updated, otherwise nil.
+func RevokeJWT(ctx context.Context, tokens repositories.TokenRepo, jwt string) error {
+	utils.LogInfoContext(ctx, "Starting JWT revocation process", nil)
+
+	if err := tokens.Revoke(ctx, jwt); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to update JWT revocation status", nil)
+		return errors.Wrap(err, "failed to revoke token")
 	}
 
-	jwtModel.RevokedAt = &time.Time{}
-	err = db.Save(&jwtModel).Error
-	if err != nil {
-		utils.LogError(err, "Failed to update JWT revocation status", nil)
-		return errors.Wrap(err, "failed to update token revocation status")
+	utils.LogInfoContext(ctx, "JWT revoked successfully", nil)
+	return nil
+}
+
+// RevokeAllUserJWTs revokes every active JWT token issued to a user, for example
+// after a password reset, so that sessions opened with the old password end.
+//
+// Parameters:
+//   - ctx: The context of the request, which the lookup is traced and logged in.
+//   - tokens: The repository the issued tokens are stored in.
+//   - userID: The ID of the user whose tokens are revoked.
+//
+// Returns:
+//   - error: An error object if the update fails, otherwise nil.
+func RevokeAllUserJWTs(ctx context.Context, tokens repositories.TokenRepo, userID uint) error {
+	utils.LogInfoContext(ctx, "Revoking all JWT tokens for user", nil)
+
+	if err := tokens.RevokeAllForUser(ctx, userID); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to revoke JWT tokens for user", nil)
+		return errors.Wrap(err, "failed to revoke user tokens")
 	}
 
-	utils.LogInfo("JWT revoked successfully", nil)
+	utils.LogInfoContext(ctx, "All JWT tokens for user revoked", nil)
 	return nil
 }
@@ -0,0 +1,46 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+)
+
+// MFAChallengeTTL is how long a user has to complete the second login step
+const MFAChallengeTTL = 5 * time.Minute
+
+// GenerateMFAChallengeToken generates the short-lived token returned by the login endpoint
+// when the user has two-factor authentication enabled. It proves that the password step
+// succeeded and must be exchanged, together with a second factor, for a session JWT.
+//
+// Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret signs the token.
+//   - userID: The unique identifier of the user.
+//
+// Returns:
+//   - string: The signed challenge token.
+//   - error: An error if signing fails.
+func GenerateMFAChallengeToken(ctx context.Context, cfg config.JWTConfig, userID uint) (string, error) {
+	utils.LogInfoContext(ctx, "Generating MFA challenge token", nil)
+	return generatePurposeToken(ctx, cfg, userID, PurposeMFAChallenge, nil, MFAChallengeTTL)
+}
+
+// ValidateMFAChallengeToken validates an MFA challenge token and returns the user ID it was issued to.
+//
+// Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret the token must be signed with.
+//   - tokenString: The challenge token.
+//
+// Returns:
+//   - uint: The user ID.
+//   - error: An error if the token is invalid, expired or not an MFA challenge token.
+func ValidateMFAChallengeToken(ctx context.Context, cfg config.JWTConfig, tokenString string) (uint, error) {
+	utils.LogInfoContext(ctx, "Validating MFA challenge token", nil)
+
+	userID, _, err := validatePurposeToken(ctx, cfg, tokenString, PurposeMFAChallenge)
+	return userID, err
+}
@@ -0,0 +1,176 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+)
+
+// maxPasswordLength bounds the work done hashing a password
+const maxPasswordLength = 128
+
+// minIdentifierLength is the shortest username or email name that is checked for reuse in the password
+const minIdentifierLength = 3
+
+// PasswordViolation describes a single password policy rule that was not met
+type PasswordViolation struct {
+	Rule    string `json:"rule" example:"min_length"`
+	Message string `json:"message" example:"Password must be at least 8 characters long"`
+}
+
+// PasswordPolicy holds the rules a new password must satisfy. Password history is checked
+// separately by the services, as it needs the database.
+type PasswordPolicy struct {
+	MinLength      int
+	MinEntropyBits int
+	HistorySize    int
+	// Breached is the list of known breached passwords, or nil to skip the check
+	Breached BreachedPasswordList
+}
+
+// DefaultPasswordPolicy returns the policy used when nothing is configured
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:      8,
+		MinEntropyBits: 40,
+		HistorySize:    5,
+	}
+}
+
+// NewPasswordPolicy creates the policy from the configuration, loading the breached password list if configured.
+//
+// Parameters:
+//   - cfg: The password policy configuration.
+//
+// Returns:
+//   - *PasswordPolicy: The password policy.
+//   - error: An error if the breached password list could not be loaded.
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength:      cfg.MinLength,
+		MinEntropyBits: cfg.MinEntropyBits,
+		HistorySize:    cfg.HistorySize,
+	}
+
+	switch {
+	case cfg.BreachedListFile != "":
+		list, err := LoadBreachedPasswordFile(cfg.BreachedListFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = list
+	case cfg.BreachedPrefixDir != "":
+		list, err := NewBreachedPasswordPrefixDir(cfg.BreachedPrefixDir)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = list
+	}
+
+	return policy, nil
+}
+
+// Check validates a password against the policy and returns every rule it violates.
+// Errors from the breached password list are logged and the check is skipped.
+//
+// Parameters:
+//   - ctx: The context of the request, which errors are logged with.
+//   - password: The plain text password.
+//   - username: The username of the account, which the password must not contain.
+//   - email: The email address of the account, whose name part the password must not contain.
+//
+// Returns:
+//   - []PasswordViolation: The violated rules, or an empty slice if the password is acceptable.
+func (p *PasswordPolicy) Check(ctx context.Context, password, username, email string) []PasswordViolation {
+	violations := []PasswordViolation{}
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		add("min_length", "Password must be at least %d characters long", p.MinLength)
+	}
+	if length > maxPasswordLength {
+		add("max_length", "Password must be at most %d characters long", maxPasswordLength)
+	}
+
+	if EstimatePasswordEntropy(password) < float64(p.MinEntropyBits) {
+		add("entropy", "Password is too easy to guess, use a longer password or more kinds of characters")
+	}
+
+	lowered := strings.ToLower(password)
+	if len(username) >= minIdentifierLength && strings.Contains(lowered, strings.ToLower(username)) {
+		add("contains_username", "Password must not contain the username")
+	}
+	emailName, _, _ := strings.Cut(email, "@")
+	if len(emailName) >= minIdentifierLength && strings.Contains(lowered, strings.ToLower(emailName)) {
+		add("contains_email", "Password must not contain the email address")
+	}
+
+	if p.Breached != nil && password != "" {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			utils.LogErrorContext(ctx, err, "Failed to check breached password list", nil)
+		} else if breached {
+			add("breached", "Password appears in a list of breached passwords")
+		}
+	}
+
+	return violations
+}
+
+// EstimatePasswordEntropy gives a rough estimate of the entropy of a password in bits.
+// Each character contributes log2 of the size of the character classes used, except
+// characters that repeat the previous one or continue a sequence like "abc" or "123".
+//
+// Parameters:
+//   - password: The plain text password.
+//
+// Returns:
+//   - float64: The estimated entropy in bits.
+func EstimatePasswordEntropy(password string) float64 {
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	effectiveLength := 0
+
+	var previous rune
+	for i, r := range []rune(password) {
+		switch {
+		case r > unicode.MaxASCII:
+			hasOther = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+
+		if i == 0 || (r != previous && r != previous+1 && r != previous-1) {
+			effectiveLength++
+		}
+		previous = r
+	}
+
+	poolSize := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{hasLower, 26}, {hasUpper, 26}, {hasDigit, 10}, {hasSymbol, 33}, {hasOther, 100}} {
+		if class.used {
+			poolSize += class.size
+		}
+	}
+	if poolSize == 0 {
+		return 0
+	}
+
+	return float64(effectiveLength) * math.Log2(float64(poolSize))
+}
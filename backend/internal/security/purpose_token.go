@@ -0,0 +1,88 @@
+package security
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// Purposes of single-purpose tokens. A purpose token is signed like a session JWT but is
+// never stored in the jwts table, so it can not be used as a session token, and a
+// session token can not be used as a purpose token.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposeMFAChallenge      = "mfa_challenge"
+)
+
+// generatePurposeToken generates a signed, short-lived token for a single purpose.
+//
+// Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret signs the token.
+//   - userID: The unique identifier of the user.
+//   - purpose: What the token may be used for.
+//   - extraClaims: Additional claims to include in the token, may be nil.
+//   - ttl: How long the token stays valid.
+//
+// Returns:
+//   - string: The signed token.
+//   - error: An error if signing fails.
+func generatePurposeToken(ctx context.Context, cfg config.JWTConfig, userID uint, purpose string, extraClaims jwt.MapClaims, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":     "whoknows",
+		"sub":     strconv.FormatUint(uint64(userID), 10),
+		"aud":     "whoknows",
+		"purpose": purpose,
+		"iat":     time.Now().Unix(),
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+	for key, value := range extraClaims {
+		claims[key] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(cfg.Secret))
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to sign purpose token", nil)
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// validatePurposeToken validates a single-purpose token and returns its user ID and claims.
+//
+// Parameters:
+//   - ctx: The context of the request, which the token is logged with.
+//   - cfg: The JWT configuration, whose secret the token must be signed with.
+//   - tokenString: The token to validate.
+//   - purpose: The purpose the token must have been issued for.
+//
+// Returns:
+//   - uint: The user ID the token was issued to.
+//   - jwt.MapClaims: All claims of the token.
+//   - error: An error if the token is invalid, expired or issued for another purpose.
+func validatePurposeToken(ctx context.Context, cfg config.JWTConfig, tokenString, purpose string) (uint, jwt.MapClaims, error) {
+	claims, err := ValidateJWT(ctx, cfg, tokenString)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if tokenPurpose, _ := claims["purpose"].(string); tokenPurpose != purpose {
+		utils.LogWarnContext(ctx, "Token was issued for another purpose", nil)
+		return 0, nil, errors.New("token was issued for another purpose")
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.ParseUint(sub, 10, 32)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "invalid subject in token")
+	}
+
+	return uint(userID), claims, nil
+}
@@ -0,0 +1,47 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+)
+
+// SignDataExportLink signs a data export download link, so the link itself grants access
+// to the export until it expires.
+//
+// Parameters:
+//   - cfg: The JWT configuration, whose secret signs the link.
+//   - exportID: The ID of the data export.
+//   - expires: When the link stops working.
+//
+// Returns:
+//   - string: The base64url encoded HMAC-SHA256 signature.
+func SignDataExportLink(cfg config.JWTConfig, exportID uint, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(cfg.Secret))
+	fmt.Fprintf(mac, "data-export:%d:%d", exportID, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateDataExportLink checks the signature and expiry of a data export download link.
+//
+// Parameters:
+//   - cfg: The JWT configuration, whose secret the link must be signed with.
+//   - exportID: The ID of the data export from the link.
+//   - expiresUnix: The expiry from the link, in Unix seconds.
+//   - signature: The signature from the link.
+//
+// Returns:
+//   - bool: True if the signature is valid and the link has not expired.
+func ValidateDataExportLink(cfg config.JWTConfig, exportID uint, expiresUnix int64, signature string) bool {
+	expires := time.Unix(expiresUnix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+
+	expected := SignDataExportLink(cfg, exportID, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,35 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// GenerateRandomToken generates a cryptographically secure random token of the given
+// number of bytes, encoded as unpadded URL-safe base64 so it can be put in links.
+//
+// Parameters:
+//   - size: The number of random bytes.
+//
+// Returns:
+//   - string: The encoded token.
+//   - error: An error if the random source fails.
+func GenerateRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate random token")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token.
+// High-entropy random tokens do not need a slow password hash, so SHA-256 is
+// enough to make a leaked database useless for replaying tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix marks WhoKnows API keys, so they are easy to recognize, for example by secret scanners
+	apiKeyPrefix = "wk_"
+	// apiKeyLookupBytes is the randomness in the public part of a key used to find it
+	apiKeyLookupBytes = 4
+	// apiKeySecretBytes is the randomness in the secret part of a key
+	apiKeySecretBytes = 32
+	// apiKeyLastUsedInterval limits how often the last used timestamp of a key is written
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is malformed, unknown or revoked
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyNotFound is returned when a key is unknown or belongs to another user
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrTooManyAPIKeys is returned when a user already has the maximum number of active keys
+	ErrTooManyAPIKeys = errors.New("too many API keys")
+)
+
+// APIKeyHasScope reports whether the scopes of a key include scope
+func APIKeyHasScope(key *models.APIKey, scope string) bool {
+	for _, keyScope := range strings.Split(key.Scopes, ",") {
+		if keyScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey creates a new API key for the user. The plain key is only returned here;
+// only its hash is stored.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The owner of the key.
+//   - name: A name to tell the key apart.
+//   - scopes: The scopes the key grants.
+//   - rateLimit: The number of requests per minute allowed for the key.
+//   - maxKeys: The number of active keys a user can have.
+//
+// Returns:
+//   - *models.APIKey: The stored key.
+//   - string: The plain key to show to the user once.
+//   - error: ErrTooManyAPIKeys if the user has maxKeys active keys, or an error if the key could not be stored.
+func CreateAPIKey(db *gorm.DB, user *models.User, name string, scopes []string, rateLimit, maxKeys int) (*models.APIKey, string, error) {
+	utils.LogInfoContext(db.Statement.Context, "Creating API key", nil)
+
+	var count int64
+	if err := db.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&count).Error; err != nil {
+		return nil, "", errors.Wrap(err, "failed to count API keys")
+	}
+	if count >= int64(maxKeys) {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	lookup := make([]byte, apiKeyLookupBytes)
+	if _, err := rand.Read(lookup); err != nil {
+		return nil, "", errors.Wrap(err, "failed to generate API key")
+	}
+	secret, err := security.GenerateRandomToken(apiKeySecretBytes)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to generate API key")
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(lookup)
+	plainKey := prefix + "_" + secret
+
+	key := &models.APIKey{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   security.HashToken(plainKey),
+		Scopes:    strings.Join(scopes, ","),
+		RateLimit: rateLimit,
+	}
+	if err := db.Create(key).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to create API key", nil)
+		return nil, "", errors.Wrap(err, "failed to create API key")
+	}
+
+	return key, plainKey, nil
+}
+
+// ListAPIKeys returns the API keys of a user, including revoked ones, newest first.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The owner of the keys.
+//
+// Returns:
+//   - []models.APIKey: The keys.
+//   - error: An error if the query fails.
+func ListAPIKeys(db *gorm.DB, userID uint) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&keys).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to list API keys")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key of a user, so it can no longer be used.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The owner of the key.
+//   - keyID: The ID of the key.
+//
+// Returns:
+//   - *models.APIKey: The revoked key.
+//   - error: ErrAPIKeyNotFound if the user has no such active key, or an error if the update fails.
+func RevokeAPIKey(db *gorm.DB, userID, keyID uint) (*models.APIKey, error) {
+	utils.LogInfoContext(db.Statement.Context, "Revoking API key", nil)
+
+	var key models.APIKey
+	err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get API key")
+	}
+
+	now := time.Now()
+	if err := db.Model(&key).Update("revoked_at", now).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to revoke API key", nil)
+		return nil, errors.Wrap(err, "failed to revoke API key")
+	}
+
+	return &key, nil
+}
+
+// AuthenticateAPIKey looks up an API key from a request and records that it was used.
+// The last used timestamp is written at most once a minute per key.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - plainKey: The key from the X-API-Key header.
+//
+// Returns:
+//   - *models.APIKey: The key.
+//   - error: ErrInvalidAPIKey if the key is malformed, unknown or revoked.
+func AuthenticateAPIKey(db *gorm.DB, plainKey string) (*models.APIKey, error) {
+	prefixLength := len(apiKeyPrefix) + 2*apiKeyLookupBytes
+	if !strings.HasPrefix(plainKey, apiKeyPrefix) || len(plainKey) <= prefixLength+1 || plainKey[prefixLength] != '_' {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	err := db.Where("prefix = ? AND revoked_at IS NULL", plainKey[:prefixLength]).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get API key")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(security.HashToken(plainKey)), []byte(key.KeyHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyLastUsedInterval {
+		if err := db.Model(&models.APIKey{}).Where("id = ?", key.ID).UpdateColumn("last_used_at", now).Error; err != nil {
+			utils.LogErrorContext(db.Statement.Context, err, "Failed to update API key last used time", nil)
+		}
+		key.LastUsedAt = &now
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// maxAuditUserAgentLength is the length user agents are cut to before they are stored
+const maxAuditUserAgentLength = 255
+
+// AuditActor is who caused an audit event, and from where
+type AuditActor struct {
+	// UserID is nil for anonymous requests and management commands
+	UserID    *uint
+	Name      string
+	IP        string
+	UserAgent string
+}
+
+// CommandAuditActor is the actor of events caused by management commands run on the server
+var CommandAuditActor = AuditActor{Name: "command"}
+
+// AuditFilter selects audit events. Zero values do not filter.
+type AuditFilter struct {
+	Type     string
+	ActorID  *uint
+	TargetID *uint
+	// Username matches events where the actor or the target has this name
+	Username string
+	IP       string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+	Offset   int
+}
+
+// RecordAuditEvent appends an event to the audit log.
+// Failures are logged and returned, but callers usually carry on, as the audited action already happened.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - actor: Who caused the event.
+//   - eventType: One of the models.Audit* event types.
+//   - target: The user the event concerns, or nil. A target without an ID, such as an unknown
+//     username in a failed login, is recorded by name only.
+//   - details: Free text with more information, such as a failure reason.
+//
+// Returns:
+//   - error: An error if the event could not be stored.
+func RecordAuditEvent(db *gorm.DB, actor AuditActor, eventType string, target *models.User, details string) error {
+	userAgent := actor.UserAgent
+	if len(userAgent) > maxAuditUserAgentLength {
+		userAgent = userAgent[:maxAuditUserAgentLength]
+	}
+
+	event := &models.AuditEvent{
+		Type:      eventType,
+		ActorID:   actor.UserID,
+		ActorName: actor.Name,
+		IP:        actor.IP,
+		UserAgent: userAgent,
+		Details:   details,
+	}
+	if target != nil {
+		if target.ID != 0 {
+			targetID := target.ID
+			event.TargetID = &targetID
+		}
+		event.TargetName = target.Username
+	}
+
+	if err := db.Create(event).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to record audit event", logrus.Fields{"message": eventType})
+		return errors.Wrap(err, "failed to record audit event")
+	}
+
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching the filter, newest first.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - filter: Which events to return, and the page.
+//
+// Returns:
+//   - []models.AuditEvent: The events on the requested page.
+//   - int64: The total number of matching events.
+//   - error: An error if the query fails.
+func ListAuditEvents(db *gorm.DB, filter AuditFilter) ([]models.AuditEvent, int64, error) {
+	query := db.Model(&models.AuditEvent{})
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.TargetID != nil {
+		query = query.Where("target_id = ?", *filter.TargetID)
+	}
+	if filter.Username != "" {
+		query = query.Where("actor_name = ? OR target_name = ?", filter.Username, filter.Username)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.Wrap(err, "failed to count audit events")
+	}
+
+	events := []models.AuditEvent{}
+	if err := query.Order("created_at DESC, id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&events).Error; err != nil {
+		return nil, 0, errors.Wrap(err, "failed to list audit events")
+	}
+
+	return events, total, nil
+}
+
+// userAuditEvents returns every audit event where the user is the actor or the target, oldest first
+func userAuditEvents(db *gorm.DB, userID uint) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	err := db.Where("actor_id = ? OR target_id = ?", userID, userID).Order("created_at, id").Find(&events).Error
+	return events, err
+}
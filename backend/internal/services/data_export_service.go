@@ -0,0 +1,354 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDataExportInProgress is returned when the user already has an export that is not finished
+	ErrDataExportInProgress = errors.New("a data export is already in progress")
+	// ErrDataExportNotFound is returned when an export is unknown, belongs to another user, or can no longer be downloaded
+	ErrDataExportNotFound = errors.New("data export not found")
+)
+
+// exportProfile is the profile section of a data export
+type exportProfile struct {
+	ID                 uint       `json:"id"`
+	Username           string     `json:"username"`
+	Email              string     `json:"email"`
+	Role               string     `json:"role"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	MustChangePassword bool       `json:"must_change_password"`
+	LastLogin          time.Time  `json:"last_login"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// exportSearchLog is a search made by the user
+type exportSearchLog struct {
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// exportSession is the metadata of a login session. The token itself is never exported.
+type exportSession struct {
+	ID        uint       `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// exportPasswordReset is the metadata of a password reset request
+type exportPasswordReset struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// exportAPIKey is the metadata of an API key. The key and its hash are never exported.
+type exportAPIKey struct {
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     string     `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// exportLinkedIdentity is an account at an identity provider linked to the user
+type exportLinkedIdentity struct {
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// exportPasskey is the metadata of a passkey. Its public key is not exported.
+type exportPasskey struct {
+	Name       string     `json:"name"`
+	AAGUID     string     `json:"aaguid" gorm:"column:aaguid"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// exportAuditEvent is a security event where the user was the actor or the target
+type exportAuditEvent struct {
+	Type       string    `json:"type"`
+	ActorName  string    `json:"actor"`
+	TargetName string    `json:"target"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// dataExportDocument holds every section of a data export. In a ZIP archive each
+// section is written to its own file, named after its JSON key.
+type dataExportDocument struct {
+	GeneratedAt      time.Time              `json:"generated_at"`
+	Profile          exportProfile          `json:"profile"`
+	SearchLogs       []exportSearchLog      `json:"search_logs"`
+	Sessions         []exportSession        `json:"sessions"`
+	PasswordResets   []exportPasswordReset  `json:"password_resets"`
+	APIKeys          []exportAPIKey         `json:"api_keys"`
+	LinkedIdentities []exportLinkedIdentity `json:"linked_identities"`
+	Passkeys         []exportPasskey        `json:"passkeys"`
+	AuditEvents      []exportAuditEvent     `json:"audit_events"`
+}
+
+// RequestDataExport queues a new data export for the user. The archive is built later by the
+// export worker. A user can only have one export waiting or being built at a time.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user requesting the export.
+//   - format: models.DataExportFormatJSON or models.DataExportFormatZIP.
+//
+// Returns:
+//   - *models.DataExport: The queued export.
+//   - error: ErrDataExportInProgress if an export is already pending, or an error if it could not be stored.
+func RequestDataExport(db *gorm.DB, user *models.User, format string) (*models.DataExport, error) {
+	utils.LogInfoContext(db.Statement.Context, "Requesting data export", nil)
+
+	var count int64
+	if err := db.Model(&models.DataExport{}).
+		Where("user_id = ? AND status IN ?", user.ID, []string{models.DataExportPending, models.DataExportProcessing}).
+		Count(&count).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to check pending data exports")
+	}
+	if count > 0 {
+		return nil, ErrDataExportInProgress
+	}
+
+	export := &models.DataExport{
+		UserID: user.ID,
+		Status: models.DataExportPending,
+		Format: format,
+	}
+	if err := db.Create(export).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to create data export", nil)
+		return nil, errors.Wrap(err, "failed to create data export")
+	}
+
+	return export, nil
+}
+
+// GetDataExport returns an export belonging to the user, without its archive.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The ID of the user the export must belong to.
+//   - exportID: The ID of the export.
+//
+// Returns:
+//   - *models.DataExport: The export.
+//   - error: ErrDataExportNotFound if there is no such export for the user.
+func GetDataExport(db *gorm.DB, userID, exportID uint) (*models.DataExport, error) {
+	var export models.DataExport
+	err := db.Omit("archive").Where("id = ? AND user_id = ?", exportID, userID).First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDataExportNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get data export")
+	}
+
+	return &export, nil
+}
+
+// BuildDataExport collects everything stored about a user into a single archive.
+// The JSON format is one document with a key per section; the ZIP format holds one
+// JSON file per section.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The ID of the user to export.
+//   - format: models.DataExportFormatJSON or models.DataExportFormatZIP.
+//
+// Returns:
+//   - []byte: The archive.
+//   - error: An error if the data could not be read or encoded.
+func BuildDataExport(db *gorm.DB, userID uint, format string) ([]byte, error) {
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	document := dataExportDocument{
+		GeneratedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			ID:                 user.ID,
+			Username:           user.Username,
+			Email:              user.Email,
+			Role:               user.Role,
+			EmailVerifiedAt:    user.VerifiedAt,
+			TwoFactorEnabledAt: user.TOTPEnabledAt,
+			PasswordChangedAt:  user.PasswordChangedAt,
+			MustChangePassword: user.MustChangePassword,
+			LastLogin:          user.LastLogin,
+			CreatedAt:          user.CreatedAt,
+			UpdatedAt:          user.UpdatedAt,
+		},
+		SearchLogs:       []exportSearchLog{},
+		Sessions:         []exportSession{},
+		PasswordResets:   []exportPasswordReset{},
+		APIKeys:          []exportAPIKey{},
+		LinkedIdentities: []exportLinkedIdentity{},
+		Passkeys:         []exportPasskey{},
+		AuditEvents:      []exportAuditEvent{},
+	}
+
+	if err := db.Model(&models.SearchLog{}).Select("query", "created_at").
+		Where("user_id = ?", userID).Order("created_at").Find(&document.SearchLogs).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read search logs")
+	}
+	if err := db.Model(&models.JWT{}).Select("id", "created_at", "expires_at", "revoked_at").
+		Where("user_id = ?", userID).Order("created_at").Find(&document.Sessions).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read sessions")
+	}
+	if err := db.Model(&models.PasswordResetToken{}).Select("created_at", "expires_at", "used_at").
+		Where("user_id = ?", userID).Order("created_at").Find(&document.PasswordResets).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read password resets")
+	}
+	if err := db.Model(&models.APIKey{}).Select("name", "prefix", "scopes", "last_used_at", "revoked_at", "created_at").
+		Where("user_id = ?", userID).Order("created_at").Find(&document.APIKeys).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read API keys")
+	}
+	if err := db.Model(&models.OIDCIdentity{}).Select("provider", "subject", "email", "last_login_at", "created_at").
+		Where("user_id = ?", userID).Order("created_at").Find(&document.LinkedIdentities).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read linked identities")
+	}
+	if err := db.Model(&models.WebAuthnCredential{}).Select("name", "aaguid", "last_used_at", "created_at").
+		Where("user_id = ?", userID).Order("created_at").Find(&document.Passkeys).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read passkeys")
+	}
+
+	events, err := userAuditEvents(db, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read audit events")
+	}
+	for _, event := range events {
+		document.AuditEvents = append(document.AuditEvents, exportAuditEvent{
+			Type:       event.Type,
+			ActorName:  event.ActorName,
+			TargetName: event.TargetName,
+			IP:         event.IP,
+			UserAgent:  event.UserAgent,
+			Details:    event.Details,
+			CreatedAt:  event.CreatedAt,
+		})
+	}
+
+	if format == models.DataExportFormatJSON {
+		return json.MarshalIndent(document, "", "  ")
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, file := range []struct {
+		name    string
+		section interface{}
+	}{
+		{"profile.json", document.Profile},
+		{"search_logs.json", document.SearchLogs},
+		{"sessions.json", document.Sessions},
+		{"password_resets.json", document.PasswordResets},
+		{"api_keys.json", document.APIKeys},
+		{"linked_identities.json", document.LinkedIdentities},
+		{"passkeys.json", document.Passkeys},
+		{"audit_events.json", document.AuditEvents},
+	} {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: document.GeneratedAt})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add file to archive")
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.section); err != nil {
+			return nil, errors.Wrap(err, "failed to encode "+file.name)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close archive")
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// ConsumeDataExport returns a ready export for download and deletes its archive, so every
+// export can only be downloaded once. The export is consumed with a conditional update,
+// so two concurrent downloads of the same link cannot both succeed.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - exportID: The ID of the export from the download link.
+//
+// Returns:
+//   - *models.DataExport: The export, including its archive.
+//   - error: ErrDataExportNotFound if the export is unknown, not ready, expired or already downloaded.
+func ConsumeDataExport(db *gorm.DB, exportID uint) (*models.DataExport, error) {
+	utils.LogInfoContext(db.Statement.Context, "Consuming data export", nil)
+
+	var export models.DataExport
+	err := db.Where("id = ? AND status = ? AND expires_at > ?", exportID, models.DataExportReady, time.Now()).First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDataExportNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get data export")
+	}
+
+	now := time.Now()
+	result := db.Model(&models.DataExport{}).
+		Where("id = ? AND status = ?", export.ID, models.DataExportReady).
+		Updates(map[string]interface{}{
+			"status":        models.DataExportDownloaded,
+			"downloaded_at": now,
+			"archive":       nil,
+		})
+	if result.Error != nil {
+		utils.LogErrorContext(db.Statement.Context, result.Error, "Failed to mark data export as downloaded", nil)
+		return nil, errors.Wrap(result.Error, "failed to consume data export")
+	}
+	if result.RowsAffected == 0 {
+		utils.LogWarnContext(db.Statement.Context, "Data export was downloaded concurrently", nil)
+		return nil, ErrDataExportNotFound
+	}
+
+	return &export, nil
+}
+
+// DataExportDownloadLink builds the signed, unauthenticated download link of a ready export.
+// The link is valid until the export expires.
+//
+// Parameters:
+//   - cfg: The JWT configuration, whose secret signs the link.
+//   - baseURL: The public base URL the API is reachable under.
+//   - export: The ready export.
+//
+// Returns:
+//   - string: The download link.
+func DataExportDownloadLink(cfg config.JWTConfig, baseURL string, export *models.DataExport) string {
+	expires := time.Now()
+	if export.ExpiresAt != nil {
+		expires = *export.ExpiresAt
+	}
+
+	return fmt.Sprintf("%s/api/exports/%d/download?expires=%d&signature=%s",
+		strings.TrimRight(baseURL, "/"), export.ID, expires.Unix(), security.SignDataExportLink(cfg, export.ID, expires))
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/oidc"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidOIDCState is returned when the state of a callback is unknown, expired, already
+	// used, or was issued for another provider or user
+	ErrInvalidOIDCState = errors.New("invalid or expired OIDC state")
+	// ErrOIDCEmailMissing is returned when a new user logs in and the provider did not share an email address
+	ErrOIDCEmailMissing = errors.New("identity provider did not return an email address")
+	// ErrOIDCEmailTaken is returned when a new user logs in with the email address of an existing
+	// account. Accounts are never linked by email address; the owner must log in and link the provider.
+	ErrOIDCEmailTaken = errors.New("an account with this email address already exists")
+	// ErrOIDCIdentityTaken is returned when the provider account is already linked to another user
+	ErrOIDCIdentityTaken = errors.New("this account is already linked to another user")
+	// ErrOIDCProviderLinked is returned when the user already linked another account at the provider
+	ErrOIDCProviderLinked = errors.New("another account at this provider is already linked")
+	// ErrOIDCIdentityNotFound is returned when the user has not linked the provider
+	ErrOIDCIdentityNotFound = errors.New("provider is not linked")
+	// ErrOIDCLastLoginMethod is returned when unlinking would leave the user without a way to log in
+	ErrOIDCLastLoginMethod = errors.New("cannot unlink the only way to log in")
+)
+
+// usernameDisallowedChars matches the characters that are dropped from suggested usernames
+var usernameDisallowedChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// StartOIDCFlow stores a new state for a login or link at the provider and returns the URL to
+// send the user to. The state, nonce and PKCE code verifier are random and used only once.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - provider: The identity provider.
+//   - userID: The user linking the provider, or nil for a login.
+//   - ttl: How long the user has to complete the flow at the provider.
+//
+// Returns:
+//   - string: The authorization URL.
+//   - error: An error if the state could not be stored or the provider can not be reached.
+func StartOIDCFlow(ctx context.Context, db *gorm.DB, provider *oidc.Provider, userID *uint, ttl time.Duration) (string, error) {
+	utils.LogInfoContext(ctx, "Starting OIDC flow", nil)
+
+	state, err := security.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := security.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := security.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthorizationURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to build authorization URL")
+	}
+
+	record := models.OIDCState{
+		StateHash:    security.HashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", errors.Wrap(err, "failed to store OIDC state")
+	}
+
+	return authURL, nil
+}
+
+// FinishOIDCFlow consumes the state of a callback, exchanges the authorization code and verifies
+// the ID token. The state is deleted before the code is exchanged, so it can only be used once,
+// also by concurrent requests on different replicas.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - provider: The identity provider.
+//   - state: The state from the callback.
+//   - code: The authorization code from the callback.
+//   - userID: The user linking the provider, or nil for a login. It must match the user the flow was started for.
+//
+// Returns:
+//   - *oidc.Claims: The verified claims of the ID token.
+//   - error: ErrInvalidOIDCState, or an error if the code exchange or the ID token verification fails.
+func FinishOIDCFlow(ctx context.Context, db *gorm.DB, provider *oidc.Provider, state, code string, userID *uint) (*oidc.Claims, error) {
+	utils.LogInfoContext(ctx, "Finishing OIDC flow", nil)
+
+	var record models.OIDCState
+	err := db.Where("state_hash = ?", security.HashToken(state)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidOIDCState
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get OIDC state")
+	}
+
+	result := db.Where("id = ?", record.ID).Delete(&models.OIDCState{})
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "failed to consume OIDC state")
+	}
+	if result.RowsAffected != 1 || time.Now().After(record.ExpiresAt) || record.Provider != provider.Name {
+		return nil, ErrInvalidOIDCState
+	}
+	if (record.UserID == nil) != (userID == nil) || (userID != nil && *record.UserID != *userID) {
+		utils.LogWarnContext(ctx, "OIDC state was issued for another user", nil)
+		return nil, ErrInvalidOIDCState
+	}
+
+	tokens, err := provider.Exchange(ctx, code, record.CodeVerifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to exchange authorization code")
+	}
+
+	return provider.VerifyIDToken(ctx, tokens.IDToken, record.Nonce)
+}
+
+// OIDCLoginUser returns the user an identity provider account is linked to. An unknown account
+// gets a new user, without a password, unless its email address belongs to an existing user.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - providerName: The name of the identity provider.
+//   - claims: The verified claims of the ID token.
+//
+// Returns:
+//   - *models.User: The user.
+//   - bool: Whether the user was created.
+//   - error: ErrOIDCEmailMissing or ErrOIDCEmailTaken for an unknown account that can not get a new user.
+func OIDCLoginUser(db *gorm.DB, providerName string, claims *oidc.Claims) (*models.User, bool, error) {
+	now := time.Now()
+
+	var identity models.OIDCIdentity
+	err := db.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+	if err == nil {
+		updates := map[string]interface{}{"last_login_at": now}
+		if claims.Email != "" {
+			updates["email"] = claims.Email
+		}
+		if err := db.Model(&identity).Updates(updates).Error; err != nil {
+			utils.LogErrorContext(db.Statement.Context, err, "Failed to update linked identity", nil)
+		}
+		user, err := GetUserByID(db, identity.UserID)
+		return user, false, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, errors.Wrap(err, "failed to get linked identity")
+	}
+
+	if claims.Email == "" {
+		return nil, false, ErrOIDCEmailMissing
+	}
+	var count int64
+	if err := db.Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(claims.Email)).Count(&count).Error; err != nil {
+		return nil, false, errors.Wrap(err, "failed to check email address")
+	}
+	if count > 0 {
+		return nil, false, ErrOIDCEmailTaken
+	}
+
+	username, err := availableUsername(db, claims)
+	if err != nil {
+		return nil, false, err
+	}
+
+	user := &models.User{Username: username, Email: claims.Email, LastLogin: now}
+	if claims.EmailVerified {
+		user.VerifiedAt = &now
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := CreateUser(tx, user); err != nil {
+			return err
+		}
+		return tx.Create(&models.OIDCIdentity{
+			UserID:      user.ID,
+			Provider:    providerName,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to create user for linked identity")
+	}
+
+	utils.LogInfoContext(db.Statement.Context, "Created user for identity provider account", nil)
+	return user, true, nil
+}
+
+// LinkOIDCIdentity links an identity provider account to an existing user.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user.
+//   - providerName: The name of the identity provider.
+//   - claims: The verified claims of the ID token.
+//
+// Returns:
+//   - *models.OIDCIdentity: The linked identity.
+//   - error: ErrOIDCIdentityTaken or ErrOIDCProviderLinked if the account or the provider is already linked.
+func LinkOIDCIdentity(db *gorm.DB, user *models.User, providerName string, claims *oidc.Claims) (*models.OIDCIdentity, error) {
+	utils.LogInfoContext(db.Statement.Context, "Linking identity provider account", nil)
+
+	var existing models.OIDCIdentity
+	err := db.Where("provider = ? AND (subject = ? OR user_id = ?)", providerName, claims.Subject, user.ID).First(&existing).Error
+	if err == nil {
+		switch {
+		case existing.UserID != user.ID:
+			return nil, ErrOIDCIdentityTaken
+		case existing.Subject != claims.Subject:
+			return nil, ErrOIDCProviderLinked
+		default:
+			return &existing, nil
+		}
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Wrap(err, "failed to get linked identity")
+	}
+
+	identity := &models.OIDCIdentity{
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := db.Create(identity).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to link identity")
+	}
+
+	return identity, nil
+}
+
+// ListOIDCIdentities returns the identity provider accounts linked to a user.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The user.
+//
+// Returns:
+//   - []models.OIDCIdentity: The linked identities.
+//   - error: An error if the query fails.
+func ListOIDCIdentities(db *gorm.DB, userID uint) ([]models.OIDCIdentity, error) {
+	identities := []models.OIDCIdentity{}
+	if err := db.Where("user_id = ?", userID).Order("provider").Find(&identities).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to list linked identities")
+	}
+	return identities, nil
+}
+
+// UnlinkOIDCIdentity removes the link between a user and their account at an identity provider.
+// A user without a password or passkey must keep at least one linked account, or they could no longer log in.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user.
+//   - providerName: The name of the identity provider.
+//
+// Returns:
+//   - error: ErrOIDCIdentityNotFound, ErrOIDCLastLoginMethod, or an error if the delete fails.
+func UnlinkOIDCIdentity(db *gorm.DB, user *models.User, providerName string) error {
+	utils.LogInfoContext(db.Statement.Context, "Unlinking identity provider account", nil)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		identities, err := ListOIDCIdentities(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		var identity *models.OIDCIdentity
+		for i := range identities {
+			if identities[i].Provider == providerName {
+				identity = &identities[i]
+			}
+		}
+		if identity == nil {
+			return ErrOIDCIdentityNotFound
+		}
+		if user.PasswordHash == "" && len(identities) == 1 {
+			hasPasskeys, err := HasPasskeys(tx, user.ID)
+			if err != nil {
+				return err
+			}
+			if !hasPasskeys {
+				return ErrOIDCLastLoginMethod
+			}
+		}
+
+		return tx.Delete(identity).Error
+	})
+}
+
+// PurgeExpiredOIDCStates deletes the states of logins that were never completed.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//
+// Returns:
+//   - int64: The number of rows deleted.
+//   - error: An error if the delete fails.
+func PurgeExpiredOIDCStates(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at < ?", time.Now()).Delete(&models.OIDCState{})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "failed to purge expired OIDC states")
+	}
+	return result.RowsAffected, nil
+}
+
+// availableUsername picks an unused username for a new user from the preferred username or
+// email address the provider shared, adding a number if the name is taken
+func availableUsername(db *gorm.DB, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	base = usernameDisallowedChars.ReplaceAllString(base, "")
+	if len(base) > 90 {
+		base = base[:90]
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		var count int64
+		if err := db.Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", errors.Wrap(err, "failed to check username")
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+
+	suffix, err := security.GenerateRandomToken(4)
+	if err != nil {
+		return "", err
+	}
+	return base + "-" + suffix, nil
+}
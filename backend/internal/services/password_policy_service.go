@@ -0,0 +1,105 @@
+package services
+
+import (
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// CheckPasswordPolicy validates a new password for a user against the password policy.
+// For existing users the password must also differ from the current password and the
+// previous passwords kept in the history.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - policy: The password policy.
+//   - user: The user the password is for. For a user that is not created yet, only Username and Email are used.
+//   - password: The new plain text password.
+//
+// Returns:
+//   - []security.PasswordViolation: The violated rules, or an empty slice if the password is acceptable.
+//   - error: An error if the password history could not be loaded.
+func CheckPasswordPolicy(db *gorm.DB, policy *security.PasswordPolicy, user *models.User, password string) ([]security.PasswordViolation, error) {
+	violations := policy.Check(db.Statement.Context, password, user.Username, user.Email)
+
+	if user.ID == 0 || policy.HistorySize <= 0 {
+		return violations, nil
+	}
+
+	hashes := []string{user.PasswordHash}
+	if policy.HistorySize > 1 {
+		var history []models.PasswordHistory
+		err := db.Where("user_id = ?", user.ID).
+			Order("created_at DESC, id DESC").
+			Limit(policy.HistorySize - 1).
+			Find(&history).Error
+		if err != nil {
+			utils.LogErrorContext(db.Statement.Context, err, "Failed to load password history", nil)
+			return nil, errors.Wrap(err, "failed to load password history")
+		}
+		for _, entry := range history {
+			hashes = append(hashes, entry.PasswordHash)
+		}
+	}
+
+	for _, hash := range hashes {
+		if hash != "" && security.CheckPasswordHash(db.Statement.Context, password, hash) {
+			violations = append(violations, security.PasswordViolation{
+				Rule:    "reused",
+				Message: "Password must not be one of your recently used passwords",
+			})
+			break
+		}
+	}
+
+	return violations, nil
+}
+
+// RecordPasswordHistory stores the current password hash of the user before it is replaced,
+// and removes history entries that are older than the policy needs.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - policy: The password policy, whose HistorySize is the number of passwords kept.
+//   - user: The user whose current password is about to change.
+//
+// Returns:
+//   - error: An error if the history could not be updated.
+func RecordPasswordHistory(db *gorm.DB, policy *security.PasswordPolicy, user *models.User) error {
+	keep := policy.HistorySize - 1
+	if user.PasswordHash == "" {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if keep > 0 {
+			entry := models.PasswordHistory{UserID: user.ID, PasswordHash: user.PasswordHash}
+			if err := tx.Create(&entry).Error; err != nil {
+				return errors.Wrap(err, "failed to store password history")
+			}
+		}
+
+		var keepIDs []uint
+		if keep > 0 {
+			err := tx.Model(&models.PasswordHistory{}).
+				Where("user_id = ?", user.ID).
+				Order("created_at DESC, id DESC").
+				Limit(keep).
+				Pluck("id", &keepIDs).Error
+			if err != nil {
+				return errors.Wrap(err, "failed to load password history")
+			}
+		}
+
+		prune := tx.Where("user_id = ?", user.ID)
+		if len(keepIDs) > 0 {
+			prune = prune.Where("id NOT IN ?", keepIDs)
+		}
+		if err := prune.Delete(&models.PasswordHistory{}).Error; err != nil {
+			return errors.Wrap(err, "failed to prune password history")
+		}
+		return nil
+	})
+}
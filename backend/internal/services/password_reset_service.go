@@ -0,0 +1,149 @@
+package services
+
+import (
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// passwordResetTokenBytes is the amount of randomness in a password reset token
+const passwordResetTokenBytes = 32
+
+// ErrInvalidResetToken is returned when a reset token is unknown, expired or already used
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// CreatePasswordResetToken creates a new password reset token for the given user.
+// Any earlier unused tokens for the user are invalidated, so only the most recent
+// email can be used. Only the hash of the token is stored.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user requesting the reset.
+//   - ttl: How long the token stays valid.
+//
+// Returns:
+//   - string: The plain token to send to the user.
+//   - error: An error if the token could not be generated or stored.
+func CreatePasswordResetToken(db *gorm.DB, user *models.User, ttl time.Duration) (string, error) {
+	utils.LogInfoContext(db.Statement.Context, "Creating password reset token", nil)
+
+	token, err := security.GenerateRandomToken(passwordResetTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: security.HashToken(token),
+			ExpiresAt: now.Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to store password reset token", nil)
+		return "", errors.Wrap(err, "failed to create password reset token")
+	}
+
+	return token, nil
+}
+
+// ConsumePasswordResetToken marks a password reset token as used and returns the user it belongs to.
+// The token is consumed with a conditional update, so two concurrent requests with the same token
+// cannot both succeed.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - token: The plain token received from the user.
+//
+// Returns:
+//   - *models.User: The user the token was issued to.
+//   - error: ErrInvalidResetToken if the token is unknown, expired or already used.
+func ConsumePasswordResetToken(db *gorm.DB, token string) (*models.User, error) {
+	utils.LogInfoContext(db.Statement.Context, "Consuming password reset token", nil)
+
+	resetToken, err := findValidPasswordResetToken(db, token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := db.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", resetToken.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		utils.LogErrorContext(db.Statement.Context, result.Error, "Failed to mark password reset token as used", nil)
+		return nil, errors.Wrap(result.Error, "failed to consume password reset token")
+	}
+	if result.RowsAffected == 0 {
+		utils.LogWarnContext(db.Statement.Context, "Password reset token was consumed concurrently", nil)
+		return nil, ErrInvalidResetToken
+	}
+
+	return GetUserByID(db, resetToken.UserID)
+}
+
+// GetPasswordResetTokenUser returns the user a valid password reset token belongs to, without
+// consuming the token. It lets the new password be validated before the token is used up.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - token: The plain token received from the user.
+//
+// Returns:
+//   - *models.User: The user the token was issued to.
+//   - error: ErrInvalidResetToken if the token is unknown, expired or already used.
+func GetPasswordResetTokenUser(db *gorm.DB, token string) (*models.User, error) {
+	resetToken, err := findValidPasswordResetToken(db, token)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByID(db, resetToken.UserID)
+}
+
+// findValidPasswordResetToken looks up an unused and unexpired password reset token
+func findValidPasswordResetToken(db *gorm.DB, token string) (*models.PasswordResetToken, error) {
+	var resetToken models.PasswordResetToken
+	if err := db.Where("token_hash = ?", security.HashToken(token)).First(&resetToken).Error; err != nil {
+		utils.LogWarnContext(db.Statement.Context, "Password reset token not found", nil)
+		return nil, ErrInvalidResetToken
+	}
+
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		utils.LogWarnContext(db.Statement.Context, "Password reset token expired or already used", nil)
+		return nil, ErrInvalidResetToken
+	}
+
+	return &resetToken, nil
+}
+
+// PurgePasswordResetTokens deletes the reset tokens that have been used, or that expired more
+// than gracePeriod ago.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - gracePeriod: How long expired tokens are kept before they are purged.
+//
+// Returns:
+//   - int64: The number of rows deleted.
+//   - error: An error if the delete fails.
+func PurgePasswordResetTokens(db *gorm.DB, gracePeriod time.Duration) (int64, error) {
+	result := db.
+		Where("used_at IS NOT NULL").
+		Or("expires_at < ?", time.Now().Add(-gracePeriod)).
+		Delete(&models.PasswordResetToken{})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "failed to purge password reset tokens")
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUsernameTaken is returned when another user already has the requested username
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrEmailTaken is returned when another user already has the requested email address
+	ErrEmailTaken = errors.New("email is already in use")
+)
+
+// UpdateProfile changes the username and/or email of a user. Empty values are left unchanged.
+// A new email address is not verified yet, so VerifiedAt is cleared and the caller should send
+// a new verification email.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user to update.
+//   - username: The new username, or an empty string to keep the current one.
+//   - email: The new email address, or an empty string to keep the current one.
+//
+// Returns:
+//   - bool: True if the email address changed.
+//   - error: ErrUsernameTaken or ErrEmailTaken if the value belongs to another user, or an error if the update fails.
+func UpdateProfile(db *gorm.DB, user *models.User, username, email string) (bool, error) {
+	utils.LogInfoContext(db.Statement.Context, "Updating user profile", nil)
+
+	emailChanged := email != "" && !strings.EqualFold(email, user.Email)
+
+	if username != "" && username != user.Username {
+		var count int64
+		if err := db.Model(&models.User{}).Where("username = ? AND id <> ?", username, user.ID).Count(&count).Error; err != nil {
+			return false, errors.Wrap(err, "failed to check username")
+		}
+		if count > 0 {
+			return false, ErrUsernameTaken
+		}
+		user.Username = username
+	}
+
+	if emailChanged {
+		var count int64
+		if err := db.Model(&models.User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+			return false, errors.Wrap(err, "failed to check email")
+		}
+		if count > 0 {
+			return false, ErrEmailTaken
+		}
+		user.Email = email
+		user.VerifiedAt = nil
+	}
+
+	if err := UpdateUser(db, user); err != nil {
+		return false, err
+	}
+
+	return emailChanged, nil
+}
+
+// DeleteUser permanently deletes a user together with everything linked to them: sessions,
+// password reset tokens, recovery codes, password history, search history, data exports, API keys,
+// linked identity provider accounts and passkeys.
+// Audit events are kept, as the audit log is append-only.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user to delete.
+//
+// Returns:
+//   - error: An error if any of the deletes fails, in which case nothing is deleted.
+func DeleteUser(db *gorm.DB, user *models.User) error {
+	utils.LogInfoContext(db.Statement.Context, "Deleting user", nil)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.JWT{},
+			&models.PasswordResetToken{},
+			&models.RecoveryCode{},
+			&models.PasswordHistory{},
+			&models.SearchLog{},
+			&models.DataExport{},
+			&models.APIKey{},
+			&models.OIDCIdentity{},
+			&models.OIDCState{},
+			&models.WebAuthnCredential{},
+			&models.WebAuthnChallenge{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&models.User{}, user.ID).Error
+	})
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to delete user", nil)
+		return errors.Wrap(err, "failed to delete user")
+	}
+
+	return nil
+}
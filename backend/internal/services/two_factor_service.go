@@ -0,0 +1,244 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// totpIssuer is the service name shown in authenticator apps
+	totpIssuer = "WhoKnows"
+	// recoveryCodeCount is the number of recovery codes issued when 2FA is enabled
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled      = errors.New("two-factor authentication enrollment has not been started")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrInvalidSecondFactor  = errors.New("invalid two-factor authentication code")
+	recoveryCodeEncoding    = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeReplacement = strings.NewReplacer("-", "", " ", "")
+)
+
+// TOTPEnabled reports whether the user has completed two-factor enrollment
+func TOTPEnabled(user *models.User) bool {
+	return user.TOTPEnabledAt != nil
+}
+
+// BeginTOTPEnrollment generates a new TOTP secret for the user and stores it, without enabling
+// two-factor authentication yet. Starting over replaces any secret from an unfinished enrollment.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user enrolling.
+//
+// Returns:
+//   - string: The base32 encoded secret.
+//   - string: The otpauth:// provisioning URI for authenticator apps.
+//   - error: ErrTOTPAlreadyEnabled, or an error if the secret could not be stored.
+func BeginTOTPEnrollment(db *gorm.DB, user *models.User) (string, string, error) {
+	utils.LogInfoContext(db.Statement.Context, "Starting TOTP enrollment", nil)
+
+	if TOTPEnabled(user) {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := security.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := db.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to store TOTP secret", nil)
+		return "", "", errors.Wrap(err, "failed to store TOTP secret")
+	}
+
+	return secret, security.TOTPProvisioningURI(secret, user.Username, totpIssuer), nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the user proves, with a first
+// code, that their authenticator app is set up. It returns a fresh set of recovery codes;
+// these are only ever shown once.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user enrolling.
+//   - code: The first TOTP code from the authenticator app.
+//
+// Returns:
+//   - []string: The plain recovery codes.
+//   - error: ErrTOTPAlreadyEnabled, ErrTOTPNotEnrolled, ErrInvalidSecondFactor, or a database error.
+func ConfirmTOTPEnrollment(db *gorm.DB, user *models.User, code string) ([]string, error) {
+	utils.LogInfoContext(db.Statement.Context, "Confirming TOTP enrollment", nil)
+
+	if TOTPEnabled(user) {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok := security.ValidateTOTPCode(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		utils.LogWarnContext(db.Statement.Context, "Invalid TOTP code during enrollment", nil)
+		return nil, ErrInvalidSecondFactor
+	}
+
+	var recoveryCodes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled_at": now,
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		recoveryCodes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to enable two-factor authentication", nil)
+		return nil, errors.Wrap(err, "failed to enable two-factor authentication")
+	}
+
+	utils.LogInfoContext(db.Statement.Context, "Two-factor authentication enabled", nil)
+	return recoveryCodes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or, if code is empty, a recovery code for the user.
+// Accepted TOTP codes and recovery codes are recorded so they can not be used again.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user logging in.
+//   - code: A TOTP code from the authenticator app, may be empty.
+//   - recoveryCode: A recovery code, used when code is empty.
+//
+// Returns:
+//   - error: ErrTOTPNotEnabled, ErrInvalidSecondFactor, or a database error.
+func VerifySecondFactor(db *gorm.DB, user *models.User, code, recoveryCode string) error {
+	utils.LogInfoContext(db.Statement.Context, "Verifying second factor", nil)
+
+	if !TOTPEnabled(user) {
+		return ErrTOTPNotEnabled
+	}
+
+	if code != "" {
+		step, ok := security.ValidateTOTPCode(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			utils.LogWarnContext(db.Statement.Context, "Invalid TOTP code", nil)
+			return ErrInvalidSecondFactor
+		}
+
+		// Only move forward, so the same code can not be accepted twice by concurrent requests
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "failed to record TOTP code")
+		}
+		if result.RowsAffected == 0 {
+			utils.LogWarnContext(db.Statement.Context, "TOTP code replayed", nil)
+			return ErrInvalidSecondFactor
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+
+	if recoveryCode == "" {
+		return ErrInvalidSecondFactor
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(recoveryCode)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to consume recovery code")
+	}
+	if result.RowsAffected == 0 {
+		utils.LogWarnContext(db.Statement.Context, "Invalid recovery code", nil)
+		return ErrInvalidSecondFactor
+	}
+
+	utils.LogInfoContext(db.Statement.Context, "Recovery code used", nil)
+	return nil
+}
+
+// DisableTOTP turns off two-factor authentication for the user and deletes their recovery codes.
+// Callers must have re-authenticated the user before calling it.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user disabling two-factor authentication.
+//
+// Returns:
+//   - error: ErrTOTPNotEnabled, or a database error.
+func DisableTOTP(db *gorm.DB, user *models.User) error {
+	utils.LogInfoContext(db.Statement.Context, "Disabling two-factor authentication", nil)
+
+	if !TOTPEnabled(user) {
+		return ErrTOTPNotEnabled
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to disable two-factor authentication", nil)
+		return errors.Wrap(err, "failed to disable two-factor authentication")
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and generates a new set
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode normalizes a recovery code, so dashes, spaces and case do not matter, and hashes it
+func hashRecoveryCode(code string) string {
+	return security.HashToken(strings.ToLower(recoveryCodeReplacement.Replace(code)))
+}
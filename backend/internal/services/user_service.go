@@ -21,14 +21,14 @@ import (
 // Returns:
 //   - error: An error if the user creation fails, otherwise nil.
 func CreateUser(db *gorm.DB, user *models.User) error {
-	utils.LogInfo("Creating new user", utils.SanitizeFields(map[string]interface{}{
+	utils.LogInfoContext(db.Statement.Context, "Creating new user", utils.SanitizeFields(map[string]interface{}{
 		"username": user.Username,
 		"email":    user.Email,
 	}))
 
 	err := db.Create(user).Error
 	if err != nil {
-		utils.LogError(err, "Failed to create user", utils.SanitizeFields(map[string]interface{}{
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to create user", utils.SanitizeFields(map[string]interface{}{
 			"username": user.Username,
 			"email":    user.Email,
 		}))
@@ -49,13 +49,13 @@ func CreateUser(db *gorm.DB, user *models.User) error {
 // Returns:
 //   - error: An error if the update operation fails, otherwise nil.
 func UpdateUser(db *gorm.DB, user *models.User) error {
-	utils.LogInfo("Updating user", map[string]interface{}{
+	utils.LogInfoContext(db.Statement.Context, "Updating user", map[string]interface{}{
 		"id": user.ID,
 	})
 
 	err := db.Save(user).Error
 	if err != nil {
-		utils.LogError(err, "Failed to update user", map[string]interface{}{
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to update user", map[string]interface{}{
 			"id": user.ID,
 		})
 		return errors.Wrap(err, "failed to update user")
@@ -76,14 +76,14 @@ func UpdateUser(db *gorm.DB, user *models.User) error {
 //   - A pointer to the retrieved models.User instance if found.
 //   - An error if the user is not found or if there is an issue with the database query.
 func GetUserByUsername(db *gorm.DB, username string) (*models.User, error) {
-	utils.LogInfo("Retrieving user by username", map[string]interface{}{
+	utils.LogInfoContext(db.Statement.Context, "Retrieving user by username", map[string]interface{}{
 		"username": username,
 	})
 
 	user := &models.User{}
 	err := db.Where("username = ?", username).First(user).Error
 	if err != nil {
-		utils.LogError(err, "Failed to retrieve user by username", map[string]interface{}{
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to retrieve user by username", map[string]interface{}{
 			"username": username,
 		})
 		return nil, errors.New("user not found")
@@ -93,6 +93,30 @@ func GetUserByUsername(db *gorm.DB, username string) (*models.User, error) {
 }
 
 
+// GetUserByEmail retrieves a user from the database by their email address.
+// It logs the process of retrieving the user and any errors that occur.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - email: The email address of the user to retrieve.
+//
+// Returns:
+//   - A pointer to the retrieved models.User instance if found.
+//   - An error if the user is not found or if there is an issue with the database query.
+func GetUserByEmail(db *gorm.DB, email string) (*models.User, error) {
+	utils.LogInfoContext(db.Statement.Context, "Retrieving user by email", nil)
+
+	user := &models.User{}
+	err := db.Where("email = ?", email).First(user).Error
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to retrieve user by email", nil)
+		return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+
 // GetUserByID retrieves a user from the database by their ID.
 // It logs the process of retrieving the user and any errors that occur.
 //
@@ -104,14 +128,14 @@ func GetUserByUsername(db *gorm.DB, username string) (*models.User, error) {
 //   - A pointer to the retrieved models.User instance, or nil if an error occurred.
 //   - An error if the user could not be found or if there was an issue with the database query.
 func GetUserByID(db *gorm.DB, userID uint) (*models.User, error) {
-	utils.LogInfo("Retrieving user by ID", map[string]interface{}{
+	utils.LogInfoContext(db.Statement.Context, "Retrieving user by ID", map[string]interface{}{
 		"userID": userID,
 	})
 
 	user := &models.User{}
 	err := db.First(user, userID).Error
 	if err != nil {
-		utils.LogError(err, "Failed to retrieve user by ID", map[string]interface{}{
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to retrieve user by ID", map[string]interface{}{
 			"userID": userID,
 		})
 		return nil, errors.New("user not found")
@@ -131,14 +155,14 @@ func GetUserByID(db *gorm.DB, userID uint) (*models.User, error) {
 // Returns:
 //   - error: An error object if the update operation fails, otherwise nil.
 func UpdateLastLogin(db *gorm.DB, user *models.User) error {
-	utils.LogInfo("Updating last login for user", map[string]interface{}{
+	utils.LogInfoContext(db.Statement.Context, "Updating last login for user", map[string]interface{}{
 		"id": user.ID,
 	})
 
 	user.LastLogin = time.Now()
 	err := db.Save(user).Error
 	if err != nil {
-		utils.LogError(err, "Failed to update last login", map[string]interface{}{
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to update last login", map[string]interface{}{
 			"id": user.ID,
 		})
 		return errors.Wrap(err, "failed to update last login")
@@ -148,6 +172,38 @@ func UpdateLastLogin(db *gorm.DB, user *models.User) error {
 }
 
 
+// MarkEmailVerified records that the user has verified their email address.
+// Verifying an already verified user keeps the original timestamp.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: A pointer to the models.User instance to mark as verified.
+//
+// Returns:
+//   - error: An error object if the update operation fails, otherwise nil.
+func MarkEmailVerified(db *gorm.DB, user *models.User) error {
+	utils.LogInfoContext(db.Statement.Context, "Marking user email as verified", map[string]interface{}{
+		"id": user.ID,
+	})
+
+	if user.VerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	err := db.Model(user).Update("verified_at", now).Error
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to mark email as verified", map[string]interface{}{
+			"id": user.ID,
+		})
+		return errors.Wrap(err, "failed to mark email as verified")
+	}
+
+	user.VerifiedAt = &now
+	return nil
+}
+
+
 // CheckUserPassword validates the provided username and password against the stored credentials in the database.
 // It logs the validation process and returns the user object if the credentials are valid.
 //
@@ -161,24 +217,183 @@ func UpdateLastLogin(db *gorm.DB, user *models.User) error {
 //   - bool: A boolean indicating whether the credentials are valid.
 //   - error: An error object if the credentials are invalid or if there is an issue during the process.
 func CheckUserPassword(db *gorm.DB, password, username string) (*models.User, bool, error) {
-	utils.LogInfo("Validating user credentials", map[string]interface{}{
+	utils.LogInfoContext(db.Statement.Context, "Validating user credentials", map[string]interface{}{
 		"username": username,
 	})
 
 	user, err := GetUserByUsername(db, username)
 	if err != nil {
-		utils.LogWarn("Invalid username", map[string]interface{}{
+		utils.LogWarnContext(db.Statement.Context, "Invalid username", map[string]interface{}{
 			"username": username,
 		})
 		return nil, false, errors.New("invalid username")
 	}
 
-	if !security.CheckPasswordHash(password, user.PasswordHash) {
-		utils.LogWarn("Invalid password", map[string]interface{}{
+	if !security.CheckPasswordHash(db.Statement.Context, password, user.PasswordHash) {
+		utils.LogWarnContext(db.Statement.Context, "Invalid password", map[string]interface{}{
 			"username": username,
 		})
 		return user, false, errors.New("invalid password")
 	}
 
+	// Upgrade hashes made with an older algorithm or weaker parameters while the password is known
+	if security.NeedsRehash(user.PasswordHash) {
+		rehashPassword(db, user, password)
+	}
+
 	return user, true, nil
 }
+
+// SetUserPassword is the single code path for changing the password of an existing user.
+// It hashes the password with the configured algorithm, records the previous hash in the
+// password history, clears a forced password change and saves the user.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - policy: The password policy, whose history size the password history is kept at.
+//   - user: The user whose password is changed.
+//   - password: The new plain text password, already checked against the password policy.
+//
+// Returns:
+//   - error: An error if hashing or saving fails.
+func SetUserPassword(db *gorm.DB, policy *security.PasswordPolicy, user *models.User, password string) error {
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := RecordPasswordHistory(db, policy, user); err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to record password history", nil)
+		return err
+	}
+
+	now := time.Now()
+	user.PasswordHash = hash
+	user.MustChangePassword = false
+	user.PasswordChangedAt = &now
+	user.UpdatedAt = now
+	return UpdateUser(db, user)
+}
+
+// rehashPassword replaces the stored hash of a password that was just verified. Only the hash
+// column is written, so the rest of the user, including UpdatedAt, is left as it was.
+// A failure is logged and otherwise ignored, as the old hash still works.
+func rehashPassword(db *gorm.DB, user *models.User, password string) {
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to rehash password", nil)
+		return
+	}
+
+	if err := db.Model(user).UpdateColumn("password_hash", hash).Error; err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to store rehashed password", nil)
+		return
+	}
+
+	user.PasswordHash = hash
+	utils.LogInfoContext(db.Statement.Context, "Password hash upgraded", nil)
+}
+
+// ForcePasswordChange flags users so they must change their password at their next login, and
+// revokes their sessions so the flag takes effect right away. Users are selected by username,
+// or, when notChangedSince is set, as all users that have not changed their password since then.
+//
+// Every flagged user gets a password.force_change and a token.revoke audit event.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - actor: Who forces the change, for the audit log.
+//   - usernames: The users to flag.
+//   - notChangedSince: If not nil, flag all users whose password was last changed before this time instead.
+//
+// Returns:
+//   - int64: The number of users flagged.
+//   - error: An error if the users could not be updated.
+func ForcePasswordChange(db *gorm.DB, actor AuditActor, usernames []string, notChangedSince *time.Time) (int64, error) {
+	utils.LogInfoContext(db.Statement.Context, "Forcing password change", nil)
+
+	var flagged int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.User{})
+		if notChangedSince != nil {
+			query = query.Where("password_changed_at IS NULL OR password_changed_at < ?", *notChangedSince)
+		} else {
+			query = query.Where("username IN ?", usernames)
+		}
+
+		var users []models.User
+		if err := query.Select("id", "username").Find(&users).Error; err != nil {
+			return errors.Wrap(err, "failed to find users")
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		userIDs := make([]uint, len(users))
+		for i, user := range users {
+			userIDs[i] = user.ID
+		}
+
+		result := tx.Model(&models.User{}).
+			Where("id IN ?", userIDs).
+			UpdateColumn("must_change_password", true)
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "failed to flag users")
+		}
+		flagged = result.RowsAffected
+
+		if err := tx.Model(&models.JWT{}).
+			Where("user_id IN ? AND revoked_at IS NULL", userIDs).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		for i := range users {
+			if err := RecordAuditEvent(tx, actor, models.AuditPasswordChangeForced, &users[i], ""); err != nil {
+				return err
+			}
+			if err := RecordAuditEvent(tx, actor, models.AuditTokenRevoke, &users[i], "all sessions, password change forced"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.LogErrorContext(db.Statement.Context, err, "Failed to force password change", nil)
+		return 0, err
+	}
+
+	return flagged, nil
+}
+
+// SetUserRole changes the role of a user and records a role.change audit event.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - actor: Who changes the role, for the audit log.
+//   - username: The user to change.
+//   - role: The new role, models.RoleUser or models.RoleAdmin.
+//
+// Returns:
+//   - error: An error if the role is unknown, the user does not exist or the update fails.
+func SetUserRole(db *gorm.DB, actor AuditActor, username, role string) error {
+	if role != models.RoleUser && role != models.RoleAdmin {
+		return errors.Errorf("unknown role %q", role)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Select("id", "username", "role").Where("username = ?", username).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("user not found")
+			}
+			return errors.Wrap(err, "failed to find user")
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("role", role).Error; err != nil {
+			return errors.Wrap(err, "failed to update role")
+		}
+
+		return RecordAuditEvent(tx, actor, models.AuditRoleChange, &user, user.Role+" -> "+role)
+	})
+}
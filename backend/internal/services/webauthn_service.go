@@ -0,0 +1,394 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/CEM-KEA/whoknows/backend/internal/webauthn"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// maxPasskeysPerUser is the number of passkeys a user can register
+const maxPasskeysPerUser = 20
+
+var (
+	// ErrInvalidPasskeyChallenge is returned when the challenge of a response is unknown, expired,
+	// already used, or was issued for another ceremony or user
+	ErrInvalidPasskeyChallenge = errors.New("invalid or expired passkey challenge")
+	// ErrPasskeyExists is returned when the authenticator is already registered
+	ErrPasskeyExists = errors.New("this passkey is already registered")
+	// ErrTooManyPasskeys is returned when the user already has the maximum number of passkeys
+	ErrTooManyPasskeys = errors.New("too many passkeys")
+	// ErrPasskeyNotFound is returned when the passkey does not exist or belongs to another user
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	// ErrPasskeyLastLoginMethod is returned when removing the passkey would leave the user without a way to log in
+	ErrPasskeyLastLoginMethod = errors.New("cannot remove the only way to log in")
+	// ErrNoPasskeys is returned when passkeys are made a second factor before one is registered
+	ErrNoPasskeys = errors.New("no passkeys registered")
+)
+
+// PasskeyUserHandle returns the WebAuthn user handle of a user. It is derived from the user ID,
+// which is not personal information, so authenticators can return it on a passwordless login.
+func PasskeyUserHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+// StartPasskeyCeremony stores a new random challenge for a passkey registration or login.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - ceremony: One of the models.WebAuthnCeremony constants.
+//   - userID: The user registering a passkey or logging in with a second factor, or nil for a passwordless login.
+//   - ttl: How long the user has to complete the ceremony.
+//
+// Returns:
+//   - string: The base64url encoded challenge.
+//   - error: An error if the challenge could not be stored.
+func StartPasskeyCeremony(db *gorm.DB, ceremony string, userID *uint, ttl time.Duration) (string, error) {
+	utils.LogInfoContext(db.Statement.Context, "Starting passkey ceremony", nil)
+
+	challenge, err := security.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.WebAuthnChallenge{
+		ChallengeHash: security.HashToken(challenge),
+		Ceremony:      ceremony,
+		UserID:        userID,
+		ExpiresAt:     time.Now().Add(ttl),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", errors.Wrap(err, "failed to store passkey challenge")
+	}
+
+	return challenge, nil
+}
+
+// FinishPasskeyCeremony consumes the challenge of a passkey response. The challenge is deleted
+// before the response is verified, so it can only be used once, also by concurrent requests.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - ceremony: The ceremony the challenge must have been issued for.
+//   - challenge: The challenge from the client data of the response.
+//   - userID: The user the challenge must have been issued for, or nil for a passwordless login.
+//
+// Returns:
+//   - error: ErrInvalidPasskeyChallenge, or a database error.
+func FinishPasskeyCeremony(db *gorm.DB, ceremony, challenge string, userID *uint) error {
+	utils.LogInfoContext(db.Statement.Context, "Finishing passkey ceremony", nil)
+
+	var record models.WebAuthnChallenge
+	err := db.Where("challenge_hash = ?", security.HashToken(challenge)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidPasskeyChallenge
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get passkey challenge")
+	}
+
+	result := db.Where("id = ?", record.ID).Delete(&models.WebAuthnChallenge{})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to consume passkey challenge")
+	}
+	if result.RowsAffected != 1 || time.Now().After(record.ExpiresAt) || record.Ceremony != ceremony {
+		return ErrInvalidPasskeyChallenge
+	}
+	if (record.UserID == nil) != (userID == nil) || (userID != nil && *record.UserID != *userID) {
+		utils.LogWarnContext(db.Statement.Context, "Passkey challenge was issued for another user", nil)
+		return ErrInvalidPasskeyChallenge
+	}
+
+	return nil
+}
+
+// ListPasskeys returns the passkeys of a user.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The user.
+//
+// Returns:
+//   - []models.WebAuthnCredential: The passkeys, oldest first.
+//   - error: An error if the query fails.
+func ListPasskeys(db *gorm.DB, userID uint) ([]models.WebAuthnCredential, error) {
+	passkeys := []models.WebAuthnCredential{}
+	if err := db.Where("user_id = ?", userID).Order("created_at, id").Find(&passkeys).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to list passkeys")
+	}
+	return passkeys, nil
+}
+
+// PasskeyDescriptors returns the passkeys of a user as credential descriptors for the options
+// of a ceremony.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - userID: The user.
+//
+// Returns:
+//   - []webauthn.CredentialDescriptor: The descriptors.
+//   - error: An error if the query fails.
+func PasskeyDescriptors(db *gorm.DB, userID uint) ([]webauthn.CredentialDescriptor, error) {
+	passkeys, err := ListPasskeys(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := []webauthn.CredentialDescriptor{}
+	for _, passkey := range passkeys {
+		descriptor := webauthn.CredentialDescriptor{Type: "public-key", ID: passkey.CredentialID}
+		if passkey.Transports != "" {
+			descriptor.Transports = strings.Split(passkey.Transports, ",")
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors, nil
+}
+
+// HasPasskeys reports whether the user registered a passkey
+func HasPasskeys(db *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	if err := db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return false, errors.Wrap(err, "failed to count passkeys")
+	}
+	return count > 0, nil
+}
+
+// AddPasskey stores a verified new passkey for a user.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user.
+//   - name: A name for the passkey, chosen by the user.
+//   - credential: The credential from the verified registration.
+//
+// Returns:
+//   - *models.WebAuthnCredential: The stored passkey.
+//   - error: ErrPasskeyExists, ErrTooManyPasskeys, or a database error.
+func AddPasskey(db *gorm.DB, user *models.User, name string, credential *webauthn.Credential) (*models.WebAuthnCredential, error) {
+	utils.LogInfoContext(db.Statement.Context, "Adding passkey", nil)
+
+	passkey := &models.WebAuthnCredential{
+		UserID:         user.ID,
+		Name:           name,
+		CredentialID:   base64.RawURLEncoding.EncodeToString(credential.ID),
+		PublicKey:      credential.PublicKey,
+		Algorithm:      credential.Algorithm,
+		SignCount:      credential.SignCount,
+		AAGUID:         formatAAGUID(credential.AAGUID),
+		Transports:     strings.Join(credential.Transports, ","),
+		BackupEligible: credential.BackupEligible,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", passkey.CredentialID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrPasskeyExists
+		}
+		if err := tx.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxPasskeysPerUser {
+			return ErrTooManyPasskeys
+		}
+		return tx.Create(passkey).Error
+	})
+	if errors.Is(err, ErrPasskeyExists) || errors.Is(err, ErrTooManyPasskeys) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add passkey")
+	}
+
+	return passkey, nil
+}
+
+// FindPasskey returns the passkey with the given credential ID.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - credentialID: The base64url encoded credential ID.
+//
+// Returns:
+//   - *models.WebAuthnCredential: The passkey.
+//   - error: ErrPasskeyNotFound, or a database error.
+func FindPasskey(db *gorm.DB, credentialID string) (*models.WebAuthnCredential, error) {
+	var passkey models.WebAuthnCredential
+	err := db.Where("credential_id = ?", strings.TrimRight(credentialID, "=")).First(&passkey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPasskeyNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get passkey")
+	}
+	return &passkey, nil
+}
+
+// RecordPasskeyUse stores the signature counter of a verified login. The update only applies
+// if the counter was not changed in the meantime, so a response replayed concurrently on
+// another replica is refused.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - passkey: The passkey, with the signature counter it was verified against.
+//   - signCount: The new signature counter.
+//
+// Returns:
+//   - error: webauthn.ErrSignCountRegressed if the counter changed concurrently, or a database error.
+func RecordPasskeyUse(db *gorm.DB, passkey *models.WebAuthnCredential, signCount uint32) error {
+	result := db.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ?", passkey.ID, passkey.SignCount).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": time.Now()})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to update passkey")
+	}
+	if result.RowsAffected != 1 {
+		return webauthn.ErrSignCountRegressed
+	}
+	return nil
+}
+
+// DeletePasskey removes a passkey of a user. A user without a password or linked identity
+// provider must keep at least one passkey, or they could no longer log in.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user.
+//   - id: The ID of the passkey.
+//
+// Returns:
+//   - error: ErrPasskeyNotFound, ErrPasskeyLastLoginMethod, or a database error.
+func DeletePasskey(db *gorm.DB, user *models.User, id uint) error {
+	utils.LogInfoContext(db.Statement.Context, "Deleting passkey", nil)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		passkeys, err := ListPasskeys(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		var passkey *models.WebAuthnCredential
+		for i := range passkeys {
+			if passkeys[i].ID == id {
+				passkey = &passkeys[i]
+			}
+		}
+		if passkey == nil {
+			return ErrPasskeyNotFound
+		}
+
+		if user.PasswordHash == "" && len(passkeys) == 1 {
+			var identities int64
+			if err := tx.Model(&models.OIDCIdentity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+				return err
+			}
+			if identities == 0 {
+				return ErrPasskeyLastLoginMethod
+			}
+		}
+
+		if err := tx.Delete(passkey).Error; err != nil {
+			return err
+		}
+		// Without passkeys there is no second factor left to ask for, and a passkey registered
+		// later should not become one without the user choosing so again
+		if len(passkeys) == 1 && user.PasskeySecondFactor {
+			return tx.Model(user).Update("passkey_second_factor", false).Error
+		}
+		return nil
+	})
+}
+
+// PurgeExpiredPasskeyChallenges deletes the challenges of ceremonies that were never completed.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//
+// Returns:
+//   - int64: The number of rows deleted.
+//   - error: An error if the delete fails.
+func PurgeExpiredPasskeyChallenges(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnChallenge{})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "failed to purge expired passkey challenges")
+	}
+	return result.RowsAffected, nil
+}
+
+// SecondFactorMethods returns the second factors a user can complete a password login with:
+// "totp" with two-factor authentication enabled, and "passkey" if they chose to use their
+// passkeys as second factor. Users without any only need their password.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user logging in.
+//
+// Returns:
+//   - []string: The methods, empty if no second factor is needed.
+//   - error: An error if the passkeys can not be counted.
+func SecondFactorMethods(db *gorm.DB, user *models.User) ([]string, error) {
+	methods := []string{}
+	if TOTPEnabled(user) {
+		methods = append(methods, "totp")
+	}
+	if !user.PasskeySecondFactor {
+		return methods, nil
+	}
+	hasPasskeys, err := HasPasskeys(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if hasPasskeys {
+		methods = append(methods, "passkey")
+	}
+	return methods, nil
+}
+
+// SetPasskeySecondFactor turns the use of the user's passkeys as second factor of a password
+// login on or off. Registering a passkey alone does not make it a second factor. Callers must
+// have re-authenticated the user before turning it off.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB instance used to interact with the database.
+//   - user: The user changing the setting.
+//   - enabled: Whether a password login needs a passkey.
+//
+// Returns:
+//   - error: ErrNoPasskeys if it is turned on without a passkey, or a database error.
+func SetPasskeySecondFactor(db *gorm.DB, user *models.User, enabled bool) error {
+	if enabled {
+		hasPasskeys, err := HasPasskeys(db, user.ID)
+		if err != nil {
+			return err
+		}
+		if !hasPasskeys {
+			return ErrNoPasskeys
+		}
+	}
+
+	if err := db.Model(user).Update("passkey_second_factor", enabled).Error; err != nil {
+		return errors.Wrap(err, "failed to update passkey second factor")
+	}
+	return nil
+}
+
+// formatAAGUID formats the AAGUID of an authenticator model as a UUID
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return ""
+	}
+	encoded := hex.EncodeToString(aaguid)
+	return encoded[:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:]
+}
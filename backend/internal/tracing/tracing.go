@@ -0,0 +1,150 @@
+// Package tracing sets up OpenTelemetry tracing. Incoming requests, database operations and
+// outbound HTTP calls are traced with the global tracer provider, and the trace context is
+// propagated in the W3C traceparent and baggage headers.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Init installs the W3C trace context propagator and, unless the exporter is "none", a tracer
+// provider exporting the sampled spans as configured in cfg. Without an exporter, the trace
+// context of incoming requests is still passed on to the logs and outbound calls.
+//
+// Parameters:
+//   - cfg: The tracing configuration.
+//   - environment: The deployment environment, recorded on every span.
+//
+// Returns:
+//   - func(context.Context) error: Flushes the remaining spans and stops the exporter.
+//   - error: An error if the exporter cannot be created.
+func Init(cfg config.TracingConfig, environment string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closeOutput, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.DeploymentEnvironment(environment),
+		),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to describe the tracing resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeOutput(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// newExporter creates the span exporter selected in cfg, and returns a function closing the
+// file it writes to, if any
+func newExporter(cfg config.TracingConfig) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+
+	switch cfg.Exporter {
+	case "otlp":
+		var options []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create the OTLP trace exporter")
+		}
+		return exporter, noClose, nil
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create the stdout trace exporter")
+		}
+		return exporter, noClose, nil
+	case "file":
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to open the trace file")
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, errors.Wrap(err, "failed to create the file trace exporter")
+		}
+		return exporter, file.Close, nil
+	default:
+		return nil, nil, errors.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+}
+
+// Handler wraps an HTTP handler so that every request gets a server span, continuing the trace
+// of the traceparent header if there is one. The span is named by the method and the route
+// returned by route, such as "GET /api/me/exports/{id:[0-9]+}", so that spans of the same
+// endpoint group together.
+//
+// Parameters:
+//   - next: The handler serving the requests.
+//   - route: Returns the route template matching a request.
+//
+// Returns:
+//   - http.Handler: The traced handler.
+func Handler(next http.Handler, route func(*http.Request) string) http.Handler {
+	withRoute := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(route(r)))
+		next.ServeHTTP(w, r)
+	})
+
+	return otelhttp.NewHandler(withRoute, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + route(r)
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			// The probes and the metrics scrapes are called every few seconds and tell nothing
+			return !utils.IsProbePath(r.URL.Path)
+		}),
+	)
+}
+
+// Transport wraps an HTTP transport so that every outbound request gets a client span, as a
+// child of the span in the context of the request, and carries the traceparent header.
+// A nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
@@ -2,7 +2,12 @@ package utils
 
 import (
 	"encoding/json"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // WriteJSONError sends a JSON response with an error message and HTTP status code.
@@ -33,4 +38,59 @@ func JSONSuccess(w http.ResponseWriter, data map[string]interface{}, status int)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
-}
\ No newline at end of file
+}
+
+// WriteTooManyRequests sends a 429 JSON error response with a Retry-After header.
+// The Retry-After value is rounded up to whole seconds, as required by the header format.
+//
+// Parameters:
+//   - w: The http.ResponseWriter to write the response to.
+//   - message: The error message to include in the JSON response.
+//   - retryAfter: How long the client should wait before retrying.
+func WriteTooManyRequests(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	WriteJSONError(w, message, http.StatusTooManyRequests)
+}
+
+// ClientIP returns the IP address of the client that sent the request.
+// When trustProxyHeaders is true, the address set by the reverse proxy in X-Real-IP,
+// or else the last X-Forwarded-For entry, is used. These headers can be forged by
+// clients, so they must only be trusted when the backend is only reachable through the proxy.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - trustProxyHeaders: Whether to trust the proxy headers.
+//
+// Returns:
+//   - string: The client IP address.
+func ClientIP(r *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			parts := strings.Split(forwardedFor, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsProbePath reports whether path is one of the health probe or metrics endpoints, which are
+// called every few seconds by the container runtime and the metrics scraper.
+func IsProbePath(path string) bool {
+	switch strings.TrimSuffix(path, "/") {
+	case "/healthz", "/readyz", "/metrics", "/api/probe":
+		return true
+	}
+	return false
+}
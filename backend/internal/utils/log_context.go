@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loggerContextKey is the context key of the logger entries are logged to
+type loggerContextKey struct{}
+
+// WithLogger returns a copy of ctx that the Log*Context functions log to logger with.
+//
+// Parameters:
+//   - ctx: The context of a request, a background job or the startup of the backend.
+//   - logger: The logger of the App doing the work.
+//
+// Returns:
+//   - context.Context: The context to do the work with.
+func WithLogger(ctx context.Context, logger *logrus.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns the logger set by WithLogger. A context without one, such as one
+// made by a test, logs to the standard logrus logger.
+func LoggerFromContext(ctx context.Context) *logrus.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*logrus.Logger); ok && logger != nil {
+		return logger
+	}
+	return logrus.StandardLogger()
+}
+
+// requestLogContextKey is the context key of the requestLogContext of a request
+type requestLogContextKey struct{}
+
+// requestLogContext holds what the logs of a request are tagged with. It is shared by the
+// contexts derived from the request context, so a user authenticated deep in the middleware
+// chain is also known to the middlewares wrapping it, such as the access log.
+type requestLogContext struct {
+	requestID string
+	userID    atomic.Uint64
+}
+
+// WithRequestID returns a copy of ctx that tags the entries logged with it with requestID,
+// and with the user set by SetLogUserID.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - requestID: The ID of the request.
+//
+// Returns:
+//   - context.Context: The context to serve the request with.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestLogContextKey{}, &requestLogContext{requestID: requestID})
+}
+
+// RequestIDFromContext returns the request ID set by WithRequestID, or "" if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	if logContext := requestLogContextFrom(ctx); logContext != nil {
+		return logContext.requestID
+	}
+	return ""
+}
+
+// SetLogUserID records the user a request is made by, so that the following entries logged
+// with its context, and its access log line, are tagged with the user ID. It does nothing if
+// ctx has no request ID.
+func SetLogUserID(ctx context.Context, userID uint) {
+	if logContext := requestLogContextFrom(ctx); logContext != nil {
+		logContext.userID.Store(uint64(userID))
+	}
+}
+
+// LogUserIDFromContext returns the user set by SetLogUserID, and whether one is set
+func LogUserIDFromContext(ctx context.Context) (uint, bool) {
+	logContext := requestLogContextFrom(ctx)
+	if logContext == nil {
+		return 0, false
+	}
+	userID := logContext.userID.Load()
+	return uint(userID), userID != 0
+}
+
+// requestLogContextFrom returns the requestLogContext of ctx, or nil if there is none
+func requestLogContextFrom(ctx context.Context) *requestLogContext {
+	logContext, _ := ctx.Value(requestLogContextKey{}).(*requestLogContext)
+	return logContext
+}
@@ -1,29 +1,40 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var Logger *logrus.Logger
-
 // Define a whitelist of allowed fields that can be logged
 var allowedLogFields = map[string]bool{
-	"request_id": true, // Example: Include request IDs for debugging
-	"status":     true, // Example: Include status codes
-	"error":      true, // Example: Include error messages
-	"message":    true, // Example: General messages
-	"timestamp":  true, // Example: Include timestamps if necessary
-	"query":      true, // Example: Include query strings
+	"request_id":  true, // Example: Include request IDs for debugging
+	"status":      true, // Example: Include status codes
+	"error":       true, // Example: Include error messages
+	"message":     true, // Example: General messages
+	"timestamp":   true, // Example: Include timestamps if necessary
+	"query":       true, // Example: Include query strings
+	"purged":      true, // Number of rows deleted by cleanup jobs
+	"table":       true, // Table of a slow database query
+	"operation":   true, // Operation of a slow database query
+	"duration_ms": true, // Duration of a slow database query
+	"sql":         true, // Normalized SQL of a slow database query, without values
+	"method":      true, // Method of a request in the access log
+	"route":       true, // Route template of a request in the access log
+	"latency_ms":  true, // Latency of a request in the access log
+	"bytes":       true, // Size of the response body in the access log
+	"user_id":     true, // User a request is made by
 }
 
 // NewLogger creates a new instance of a logrus.Logger with the specified log level and format.
 // The log level can be any valid logrus log level (e.g., "debug", "info", "warn", "error").
 // If the provided log level is invalid, it defaults to "info" level.
 // The log format can be either "json" or "JSON" for JSON formatted logs, or any other value for text formatted logs.
-// The logger output is set to os.Stdout.
+// The logger output is set to os.Stdout. Entries logged with the context of a request get its
+// request_id, the user_id of the user making it, and the trace_id and span_id if it is traced.
 //
 // Parameters:
 //   - logLevel: The desired log level as a string.
@@ -33,7 +44,26 @@ var allowedLogFields = map[string]bool{
 //   - *logrus.Logger: A pointer to the configured logrus.Logger instance.
 func NewLogger(logLevel string, logFormat string) *logrus.Logger {
 	logger := logrus.New()
+	configureLogger(logger, logLevel, logFormat)
+	logger.SetOutput(os.Stdout)
+	logger.AddHook(contextHook{})
+
+	return logger
+}
+
+// ReconfigureLogger changes the level and format of a logger in place, so it can be done while
+// other goroutines are logging.
+//
+// Parameters:
+//   - logger: The logger to change.
+//   - logLevel: The desired log level as a string.
+//   - logFormat: The desired log format as a string.
+func ReconfigureLogger(logger *logrus.Logger, logLevel, logFormat string) {
+	configureLogger(logger, logLevel, logFormat)
+}
 
+// configureLogger sets the level and formatter of a logger
+func configureLogger(logger *logrus.Logger, logLevel, logFormat string) {
 	// Set log level
 	level, err := logrus.ParseLevel(logLevel)
 	if err != nil {
@@ -47,14 +77,6 @@ func NewLogger(logLevel string, logFormat string) *logrus.Logger {
 	default:
 		logger.SetFormatter(&logrus.TextFormatter{})
 	}
-
-	logger.SetOutput(os.Stdout)
-
-	return logger
-}
-
-func InitGlobalLogger(logLevel, logFormat string) {
-	Logger = NewLogger(logLevel, logFormat)
 }
 
 
@@ -80,32 +102,61 @@ func cleanFields(fields logrus.Fields) logrus.Fields {
 	return sanitizedFields
 }
 
-// LogDebug logs a debug message with specific fields
-func LogDebug(message string, fields logrus.Fields) {
-	Logger.WithFields(cleanFields(fields)).Debug(message)
+// LogDebugContext logs a debug message with specific fields, and the request and trace of ctx,
+// to the logger of ctx
+func LogDebugContext(ctx context.Context, message string, fields logrus.Fields) {
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(cleanFields(fields)).Debug(message)
+}
+
+// LogInfoContext logs an informational message with specific fields, and the request and trace
+// of ctx, to the logger of ctx
+func LogInfoContext(ctx context.Context, message string, fields logrus.Fields) {
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(cleanFields(fields)).Info(message)
 }
 
-// LogInfo logs an informational message with specific fields
-func LogInfo(message string, fields logrus.Fields) {
-	Logger.WithFields(cleanFields(fields)).Info(message)
+// LogErrorContext logs an error with a specific context, and the request and trace of ctx, to
+// the logger of ctx
+func LogErrorContext(ctx context.Context, err error, message string, fields logrus.Fields) {
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(cleanFields(fields)).WithError(err).Warn(message)
 }
 
-// LogError logs an error with a specific context
-func LogError(err error, message string, fields logrus.Fields) {
-	Logger.WithFields(cleanFields(fields)).WithError(err).Warn(message)
+// LogWarnContext logs a warning message with specific fields, and the request and trace of ctx,
+// to the logger of ctx
+func LogWarnContext(ctx context.Context, message string, fields logrus.Fields) {
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(cleanFields(fields)).Warn(message)
 }
 
-// LogWarn logs a warning message with specific fields
-func LogWarn(message string, fields logrus.Fields) {
-	Logger.WithFields(cleanFields(fields)).Warn(message)
+// contextHook adds the request ID, the user and the trace and span IDs in the context of an
+// entry to the entry, so that the logs of a request can be found by its ID or from its trace
+type contextHook struct{}
+
+// Levels returns the levels the hook fires for, which are all of them
+func (contextHook) Levels() []logrus.Level {
+	return logrus.AllLevels
 }
 
-// LogFatal logs a fatal message with specific fields
-func LogFatal(message string, fields logrus.Fields) {
-	Logger.WithFields(cleanFields(fields)).Fatal(message)
+// Fire adds request_id and user_id to an entry logged with the context of a request, and
+// trace_id and span_id if the context has a valid span
+func (contextHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if requestID := RequestIDFromContext(entry.Context); requestID != "" {
+		entry.Data["request_id"] = requestID
+	}
+	if userID, ok := LogUserIDFromContext(entry.Context); ok {
+		entry.Data["user_id"] = userID
+	}
+	spanContext := trace.SpanContextFromContext(entry.Context)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	entry.Data["trace_id"] = spanContext.TraceID().String()
+	entry.Data["span_id"] = spanContext.SpanID().String()
+	return nil
 }
 
-// LogPanic logs a panic message with specific fields
-func LogPanic(message string, fields logrus.Fields) {
-	Logger.WithFields(cleanFields(fields)).Panic(message)
+// LogFatalContext logs a fatal message with specific fields to the logger of ctx, and exits
+func LogFatalContext(ctx context.Context, message string, fields logrus.Fields) {
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(cleanFields(fields)).Fatal(message)
 }
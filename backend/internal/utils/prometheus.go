@@ -1,17 +1,33 @@
 package utils
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 var (
-	// HTTP Metrics
+	// HTTP Metrics, labelled with the route template rather than the path, so that the number
+	// of series stays bounded; see HTTPRouteUnmatched and HTTPMethodOther
 	HttpRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
+			Help: "Total number of HTTP requests by method, route and status code",
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	HttpRequestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_errors_total",
+			Help: "Total number of HTTP requests answered with a 4xx or 5xx status code, by status class",
 		},
-		[]string{"method", "endpoint"},
+		[]string{"method", "route", "class"},
 	)
 
 	HttpRequestDuration = prometheus.NewHistogramVec(
@@ -20,15 +36,15 @@ var (
 			Help:    "Duration of HTTP requests in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "endpoint"},
+		[]string{"method", "route", "status"},
 	)
 
-	HttpActiveRequests = prometheus.NewGaugeVec(
+	HttpRequestsInFlight = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "http_active_requests",
-			Help: "Number of active HTTP requests being processed",
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests being processed",
 		},
-		[]string{"method", "endpoint"},
+		[]string{"method", "route"},
 	)
 
 	HttpRequestSize = prometheus.NewHistogramVec(
@@ -37,7 +53,7 @@ var (
 			Help:    "Size of HTTP requests in bytes",
 			Buckets: prometheus.ExponentialBuckets(100, 10, 6), // 100B, 1KB, 10KB...
 		},
-		[]string{"method", "endpoint"},
+		[]string{"method", "route"},
 	)
 
 	HttpResponseSize = prometheus.NewHistogramVec(
@@ -46,25 +62,25 @@ var (
 			Help:    "Size of HTTP responses in bytes",
 			Buckets: prometheus.ExponentialBuckets(100, 10, 6),
 		},
-		[]string{"method", "endpoint"},
+		[]string{"method", "route"},
 	)
 
 	// Database Metrics
 	DBQueryDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "db_query_duration_seconds",
-			Help:    "Duration of database queries in seconds",
+			Help:    "Duration of database operations in seconds by table and operation",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"query_type"},
+		[]string{"table", "operation"},
 	)
 
 	DBFailedQueries = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "db_failed_queries_total",
-			Help: "Total number of failed database queries",
+			Help: "Total number of failed database operations by table and operation",
 		},
-		[]string{"query_type"},
+		[]string{"table", "operation"},
 	)
 
 	// Cache Metrics
@@ -99,14 +115,56 @@ var (
 		},
 		[]string{"query_type"},
 	)
+
+	// Security Metrics
+	AuthBlockedAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_blocked_attempts_total",
+			Help: "Total number of authentication attempts blocked by brute-force protection",
+		},
+		[]string{"endpoint", "scope"},
+	)
+
+	RateLimitedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_requests_total",
+			Help: "Total number of requests rejected by the per-route rate limits",
+		},
+		[]string{"route", "scope"},
+	)
+
+	// Background Job Metrics
+	JWTTokensPurged = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jwt_tokens_purged_total",
+			Help: "Total number of expired or revoked JWT rows deleted by the cleanup job",
+		},
+	)
+
+	JWTCleanupRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jwt_cleanup_runs_total",
+			Help: "Total number of JWT cleanup job runs by result",
+		},
+		[]string{"result"},
+	)
+
+	DataExportsBuilt = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "data_exports_built_total",
+			Help: "Total number of personal data exports built by the export worker by result",
+		},
+		[]string{"result"},
+	)
 )
 
 // RegisterMetrics registers various Prometheus metrics used for monitoring
 // the application. It logs the start and successful completion of the
-// registration process. The metrics registered include:
+// registration process with the logger of ctx. The metrics registered include:
 //   - HttpRequestsTotal: Total number of HTTP requests
+//   - HttpRequestErrors: Number of HTTP requests answered with an error status
 //   - HttpRequestDuration: Duration of HTTP requests
-//   - HttpActiveRequests: Number of active HTTP requests
+//   - HttpRequestsInFlight: Number of HTTP requests being processed
 //   - HttpRequestSize: Size of HTTP requests
 //   - HttpResponseSize: Size of HTTP responses
 //   - DBQueryDuration: Duration of database queries
@@ -115,11 +173,17 @@ var (
 //   - CacheMisses: Number of cache misses
 //   - UserRegistrations: Number of user registrations
 //   - SearchQueries: Number of search queries
-func RegisterMetrics() {
-	LogInfo("Registering Prometheus metrics", nil)
+//   - AuthBlockedAttempts: Number of authentication attempts blocked by brute-force protection
+//   - RateLimitedRequests: Number of requests rejected by the rate limits
+//   - JWTTokensPurged: Number of JWT rows purged by the cleanup job
+//   - JWTCleanupRuns: Number of JWT cleanup job runs
+//   - DataExportsBuilt: Number of personal data exports built
+func RegisterMetrics(ctx context.Context) {
+	LogInfoContext(ctx, "Registering Prometheus metrics", nil)
 	prometheus.MustRegister(HttpRequestsTotal)
+	prometheus.MustRegister(HttpRequestErrors)
 	prometheus.MustRegister(HttpRequestDuration)
-	prometheus.MustRegister(HttpActiveRequests)
+	prometheus.MustRegister(HttpRequestsInFlight)
 	prometheus.MustRegister(HttpRequestSize)
 	prometheus.MustRegister(HttpResponseSize)
 	prometheus.MustRegister(DBQueryDuration)
@@ -128,54 +192,99 @@ func RegisterMetrics() {
 	prometheus.MustRegister(CacheMisses)
 	prometheus.MustRegister(UserRegistrations)
 	prometheus.MustRegister(SearchQueries)
-	LogInfo("Prometheus metrics registered successfully", nil)
+	prometheus.MustRegister(AuthBlockedAttempts)
+	prometheus.MustRegister(RateLimitedRequests)
+	prometheus.MustRegister(JWTTokensPurged)
+	prometheus.MustRegister(JWTCleanupRuns)
+	prometheus.MustRegister(DataExportsBuilt)
+	LogInfoContext(ctx, "Prometheus metrics registered successfully", nil)
 }
 
 // ExposeMetrics sets up an HTTP handler for Prometheus metrics at the endpoint "/api/probe"
 // and starts a server on port 9090 to expose these metrics. If the server fails to start,
 // an error is logged. A log message is also generated when the server starts successfully.
-func ExposeMetrics() {
-	LogInfo("Prometheus metrics server started on port 9090", nil)
+func ExposeMetrics(ctx context.Context) {
+	LogInfoContext(ctx, "Prometheus metrics server started on port 9090", nil)
 }
 
-// IncrementHTTPRequest increments the HTTP request counter
-func IncrementHTTPRequest(method, endpoint string) {
-	HttpRequestsTotal.WithLabelValues(method, endpoint).Inc()
+// HTTPRouteUnmatched is the route label of requests that match no route, so that scans of
+// random paths do not create a series per path
+const HTTPRouteUnmatched = "unmatched"
+
+// HTTPMethodOther is the method label of requests with a method outside the standard ones
+const HTTPMethodOther = "OTHER"
+
+// httpMethods are the methods used as they are in the method label
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// HTTPMethodLabel returns the method label of a request method, HTTPMethodOther for
+// methods outside the standard ones
+func HTTPMethodLabel(method string) string {
+	if httpMethods[method] {
+		return method
+	}
+	return HTTPMethodOther
+}
+
+// IncrementHTTPRequest increments the HTTP request counter, and the error counter for 4xx and 5xx status codes
+func IncrementHTTPRequest(method, route string, status int) {
+	HttpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	if status >= 400 {
+		HttpRequestErrors.WithLabelValues(method, route, strconv.Itoa(status/100)+"xx").Inc()
+	}
 }
 
 // ObserveHTTPRequestDuration observes the duration of an HTTP request
-func ObserveHTTPRequestDuration(method, endpoint string, duration float64) {
-	HttpRequestDuration.WithLabelValues(method, endpoint).Observe(duration)
+func ObserveHTTPRequestDuration(method, route string, status int, duration float64) {
+	HttpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(duration)
 }
 
-// IncrementActiveRequests increments the active HTTP requests counter
-func IncrementActiveRequests(method, endpoint string) {
-	HttpActiveRequests.WithLabelValues(method, endpoint).Inc()
+// IncrementRequestsInFlight increments the in-flight HTTP requests gauge
+func IncrementRequestsInFlight(method, route string) {
+	HttpRequestsInFlight.WithLabelValues(method, route).Inc()
 }
 
-// DecrementActiveRequests decrements the active HTTP requests counter
-func DecrementActiveRequests(method, endpoint string) {
-	HttpActiveRequests.WithLabelValues(method, endpoint).Dec()
+// DecrementRequestsInFlight decrements the in-flight HTTP requests gauge
+func DecrementRequestsInFlight(method, route string) {
+	HttpRequestsInFlight.WithLabelValues(method, route).Dec()
 }
 
 // ObserveRequestSize observes the size of an HTTP request
-func ObserveRequestSize(method, endpoint string, size float64) {
-	HttpRequestSize.WithLabelValues(method, endpoint).Observe(size)
+func ObserveRequestSize(method, route string, size float64) {
+	HttpRequestSize.WithLabelValues(method, route).Observe(size)
 }
 
 // ObserveResponseSize observes the size of an HTTP response
-func ObserveResponseSize(method, endpoint string, size float64) {
-	HttpResponseSize.WithLabelValues(method, endpoint).Observe(size)
+func ObserveResponseSize(method, route string, size float64) {
+	HttpResponseSize.WithLabelValues(method, route).Observe(size)
+}
+
+// IncrementDBFailedQuery increments the failed database operations counter
+func IncrementDBFailedQuery(table, operation string) {
+	DBFailedQueries.WithLabelValues(table, operation).Inc()
 }
 
-// IncrementDBQuery increments the database query counter
-func IncrementDBQuery(queryType string) {
-	DBFailedQueries.WithLabelValues(queryType).Inc()
+// ObserveDBQueryDuration observes the duration of a database operation
+func ObserveDBQueryDuration(table, operation string, duration float64) {
+	DBQueryDuration.WithLabelValues(table, operation).Observe(duration)
 }
 
-// ObserveDBQueryDuration observes the duration of a database query
-func ObserveDBQueryDuration(queryType string, duration float64) {
-	DBQueryDuration.WithLabelValues(queryType).Observe(duration)
+// RegisterDBPoolMetrics registers the connection pool metrics of db, taken from sql.DB.Stats
+// on every scrape: the open, in use and idle connections, and how often and how long
+// requests waited for a connection. They are named go_sql_* and labelled with db_name.
+// Registering the same pool again is ignored.
+//
+// Returns an error if the metrics cannot be registered.
+func RegisterDBPoolMetrics(db *sql.DB, name string) error {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, name))
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		return nil
+	}
+	return err
 }
 
 // IncrementCacheHit increments the cache hit counter
@@ -197,3 +306,28 @@ func IncrementUserRegistrations() {
 func IncrementSearchQueries(queryType string) {
 	SearchQueries.WithLabelValues(queryType).Inc()
 }
+
+// IncrementAuthBlockedAttempts increments the blocked authentication attempts counter
+func IncrementAuthBlockedAttempts(endpoint, scope string) {
+	AuthBlockedAttempts.WithLabelValues(endpoint, scope).Inc()
+}
+
+// IncrementRateLimitedRequests increments the rate limited requests counter
+func IncrementRateLimitedRequests(route, scope string) {
+	RateLimitedRequests.WithLabelValues(route, scope).Inc()
+}
+
+// AddJWTTokensPurged adds the number of purged JWT rows to the purge counter
+func AddJWTTokensPurged(count int64) {
+	JWTTokensPurged.Add(float64(count))
+}
+
+// IncrementJWTCleanupRuns increments the JWT cleanup runs counter for the given result
+func IncrementJWTCleanupRuns(result string) {
+	JWTCleanupRuns.WithLabelValues(result).Inc()
+}
+
+// IncrementDataExportsBuilt increments the data exports counter for the given result
+func IncrementDataExportsBuilt(result string) {
+	DataExportsBuilt.WithLabelValues(result).Inc()
+}
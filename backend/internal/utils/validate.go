@@ -2,27 +2,17 @@ package utils
 
 import (
 	"github.com/go-playground/validator/v10"
-	"github.com/sirupsen/logrus"
 )
 
 var validate *validator.Validate
 
 // InitValidator initializes the validator instance
 func InitValidator() {
-	LogInfo("Initializing validator", nil)
 	validate = validator.New()
 }
 
-// Validate validates the given struct
+// Validate validates the given struct. The handlers log a failed validation with the
+// context of their request.
 func Validate(data interface{}) error {
-	err := validate.Struct(data)
-	if err != nil {
-		LogWarn("Validation failed", logrus.Fields{
-			"errors": err.Error(),
-		})
-		return err
-	}
-
-	LogInfo("Validation successful", nil)
-	return nil
+	return validate.Struct(data)
 }
@@ -0,0 +1,89 @@
+package webauthn
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Flags of the authenticator data
+const (
+	flagUserPresent           byte = 0x01
+	flagUserVerified          byte = 0x04
+	flagBackupEligible        byte = 0x08
+	flagBackupState           byte = 0x10
+	flagAttestedCredential    byte = 0x40
+	flagExtensionDataIncluded byte = 0x80
+)
+
+// maxCredentialIDLength is the longest credential ID allowed by the specification
+const maxCredentialIDLength = 1023
+
+// authenticatorData is the data an authenticator signs during registration and login
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// The attested credential data, only present during registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData decodes authenticator data (WebAuthn §6.1).
+//
+// Parameters:
+//   - data: The raw authenticator data.
+//
+// Returns:
+//   - *authenticatorData: The decoded data.
+//   - error: An error if the data is malformed.
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+	parsed := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: uint32At(data[33:37]),
+	}
+	rest := data[37:]
+
+	if parsed.flags&flagAttestedCredential != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data is too short")
+		}
+		parsed.aaguid = rest[:16]
+		idLength := int(rest[16])<<8 | int(rest[17])
+		rest = rest[18:]
+		if idLength > maxCredentialIDLength || idLength > len(rest) {
+			return nil, errors.New("invalid credential ID length")
+		}
+		parsed.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid credential public key")
+		}
+		parsed.publicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if parsed.flags&flagExtensionDataIncluded != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid extension data")
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after authenticator data")
+	}
+	return parsed, nil
+}
+
+// has reports whether a flag is set
+func (a *authenticatorData) has(flag byte) bool {
+	return a.flags&flag != 0
+}
@@ -0,0 +1,174 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// maxCBORDepth limits the nesting of decoded CBOR items. Attestation objects and COSE keys
+// are only a few levels deep.
+const maxCBORDepth = 16
+
+// errCBORTruncated is returned when a CBOR item ends before its announced length
+var errCBORTruncated = errors.New("truncated CBOR data")
+
+// decodeCBOR decodes the CBOR data item at the start of data. It supports the subset of CBOR
+// (RFC 8949) used by WebAuthn: integers, byte and text strings, arrays, maps, tags, booleans
+// and null, all with definite lengths. Integers are returned as int64, byte strings as []byte,
+// text strings as string, arrays as []interface{} and maps as map[interface{}]interface{}.
+//
+// Parameters:
+//   - data: The encoded data.
+//
+// Returns:
+//   - interface{}: The decoded item.
+//   - int: The number of bytes the item takes up; data may continue after it.
+//   - error: An error if the data is malformed or uses an unsupported feature.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	decoder := cborDecoder{data: data}
+	value, err := decoder.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, decoder.pos, nil
+}
+
+// cborDecoder reads CBOR data items from a byte slice
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads the initial byte of an item and its argument
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errCBORTruncated
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, errors.New("indefinite length CBOR items are not supported")
+	}
+	size := 1 << (info - 24)
+	if len(d.data)-d.pos < size {
+		return 0, 0, errCBORTruncated
+	}
+	var argument uint64
+	for _, b := range d.data[d.pos : d.pos+size] {
+		argument = argument<<8 | uint64(b)
+	}
+	d.pos += size
+	return major, argument, nil
+}
+
+// bytes reads the content of a byte or text string
+func (d *cborDecoder) bytes(length uint64) ([]byte, error) {
+	if length > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+	content := d.data[d.pos : d.pos+int(length)]
+	d.pos += int(length)
+	return content, nil
+}
+
+// value reads one complete data item
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("CBOR data is nested too deeply")
+	}
+
+	major, argument, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, errors.New("CBOR integer out of range")
+		}
+		return int64(argument), nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, errors.New("CBOR integer out of range")
+		}
+		return -1 - int64(argument), nil
+	case 2:
+		return d.bytes(argument)
+	case 3:
+		content, err := d.bytes(argument)
+		return string(content), err
+	case 4:
+		// Every item takes at least one byte, which bounds the allocation
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if argument > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBORTruncated
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("unsupported CBOR map key")
+			}
+			if _, duplicate := entries[key]; duplicate {
+				return nil, errors.New("duplicate CBOR map key")
+			}
+			entries[key], err = d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	case 6:
+		// Tags only add meaning to the item that follows
+		return d.value(depth + 1)
+	default:
+		switch argument {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, errors.New("unsupported CBOR simple value")
+	}
+}
+
+// cborMap returns a decoded item as a map
+func cborMap(value interface{}) (map[interface{}]interface{}, error) {
+	entries, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("expected a CBOR map")
+	}
+	return entries, nil
+}
+
+// uint32At reads a big-endian uint32
+func uint32At(data []byte) uint32 {
+	return binary.BigEndian.Uint32(data)
+}
@@ -0,0 +1,135 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// COSE algorithm identifiers (RFC 9053) of the signature algorithms accepted for passkeys
+const (
+	AlgorithmES256 int64 = -7
+	AlgorithmEdDSA int64 = -8
+	AlgorithmRS256 int64 = -257
+)
+
+// SupportedAlgorithms are the accepted algorithms, in order of preference
+var SupportedAlgorithms = []int64{AlgorithmES256, AlgorithmEdDSA, AlgorithmRS256}
+
+// COSE key parameters (RFC 9052)
+const (
+	coseKeyType      int64 = 1
+	coseKeyAlgorithm int64 = 3
+	coseKeyCurve     int64 = -1
+	coseKeyX         int64 = -2
+	coseKeyY         int64 = -3
+	coseKeyModulus   int64 = -1
+	coseKeyExponent  int64 = -2
+
+	coseKeyTypeOKP int64 = 1
+	coseKeyTypeEC2 int64 = 2
+	coseKeyTypeRSA int64 = 3
+
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+)
+
+// publicKey is a credential public key decoded from its COSE_Key encoding
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, as found in the attested credential data of a registration.
+//
+// Parameters:
+//   - raw: The CBOR encoded COSE_Key.
+//
+// Returns:
+//   - *publicKey: The public key.
+//   - error: An error if the key is malformed or uses an unsupported algorithm.
+func parsePublicKey(raw []byte) (*publicKey, error) {
+	value, n, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid COSE key")
+	}
+	if n != len(raw) {
+		return nil, errors.New("invalid COSE key: trailing data")
+	}
+	params, err := cborMap(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid COSE key")
+	}
+
+	keyType, _ := params[coseKeyType].(int64)
+	algorithm, _ := params[coseKeyAlgorithm].(int64)
+	switch {
+	case algorithm == AlgorithmES256 && keyType == coseKeyTypeEC2:
+		curve, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		y, _ := params[coseKeyY].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 key")
+		}
+		// crypto/ecdh checks that the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, errors.Wrap(err, "invalid P-256 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return &publicKey{algorithm: algorithm, key: key}, nil
+
+	case algorithm == AlgorithmEdDSA && keyType == coseKeyTypeOKP:
+		curve, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return &publicKey{algorithm: algorithm, key: ed25519.PublicKey(x)}, nil
+
+	case algorithm == AlgorithmRS256 && keyType == coseKeyTypeRSA:
+		modulus, _ := params[coseKeyModulus].([]byte)
+		exponent, _ := params[coseKeyExponent].([]byte)
+		if len(exponent) == 0 || len(exponent) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		e := 0
+		for _, b := range exponent {
+			e = e<<8 | int(b)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: e}
+		if key.N.BitLen() < 2048 || e < 3 || e%2 == 0 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &publicKey{algorithm: algorithm, key: key}, nil
+	}
+
+	return nil, errors.Errorf("unsupported COSE key algorithm %d", algorithm)
+}
+
+// verify checks a signature made with the private key over data
+func (k *publicKey) verify(data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
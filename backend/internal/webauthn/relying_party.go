@@ -0,0 +1,427 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/pkg/errors"
+)
+
+// Ceremony types of the client data
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// User verification requirements of the options sent to the browser
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+var (
+	// ErrInvalidResponse is returned when the response of an authenticator does not verify
+	ErrInvalidResponse = errors.New("invalid WebAuthn response")
+	// ErrSignCountRegressed is returned when the signature counter of an authenticator did not
+	// increase, which indicates a cloned authenticator
+	ErrSignCountRegressed = errors.New("signature counter did not increase")
+)
+
+// RelyingParty is this application as WebAuthn relying party. Credentials are scoped to its ID,
+// a domain, and responses are only accepted from its origins.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	Timeout time.Duration
+}
+
+// NewRelyingParty builds the relying party from the configuration. The ID and origin default
+// to the host and origin of the public URL of the frontend.
+//
+// Parameters:
+//   - cfg: The WebAuthn configuration.
+//   - publicURL: The public base URL of the frontend.
+//
+// Returns:
+//   - *RelyingParty: The relying party.
+//   - error: An error if no relying party ID can be determined.
+func NewRelyingParty(cfg config.WebAuthnConfig, publicURL string) (*RelyingParty, error) {
+	rp := &RelyingParty{ID: cfg.RPID, Name: cfg.RPName, Origins: cfg.Origins, Timeout: cfg.ChallengeTTL}
+
+	if rp.ID == "" || len(rp.Origins) == 0 {
+		parsed, err := url.Parse(publicURL)
+		if err != nil || parsed.Hostname() == "" {
+			return nil, errors.New("WebAuthn needs a relying party ID or a public URL")
+		}
+		if rp.ID == "" {
+			rp.ID = parsed.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{parsed.Scheme + "://" + parsed.Host}
+		}
+	}
+	if rp.Name == "" {
+		rp.Name = rp.ID
+	}
+	return rp, nil
+}
+
+// UserEntity describes the user a credential is created for. Handle is an opaque identifier
+// the authenticator returns on login; it must not contain personal information.
+type UserEntity struct {
+	Handle      []byte
+	Name        string
+	DisplayName string
+}
+
+// CredentialDescriptor identifies an existing credential in the options
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CreationOptions are the options for navigator.credentials.create, in the JSON form of
+// WebAuthn Level 3, with binary values encoded as base64url
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Challenge        string `json:"challenge"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are the options for navigator.credentials.get, in the JSON form of WebAuthn Level 3
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions returns the options to register a new passkey. Passkeys are discoverable
+// when the authenticator supports it, so they can be used without entering a username.
+// No attestation is requested; which authenticator the user picks is up to them.
+//
+// Parameters:
+//   - challenge: A new random challenge.
+//   - user: The user the credential is for.
+//   - exclude: The credentials the user already has, so an authenticator is not registered twice.
+//
+// Returns:
+//   - CreationOptions: The options.
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	var options CreationOptions
+	options.RP.ID = rp.ID
+	options.RP.Name = rp.Name
+	options.User.ID = base64.RawURLEncoding.EncodeToString(user.Handle)
+	options.User.Name = user.Name
+	options.User.DisplayName = user.DisplayName
+	options.Challenge = challenge
+	for _, algorithm := range SupportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int64  `json:"alg"`
+		}{"public-key", algorithm})
+	}
+	options.Timeout = rp.Timeout.Milliseconds()
+	options.ExcludeCredentials = exclude
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = UserVerificationPreferred
+	options.Attestation = "none"
+	return options
+}
+
+// RequestOptions returns the options to log in with a passkey.
+//
+// Parameters:
+//   - challenge: A new random challenge.
+//   - allow: The credentials that may be used, empty to let the user pick a discoverable credential.
+//   - userVerification: One of the UserVerification constants.
+//
+// Returns:
+//   - RequestOptions: The options.
+func (rp *RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor, userVerification string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// RegistrationResponse is the credential returned by navigator.credentials.create, in the JSON
+// form of PublicKeyCredential.toJSON()
+type RegistrationResponse struct {
+	ID       string `json:"id" validate:"required"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type" validate:"required"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON" validate:"required"`
+		AttestationObject string   `json:"attestationObject" validate:"required"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get, in the JSON
+// form of PublicKeyCredential.toJSON()
+type AssertionResponse struct {
+	ID       string `json:"id" validate:"required"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type" validate:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" validate:"required"`
+		AuthenticatorData string `json:"authenticatorData" validate:"required"`
+		Signature         string `json:"signature" validate:"required"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a newly registered passkey
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte
+	Algorithm      int64
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	UserVerified   bool
+	BackupEligible bool
+	BackupState    bool
+}
+
+// Assertion is a verified login with a passkey
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackupState  bool
+}
+
+// clientData is the collected client data the browser passes to the authenticator
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Challenge returns the challenge the response was made for, so the caller can look up the
+// ceremony it belongs to. The challenge is only trusted once the response is verified.
+func (r *RegistrationResponse) Challenge() (string, error) {
+	data, err := decodeClientData(r.Response.ClientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	return data.Challenge, nil
+}
+
+// Challenge returns the challenge the response was made for, so the caller can look up the
+// ceremony it belongs to. The challenge is only trusted once the response is verified.
+func (r *AssertionResponse) Challenge() (string, error) {
+	data, err := decodeClientData(r.Response.ClientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	return data.Challenge, nil
+}
+
+// VerifyRegistration verifies the response of a registration ceremony (WebAuthn §7.1): the
+// client data, the relying party ID, the user presence flag and the credential public key.
+// The attestation statement is not verified, as no attestation is requested.
+//
+// Parameters:
+//   - response: The credential returned by the browser.
+//   - challenge: The challenge of the ceremony.
+//   - requireUserVerification: Whether the user must have been verified, e.g. with a PIN or biometrics.
+//
+// Returns:
+//   - *Credential: The new credential.
+//   - error: An error wrapping ErrInvalidResponse if the response does not verify.
+func (rp *RelyingParty) VerifyRegistration(response *RegistrationResponse, challenge string, requireUserVerification bool) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, errors.Wrap(ErrInvalidResponse, "unexpected credential type")
+	}
+	if err := rp.verifyClientData(response.Response.ClientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	rawObject, err := base64.RawURLEncoding.DecodeString(response.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, "attestation object is not base64url encoded")
+	}
+	value, n, err := decodeCBOR(rawObject)
+	if err != nil || n != len(rawObject) {
+		return nil, errors.Wrap(ErrInvalidResponse, "malformed attestation object")
+	}
+	attestation, err := cborMap(value)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, "malformed attestation object")
+	}
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if _, ok := attestation["fmt"].(string); !ok {
+		return nil, errors.Wrap(ErrInvalidResponse, "missing attestation format")
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if !authData.has(flagAttestedCredential) {
+		return nil, errors.Wrap(ErrInvalidResponse, "no attested credential data")
+	}
+	if base64.RawURLEncoding.EncodeToString(authData.credentialID) != strings.TrimRight(response.ID, "=") {
+		return nil, errors.Wrap(ErrInvalidResponse, "credential ID does not match")
+	}
+	key, err := parsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, err.Error())
+	}
+
+	return &Credential{
+		ID:             authData.credentialID,
+		PublicKey:      authData.publicKey,
+		Algorithm:      key.algorithm,
+		SignCount:      authData.signCount,
+		AAGUID:         authData.aaguid,
+		Transports:     response.Response.Transports,
+		UserVerified:   authData.has(flagUserVerified),
+		BackupEligible: authData.has(flagBackupEligible),
+		BackupState:    authData.has(flagBackupState),
+	}, nil
+}
+
+// VerifyAssertion verifies the response of an authentication ceremony (WebAuthn §7.2) against
+// a stored credential: the client data, the relying party ID, the flags, the signature and
+// the signature counter.
+//
+// Parameters:
+//   - response: The credential returned by the browser.
+//   - challenge: The challenge of the ceremony.
+//   - publicKey: The COSE encoded public key of the stored credential.
+//   - storedSignCount: The signature counter from the previous use of the credential.
+//   - requireUserVerification: Whether the user must have been verified, e.g. with a PIN or biometrics.
+//
+// Returns:
+//   - *Assertion: The verified assertion, with the new signature counter.
+//   - error: An error wrapping ErrInvalidResponse, or ErrSignCountRegressed.
+func (rp *RelyingParty) VerifyAssertion(response *AssertionResponse, challenge string, publicKey []byte, storedSignCount uint32, requireUserVerification bool) (*Assertion, error) {
+	if response.Type != "public-key" {
+		return nil, errors.Wrap(ErrInvalidResponse, "unexpected credential type")
+	}
+	if err := rp.verifyClientData(response.Response.ClientDataJSON, ceremonyGet, challenge); err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := base64.RawURLEncoding.DecodeString(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, "authenticator data is not base64url encoded")
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(response.Response.Signature)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, "signature is not base64url encoded")
+	}
+	rawClientData, _ := base64.RawURLEncoding.DecodeString(response.Response.ClientDataJSON)
+	clientDataHash := sha256.Sum256(rawClientData)
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid stored public key")
+	}
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, err.Error())
+	}
+
+	// Authenticators that do not count signatures always report 0
+	if (authData.signCount != 0 || storedSignCount != 0) && authData.signCount <= storedSignCount {
+		return nil, ErrSignCountRegressed
+	}
+
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.has(flagUserVerified),
+		BackupState:  authData.has(flagBackupState),
+	}, nil
+}
+
+// verifyClientData checks the type, challenge and origin of the client data
+func (rp *RelyingParty) verifyClientData(encoded, ceremony, challenge string) error {
+	data, err := decodeClientData(encoded)
+	if err != nil {
+		return err
+	}
+	if data.Type != ceremony {
+		return errors.Wrap(ErrInvalidResponse, "unexpected ceremony type")
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(challenge)) != 1 {
+		return errors.Wrap(ErrInvalidResponse, "challenge does not match")
+	}
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return errors.Wrap(ErrInvalidResponse, "unexpected origin")
+}
+
+// verifyAuthenticatorData parses authenticator data and checks the relying party ID hash and flags
+func (rp *RelyingParty) verifyAuthenticatorData(raw []byte, requireUserVerification bool) (*authenticatorData, error) {
+	authData, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, err.Error())
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return nil, errors.Wrap(ErrInvalidResponse, "relying party ID does not match")
+	}
+	if !authData.has(flagUserPresent) {
+		return nil, errors.Wrap(ErrInvalidResponse, "user was not present")
+	}
+	if requireUserVerification && !authData.has(flagUserVerified) {
+		return nil, errors.Wrap(ErrInvalidResponse, "user was not verified")
+	}
+	return authData, nil
+}
+
+// decodeClientData decodes base64url encoded client data JSON
+func decodeClientData(encoded string) (*clientData, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, "client data is not base64url encoded")
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, errors.Wrap(ErrInvalidResponse, "malformed client data")
+	}
+	return &data, nil
+}
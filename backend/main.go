@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
 	"github.com/CEM-KEA/whoknows/backend/internal/config"
 	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/jobs"
+	"github.com/CEM-KEA/whoknows/backend/internal/mail"
+	"github.com/CEM-KEA/whoknows/backend/internal/oidc"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/tracing"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // @title						WhoKnows API
@@ -20,91 +36,392 @@ import (
 // @securityDefinitions.apiKey	Bearer
 // @in							header
 // @name						JWT
+// @securityDefinitions.apiKey	ApiKey
+// @in							header
+// @name						X-API-Key
 func main() {
-	// Initialize logger immediately
-	utils.InitGlobalLogger("info", "json")
-	utils.LogInfo("Logger initialized for early application setup", nil)
+	// Log with the defaults until the configuration is loaded
+	logger := utils.NewLogger("info", "json")
+	ctx := utils.WithLogger(context.Background(), logger)
+	utils.LogInfoContext(ctx, "Logger initialized for early application setup", nil)
 
 	// Load configuration
-	if err := loadConfig(); err != nil {
-		utils.LogFatal("Failed to load configuration", logrus.Fields{
+	cfg, commandLine, err := loadConfig(ctx)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		utils.LogFatalContext(ctx, "Failed to load configuration", logrus.Fields{
 			"error": err.Error(),
 		})
 		return
 	}
+	if commandLine.PrintConfig {
+		if err := config.PrintConfig(os.Stdout, cfg); err != nil {
+			utils.LogFatalContext(ctx, "Failed to print configuration", logrus.Fields{
+				"error": err.Error(),
+			})
+		}
+		return
+	}
 
-	// Initialize logger
-	initLogger()
+	// Apply the configured log settings
+	initLogger(ctx, logger, cfg.Log)
 
 	// Initialize utilities
 	utils.InitValidator()
 
+	// Initialize tracing, flushing the remaining spans when the backend stops
+	shutdownTracing, err := initTracing(ctx, cfg)
+	if err != nil {
+		utils.LogFatalContext(ctx, "Failed to initialize tracing", logrus.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+	defer flushTraces(ctx, cfg.Server.ShutdownTimeout, shutdownTracing)
+
+	// The migrate command decides itself which migrations to run
+	if len(commandLine.Args) > 0 && commandLine.Args[0] == "migrate" {
+		cfg.Database.Migrate = false
+	}
+
 	// Initialize the database
-	if err := initDatabase(); err != nil {
-		utils.LogFatal("Failed to initialize the database", logrus.Fields{
+	db, err := initDatabase(ctx, cfg.Database)
+	if err != nil {
+		utils.LogFatalContext(ctx, "Failed to initialize the database", logrus.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Run a management command instead of the server, if one is given
+	if len(commandLine.Args) > 0 {
+		if err := runCommand(ctx, db, commandLine.Args); err != nil {
+			utils.LogFatalContext(ctx, "Command failed", logrus.Fields{
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
+	a := app.New(cfg, db, logger)
+
+	// Initialize the mailer
+	if err := initMailer(ctx, a); err != nil {
+		utils.LogFatalContext(ctx, "Failed to initialize the mailer", logrus.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Initialize the password policy
+	if err := initPasswordPolicy(ctx, a); err != nil {
+		utils.LogFatalContext(ctx, "Failed to initialize the password policy", logrus.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Initialize brute-force protection
+	if err := initBruteForceGuard(ctx, a); err != nil {
+		utils.LogFatalContext(ctx, "Failed to initialize brute-force protection", logrus.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Initialize the OpenID Connect identity providers
+	if err := initOIDCProviders(ctx, a); err != nil {
+		utils.LogFatalContext(ctx, "Failed to initialize the identity providers", logrus.Fields{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	// Start the server
-	startServer()
+	// Start the server and the background jobs, and run until a shutdown signal
+	if err := runServer(ctx, a); err != nil {
+		utils.LogFatalContext(ctx, "Server stopped with an error", logrus.Fields{
+			"error": err.Error(),
+		})
+	}
+}
+
+// loadConfig loads application configuration from the configuration file, the environment
+// and the command line flags
+func loadConfig(ctx context.Context) (*config.Config, *config.CommandLine, error) {
+	utils.LogInfoContext(ctx, "Loading application configuration", nil)
+	cfg, commandLine, err := config.Load(ctx, os.Args[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	utils.LogInfoContext(ctx, "Configuration loaded successfully", nil)
+	return cfg, commandLine, nil
+}
+
+// reloadConfig reloads the configuration of a on SIGHUP and applies the new log settings. The
+// CORS origins are read from the configuration on every request, so they need nothing more.
+func reloadConfig(ctx context.Context, a *app.App) {
+	utils.LogInfoContext(ctx, "Reloading configuration", nil)
+	changed, err := config.Reload(ctx, a.Config)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error reloading configuration, keeping the current one", nil)
+		return
+	}
+	utils.ReconfigureLogger(a.Logger, a.Config.Log.Level, a.Config.Log.Format)
+	utils.LogInfoContext(ctx, "Configuration reloaded", logrus.Fields{
+		"message": fmt.Sprintf("%d keys changed: %s", len(changed), strings.Join(changed, ", ")),
+	})
+}
+
+// initLogger applies the configured level and format to logger
+func initLogger(ctx context.Context, logger *logrus.Logger, cfg config.LogConfig) {
+	utils.ReconfigureLogger(logger, cfg.Level, cfg.Format)
+	utils.LogInfoContext(ctx, "Logger initialized", logrus.Fields{
+		"logLevel":  cfg.Level,
+		"logFormat": cfg.Format,
+	})
+}
+
+// initTracing initializes the tracer provider and the trace context propagation, and returns
+// the function flushing the remaining spans
+func initTracing(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	utils.LogInfoContext(ctx, "Initializing tracing", logrus.Fields{
+		"message": "exporter " + cfg.Tracing.Exporter,
+	})
+	shutdownTracing, err := tracing.Init(cfg.Tracing, cfg.Environment.Environment)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing tracing", nil)
+		return nil, err
+	}
+	return shutdownTracing, nil
+}
+
+// flushTraces exports the spans that have not been exported yet, giving up after timeout
+func flushTraces(ctx context.Context, timeout time.Duration, shutdownTracing func(context.Context) error) {
+	flushCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to flush the remaining spans", nil)
+	}
+}
+
+// initDatabase initializes the database connection
+func initDatabase(ctx context.Context, cfg config.DatabaseConfig) (*gorm.DB, error) {
+	utils.LogInfoContext(ctx, "Initializing database", nil)
+	db, err := database.InitDatabase(ctx, cfg)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing database", nil)
+		return nil, err
+	}
+	utils.LogInfoContext(ctx, "Database initialized successfully", nil)
+	return db, nil
+}
+
+// initMailer sets the mailer of a, used for outgoing emails, to the configured one
+func initMailer(ctx context.Context, a *app.App) error {
+	utils.LogInfoContext(ctx, "Initializing mailer", nil)
+	mailer, err := mail.NewMailer(a.Config.Mail)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing mailer", nil)
+		return err
+	}
+	a.Mailer = mailer
+	utils.LogInfoContext(ctx, "Mailer initialized", logrus.Fields{
+		"message": a.Config.Mail.Driver,
+	})
+	return nil
 }
 
-// loadConfig loads application configuration
-func loadConfig() error {
-	utils.LogInfo("Loading application configuration", nil)
-	if err := config.LoadEnv(); err != nil {
-		utils.LogError(err, "Error loading configuration", nil)
+// initPasswordPolicy initializes password hashing and sets the password policy of a to the
+// configured one, loading the breached password list if configured
+func initPasswordPolicy(ctx context.Context, a *app.App) error {
+	utils.LogInfoContext(ctx, "Initializing password policy", nil)
+	if err := security.InitPasswordHashing(a.Config.PasswordHash); err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing password hashing", nil)
+		return err
+	}
+	policy, err := security.NewPasswordPolicy(a.Config.Password)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing password policy", nil)
 		return err
 	}
-	utils.LogInfo("Configuration loaded successfully", nil)
+	a.PasswordPolicy = policy
 	return nil
 }
 
-// initLogger initializes the global logger
-func initLogger() {
-	logLevel := config.AppConfig.Log.Level
-	logFormat := config.AppConfig.Log.Format
-	utils.InitGlobalLogger(logLevel, logFormat)
-	utils.LogInfo("Logger initialized", logrus.Fields{
-		"logLevel":  logLevel,
-		"logFormat": logFormat,
+// initBruteForceGuard sets the login attempt tracking of a to the configured one
+func initBruteForceGuard(ctx context.Context, a *app.App) error {
+	utils.LogInfoContext(ctx, "Initializing brute-force protection", nil)
+	guard, err := bruteforce.New(a.Config.BruteForce, a.DB)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing brute-force protection", nil)
+		return err
+	}
+	a.Guard = guard
+	utils.LogInfoContext(ctx, "Brute-force protection initialized", logrus.Fields{
+		"message": a.Config.BruteForce.Store,
 	})
+	return nil
 }
 
-// initDatabase initializes the database connection
-func initDatabase() error {
-	utils.LogInfo("Initializing database", nil)
-	if err := database.InitDatabase(); err != nil {
-		utils.LogError(err, "Error initializing database", nil)
+// initOIDCProviders sets the OpenID Connect identity providers users can log in with to the
+// ones configured for a
+func initOIDCProviders(ctx context.Context, a *app.App) error {
+	utils.LogInfoContext(ctx, "Initializing identity providers", nil)
+	providers, err := oidc.NewProviders(a.Config.OIDC, a.Config.Server.PublicURL)
+	if err != nil {
+		utils.LogErrorContext(ctx, err, "Error initializing identity providers", nil)
 		return err
 	}
-	utils.LogInfo("Database initialized successfully", nil)
+	a.OIDC = providers
+	utils.LogInfoContext(ctx, "OIDC providers initialized", logrus.Fields{
+		"message": len(providers.All()),
+	})
 	return nil
 }
 
-// startServer configures and starts the HTTP server
-func startServer() {
-	serverPort := config.AppConfig.Server.Port
-	utils.LogInfo("Starting server", logrus.Fields{
+// startBackgroundJobs starts the background jobs of a that run inside the backend process.
+// The jobs stop when ctx is cancelled, and wg is done once they have stopped.
+func startBackgroundJobs(ctx context.Context, wg *sync.WaitGroup, a *app.App) {
+	utils.LogInfoContext(ctx, "Starting background jobs", nil)
+	jobs.StartTokenCleanup(
+		ctx,
+		wg,
+		a.DB,
+		a.Guard,
+		a.Config.JWT.CleanupInterval,
+		a.Config.JWT.CleanupGracePeriod,
+	)
+	jobs.StartDataExportWorker(
+		ctx,
+		wg,
+		a.DB,
+		a.Mailer,
+		a.Config.JWT,
+		a.Config.Export.WorkerInterval,
+		a.Config.Export.LinkTTL,
+		a.Config.Server.PublicURL,
+	)
+}
+
+// runServer configures and starts the HTTP server and the background jobs of a, and shuts them
+// down gracefully on SIGINT or SIGTERM: the backend is marked not ready, in-flight requests
+// are drained, the background jobs are stopped, and the database connection is closed last.
+// SIGHUP reloads the configuration. Everything is logged with ctx, and the background jobs run
+// with it until the shutdown.
+//
+// Returns an error if the server fails to start or does not shut down cleanly.
+func runServer(ctx context.Context, a *app.App) error {
+	serverPort := a.Config.Server.Port
+	utils.LogInfoContext(ctx, "Starting server", logrus.Fields{
 		"port": serverPort,
 	})
 
-	utils.RegisterMetrics()
-    utils.ExposeMetrics()
+	utils.RegisterMetrics(ctx)
+	if err := database.RegisterPoolMetrics(a.DB, a.Config.Database.Name); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to register database pool metrics", nil)
+	}
+	utils.ExposeMetrics(ctx)
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", serverPort),
-		Handler:      api.NewRouter(),
+		Handler:      api.NewRouter(a),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  15 * time.Second,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		utils.LogFatal("Error starting server", logrus.Fields{
-			"error": err.Error(),
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	defer stopJobs()
+	var workers sync.WaitGroup
+	startBackgroundJobs(jobsCtx, &workers, a)
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	a.Readiness.SetReady(true)
+
+	for running := true; running; {
+		select {
+		case err := <-serverErr:
+			a.Readiness.SetReady(false)
+			stopJobs()
+			workers.Wait()
+			database.CloseDatabase(ctx, a.DB)
+			return fmt.Errorf("error starting server: %s", err)
+		case <-reloads:
+			reloadConfig(ctx, a)
+		case <-signals.Done():
+			running = false
+		}
+	}
+	// A second signal kills the process right away
+	stopSignals()
+
+	return shutdown(ctx, a, server, stopJobs, &workers)
+}
+
+// shutdown takes the backend out of rotation and drains in-flight requests within the configured
+// timeout. It then stops the background jobs and waits for the tasks started by the handlers,
+// which get the same timeout of their own, before it closes the database connection of a.
+// The steps are logged with ctx.
+func shutdown(ctx context.Context, a *app.App, server *http.Server, stopJobs context.CancelFunc, workers *sync.WaitGroup) error {
+	delay := a.Config.Server.ShutdownDelay
+	timeout := a.Config.Server.ShutdownTimeout
+	utils.LogInfoContext(ctx, "Shutting down", logrus.Fields{
+		"message": fmt.Sprintf("Draining requests after %s, within %s", delay, timeout),
+	})
+
+	a.Readiness.SetReady(false)
+	time.Sleep(delay)
+
+	serverCtx, cancelServer := context.WithTimeout(context.Background(), timeout)
+	defer cancelServer()
+
+	var shutdownErr error
+	if err := server.Shutdown(serverCtx); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to drain in-flight requests", nil)
+		shutdownErr = fmt.Errorf("error shutting down server: %s", err)
+	} else {
+		utils.LogInfoContext(ctx, "Server stopped", nil)
+	}
+
+	// The server may have used up its timeout, so the jobs get a deadline of their own
+	jobsCtx, cancelJobs := context.WithTimeout(context.Background(), timeout)
+	defer cancelJobs()
+
+	stopJobs()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		a.Tasks.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		utils.LogInfoContext(ctx, "Background jobs stopped", nil)
+	case <-jobsCtx.Done():
+		utils.LogWarnContext(ctx, "Background jobs did not stop in time", logrus.Fields{
+			"message": fmt.Sprintf("Closing the database after waiting %s for the background jobs", timeout),
 		})
 	}
+
+	if err := database.CloseDatabase(ctx, a.DB); err != nil {
+		utils.LogErrorContext(ctx, err, "Failed to close the database connection", nil)
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	utils.LogInfoContext(ctx, "Shutdown complete", nil)
+	return shutdownErr
 }
@@ -0,0 +1,210 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/oidc"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// MockOIDCUser is the account a user logs in with at the mock identity provider.
+// ExtraClaims are added to, or replace, the claims of the ID token, to test invalid tokens.
+type MockOIDCUser struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	ExtraClaims       jwt.MapClaims
+}
+
+// MockOIDCProvider is a minimal OpenID Connect identity provider for tests. It serves discovery,
+// the token endpoint and its signing keys, and checks the client credentials, redirect URI and
+// PKCE code verifier like a real provider.
+type MockOIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+// mockAuthorization is an authorization code issued by the mock provider
+type mockAuthorization struct {
+	user          MockOIDCUser
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// SetupMockOIDCProvider starts a mock identity provider and configures it as the only
+// identity provider of testApp, under the given name
+func SetupMockOIDCProvider(t *testing.T, testApp *app.App, name string) *MockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := &MockOIDCProvider{
+		Name:         name,
+		ClientID:     "whoknows-test",
+		ClientSecret: "test-client-secret",
+		key:          key,
+		codes:        map[string]mockAuthorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("/token", provider.token)
+	mux.HandleFunc("/jwks", provider.jwks)
+	server := httptest.NewServer(mux)
+	provider.Issuer = server.URL
+
+	testApp.Config.OIDC = config.OIDCConfig{
+		Providers: []config.OIDCProviderConfig{{
+			Name:         name,
+			DisplayName:  "Mock IdP",
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			Scopes:       []string{"openid", "email", "profile"},
+		}},
+		StateTTL: 10 * time.Minute,
+	}
+	providers, err := oidc.NewProviders(testApp.Config.OIDC, testApp.Config.Server.PublicURL)
+	require.NoError(t, err)
+	testApp.OIDC = providers
+
+	t.Cleanup(server.Close)
+	return provider
+}
+
+// Authorize plays the user logging in at the provider: it checks the authorization URL the
+// application sent the user to, and returns the code and state the provider redirects back with
+func (p *MockOIDCProvider) Authorize(t *testing.T, authorizationURL string, user MockOIDCUser) (string, string) {
+	parsed, err := url.Parse(authorizationURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	require.Equal(t, p.Issuer+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	require.Equal(t, "code", query.Get("response_type"))
+	require.Equal(t, p.ClientID, query.Get("client_id"))
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.NotEmpty(t, query.Get("code_challenge"))
+	require.NotEmpty(t, query.Get("state"))
+	require.NotEmpty(t, query.Get("nonce"))
+
+	code, err := security.GenerateRandomToken(16)
+	require.NoError(t, err)
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{
+		user:          user,
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   query.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	return code, query.Get("state")
+}
+
+// discovery serves the discovery document
+func (p *MockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// jwks serves the public signing key
+func (p *MockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "test-key",
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// token exchanges an authorization code for an ID token
+func (p *MockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		tokenError("invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError("invalid_request")
+		return
+	}
+
+	p.mu.Lock()
+	authorization, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !ok || authorization.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != authorization.codeChallenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.Issuer,
+		"sub":                authorization.user.Subject,
+		"aud":                p.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              authorization.nonce,
+		"email":              authorization.user.Email,
+		"email_verified":     authorization.user.EmailVerified,
+		"preferred_username": authorization.user.PreferredUsername,
+	}
+	for name, value := range authorization.user.ExtraClaims {
+		claims[name] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test-key"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
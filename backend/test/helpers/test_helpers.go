@@ -2,62 +2,125 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
 	"github.com/CEM-KEA/whoknows/backend/internal/config"
 	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/mail"
 	"github.com/CEM-KEA/whoknows/backend/internal/models"
 	"github.com/CEM-KEA/whoknows/backend/internal/security"
 	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
-// SetupLogger initializes the logger for testing
-func SetupLogger() {
-	// Initialize logger for tests with debug level and text format
-	utils.InitGlobalLogger("debug", "text")
+// SetupLogger creates a logger for testing
+func SetupLogger() *logrus.Logger {
+	// Log at debug level in text format in tests
+	return utils.NewLogger("debug", "text")
 }
 
-// SetupTestDB initializes the test database and seeds initial data
-func SetupTestDB(t *testing.T) {
+// SetupTestDB initializes the test database, seeds initial data and returns an App using it.
+// Every App gets its own configuration, which tests may change, and starts without failed
+// login attempts.
+func SetupTestDB(t *testing.T) *app.App {
 	utils.InitValidator()
 
-	config.AppConfig = config.Config{
+	cfg := config.Config{
 		JWT: config.JWTConfig{
 			Secret:     "testsecret",
 			Expiration: 3600,
 		},
 		Server: config.ServerConfig{
-			Port: 8080,
+			Port:      8080,
+			PublicURL: "http://localhost",
 		},
 		Environment: config.Environment{
 			Environment: "test",
 		},
+		Auth: config.AuthConfig{
+			PasswordResetTokenTTL: time.Hour,
+			EmailVerificationTTL:  time.Hour,
+		},
+		Export: config.ExportConfig{
+			LinkTTL: time.Hour,
+		},
+		APIKeys: config.APIKeyConfig{
+			RateLimit:  60,
+			MaxPerUser: 10,
+		},
+		WebAuthn: config.WebAuthnConfig{
+			RPName:       "WhoKnows",
+			ChallengeTTL: 5 * time.Minute,
+		},
+		Health: config.HealthConfig{
+			DatabaseTimeout: 2 * time.Second,
+		},
 	}
 
-	err := database.InitTestDatabase()
+	logger := SetupLogger()
+	db, err := database.InitTestDatabase(utils.WithLogger(context.Background(), logger))
 	if err != nil {
 		t.Fatalf("Failed to initialize the test database: %v", err)
 	}
 
 	fmt.Println("Test database initialized")
 
-	seedTestData(t)
+	seedTestData(t, db)
 
 	t.Cleanup(func() {
-		TeardownTestDB(t)
+		TeardownTestDB(t, db)
 	})
+
+	return app.New(&cfg, db, logger)
+}
+
+// SetupTestMailer replaces the mailer of testApp with a file mailer writing to a temporary
+// directory, and returns that directory so tests can inspect the sent emails
+func SetupTestMailer(t *testing.T, testApp *app.App) string {
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "test@whoknows.local")
+	if err != nil {
+		t.Fatalf("Failed to create test mailer: %v", err)
+	}
+
+	testApp.Mailer = mailer
+	return dir
+}
+
+// ReadSentEmails returns the contents of every email written by the test mailer
+func ReadSentEmails(t *testing.T, dir string) []string {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatalf("Failed to list sent emails: %v", err)
+	}
+
+	emails := make([]string, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read sent email: %v", err)
+		}
+		emails = append(emails, string(content))
+	}
+	return emails
 }
 
 // TeardownTestDB cleans up the test database
-func TeardownTestDB(t *testing.T) {
-	err := database.DB.Migrator().DropTable("users", "pages")
+func TeardownTestDB(t *testing.T, db *gorm.DB) {
+	err := db.Migrator().DropTable("users", "pages")
 	if err != nil {
 		t.Fatalf("Failed to drop tables: %v", err)
 	}
 
-	sqlDB, err := database.DB.DB()
+	sqlDB, err := db.DB()
 	if err != nil {
 		t.Fatalf("Failed to get the underlying SQL DB: %v", err)
 	}
@@ -71,13 +134,13 @@ func TeardownTestDB(t *testing.T) {
 }
 
 // SeedTestData seeds initial data into the test database
-func seedTestData(t *testing.T) {
+func seedTestData(t *testing.T, db *gorm.DB) {
 	hashedPassword, _ := security.HashPassword("password123")
 	testUser := models.User{
 		Username:     "testuser",
 		PasswordHash: hashedPassword,
 	}
-	err := database.DB.Create(&testUser).Error
+	err := db.Create(&testUser).Error
 	assert.NoError(t, err, "Failed to seed test data")
 
 	pages := []models.Page{
@@ -85,6 +148,6 @@ func seedTestData(t *testing.T) {
 		{Title: "Python Programming", Content: "Learn Python with examples.", Language: "en", Url: "/python-programming"},
 		{Title: "Danish Guide", Content: "Guide to Danish culture and language.", Language: "da", Url: "/danish-guide"},
 	}
-	err = database.DB.Create(&pages).Error
+	err = db.Create(&pages).Error
 	assert.NoError(t, err, "Failed to seed pages")
 }
@@ -0,0 +1,208 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// SoftwareAuthenticator is a passkey authenticator for tests. It creates P-256 credentials and
+// answers the options returned by the API the way a browser with a platform authenticator
+// answers navigator.credentials.create and navigator.credentials.get.
+type SoftwareAuthenticator struct {
+	// Origin is the origin of the page the browser reports in the client data
+	Origin string
+	// UserVerified sets the user verification flag, as after entering a PIN or using biometrics
+	UserVerified bool
+
+	credentials []*softwareCredential
+}
+
+// softwareCredential is a credential held by the software authenticator
+type softwareCredential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// NewSoftwareAuthenticator returns an authenticator without credentials that verifies the user
+func NewSoftwareAuthenticator(origin string) *SoftwareAuthenticator {
+	return &SoftwareAuthenticator{Origin: origin, UserVerified: true}
+}
+
+// Clone returns an authenticator with copies of the credentials, including their signature
+// counters, as an attacker who extracted the keys would have
+func (a *SoftwareAuthenticator) Clone() *SoftwareAuthenticator {
+	clone := &SoftwareAuthenticator{Origin: a.Origin, UserVerified: a.UserVerified}
+	for _, credential := range a.credentials {
+		copied := *credential
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return clone
+}
+
+// Register creates a credential for the "publicKey" creation options returned by the API and
+// returns the credential as the browser would send it, in the JSON form of PublicKeyCredential
+func (a *SoftwareAuthenticator) Register(t *testing.T, options map[string]interface{}) map[string]interface{} {
+	rp := options["rp"].(map[string]interface{})
+	user := options["user"].(map[string]interface{})
+	userHandle, err := base64.RawURLEncoding.DecodeString(user["id"].(string))
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credential := &softwareCredential{id: make([]byte, 32), key: key, rpID: rp["id"].(string), userHandle: userHandle}
+	_, err = rand.Read(credential.id)
+	require.NoError(t, err)
+	a.credentials = append(a.credentials, credential)
+
+	// Attested credential data: an all-zero AAGUID, the credential ID and the COSE key
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.PublicKey.X.FillBytes(x)
+	key.PublicKey.Y.FillBytes(y)
+	coseKey := cborHead(5, 5)
+	coseKey = append(coseKey, cborInt(1)...)
+	coseKey = append(coseKey, cborInt(2)...)
+	coseKey = append(coseKey, cborInt(3)...)
+	coseKey = append(coseKey, cborInt(-7)...)
+	coseKey = append(coseKey, cborInt(-1)...)
+	coseKey = append(coseKey, cborInt(1)...)
+	coseKey = append(coseKey, cborInt(-2)...)
+	coseKey = append(coseKey, cborBytes(x)...)
+	coseKey = append(coseKey, cborInt(-3)...)
+	coseKey = append(coseKey, cborBytes(y)...)
+
+	attested := make([]byte, 16, 16+2+len(credential.id)+len(coseKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(credential.id)))
+	attested = append(attested, credential.id...)
+	attested = append(attested, coseKey...)
+	authData := append(a.authenticatorData(credential, 0x40), attested...)
+
+	attestationObject := cborHead(5, 3)
+	attestationObject = append(attestationObject, cborText("fmt")...)
+	attestationObject = append(attestationObject, cborText("none")...)
+	attestationObject = append(attestationObject, cborText("attStmt")...)
+	attestationObject = append(attestationObject, cborHead(5, 0)...)
+	attestationObject = append(attestationObject, cborText("authData")...)
+	attestationObject = append(attestationObject, cborBytes(authData)...)
+
+	id := base64.RawURLEncoding.EncodeToString(credential.id)
+	return map[string]interface{}{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    a.clientData(t, "webauthn.create", options["challenge"].(string)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+			"transports":        []string{"internal"},
+		},
+	}
+}
+
+// Login signs the challenge of the "publicKey" request options returned by the API with the
+// first allowed credential, or any credential for the relying party if none are listed, and
+// returns the credential as the browser would send it
+func (a *SoftwareAuthenticator) Login(t *testing.T, options map[string]interface{}) map[string]interface{} {
+	allowed := map[string]bool{}
+	if list, ok := options["allowCredentials"].([]interface{}); ok {
+		for _, descriptor := range list {
+			allowed[descriptor.(map[string]interface{})["id"].(string)] = true
+		}
+	}
+
+	var credential *softwareCredential
+	for _, candidate := range a.credentials {
+		id := base64.RawURLEncoding.EncodeToString(candidate.id)
+		if candidate.rpID == options["rpId"] && (len(allowed) == 0 || allowed[id]) {
+			credential = candidate
+			break
+		}
+	}
+	require.NotNil(t, credential, "the authenticator has no credential for these options")
+
+	credential.signCount++
+	authData := a.authenticatorData(credential, 0)
+	clientDataJSON := a.clientData(t, "webauthn.get", options["challenge"].(string))
+	rawClientData, _ := base64.RawURLEncoding.DecodeString(clientDataJSON)
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, credential.key, digest[:])
+	require.NoError(t, err)
+
+	id := base64.RawURLEncoding.EncodeToString(credential.id)
+	return map[string]interface{}{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    clientDataJSON,
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(credential.userHandle),
+		},
+	}
+}
+
+// authenticatorData returns the RP ID hash, flags and signature counter, with the user present
+func (a *SoftwareAuthenticator) authenticatorData(credential *softwareCredential, flags byte) []byte {
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(credential.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, credential.signCount)
+}
+
+// clientData returns the base64url encoded client data JSON the browser would collect
+func (a *SoftwareAuthenticator) clientData(t *testing.T, ceremony, challenge string) string {
+	raw, err := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// cborHead encodes the initial bytes of a CBOR item
+func cborHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	}
+}
+
+// cborInt encodes a CBOR integer
+func cborInt(value int64) []byte {
+	if value < 0 {
+		return cborHead(1, uint64(-1-value))
+	}
+	return cborHead(0, uint64(value))
+}
+
+// cborBytes encodes a CBOR byte string
+func cborBytes(value []byte) []byte {
+	return append(cborHead(2, uint64(len(value))), value...)
+}
+
+// cborText encodes a CBOR text string
+func cborText(value string) []byte {
+	return append(cborHead(3, uint64(len(value))), value...)
+}
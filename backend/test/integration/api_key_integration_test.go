@@ -0,0 +1,143 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	hashedPassword, _ := security.HashPassword("admin-Password-1")
+	require.NoError(t, testApp.DB.Create(&models.User{Username: "adminuser", Email: "admin@example.com", PasswordHash: hashedPassword, Role: models.RoleAdmin}).Error)
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	login := func(username, password string) string {
+		rr, response := serve("POST", "/api/login", map[string]string{"username": username, "password": password}, "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		return response["token"].(string)
+	}
+
+	token := login("testuser", "password123")
+	adminToken := login("adminuser", "admin-Password-1")
+
+	rr, _ := serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "script", "scopes": []string{"search"}}, "", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "script", "scopes": []string{"everything"}}, token, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "script", "scopes": []string{"search"}, "rate_limit": 1000}, token, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "script", "scopes": []string{"admin"}}, token, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr, response := serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "script", "scopes": []string{"search"}, "rate_limit": 2}, token, "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	searchKey := response["key"].(string)
+	assert.True(t, strings.HasPrefix(searchKey, response["prefix"].(string)+"_"))
+	assert.Equal(t, float64(2), response["rate_limit"])
+	keyID := response["id"]
+
+	// Only the hash of the key is stored
+	var stored models.APIKey
+	require.NoError(t, testApp.DB.First(&stored).Error)
+	assert.Equal(t, security.HashToken(searchKey), stored.KeyHash)
+	assert.NotContains(t, stored.KeyHash, searchKey)
+	assert.Nil(t, stored.LastUsedAt)
+
+	// Searches with the key are recorded for its owner, up to the rate limit
+	rr, _ = serve("GET", "/api/search?q=test", nil, "", searchKey)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var searchLog models.SearchLog
+	require.NoError(t, testApp.DB.First(&searchLog).Error)
+	require.NotNil(t, searchLog.UserID)
+	assert.Equal(t, stored.UserID, *searchLog.UserID)
+
+	rr, _ = serve("GET", "/api/search?q=test", nil, "", searchKey)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+	rr, _ = serve("GET", "/api/search?q=test", nil, "", searchKey)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	require.NoError(t, testApp.DB.First(&stored, stored.ID).Error)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	rr, _ = serve("GET", "/api/search?q=test", nil, "", "wk_00000000_notakey")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("GET", "/api/search?q=test", nil, "", searchKey+"x")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// A search key can not be used for admin routes, even by an admin
+	rr, response = serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "admin search", "scopes": []string{"search"}}, adminToken, "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	rr, _ = serve("GET", "/api/admin/audit", nil, "", response["key"].(string))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr, response = serve("POST", "/api/me/api-keys", map[string]interface{}{"name": "reporting", "scopes": []string{"admin", "search"}}, adminToken, "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	adminKey := response["key"].(string)
+	assert.Equal(t, []interface{}{"admin", "search"}, response["scopes"])
+
+	rr, response = serve("GET", "/api/admin/audit?type="+models.AuditAPIKeyCreate, nil, "", adminKey)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, response["events"], 3)
+
+	// Listing never shows the key again
+	rr, _ = serve("GET", "/api/me/api-keys", nil, token, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var keys []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+	require.Len(t, keys, 1)
+	assert.Equal(t, "script", keys[0]["name"])
+	assert.NotNil(t, keys[0]["last_used_at"])
+	assert.NotContains(t, rr.Body.String(), searchKey)
+	assert.NotContains(t, rr.Body.String(), stored.KeyHash)
+
+	// Keys can only be managed with a session, and only by their owner
+	rr, _ = serve("GET", "/api/me/api-keys", nil, "", searchKey)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("DELETE", fmt.Sprintf("/api/me/api-keys/%v", keyID), nil, adminToken, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr, _ = serve("DELETE", fmt.Sprintf("/api/me/api-keys/%v", keyID), nil, token, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = serve("GET", "/api/search?q=test", nil, "", searchKey)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("DELETE", fmt.Sprintf("/api/me/api-keys/%v", keyID), nil, token, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr, response = serve("GET", "/api/admin/audit?type="+models.AuditAPIKeyRevoke, nil, adminToken, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, response["events"], 1)
+}
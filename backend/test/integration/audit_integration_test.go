@@ -0,0 +1,147 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/internal/services"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	hashedPassword, _ := security.HashPassword("admin-Password-1")
+	admin := models.User{Username: "adminuser", Email: "admin@example.com", PasswordHash: hashedPassword, Role: models.RoleAdmin}
+	require.NoError(t, testApp.DB.Create(&admin).Error)
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "audit-test")
+		req.RemoteAddr = "192.0.2.10:12345"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	login := func(username, password string) string {
+		rr, response := serve("POST", "/api/login", map[string]string{"username": username, "password": password}, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		return response["token"].(string)
+	}
+	auditLog := func(query url.Values, token string) (int, []interface{}) {
+		rr, response := serve("GET", "/api/admin/audit?"+query.Encode(), nil, token)
+		events, _ := response["events"].([]interface{})
+		return rr.Code, events
+	}
+
+	rr, _ := serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "wrongpassword"}, "")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("POST", "/api/login", map[string]string{"username": "nobody", "password": "wrongpassword"}, "")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	userToken := login("testuser", "password123")
+	adminToken := login("adminuser", "admin-Password-1")
+
+	rr, _ = serve("GET", "/api/logout", nil, userToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr, _ = serve("POST", "/api/change-password", map[string]string{
+		"username":            "testuser",
+		"old_password":        "password123",
+		"new_password":        "a-much-Better-passphrase-42",
+		"repeat_new_password": "a-much-Better-passphrase-42",
+	}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	require.NoError(t, services.SetUserRole(testApp.DB, services.CommandAuditActor, "testuser", models.RoleAdmin))
+
+	// Only admins can read the audit log
+	hashedPassword, _ = security.HashPassword("plain-Password-1")
+	require.NoError(t, testApp.DB.Create(&models.User{Username: "plainuser", Email: "plain@example.com", PasswordHash: hashedPassword}).Error)
+	plainToken := login("plainuser", "plain-Password-1")
+
+	code, _ := auditLog(url.Values{}, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = auditLog(url.Values{}, plainToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Changing your own password revokes your sessions
+	rr, _ = serve("POST", "/api/password", map[string]string{
+		"old_password":        "plain-Password-1",
+		"new_password":        "another-Good-passphrase-7",
+		"repeat_new_password": "another-Good-passphrase-7",
+	}, plainToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	code, events := auditLog(url.Values{"type": {models.AuditLoginFailure}}, adminToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, events, 2)
+	newest := events[0].(map[string]interface{})
+	assert.Equal(t, "nobody", newest["target"])
+	assert.Nil(t, newest["target_id"])
+	assert.Nil(t, newest["actor_id"])
+	assert.Equal(t, "192.0.2.10", newest["ip"])
+	assert.Equal(t, "audit-test", newest["user_agent"])
+	assert.Equal(t, "testuser", events[1].(map[string]interface{})["target"])
+
+	code, events = auditLog(url.Values{"user": {"testuser"}}, adminToken)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{
+		models.AuditRoleChange,
+		models.AuditTokenRevoke,
+		models.AuditPasswordChange,
+		models.AuditLogout,
+		models.AuditLoginSuccess,
+		models.AuditLoginFailure,
+	}, eventTypes(events))
+
+	roleChange := events[0].(map[string]interface{})
+	assert.Equal(t, "command", roleChange["actor"])
+	assert.Nil(t, roleChange["actor_id"])
+	assert.Equal(t, "user -> admin", roleChange["details"])
+
+	code, events = auditLog(url.Values{"user": {"plainuser"}, "type": {models.AuditTokenRevoke}}, adminToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, events, 1)
+	assert.Equal(t, "plainuser", events[0].(map[string]interface{})["actor"])
+
+	code, events = auditLog(url.Values{"type": {models.AuditLoginSuccess}, "limit": {"1"}, "offset": {"1"}}, adminToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, events, 1)
+
+	code, _ = auditLog(url.Values{"since": {"yesterday"}}, adminToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Events can not be changed or deleted
+	var event models.AuditEvent
+	require.NoError(t, testApp.DB.First(&event).Error)
+	assert.ErrorIs(t, testApp.DB.Model(&event).Update("details", "tampered").Error, models.ErrAuditEventImmutable)
+	assert.ErrorIs(t, testApp.DB.Delete(&event).Error, models.ErrAuditEventImmutable)
+}
+
+// eventTypes returns the types of audit events from an audit log response
+func eventTypes(events []interface{}) []string {
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.(map[string]interface{})["type"].(string))
+	}
+	return types
+}
@@ -0,0 +1,69 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBruteForceIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	router := api.NewRouter(testApp)
+	login := func(remoteAddr, username, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		req, _ := http.NewRequest("POST", "/api/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The free attempts are answered normally
+	for i := 0; i < 5; i++ {
+		rr := login("192.0.2.1:1000", "testuser", "wrongpassword")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+
+	// The next failure locks the username and the client IP
+	rr := login("192.0.2.1:1000", "testuser", "wrongpassword")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = login("192.0.2.1:1000", "testuser", "password123")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Positive(t, retryAfter)
+
+	// The username is locked from other addresses too
+	rr = login("198.51.100.2:1000", "testuser", "password123")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	// Other users from other addresses are unaffected
+	rr = login("198.51.100.2:1000", "nonexistent", "password123")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Changing the password is protected by the same lockout
+	body, _ := json.Marshal(map[string]string{
+		"username":            "testuser",
+		"old_password":        "password123",
+		"new_password":        "newpassword123",
+		"repeat_new_password": "newpassword123",
+	})
+	req, _ := http.NewRequest("POST", "/api/change-password", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "198.51.100.3:1000"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+}
@@ -0,0 +1,143 @@
+package integration_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/jobs"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataExportIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+	mailDir := helpers.SetupTestMailer(t, testApp)
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	rr, response := serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	token := response["token"].(string)
+
+	var user models.User
+	require.NoError(t, testApp.DB.Where("username = ?", "testuser").First(&user).Error)
+	require.NoError(t, testApp.DB.Create(&models.SearchLog{Query: "my secret search", UserID: &user.ID}).Error)
+
+	rr, _ = serve("POST", "/api/me/export", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("POST", "/api/me/export", map[string]string{"format": "pdf"}, token)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Only one export can be in progress at a time
+	rr, response = serve("POST", "/api/me/export", nil, token)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, models.DataExportPending, response["status"])
+	assert.Equal(t, models.DataExportFormatZIP, response["format"])
+	exportPath := fmt.Sprintf("/api/me/exports/%v", response["id"])
+
+	rr, _ = serve("POST", "/api/me/export", nil, token)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr, response = serve("GET", exportPath, nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, models.DataExportPending, response["status"])
+	assert.Nil(t, response["download_url"])
+
+	built, err := jobs.RunDataExports(testApp.DB, testApp.Mailer, testApp.Config.JWT, time.Hour, "http://localhost")
+	require.NoError(t, err)
+	assert.Equal(t, 1, built)
+
+	rr, response = serve("GET", exportPath, nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, models.DataExportReady, response["status"])
+	downloadURL := response["download_url"].(string)
+	require.True(t, strings.HasPrefix(downloadURL, "http://localhost/api/exports/"))
+
+	emails := helpers.ReadSentEmails(t, mailDir)
+	require.Len(t, emails, 1)
+	assert.Contains(t, emails[0], downloadURL)
+
+	// A tampered link is refused and does not use up the export
+	downloadPath := strings.TrimPrefix(downloadURL, "http://localhost")
+	rr, _ = serve("GET", downloadPath+"x", nil, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr, _ = serve("GET", downloadPath, nil, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(content)
+	}
+	assert.Contains(t, files["profile.json"], `"username": "testuser"`)
+	assert.NotContains(t, files["profile.json"], "password_hash")
+	assert.Contains(t, files["search_logs.json"], "my secret search")
+	assert.Contains(t, files["sessions.json"], `"expires_at"`)
+	assert.NotContains(t, files["sessions.json"], token)
+	assert.Equal(t, "[]\n", files["api_keys.json"])
+	assert.Contains(t, files["audit_events.json"], models.AuditLoginSuccess)
+
+	// The link only works once
+	rr, _ = serve("GET", downloadPath, nil, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	var export models.DataExport
+	require.NoError(t, testApp.DB.First(&export).Error)
+	assert.Equal(t, models.DataExportDownloaded, export.Status)
+	assert.Empty(t, export.Archive)
+
+	// A JSON export is a single document
+	rr, _ = serve("POST", "/api/me/export", map[string]string{"format": "json"}, token)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	_, err = jobs.RunDataExports(testApp.DB, testApp.Mailer, testApp.Config.JWT, time.Hour, "http://localhost")
+	require.NoError(t, err)
+
+	emails = helpers.ReadSentEmails(t, mailDir)
+	require.Len(t, emails, 2)
+	var jsonExport models.DataExport
+	require.NoError(t, testApp.DB.Where("format = ?", models.DataExportFormatJSON).First(&jsonExport).Error)
+
+	rr, response = serve("GET", fmt.Sprintf("/api/me/exports/%d", jsonExport.ID), nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, response = serve("GET", strings.TrimPrefix(response["download_url"].(string), "http://localhost"), nil, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "testuser", response["profile"].(map[string]interface{})["username"])
+	assert.Len(t, response["search_logs"], 1)
+
+	// Exports of other users are not visible
+	rr, _ = serve("GET", "/api/me/exports/999", nil, token)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
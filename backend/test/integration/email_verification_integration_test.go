@@ -0,0 +1,86 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var verificationLinkPattern = regexp.MustCompile(`(/api/verify-email\?token=[A-Za-z0-9_.-]+)`)
+
+func TestEmailVerificationIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+	mailDir := helpers.SetupTestMailer(t, testApp)
+	testApp.Config.Auth.RequireVerifiedEmail = true
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&body).Encode(payload)
+		}
+		req, _ := http.NewRequest(method, path, &body)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("POST", "/api/register", map[string]string{
+		"username": "verifyuser",
+		"email":    "verify@example.com",
+		"password": "password123",
+	}, "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	messages := helpers.ReadSentEmails(t, mailDir)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "To: verify@example.com")
+	match := verificationLinkPattern.FindStringSubmatch(messages[0])
+	require.Len(t, match, 2)
+	verificationPath := match[1]
+
+	// Unverified users cannot log in while verification is required
+	login := map[string]string{"username": "verifyuser", "password": "password123"}
+	rr = serve("POST", "/api/login", login, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Email address not verified")
+
+	rr = serve("GET", "/api/verify-email?token=not-a-token", nil, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serve("GET", verificationPath, nil, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Email verified successfully")
+
+	var user models.User
+	require.NoError(t, testApp.DB.Where("username = ?", "verifyuser").First(&user).Error)
+	assert.NotNil(t, user.VerifiedAt)
+
+	rr = serve("POST", "/api/login", login, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var loginResponse map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &loginResponse))
+	token, _ := loginResponse["token"].(string)
+
+	// Verified users get their searches recorded in their history
+	rr = serve("GET", "/api/search?q=Guide", nil, token)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var searchLog models.SearchLog
+	require.NoError(t, testApp.DB.Where("query = ?", "Guide").First(&searchLog).Error)
+	require.NotNil(t, searchLog.UserID)
+	assert.Equal(t, user.ID, *searchLog.UserID)
+}
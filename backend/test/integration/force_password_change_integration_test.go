@@ -0,0 +1,106 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForcePasswordChangeIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	hashedPassword, _ := security.HashPassword("admin-Password-1")
+	admin := models.User{Username: "adminuser", Email: "admin@example.com", PasswordHash: hashedPassword, Role: models.RoleAdmin}
+	require.NoError(t, testApp.DB.Create(&admin).Error)
+
+	router := api.NewRouter(testApp)
+	serve := func(path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	login := func(username, password string) (string, bool) {
+		rr, response := serve("/api/login", map[string]string{"username": username, "password": password}, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		return response["token"].(string), response["require_password_change"].(bool)
+	}
+
+	userToken, mustChange := login("testuser", "password123")
+	assert.False(t, mustChange)
+	adminToken, _ := login("adminuser", "admin-Password-1")
+
+	// Only admins can force a password change
+	force := map[string]interface{}{"usernames": []string{"testuser"}}
+	rr, _ := serve("/api/admin/force-password-change", force, userToken)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr, _ = serve("/api/admin/force-password-change", map[string]interface{}{}, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr, response := serve("/api/admin/force-password-change", force, adminToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, float64(1), response["flagged"])
+
+	// Existing sessions are revoked
+	rr, _ = serve("/api/2fa/enroll", nil, userToken)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// The next login only yields a token for changing the password
+	limitedToken, mustChange := login("testuser", "password123")
+	assert.True(t, mustChange)
+
+	rr, _ = serve("/api/2fa/enroll", nil, limitedToken)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr, _ = serve("/api/password", map[string]string{
+		"old_password":        "password123",
+		"new_password":        "rotated-Password-1",
+		"repeat_new_password": "rotated-Password-1",
+	}, limitedToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// The limited token is revoked, and the new password gives a full session
+	rr, _ = serve("/api/password", map[string]string{
+		"old_password":        "rotated-Password-1",
+		"new_password":        "another-Password-2",
+		"repeat_new_password": "another-Password-2",
+	}, limitedToken)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	userToken, mustChange = login("testuser", "rotated-Password-1")
+	assert.False(t, mustChange)
+	rr, _ = serve("/api/2fa/enroll", nil, userToken)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Flag everyone that has not changed their password in the last hour, which
+	// leaves out testuser who just did
+	since := time.Now().Add(-time.Hour)
+	rr, response = serve("/api/admin/force-password-change", map[string]interface{}{"not_changed_since": since}, adminToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, float64(1), response["flagged"])
+
+	var user, flaggedAdmin models.User
+	require.NoError(t, testApp.DB.Where("username = ?", "testuser").First(&user).Error)
+	assert.False(t, user.MustChangePassword)
+	require.NoError(t, testApp.DB.Where("username = ?", "adminuser").First(&flaggedAdmin).Error)
+	assert.True(t, flaggedAdmin.MustChangePassword)
+}
@@ -0,0 +1,79 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	router := api.NewRouter(testApp)
+	probe := func(path string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response
+	}
+
+	// The process is alive whether or not it is ready
+	testApp.Readiness.SetReady(false)
+	code, response := probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response["status"])
+	assert.Equal(t, false, response["maintenance"])
+
+	// Not ready until the server has started
+	code, response = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not ready", response["status"])
+
+	testApp.Readiness.SetReady(true)
+	code, response = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", response["status"])
+	checks := response["checks"].(map[string]interface{})
+	for _, name := range []string{"database", "migrations", "cache"} {
+		require.Contains(t, checks, name)
+		check := checks[name].(map[string]interface{})
+		assert.Equal(t, "up", check["status"], name)
+		assert.Contains(t, check, "latency_ms")
+	}
+	assert.Equal(t, true, checks["database"].(map[string]interface{})["critical"])
+	assert.Equal(t, false, checks["cache"].(map[string]interface{})["critical"])
+	// The weather provider is only checked when it has a timeout
+	assert.NotContains(t, checks, "weather")
+
+	// Maintenance mode is reported, but does not make the backend unready
+	testApp.Config.Server.MaintenanceMode = true
+	code, response = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response["maintenance"])
+	_, response = probe("/healthz")
+	assert.Equal(t, true, response["maintenance"])
+	testApp.Config.Server.MaintenanceMode = false
+
+	// A missing migration fails the readiness check
+	require.NoError(t, testApp.DB.Exec("DELETE FROM schema_migrations").Error)
+	code, response = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	checks = response["checks"].(map[string]interface{})
+	assert.Equal(t, "down", checks["migrations"].(map[string]interface{})["status"])
+	assert.Equal(t, "failed", checks["migrations"].(map[string]interface{})["error"])
+	assert.Equal(t, "up", checks["database"].(map[string]interface{})["status"])
+
+	// Not ready again as soon as the shutdown begins
+	testApp.Readiness.SetReady(false)
+	code, _ = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -13,10 +13,9 @@ import (
 )
 
 func TestLoginIntegration(t *testing.T) {
-	helpers.SetupLogger()
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
-	router := api.NewRouter()
+	router := api.NewRouter(testApp)
 
 	tests := []struct {
 		name           string
@@ -0,0 +1,180 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+	helpers.SetupTestMailer(t, testApp)
+	idp := helpers.SetupMockOIDCProvider(t, testApp, "mock")
+
+	hashedPassword, _ := security.HashPassword("alice-Password-1")
+	require.NoError(t, testApp.DB.Create(&models.User{Username: "alice", Email: "alice@example.com", PasswordHash: hashedPassword}).Error)
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	authorize := func(path, token string) string {
+		rr, response := serve("POST", path, nil, token)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return response["authorization_url"].(string)
+	}
+	callback := func(path, code, state, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return serve("POST", path, map[string]string{"code": code, "state": state}, token)
+	}
+	login := func(user helpers.MockOIDCUser) (*httptest.ResponseRecorder, map[string]interface{}) {
+		code, state := idp.Authorize(t, authorize("/api/oidc/mock/authorize", ""), user)
+		return callback("/api/oidc/mock/callback", code, state, "")
+	}
+
+	rr, _ := serve("GET", "/api/oidc/providers", nil, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"name":"mock","display_name":"Mock IdP"}]`, rr.Body.String())
+
+	rr, _ = serve("POST", "/api/oidc/unknown/authorize", nil, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// The authorization request uses PKCE and sends the user back to the frontend
+	authURL, err := url.Parse(authorize("/api/oidc/mock/authorize", ""))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost/oidc/callback", authURL.Query().Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", authURL.Query().Get("scope"))
+
+	// An unknown account gets a new user without a password
+	newcomer := helpers.MockOIDCUser{Subject: "sub-new", Email: "new@example.com", EmailVerified: true, PreferredUsername: "new comer!"}
+	code, state := idp.Authorize(t, authorize("/api/oidc/mock/authorize", ""), newcomer)
+	rr, response := callback("/api/oidc/mock/callback", code, state, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, response["token"])
+
+	var created models.User
+	require.NoError(t, testApp.DB.Where("email = ?", "new@example.com").First(&created).Error)
+	assert.Equal(t, "newcomer", created.Username)
+	assert.Empty(t, created.PasswordHash)
+	assert.NotNil(t, created.VerifiedAt)
+
+	// A state can only be used once
+	rr, _ = callback("/api/oidc/mock/callback", code, state, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// The next login finds the same user
+	rr, _ = login(newcomer)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var count int64
+	testApp.DB.Model(&models.User{}).Where("email = ?", "new@example.com").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// Accounts are never linked by email address
+	rr, _ = login(helpers.MockOIDCUser{Subject: "sub-other", Email: "ALICE@example.com", EmailVerified: true})
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// ID tokens with a wrong nonce or audience are refused
+	rr, _ = login(helpers.MockOIDCUser{Subject: "sub-new", Email: "new@example.com", ExtraClaims: jwt.MapClaims{"nonce": "replayed"}})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = login(helpers.MockOIDCUser{Subject: "sub-new", Email: "new@example.com", ExtraClaims: jwt.MapClaims{"aud": "another-client"}})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = login(helpers.MockOIDCUser{Subject: "sub-new", Email: "new@example.com", ExtraClaims: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// The code must be exchanged with the code verifier of its own authorization request
+	code, _ = idp.Authorize(t, authorize("/api/oidc/mock/authorize", ""), newcomer)
+	_, otherState := idp.Authorize(t, authorize("/api/oidc/mock/authorize", ""), newcomer)
+	rr, _ = callback("/api/oidc/mock/callback", code, otherState, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// An existing user links the provider, and can then log in with it
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "alice", "password": "alice-Password-1"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	aliceToken := response["token"].(string)
+	alice := helpers.MockOIDCUser{Subject: "sub-alice", Email: "alice@example.com", EmailVerified: true}
+
+	rr, _ = serve("POST", "/api/me/oidc/mock/authorize", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// A link can not be completed as a login
+	code, state = idp.Authorize(t, authorize("/api/me/oidc/mock/authorize", aliceToken), alice)
+	rr, _ = callback("/api/oidc/mock/callback", code, state, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	code, state = idp.Authorize(t, authorize("/api/me/oidc/mock/authorize", aliceToken), alice)
+	rr, response = callback("/api/me/oidc/mock/callback", code, state, aliceToken)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "Mock IdP", response["display_name"])
+	assert.Equal(t, "alice@example.com", response["email"])
+
+	rr, response = login(alice)
+	require.Equal(t, http.StatusOK, rr.Code)
+	claims, err := security.ValidateJWT(context.Background(), testApp.Config.JWT, response["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims["username"])
+
+	rr, _ = serve("GET", "/api/me/oidc", nil, aliceToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var identities []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &identities))
+	require.Len(t, identities, 1)
+	assert.Equal(t, "mock", identities[0]["provider"])
+	assert.NotNil(t, identities[0]["last_login_at"])
+
+	// A provider account can only be linked to one user
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	testToken := response["token"].(string)
+	code, state = idp.Authorize(t, authorize("/api/me/oidc/mock/authorize", testToken), alice)
+	rr, _ = callback("/api/me/oidc/mock/callback", code, state, testToken)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// Users with two-factor authentication still need their second factor
+	now := time.Now()
+	require.NoError(t, testApp.DB.Model(&models.User{}).Where("username = ?", "alice").Updates(map[string]interface{}{"totp_secret": "JBSWY3DPEHPK3PXP", "totp_enabled_at": now}).Error)
+	rr, response = login(alice)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, "mfa_required", response["status"])
+
+	// Users without a password can not unlink their only provider
+	rr, response = login(newcomer)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = serve("DELETE", "/api/me/oidc/mock", nil, response["token"].(string))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr, _ = serve("DELETE", "/api/me/oidc/mock", nil, aliceToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = serve("DELETE", "/api/me/oidc/mock", nil, aliceToken)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	var events []models.AuditEvent
+	require.NoError(t, testApp.DB.Where("type IN ?", []string{models.AuditOIDCLink, models.AuditOIDCUnlink}).Order("id").Find(&events).Error)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.AuditOIDCLink, events[0].Type)
+	assert.Equal(t, "mock", events[0].Details)
+	var success models.AuditEvent
+	require.NoError(t, testApp.DB.Where("type = ? AND details = ?", models.AuditLoginSuccess, "oidc mock").First(&success).Error)
+}
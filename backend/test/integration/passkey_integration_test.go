@@ -0,0 +1,195 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasskeyIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	options := func(path string, payload interface{}, token string) map[string]interface{} {
+		rr, response := serve("POST", path, payload, token)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		return response["publicKey"].(map[string]interface{})
+	}
+	register := func(authenticator *helpers.SoftwareAuthenticator, name, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		credential := authenticator.Register(t, options("/api/me/passkeys/register/begin", nil, token))
+		return serve("POST", "/api/me/passkeys/register/finish", map[string]interface{}{"name": name, "credential": credential}, token)
+	}
+	passkeyLogin := func(authenticator *helpers.SoftwareAuthenticator) (*httptest.ResponseRecorder, map[string]interface{}) {
+		credential := authenticator.Login(t, options("/api/login/passkey/begin", nil, ""))
+		return serve("POST", "/api/login/passkey/finish", map[string]interface{}{"credential": credential}, "")
+	}
+
+	rr, response := serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	token := response["token"].(string)
+
+	// Registration options are bound to the public URL and exclude existing passkeys
+	creation := options("/api/me/passkeys/register/begin", nil, token)
+	assert.Equal(t, "localhost", creation["rp"].(map[string]interface{})["id"])
+	assert.Equal(t, "none", creation["attestation"])
+	assert.Empty(t, creation["excludeCredentials"])
+
+	phone := helpers.NewSoftwareAuthenticator("http://localhost")
+	credential := phone.Register(t, creation)
+	rr, response = serve("POST", "/api/me/passkeys/register/finish", map[string]interface{}{"name": "Phone", "credential": credential}, token)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "Phone", response["name"])
+
+	// A registration response can only be used once
+	rr, _ = serve("POST", "/api/me/passkeys/register/finish", map[string]interface{}{"name": "Phone", "credential": credential}, token)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Responses from another origin are refused
+	rr, _ = register(helpers.NewSoftwareAuthenticator("https://evil.example"), "Evil", token)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// A user can have several authenticators
+	securityKey := helpers.NewSoftwareAuthenticator("http://localhost")
+	securityKey.UserVerified = false
+	rr, _ = register(securityKey, "Security key", token)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	creation = options("/api/me/passkeys/register/begin", nil, token)
+	assert.Len(t, creation["excludeCredentials"], 2)
+
+	rr, _ = serve("GET", "/api/me/passkeys", nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var passkeys []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &passkeys))
+	require.Len(t, passkeys, 2)
+	assert.Equal(t, "Phone", passkeys[0]["name"])
+
+	// Passwordless login lets the browser pick a passkey and requires user verification
+	request := options("/api/login/passkey/begin", nil, "")
+	assert.Equal(t, "required", request["userVerification"])
+	assert.Empty(t, request["allowCredentials"])
+	assertion := phone.Login(t, request)
+	rr, response = serve("POST", "/api/login/passkey/finish", map[string]interface{}{"credential": assertion}, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	claims, err := security.ValidateJWT(context.Background(), testApp.Config.JWT, response["token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", claims["username"])
+
+	// An assertion can not be replayed
+	rr, _ = serve("POST", "/api/login/passkey/finish", map[string]interface{}{"credential": assertion}, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr, _ = passkeyLogin(securityKey)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// A tampered signature is refused
+	assertion = phone.Login(t, options("/api/login/passkey/begin", nil, ""))
+	assertion["response"].(map[string]interface{})["signature"] = assertion["response"].(map[string]interface{})["authenticatorData"]
+	rr, _ = serve("POST", "/api/login/passkey/finish", map[string]interface{}{"credential": assertion}, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// A cloned authenticator is detected by its signature counter
+	clone := phone.Clone()
+	rr, _ = passkeyLogin(phone)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = passkeyLogin(clone)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Registering passkeys does not change the password login
+	rr, _ = serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Once opted in, a password login needs a passkey as second factor
+	rr, _ = serve("PUT", "/api/me/passkeys/second-factor", map[string]interface{}{}, token)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = serve("PUT", "/api/me/passkeys/second-factor", map[string]interface{}{"enabled": true}, token)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, []interface{}{"passkey"}, response["methods"])
+	mfaToken := response["mfa_token"].(string)
+
+	request = options("/api/login/2fa/passkey/begin", map[string]string{"mfa_token": mfaToken}, "")
+	assert.Len(t, request["allowCredentials"], 2)
+	assertion = securityKey.Login(t, request)
+	rr, response = serve("POST", "/api/login/2fa/passkey/finish", map[string]interface{}{"mfa_token": mfaToken, "credential": assertion}, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotEmpty(t, response["token"])
+
+	// Passkeys of another user can not complete the second factor
+	hashedPassword, _ := security.HashPassword("alice-Password-1")
+	alice := models.User{Username: "alice", Email: "alice@example.com", PasswordHash: hashedPassword}
+	require.NoError(t, testApp.DB.Create(&alice).Error)
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "alice", "password": "alice-Password-1"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	aliceToken := response["token"].(string)
+	aliceKey := helpers.NewSoftwareAuthenticator("http://localhost")
+	rr, _ = serve("PUT", "/api/me/passkeys/second-factor", map[string]interface{}{"enabled": true}, aliceToken)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "no passkeys registered yet")
+	rr, _ = register(aliceKey, "Laptop", aliceToken)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	rr, _ = serve("PUT", "/api/me/passkeys/second-factor", map[string]interface{}{"enabled": true}, aliceToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "alice", "password": "alice-Password-1"}, "")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	aliceMFAToken := response["mfa_token"].(string)
+	request = options("/api/login/2fa/passkey/begin", map[string]string{"mfa_token": aliceMFAToken}, "")
+	delete(request, "allowCredentials")
+	assertion = phone.Login(t, request)
+	rr, _ = serve("POST", "/api/login/2fa/passkey/finish", map[string]interface{}{"mfa_token": aliceMFAToken, "credential": assertion}, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Challenges are bound to the user of the challenge token
+	assertion = phone.Login(t, options("/api/login/2fa/passkey/begin", map[string]string{"mfa_token": mfaToken}, ""))
+	rr, _ = serve("POST", "/api/login/2fa/passkey/finish", map[string]interface{}{"mfa_token": aliceMFAToken, "credential": assertion}, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Removing passkeys
+	rr, _ = serve("DELETE", fmt.Sprintf("/api/me/passkeys/%v", passkeys[1]["id"]), nil, aliceToken)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr, _ = serve("DELETE", fmt.Sprintf("/api/me/passkeys/%v", passkeys[1]["id"]), nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Users without a password can not remove their only passkey
+	require.NoError(t, testApp.DB.Model(&models.User{}).Where("id = ?", alice.ID).Update("password_hash", "").Error)
+	rr, response = passkeyLogin(aliceKey)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = serve("GET", "/api/me/passkeys", nil, response["token"].(string))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &passkeys))
+	require.Len(t, passkeys, 1)
+	rr, _ = serve("DELETE", fmt.Sprintf("/api/me/passkeys/%v", passkeys[0]["id"]), nil, response["token"].(string))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	var events []models.AuditEvent
+	require.NoError(t, testApp.DB.Where("type IN ?", []string{models.AuditPasskeyAdd, models.AuditPasskeyRemove}).Order("id").Find(&events).Error)
+	require.Len(t, events, 4)
+	assert.Equal(t, "Phone", events[0].Details)
+	assert.Equal(t, models.AuditPasskeyRemove, events[3].Type)
+	var success models.AuditEvent
+	assert.NoError(t, testApp.DB.Where("type = ? AND details = ?", models.AuditLoginSuccess, "passkey").First(&success).Error)
+}
@@ -0,0 +1,104 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubBreachedList is a breached password list holding a fixed set of passwords
+type stubBreachedList map[string]bool
+
+func (l stubBreachedList) Contains(password string) (bool, error) {
+	return l[password], nil
+}
+
+func TestPasswordPolicyIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	testApp.PasswordPolicy = &security.PasswordPolicy{
+		MinLength:      8,
+		MinEntropyBits: 40,
+		HistorySize:    3,
+		Breached:       stubBreachedList{"letmein-please-2024": true},
+	}
+
+	router := api.NewRouter(testApp)
+	postJSON := func(path string, payload interface{}) (*httptest.ResponseRecorder, []string) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response struct {
+			Violations []security.PasswordViolation `json:"violations"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, violatedRules(response.Violations)
+	}
+	register := func(password string) (*httptest.ResponseRecorder, []string) {
+		return postJSON("/api/register", map[string]string{
+			"username": "policyuser",
+			"email":    "policy@example.com",
+			"password": password,
+		})
+	}
+	changePassword := func(oldPassword, newPassword string) (*httptest.ResponseRecorder, []string) {
+		return postJSON("/api/change-password", map[string]string{
+			"username":            "testuser",
+			"old_password":        oldPassword,
+			"new_password":        newPassword,
+			"repeat_new_password": newPassword,
+		})
+	}
+
+	// Every failed rule is listed
+	rr, rules := register("policy")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.ElementsMatch(t, []string{"min_length", "entropy", "contains_email"}, rules)
+
+	rr, rules = register("letmein-please-2024")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []string{"breached"}, rules)
+
+	rr, _ = register("correct horse battery")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// The current password and the recent history can not be reused
+	rr, rules = changePassword("password123", "password123")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []string{"reused"}, rules)
+
+	rr, _ = changePassword("password123", "second-Password-1")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = changePassword("second-Password-1", "third-Password-2")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr, rules = changePassword("third-Password-2", "password123")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []string{"reused"}, rules)
+
+	// Passwords older than the history size are allowed again
+	rr, _ = changePassword("third-Password-2", "fourth-Password-3")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = changePassword("fourth-Password-3", "password123")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+// violatedRules returns the rule names of the violations
+func violatedRules(violations []security.PasswordViolation) []string {
+	rules := []string{}
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
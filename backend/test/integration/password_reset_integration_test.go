@@ -0,0 +1,91 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var resetTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func TestPasswordResetIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+	mailDir := helpers.SetupTestMailer(t, testApp)
+
+	hashedPassword, _ := security.HashPassword("oldpassword")
+	user := models.User{Username: "resetuser", Email: "reset@example.com", PasswordHash: hashedPassword}
+	require.NoError(t, testApp.DB.Create(&user).Error)
+
+	router := api.NewRouter(testApp)
+	postJSON := func(path string, payload interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Unknown emails get the same response and no email is sent
+	rr := postJSON("/api/password-reset/request", map[string]string{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	unknownBody := rr.Body.String()
+	testApp.Tasks.Wait()
+	assert.Empty(t, helpers.ReadSentEmails(t, mailDir))
+
+	rr = postJSON("/api/password-reset/request", map[string]string{"email": "reset@example.com"})
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, unknownBody, rr.Body.String())
+
+	testApp.Tasks.Wait()
+	emails := helpers.ReadSentEmails(t, mailDir)
+	require.Len(t, emails, 1)
+	assert.Contains(t, emails[0], "To: reset@example.com")
+	match := resetTokenPattern.FindStringSubmatch(emails[0])
+	require.Len(t, match, 2)
+	token := match[1]
+
+	// The stored token is hashed
+	var stored models.PasswordResetToken
+	require.NoError(t, testApp.DB.Where("user_id = ?", user.ID).First(&stored).Error)
+	assert.NotEqual(t, token, stored.TokenHash)
+
+	// A password that fails the policy does not use up the token
+	rr = postJSON("/api/password-reset/confirm", map[string]string{
+		"token":               token,
+		"new_password":        "short",
+		"repeat_new_password": "short",
+	})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "min_length")
+
+	confirm := map[string]string{
+		"token":               token,
+		"new_password":        "newpassword",
+		"repeat_new_password": "newpassword",
+	}
+	rr = postJSON("/api/password-reset/confirm", confirm)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Password reset successfully")
+
+	// The token is single-use
+	rr = postJSON("/api/password-reset/confirm", confirm)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid or expired reset token")
+
+	// The new password works and the old one does not
+	rr = postJSON("/api/login", map[string]string{"username": "resetuser", "password": "newpassword"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = postJSON("/api/login", map[string]string{"username": "resetuser", "password": "oldpassword"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
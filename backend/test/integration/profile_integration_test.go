@@ -0,0 +1,152 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+	mailDir := helpers.SetupTestMailer(t, testApp)
+
+	hashedPassword, _ := security.HashPassword("other-Password-1")
+	require.NoError(t, testApp.DB.Create(&models.User{Username: "otheruser", Email: "other@example.com", PasswordHash: hashedPassword}).Error)
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	rr, response := serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	token := response["token"].(string)
+
+	rr, _ = serve("GET", "/api/me", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr, response = serve("GET", "/api/me", nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "testuser", response["username"])
+	assert.Equal(t, false, response["two_factor_enabled"])
+	assert.NotEmpty(t, response["created_at"])
+	assert.NotContains(t, rr.Body.String(), "password")
+
+	// Changes require the current password and unique values
+	rr, _ = serve("PATCH", "/api/me", map[string]string{"username": "renamed", "password": "wrongpassword"}, token)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("PATCH", "/api/me", map[string]string{"username": "otheruser", "password": "password123"}, token)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr, _ = serve("PATCH", "/api/me", map[string]string{"email": "OTHER@example.com", "password": "password123"}, token)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// A new email address has to be verified
+	rr, response = serve("PATCH", "/api/me", map[string]string{"username": "renamed", "email": "renamed@example.com", "password": "password123"}, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "renamed", response["username"])
+	assert.Equal(t, "renamed@example.com", response["email"])
+	assert.Equal(t, false, response["email_verified"])
+
+	emails := helpers.ReadSentEmails(t, mailDir)
+	require.Len(t, emails, 1)
+	assert.Contains(t, emails[0], "To: renamed@example.com")
+
+	// Deleting the account removes the user and everything linked to them
+	var user models.User
+	require.NoError(t, testApp.DB.Where("username = ?", "renamed").First(&user).Error)
+	require.NoError(t, testApp.DB.Create(&models.SearchLog{Query: "secret", UserID: &user.ID}).Error)
+
+	rr, _ = serve("DELETE", "/api/me", map[string]string{"password": "wrongpassword"}, token)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("DELETE", "/api/me", map[string]string{"password": "password123"}, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var count int64
+	testApp.DB.Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+	testApp.DB.Model(&models.JWT{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+	testApp.DB.Model(&models.SearchLog{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+
+	rr, _ = serve("GET", "/api/me", nil, token)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestPasswordlessAccountConfirmation(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	// Users that signed up with an identity provider or a passkey have no password
+	user := models.User{Username: "oidcuser", Email: "oidc@example.com"}
+	require.NoError(t, testApp.DB.Create(&user).Error)
+	session := func(loggedIn time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss": "whoknows",
+			"sub": user.ID,
+			"aud": "whoknows",
+			"iat": loggedIn.Unix(),
+			"exp": loggedIn.Add(24 * time.Hour).Unix(),
+		}).SignedString([]byte(testApp.Config.JWT.Secret))
+		require.NoError(t, err)
+		require.NoError(t, testApp.DB.Create(&models.JWT{UserID: user.ID, Token: token, ExpiresAt: loggedIn.Add(24 * time.Hour)}).Error)
+		return token
+	}
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// An old login does not confirm changes, and the attempts do not lock the account
+	staleToken := session(time.Now().Add(-time.Hour))
+	for i := 0; i < 10; i++ {
+		rr := serve("PATCH", "/api/me", map[string]string{"username": "renamed"}, staleToken)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "log in again")
+	}
+	rr := serve("DELETE", "/api/me", map[string]string{}, staleToken)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// A recent login does
+	freshToken := session(time.Now())
+	rr = serve("PATCH", "/api/me", map[string]string{"username": "renamed"}, freshToken)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// and lets the user set a password
+	rr = serve("POST", "/api/password", map[string]string{
+		"new_password":        "a-first-Real-passphrase-9",
+		"repeat_new_password": "a-first-Real-passphrase-9",
+	}, freshToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, testApp.DB.First(&user, user.ID).Error)
+	assert.True(t, security.CheckPasswordHash(context.Background(), "a-first-Real-passphrase-9", user.PasswordHash))
+}
@@ -0,0 +1,89 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	testApp.Config.RateLimit = config.RateLimitConfig{
+		Enabled:             true,
+		Store:               "memory",
+		Search:              config.Rate{Requests: 2, Period: time.Minute},
+		SearchAuthenticated: config.Rate{Requests: 3, Period: time.Minute},
+		Register:            config.Rate{Requests: 2, Period: time.Hour},
+	}
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, token, remoteAddr string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Register allows two requests per hour from an IP, whatever their outcome
+	register := map[string]string{"username": "ratelimited", "email": "ratelimited@example.com", "password": "password123", "password2": "password456"}
+	rr := serve("POST", "/api/register", register, "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1800", rr.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=3600", rr.Header().Get("RateLimit-Policy"))
+
+	rr = serve("POST", "/api/register", register, "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+
+	rr = serve("POST", "/api/register", register, "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1800", rr.Header().Get("Retry-After"))
+
+	// Another IP has its own bucket
+	rr = serve("POST", "/api/register", register, "", "198.51.100.2:1234")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Login is not limited, so it has no rate limit headers
+	rr = serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, "", "192.0.2.1:1234")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	token := response["token"].(string)
+
+	// Anonymous searches are counted per IP, signed in searches per user at their own rate
+	for i := 0; i < 2; i++ {
+		rr = serve("GET", "/api/search?q=test", nil, "", "192.0.2.1:1234")
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	rr = serve("GET", "/api/search?q=test", nil, "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+
+	for i := 0; i < 3; i++ {
+		rr = serve("GET", "/api/search?q=test", nil, token, "192.0.2.1:1234")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "3", rr.Header().Get("RateLimit-Limit"))
+	}
+	rr = serve("GET", "/api/search?q=test", nil, token, "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "20", rr.Header().Get("Retry-After"))
+}
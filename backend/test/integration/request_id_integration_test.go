@@ -0,0 +1,36 @@
+package integration_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	router := api.NewRouter(testApp)
+	serve := func(requestID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/search?q=programming", nil)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Every response carries a request ID
+	rr := serve("")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+
+	// A request ID set by the proxy is kept
+	rr = serve("proxy-request-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "proxy-request-1", rr.Header().Get("X-Request-ID"))
+}
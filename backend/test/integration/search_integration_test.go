@@ -11,10 +11,9 @@ import (
 )
 
 func TestSearchIntegration(t *testing.T) {
-	helpers.SetupLogger()
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
-	router := api.NewRouter()
+	router := api.NewRouter(testApp)
 
 	tests := []struct {
 		name            string
@@ -0,0 +1,120 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionCookieIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+	testApp.Config.Session = config.SessionConfig{Mode: config.SessionModeCookie, CookieSecure: true, CookieSameSite: "lax"}
+
+	router := api.NewRouter(testApp)
+	serve := func(method, path string, payload interface{}, cookies []*http.Cookie, headers map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	findCookie := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// Logging in sets an HttpOnly session cookie and keeps the token out of the body
+	rr, response := serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, response, "token")
+	csrfToken, _ := response["csrf_token"].(string)
+	require.NotEmpty(t, csrfToken)
+
+	session := findCookie(rr, middlewares.SessionCookieName)
+	require.NotNil(t, session)
+	assert.True(t, session.HttpOnly)
+	assert.True(t, session.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+	csrfCookie := findCookie(rr, middlewares.CSRFCookieName)
+	require.NotNil(t, csrfCookie)
+	assert.False(t, csrfCookie.HttpOnly)
+	assert.Equal(t, csrfToken, csrfCookie.Value)
+	cookies := []*http.Cookie{session, csrfCookie}
+
+	rr, _ = serve("GET", "/api/me", nil, cookies, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr, response = serve("GET", "/api/validate-login", nil, cookies, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "valid", response["status"])
+
+	// State-changing requests with the cookie need the CSRF token of the session
+	update := map[string]string{"username": "renamed", "password": "password123"}
+	rr, _ = serve("PATCH", "/api/me", update, cookies, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr, _ = serve("PATCH", "/api/me", update, cookies, map[string]string{middlewares.CSRFHeader: "forged"})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr, _ = serve("PATCH", "/api/me", update, cookies, map[string]string{middlewares.CSRFHeader: csrfToken})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Logging out with the cookie needs the CSRF token too, even over GET
+	rr, _ = serve("GET", "/api/logout", nil, cookies, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr, _ = serve("POST", "/api/logout", nil, cookies, map[string]string{middlewares.CSRFHeader: csrfToken})
+	require.Equal(t, http.StatusOK, rr.Code)
+	cleared := findCookie(rr, middlewares.SessionCookieName)
+	require.NotNil(t, cleared)
+	assert.Empty(t, cleared.Value)
+	assert.Equal(t, -1, cleared.MaxAge)
+
+	rr, _ = serve("GET", "/api/me", nil, cookies, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// In "both" mode API clients still get a token, and Bearer auth needs no CSRF token
+	testApp.Config.Session.Mode = config.SessionModeBoth
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "renamed", "password": "password123"}, nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	token, _ := response["token"].(string)
+	require.NotEmpty(t, token)
+	bothSession := findCookie(rr, middlewares.SessionCookieName)
+	require.NotNil(t, bothSession)
+	bearer := map[string]string{"Authorization": "Bearer " + token}
+	rr, _ = serve("PATCH", "/api/me", map[string]string{"username": "testuser", "password": "password123"}, nil, bearer)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The default bearer mode sets no cookies, and ignores a session cookie that is sent anyway
+	testApp.Config.Session.Mode = config.SessionModeBearer
+	router = api.NewRouter(testApp)
+	rr, _ = serve("GET", "/api/me", nil, []*http.Cookie{bothSession}, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("GET", "/api/validate-login", nil, []*http.Cookie{bothSession}, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr, response = serve("POST", "/api/login", map[string]string{"username": "testuser", "password": "password123"}, nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, response["token"])
+	assert.NotContains(t, response, "csrf_token")
+	assert.Empty(t, rr.Result().Cookies())
+}
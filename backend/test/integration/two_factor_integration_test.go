@@ -0,0 +1,97 @@
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactorIntegration(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	router := api.NewRouter(testApp)
+	serve := func(path string, payload interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+	login := map[string]string{"username": "testuser", "password": "password123"}
+
+	rr, response := serve("/api/login", login, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	token := response["token"].(string)
+
+	// Enrollment requires authentication
+	rr, _ = serve("/api/2fa/enroll", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr, response = serve("/api/2fa/enroll", nil, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	secret := response["secret"].(string)
+	assert.Contains(t, response["otpauth_uri"], "otpauth://totp/WhoKnows:testuser")
+
+	rr, _ = serve("/api/2fa/confirm", map[string]string{"code": "000000"}, token)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	code, _ := security.GenerateTOTPCode(secret, time.Now())
+	rr, response = serve("/api/2fa/confirm", map[string]string{"code": code}, token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	recoveryCodes := response["recovery_codes"].([]interface{})
+	assert.Len(t, recoveryCodes, 10)
+
+	// The password alone now only yields a challenge
+	rr, response = serve("/api/login", login, "")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, "mfa_required", response["status"])
+	assert.Nil(t, response["token"])
+	challenge := response["mfa_token"].(string)
+
+	// The challenge is not a session token
+	rr, _ = serve("/api/2fa/enroll", nil, challenge)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// The code used for enrollment can not be replayed
+	rr, _ = serve("/api/login/2fa", map[string]string{"mfa_token": challenge, "code": code}, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	nextCode, _ := security.GenerateTOTPCode(secret, time.Now().Add(30*time.Second))
+	rr, response = serve("/api/login/2fa", map[string]string{"mfa_token": challenge, "code": nextCode}, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, response["token"])
+
+	// Recovery codes work once
+	recovery := map[string]string{"mfa_token": challenge, "recovery_code": recoveryCodes[0].(string)}
+	rr, response = serve("/api/login/2fa", recovery, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	token = response["token"].(string)
+	rr, _ = serve("/api/login/2fa", recovery, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Disabling requires the password
+	rr, _ = serve("/api/2fa/disable", map[string]string{"password": "wrongpassword"}, token)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, _ = serve("/api/2fa/disable", map[string]string{"password": "password123"}, token)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr, response = serve("/api/login", login, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, response["token"])
+}
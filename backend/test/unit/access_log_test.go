@@ -0,0 +1,93 @@
+package unit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRequestIDMiddleware tests that a valid X-Request-ID is kept, that a missing or unsafe
+// one is replaced, and that the ID is returned and put in the request context
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := middlewares.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = utils.RequestIDFromContext(r.Context())
+	}))
+
+	serve := func(requestID string) string {
+		req := httptest.NewRequest("GET", "/api/search", nil)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, seen, rec.Header().Get("X-Request-ID"))
+		return seen
+	}
+
+	assert.Equal(t, "3f2a9c1e-5b7d-4e2f-9a6b-1c8d0e4f7a2b", serve("3f2a9c1e-5b7d-4e2f-9a6b-1c8d0e4f7a2b"))
+
+	generated := serve("")
+	assert.Regexp(t, `^[A-Za-z0-9_-]{22}$`, generated)
+	assert.NotEqual(t, generated, serve(""), "every request gets its own ID")
+
+	unsafe := serve("<script>\nalert(1)")
+	assert.Regexp(t, `^[A-Za-z0-9_-]{22}$`, unsafe)
+}
+
+// TestAccessLog tests that a request is logged in one line with its route, status, size and
+// user, and that the entries logged while serving it are tagged with its request ID
+func TestAccessLog(t *testing.T) {
+	logger := helpers.SetupLogger()
+	hook := logtest.NewLocal(logger)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/pages/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		// Stand in for the authentication middleware, which sets the user on a derived context
+		ctx := context.WithValue(r.Context(), middlewares.UserKey, uint(7))
+		utils.SetLogUserID(ctx, 7)
+		utils.LogInfoContext(ctx, "Serving page", nil)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("page"))
+	}).Methods("GET")
+	handler := middlewares.LoggerMiddleware(logger)(middlewares.RequestIDMiddleware(middlewares.AccessLogMiddleware(router)(router)))
+
+	req := httptest.NewRequest("GET", "/api/pages/42?token=secret", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Serving page", entries[0].Message)
+	assert.Equal(t, "req-1", entries[0].Data["request_id"])
+
+	access := entries[1]
+	assert.Equal(t, logrus.InfoLevel, access.Level)
+	assert.Equal(t, "req-1", access.Data["request_id"])
+	assert.Equal(t, uint(7), access.Data["user_id"])
+	assert.Equal(t, "GET", access.Data["method"])
+	assert.Equal(t, "/api/pages/{id:[0-9]+}", access.Data["route"])
+	assert.Equal(t, "201", access.Data["status"])
+	assert.Equal(t, "4", access.Data["bytes"])
+	assert.Contains(t, access.Data, "latency_ms")
+	for _, value := range access.Data {
+		assert.NotContains(t, fmt.Sprint(value), "secret", "the path and query are not logged")
+	}
+
+	// The probes are only logged at debug level
+	hook.Reset()
+	logger.SetLevel(logrus.InfoLevel)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	assert.Empty(t, hook.AllEntries())
+}
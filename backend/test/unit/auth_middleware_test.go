@@ -9,19 +9,14 @@ import (
 	"testing"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
 	"github.com/CEM-KEA/whoknows/backend/internal/models"
-	"github.com/CEM-KEA/whoknows/backend/internal/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func init() {
-	// Initialize logger for tests with debug level and text format
-	utils.InitGlobalLogger("debug", "text")
-}
-
 // setupTestDB creates an in-memory SQLite database for testing
 func setupTestDB(t *testing.T) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
@@ -53,7 +48,7 @@ func TestAuthMiddleware(t *testing.T) {
 	testUser := createTestUser(t, db)
 
 	// Create mock JWT validator
-	mockValidateJWT := func(token string) (map[string]interface{}, error) {
+	mockValidateJWT := func(_ context.Context, token string) (map[string]interface{}, error) {
 		switch token {
 		case "valid_token":
 			return map[string]interface{}{
@@ -161,7 +156,7 @@ func TestAuthMiddleware(t *testing.T) {
 			})
 
 			// Create and apply the middleware
-			middleware := middlewares.AuthMiddleware(db, mockValidateJWT)
+			middleware := middlewares.AuthMiddleware(db, mockValidateJWT, config.JWTConfig{}, false)
 			handler := middleware(testHandler)
 
 			// Execute the request
@@ -0,0 +1,126 @@
+package unit_test
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/bruteforce"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = bruteforce.Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxLockout:   10 * time.Second,
+	ResetAfter:   time.Hour,
+}
+
+// testGuardBackoff runs the lockout scenario against a store
+func testGuardBackoff(t *testing.T, store bruteforce.Store) {
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	guard := bruteforce.NewGuard(store, testPolicy)
+	guard.SetClock(func() time.Time { return now })
+	key := bruteforce.UserKey("Alice")
+
+	// The free attempts do not lock
+	for i := 0; i < 3; i++ {
+		require.NoError(t, guard.RegisterFailure(key))
+	}
+	retryAfter, _, err := guard.Check(key)
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	// Each further failure doubles the lockout, up to the maximum
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		require.NoError(t, guard.RegisterFailure(key))
+		retryAfter, blockedKey, err := guard.Check(bruteforce.IPKey("192.0.2.1"), key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, retryAfter)
+		assert.Equal(t, "user:alice", blockedKey)
+	}
+
+	// The lockout expires
+	now = now.Add(11 * time.Second)
+	retryAfter, _, err = guard.Check(key)
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	// Failures are forgotten after a quiet period
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, guard.RegisterFailure(key))
+	retryAfter, _, _ = guard.Check(key)
+	assert.Zero(t, retryAfter)
+
+	// A success resets the key
+	for i := 0; i < 5; i++ {
+		require.NoError(t, guard.RegisterFailure(key))
+	}
+	retryAfter, _, _ = guard.Check(key)
+	assert.Positive(t, retryAfter)
+	require.NoError(t, guard.RegisterSuccess(key))
+	retryAfter, _, _ = guard.Check(key)
+	assert.Zero(t, retryAfter)
+
+	// Keys without failures for the quiet period are pruned
+	require.NoError(t, guard.RegisterFailure(key))
+	now = now.Add(2 * time.Hour)
+	ip := bruteforce.IPKey("192.0.2.1")
+	require.NoError(t, guard.RegisterFailure(ip))
+	require.NoError(t, guard.Prune())
+	record, err := store.Get(key)
+	require.NoError(t, err)
+	assert.Zero(t, record.Failures)
+	record, err = store.Get(ip)
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.Failures)
+}
+
+// TestGuardMemoryStore tests exponential backoff with the in-memory store
+func TestGuardMemoryStore(t *testing.T) {
+	testGuardBackoff(t, bruteforce.NewMemoryStore())
+}
+
+// TestGuardDBStore tests exponential backoff with the database store
+func TestGuardDBStore(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	testGuardBackoff(t, bruteforce.NewDBStore(testApp.DB))
+}
+
+// TestGuardConcurrentFailures tests that concurrent failures are all counted
+func TestGuardConcurrentFailures(t *testing.T) {
+	store := bruteforce.NewMemoryStore()
+	guard := bruteforce.NewGuard(store, testPolicy)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			guard.RegisterFailure(bruteforce.IPKey("192.0.2.1"))
+		}()
+	}
+	wg.Wait()
+
+	record, err := store.Get(bruteforce.IPKey("192.0.2.1"))
+	require.NoError(t, err)
+	assert.Equal(t, 50, record.Failures)
+}
+
+// TestClientIP tests that proxy headers are only used when trusted
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/login", nil)
+	req.RemoteAddr = "10.0.0.2:51234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9, 203.0.113.7")
+
+	assert.Equal(t, "10.0.0.2", utils.ClientIP(req, false))
+	assert.Equal(t, "203.0.113.7", utils.ClientIP(req, true))
+
+	req.Header.Set("X-Real-IP", "203.0.113.8")
+	assert.Equal(t, "203.0.113.8", utils.ClientIP(req, true))
+}
@@ -1,36 +1,41 @@
 package unit_test
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestLoadEnvSuccess tests if LoadEnv successfully loads variables from a .env file
 func TestLoadEnvSuccess(t *testing.T) {
 	os.Setenv("ENV_FILE_PATH", "../test.env")
 
-	err := config.LoadEnv()
-	assert.NoError(t, err)
-	assert.Equal(t, 8080, config.AppConfig.Server.Port)
-	assert.Equal(t, "localhost", config.AppConfig.Database.Host)
-	assert.Equal(t, 5432, config.AppConfig.Database.Port)
-	assert.Equal(t, "user", config.AppConfig.Database.User)
-	assert.Equal(t, "password", config.AppConfig.Database.Password)
-	assert.Equal(t, "mydb", config.AppConfig.Database.Name)
-	assert.Equal(t, "disable", config.AppConfig.Database.SSLMode)
-	assert.Equal(t, true, config.AppConfig.Database.Migrate)
-	assert.Equal(t, "mysecret", config.AppConfig.JWT.Secret)
-	assert.Equal(t, 3600, config.AppConfig.JWT.Expiration)
-	assert.Equal(t, "test", config.AppConfig.Environment.Environment)
-	assert.Equal(t, 10, config.AppConfig.Pagination.Limit)
-	assert.Equal(t, 0, config.AppConfig.Pagination.Offset)
-	assert.Equal(t, "debug", config.AppConfig.Log.Level)
-	assert.Equal(t, "text", config.AppConfig.Log.Format)
-	assert.Equal(t, "weatherapikey", config.AppConfig.WeatherAPI.OpenWeatherAPIKey)
+	cfg, err := config.LoadEnv(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 8080, cfg.Server.Port)
+	assert.Equal(t, "localhost", cfg.Database.Host)
+	assert.Equal(t, 5432, cfg.Database.Port)
+	assert.Equal(t, "user", cfg.Database.User)
+	assert.Equal(t, "password", cfg.Database.Password)
+	assert.Equal(t, "mydb", cfg.Database.Name)
+	assert.Equal(t, "disable", cfg.Database.SSLMode)
+	assert.Equal(t, true, cfg.Database.Migrate)
+	assert.Equal(t, "mysecret", cfg.JWT.Secret)
+	assert.Equal(t, 3600, cfg.JWT.Expiration)
+	assert.Equal(t, "test", cfg.Environment.Environment)
+	assert.Equal(t, 10, cfg.Pagination.Limit)
+	assert.Equal(t, 0, cfg.Pagination.Offset)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	assert.Equal(t, "text", cfg.Log.Format)
+	assert.Equal(t, "weatherapikey", cfg.WeatherAPI.OpenWeatherAPIKey)
 
 	os.Unsetenv("ENV_FILE_PATH")
 }
@@ -44,8 +49,182 @@ func TestLoadEnvFailure(t *testing.T) {
 		fmt.Println(e)
 	}
 
-	err := config.LoadEnv()
+	_, err := config.LoadEnv(context.Background())
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "error loading configuration")
 }
+
+// setRequiredConfig sets the keys that have no default, and clears the ones the tests read
+func setRequiredConfig(t *testing.T) {
+	for key, value := range map[string]string{
+		"ENV_FILE_PATH":           "",
+		"API_CONFIG_FILE":         "",
+		"API_OIDC_PROVIDERS":      "",
+		"API_ENVIRONMENT":         "test",
+		"API_JWT_SECRET":          "jwt-secret",
+		"API_DATABASE_HOST":       "localhost",
+		"API_DATABASE_USER":       "user",
+		"API_DATABASE_PASSWORD":   "db-password",
+		"API_DATABASE_NAME":       "mydb",
+		"API_MAIL_DRIVER":         "file",
+		"API_SERVER_PORT":         "",
+		"API_SERVER_CORS_ORIGINS": "",
+		"API_LOG_LEVEL":           "",
+		"API_LOG_FORMAT":          "",
+		"API_PAGINATION_LIMIT":    "",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+// TestLoadReportsAllProblems tests that every missing or invalid key is reported at once, in
+// the order the keys are declared
+func TestLoadReportsAllProblems(t *testing.T) {
+	setRequiredConfig(t)
+	t.Setenv("API_JWT_SECRET", "")
+	t.Setenv("API_DATABASE_HOST", "")
+	t.Setenv("API_SERVER_PORT", "eighty")
+	t.Setenv("API_SESSION_MODE", "token")
+	t.Setenv("API_RATE_LIMIT_REGISTER", "5 per hour")
+	t.Setenv("API_TRACING_SAMPLE_RATIO", "half")
+
+	_, _, err := config.Load(context.Background(), []string{"--pagination.limit=0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error loading configuration")
+
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []config.Problem{
+		{Key: "API_JWT_SECRET", Message: "is required"},
+		{Key: "API_SERVER_PORT", Message: "must be an integer"},
+		{Key: "API_DATABASE_HOST", Message: "is required"},
+		{Key: "--pagination.limit", Message: "must be at least 1"},
+		{Key: "API_SESSION_MODE", Message: "must be one of bearer, cookie, both"},
+		{Key: "API_RATE_LIMIT_REGISTER", Message: "must be a rate, e.g. 30/1m, or off"},
+		{Key: "API_TRACING_SAMPLE_RATIO", Message: "must be a number"},
+	}, validationErr.Problems)
+}
+
+// TestLoadRejectsLogMailerOutsideDevelopment tests that the log mail driver, which would put
+// the links of the emails in the log, is only accepted in development
+func TestLoadRejectsLogMailerOutsideDevelopment(t *testing.T) {
+	setRequiredConfig(t)
+	t.Setenv("API_MAIL_DRIVER", "log")
+
+	t.Setenv("API_ENVIRONMENT", "production")
+	_, _, err := config.Load(context.Background(), nil)
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []config.Problem{
+		{Key: "API_MAIL_DRIVER", Message: "must not be log outside development"},
+	}, validationErr.Problems)
+
+	t.Setenv("API_ENVIRONMENT", "development")
+	_, _, err = config.Load(context.Background(), nil)
+	assert.NoError(t, err)
+}
+
+// TestLoadLayers tests that the environment overrides the configuration file, and the flags
+// override the environment
+func TestLoadLayers(t *testing.T) {
+	setRequiredConfig(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+server:
+  port: 9000
+  shutdown_timeout: 30s
+  cors_origins: [https://a.example.com]
+log:
+  level: warn
+pagination:
+  limit: 25
+rate_limit:
+  search: 10/30s
+  register: "off"
+tracing:
+  exporter: stdout
+  sample_ratio: 0.25
+`), 0o600))
+	t.Setenv("API_SERVER_PORT", "9100")
+	t.Setenv("API_LOG_LEVEL", "error")
+
+	cfg, commandLine, err := config.Load(context.Background(), []string{"--config", path, "--log.level=debug", "--print-config", "migrate", "status"})
+	require.NoError(t, err)
+	assert.True(t, commandLine.PrintConfig)
+	assert.Equal(t, []string{"migrate", "status"}, commandLine.Args)
+
+	assert.Equal(t, 9100, cfg.Server.Port, "the environment overrides the file")
+	assert.Equal(t, "debug", cfg.Log.Level, "flags override the environment")
+	assert.Equal(t, 25, cfg.Pagination.Limit, "the file overrides the defaults")
+	assert.Equal(t, 30*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, []string{"https://a.example.com"}, cfg.CORSOrigins())
+	assert.Equal(t, "json", cfg.Log.Format, "unset keys keep their default")
+	assert.Equal(t, config.Rate{Requests: 10, Period: 30 * time.Second}, cfg.RateLimit.Search)
+	assert.True(t, cfg.RateLimit.Register.Off())
+	assert.Equal(t, "120/1m", cfg.RateLimit.SearchAuthenticated.String())
+	assert.Equal(t, "stdout", cfg.Tracing.Exporter)
+	assert.Equal(t, 0.25, cfg.Tracing.SampleRatio)
+
+	// Unknown keys in the file are reported rather than ignored
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  prot: 9000\n"), 0o600))
+	_, _, err = config.Load(context.Background(), []string{"--config", path})
+	assert.ErrorContains(t, err, "field prot not found")
+}
+
+// TestPrintConfigRedactsSecrets tests that secrets are not printed, and that the identity
+// providers are not redacted in the loaded configuration
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	setRequiredConfig(t)
+	t.Setenv("API_OIDC_PROVIDERS", "google")
+	t.Setenv("API_OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("API_OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("API_OIDC_GOOGLE_CLIENT_SECRET", "google-secret")
+	cfg, err := config.LoadEnv(context.Background())
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, config.PrintConfig(&out, cfg))
+	printed := out.String()
+	for _, secret := range []string{"jwt-secret", "db-password", "google-secret"} {
+		assert.NotContains(t, printed, secret)
+	}
+	assert.Contains(t, printed, "secret: <REDACTED>")
+	assert.Contains(t, printed, "client_id: google-client")
+	assert.Contains(t, printed, "host: localhost")
+	assert.Equal(t, "google-secret", cfg.OIDC.Providers[0].ClientSecret)
+}
+
+// TestReload tests that a reload applies the reloadable keys, and leaves the others as they are
+func TestReload(t *testing.T) {
+	setRequiredConfig(t)
+	envFile := filepath.Join(t.TempDir(), ".env")
+	t.Cleanup(func() {
+		for _, key := range []string{"API_LOG_LEVEL", "API_SERVER_CORS_ORIGINS", "API_PAGINATION_LIMIT"} {
+			os.Unsetenv(key)
+		}
+	})
+	os.Unsetenv("API_LOG_LEVEL")
+	os.Unsetenv("API_SERVER_CORS_ORIGINS")
+	os.Unsetenv("API_PAGINATION_LIMIT")
+	t.Setenv("ENV_FILE_PATH", envFile)
+
+	require.NoError(t, os.WriteFile(envFile, []byte("API_LOG_LEVEL=info\n"), 0o600))
+	cfg, err := config.LoadEnv(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost", "https://localhost"}, cfg.CORSOrigins())
+
+	require.NoError(t, os.WriteFile(envFile, []byte("API_LOG_LEVEL=debug\nAPI_SERVER_CORS_ORIGINS=https://a.example.com,https://b.example.com\nAPI_PAGINATION_LIMIT=50\n"), 0o600))
+	changed, err := config.Reload(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"API_SERVER_CORS_ORIGINS", "API_LOG_LEVEL"}, changed)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORSOrigins())
+	assert.Equal(t, 10, cfg.Pagination.Limit, "keys that are not reloadable need a restart")
+
+	// An invalid configuration is not applied
+	require.NoError(t, os.WriteFile(envFile, []byte("API_LOG_LEVEL=loud\n"), 0o600))
+	_, err = config.Reload(context.Background(), cfg)
+	assert.ErrorContains(t, err, "API_LOG_LEVEL must be one of")
+	assert.Equal(t, "debug", cfg.Log.Level)
+}
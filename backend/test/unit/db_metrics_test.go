@@ -0,0 +1,88 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// queryDurations returns the number and the sum of the observed durations of an operation on a table
+func queryDurations(t *testing.T, table, operation string) (uint64, float64) {
+	var metric dto.Metric
+	require.NoError(t, utils.DBQueryDuration.WithLabelValues(table, operation).(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+// TestDBCallbacksMeasureOperations tests that the duration covers the whole operation, that
+// failures are counted by table and operation, and that slow queries are logged without values
+func TestDBCallbacksMeasureOperations(t *testing.T) {
+	logger := helpers.SetupLogger()
+	hook := logtest.NewLocal(logger)
+
+	// The slow queries are logged to the logger of the context of the operation
+	ctx := utils.WithLogger(context.Background(), logger)
+	db, err := database.InitTestDatabase(ctx)
+	require.NoError(t, err)
+	db = db.WithContext(ctx)
+	database.RegisterCallbacks(db, 10*time.Millisecond)
+
+	// Stand in for a slow database between the callbacks
+	slow := false
+	require.NoError(t, db.Callback().Query().After("prometheus:before_query").Before("gorm:query").Register("test:slow", func(*gorm.DB) {
+		if slow {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+
+	count, sum := queryDurations(t, "users", "query")
+	slow = true
+	db.Where("username = ?", "secretuser").First(&models.User{})
+	slow = false
+	newCount, newSum := queryDurations(t, "users", "query")
+	assert.Equal(t, count+1, newCount)
+	assert.GreaterOrEqual(t, newSum-sum, (20 * time.Millisecond).Seconds())
+
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, "Slow database query", hook.LastEntry().Message)
+	assert.Equal(t, "users", hook.LastEntry().Data["table"])
+	assert.Equal(t, "query", hook.LastEntry().Data["operation"])
+	assert.Contains(t, hook.LastEntry().Data["sql"], "username = ?")
+	assert.NotContains(t, hook.LastEntry().Data["sql"], "secretuser")
+
+	// Fast operations are not logged, and a missing record is not a failure
+	hook.Reset()
+	failed := testutil.ToFloat64(utils.DBFailedQueries.WithLabelValues("users", "query"))
+	db.Where("username = ?", "nobody").First(&models.User{})
+	assert.Empty(t, hook.AllEntries())
+	assert.Equal(t, failed, testutil.ToFloat64(utils.DBFailedQueries.WithLabelValues("users", "query")))
+
+	failed = testutil.ToFloat64(utils.DBFailedQueries.WithLabelValues("raw", "raw"))
+	assert.Error(t, db.Exec("UPDATE missing_table SET name = 'x'").Error)
+	assert.Equal(t, failed+1, testutil.ToFloat64(utils.DBFailedQueries.WithLabelValues("raw", "raw")))
+}
+
+// TestNormalizeSQL tests that literals are removed from logged SQL, and placeholders kept
+func TestNormalizeSQL(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM \"users\" WHERE username = $1 AND \"users\".\"deleted_at\" IS NULL LIMIT 1": "SELECT * FROM \"users\" WHERE username = $1 AND \"users\".\"deleted_at\" IS NULL LIMIT ?",
+		"UPDATE users SET email = 'secret@example.com', note = 'it''s me'\n\tWHERE id = 42":        "UPDATE users SET email = ?, note = ? WHERE id = ?",
+		"SELECT * FROM pages WHERE score > -1.5 AND title = ?":                                     "SELECT * FROM pages WHERE score > ? AND title = ?",
+		"SELECT md5 FROM table2 WHERE col1 = 3":                                                    "SELECT md5 FROM table2 WHERE col1 = ?",
+	}
+
+	for sql, expected := range tests {
+		assert.Equal(t, expected, database.NormalizeSQL(sql), sql)
+	}
+}
@@ -1,8 +1,13 @@
 package unit_test
 
 import (
-	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"context"
+	"strings"
 	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/stretchr/testify/assert"
 )
 
 // TestHashPassword tests the HashPassword function
@@ -37,17 +42,54 @@ func TestCheckPasswordHash(t *testing.T) {
 	}
 
 	// Test valid password check
-	if !security.CheckPasswordHash(password, hashedPassword) {
+	if !security.CheckPasswordHash(context.Background(), password, hashedPassword) {
 		t.Fatalf("Expected CheckPasswordHash to return true for a valid password and hash")
 	}
 
 	// Test invalid password check
-	if security.CheckPasswordHash("wrongpassword", hashedPassword) {
+	if security.CheckPasswordHash(context.Background(), "wrongpassword", hashedPassword) {
 		t.Fatalf("Expected CheckPasswordHash to return false for an invalid password and hash")
 	}
 
 	// Test invalid hash format
-	if security.CheckPasswordHash(password, "invalidhash") {
+	if security.CheckPasswordHash(context.Background(), password, "invalidhash") {
 		t.Fatalf("Expected CheckPasswordHash to return false for an invalid hash format")
 	}
 }
+
+// useHashAlgorithm switches the algorithm used for new hashes for the duration of a test
+func useHashAlgorithm(t *testing.T, algorithm string, memory int) {
+	cfg := config.PasswordHashConfig{Algorithm: algorithm, Argon2Memory: memory, Argon2Iterations: 1, Argon2Parallelism: 1}
+	if err := security.InitPasswordHashing(cfg); err != nil {
+		t.Fatalf("Failed to configure password hashing: %v", err)
+	}
+	t.Cleanup(func() {
+		security.InitPasswordHashing(config.PasswordHashConfig{Algorithm: "argon2id", Argon2Memory: 64 * 1024, Argon2Iterations: 3, Argon2Parallelism: 2})
+	})
+}
+
+// TestHashPasswordFormats tests that hashes of every supported format can be verified and are upgraded when outdated
+func TestHashPasswordFormats(t *testing.T) {
+	useHashAlgorithm(t, "bcrypt", 1024)
+	bcryptHash, _ := security.HashPassword("testpassword")
+	assert.True(t, strings.HasPrefix(bcryptHash, "$2a$"))
+	assert.False(t, security.NeedsRehash(bcryptHash))
+
+	useHashAlgorithm(t, "argon2id", 1024)
+	argonHash, _ := security.HashPassword("testpassword")
+	assert.True(t, strings.HasPrefix(argonHash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.False(t, security.NeedsRehash(argonHash))
+	assert.True(t, security.NeedsRehash(bcryptHash), "bcrypt hashes should be upgraded to argon2id")
+
+	// Both formats verify regardless of the configured algorithm
+	assert.True(t, security.CheckPasswordHash(context.Background(), "testpassword", bcryptHash))
+	assert.True(t, security.CheckPasswordHash(context.Background(), "testpassword", argonHash))
+	assert.False(t, security.CheckPasswordHash(context.Background(), "wrongpassword", argonHash))
+
+	// Changed parameters also call for a rehash
+	useHashAlgorithm(t, "argon2id", 2048)
+	assert.True(t, security.NeedsRehash(argonHash))
+	assert.True(t, security.CheckPasswordHash(context.Background(), "testpassword", argonHash))
+
+	assert.Error(t, security.InitPasswordHashing(config.PasswordHashConfig{Algorithm: "md5"}))
+}
@@ -1,6 +1,7 @@
 package unit_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -15,12 +16,12 @@ const (
 
 // TestGenerateAndValidateJWT tests the generation and validation of a JWT
 func TestGenerateAndValidateJWT(t *testing.T) {
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
-	token, err := security.GenerateJWT(1, testUsername)
+	token, err := security.GenerateJWT(context.Background(), testApp.Config.JWT, 1, testUsername)
 	assert.NoError(t, err)
 
-	claims, err := security.ValidateJWT(token)
+	claims, err := security.ValidateJWT(context.Background(), testApp.Config.JWT, token)
 	assert.NoError(t, err)
 	assert.NotNil(t, claims)
 	assert.Equal(t, float64(1), claims["sub"])
@@ -29,24 +30,24 @@ func TestGenerateAndValidateJWT(t *testing.T) {
 
 // TestValidateJWTInvalidToken tests JWT validation with an invalid token
 func TestValidateJWTInvalidToken(t *testing.T) {
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
 	invalidTokenString := "invalid.token.string"
 
-	claims, err := security.ValidateJWT(invalidTokenString)
+	claims, err := security.ValidateJWT(context.Background(), testApp.Config.JWT, invalidTokenString)
 	assert.Error(t, err)
 	assert.Nil(t, claims)
 }
 
 // TestValidateJWTExpiredToken tests JWT validation with an expired token
 func TestValidateJWTExpiredToken(t *testing.T) {
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
 	expiredTime := time.Now().Add(-time.Hour)
-	token, err := security.GenerateJWTWithCustomExpiration(1, testUsername, expiredTime)
+	token, err := security.GenerateJWTWithCustomExpiration(context.Background(), testApp.Config.JWT, 1, testUsername, expiredTime)
 	assert.NoError(t, err)
 
-	claims, err := security.ValidateJWT(token)
+	claims, err := security.ValidateJWT(context.Background(), testApp.Config.JWT, token)
 
 	assert.Error(t, err, "An error is expected but got nil.")
 	assert.Nil(t, claims)
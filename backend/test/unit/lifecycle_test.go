@@ -0,0 +1,47 @@
+package unit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/lifecycle"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunChecks tests that every check runs under its own timeout, and that only critical
+// checks decide the outcome
+func TestRunChecks(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	hangs := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	// A check that ignores its context is abandoned at the timeout
+	ignoresContext := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	start := time.Now()
+	results, healthy := lifecycle.RunChecks(context.Background(), []lifecycle.Check{
+		{Name: "database", Critical: true, Timeout: time.Second, Run: up},
+		{Name: "slow", Timeout: 20 * time.Millisecond, Run: hangs},
+		{Name: "stuck", Timeout: 20 * time.Millisecond, Run: ignoresContext},
+		{Name: "broken", Timeout: time.Second, Run: func(ctx context.Context) error { return errors.New("boom") }},
+	})
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "checks should run concurrently and time out")
+	assert.True(t, healthy, "non-critical failures should not fail the probe")
+
+	assert.Equal(t, lifecycle.CheckUp, results["database"].Status)
+	assert.Equal(t, "timed out", results["slow"].Error)
+	assert.Equal(t, "timed out", results["stuck"].Error)
+	assert.Equal(t, lifecycle.CheckDown, results["broken"].Status)
+	assert.Equal(t, "failed", results["broken"].Error)
+
+	_, healthy = lifecycle.RunChecks(context.Background(), []lifecycle.Check{
+		{Name: "database", Critical: true, Timeout: 20 * time.Millisecond, Run: hangs},
+	})
+	assert.False(t, healthy)
+}
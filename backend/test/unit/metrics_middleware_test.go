@@ -0,0 +1,59 @@
+package unit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetricsMiddlewareLabels tests that requests are counted by route template and status
+// code, and that unmatched paths and unknown methods share one label
+func TestMetricsMiddlewareLabels(t *testing.T) {
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/pages/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "0" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("page"))
+	}).Methods("GET")
+	handler := middlewares.MetricsMiddleware(router)(router)
+
+	count := func(method, route, status string) float64 {
+		return testutil.ToFloat64(utils.HttpRequestsTotal.WithLabelValues(method, route, status))
+	}
+	errors := func(method, route, class string) float64 {
+		return testutil.ToFloat64(utils.HttpRequestErrors.WithLabelValues(method, route, class))
+	}
+	serve := func(method, path string) {
+		req := httptest.NewRequest(method, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	route := "/api/pages/{id:[0-9]+}"
+	ok, notFound := count("GET", route, "200"), count("GET", route, "404")
+	clientErrors := errors("GET", route, "4xx")
+	unmatched := count("GET", utils.HTTPRouteUnmatched, "404")
+	otherMethod := count(utils.HTTPMethodOther, utils.HTTPRouteUnmatched, "405")
+
+	serve("GET", "/api/pages/1")
+	serve("GET", "/api/pages/2")
+	serve("GET", "/api/pages/0")
+	serve("GET", "/api/pages/abc")
+	serve("GET", "/wp-login.php")
+	serve("BREW", "/api/pages/1")
+
+	assert.Equal(t, ok+2, count("GET", route, "200"))
+	assert.Equal(t, notFound+1, count("GET", route, "404"))
+	assert.Equal(t, clientErrors+1, errors("GET", route, "4xx"))
+	assert.Equal(t, unmatched+2, count("GET", utils.HTTPRouteUnmatched, "404"))
+	assert.Equal(t, otherMethod+1, count(utils.HTTPMethodOther, utils.HTTPRouteUnmatched, "405"))
+	assert.Equal(t, float64(0), testutil.ToFloat64(utils.HttpRequestsInFlight.WithLabelValues("GET", route)))
+}
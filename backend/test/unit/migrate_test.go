@@ -0,0 +1,149 @@
+package unit_test
+
+import (
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/database/migrations"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// openMigrationTestDB opens an empty in-memory SQLite database
+func openMigrationTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// Every connection to :memory: is a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// appliedVersions returns the versions recorded in schema_migrations
+func appliedVersions(t *testing.T, db *gorm.DB) []int {
+	var versions []int
+	require.NoError(t, db.Model(&models.SchemaMigration{}).Order("version").Pluck("version", &versions).Error)
+	return versions
+}
+
+// TestMigrateUpDown tests applying and rolling back the migrations, and that applying them
+// again does nothing
+func TestMigrateUpDown(t *testing.T) {
+	db := openMigrationTestDB(t)
+	latest := migrations.Latest()
+
+	applied, err := database.MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, latest, applied)
+
+	applied, err = database.MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied, "a second run should find nothing to do")
+
+	states, err := database.MigrationStatus(db)
+	require.NoError(t, err)
+	require.Len(t, states, latest)
+	for i, state := range states {
+		assert.Equal(t, i+1, state.Version)
+		assert.NotNil(t, state.AppliedAt, state.Name)
+	}
+
+	rolledBack, err := database.MigrateDown(db)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rolledBack)
+	assert.Len(t, appliedVersions(t, db), latest-1)
+
+	_, err = database.MigrateTo(db, 0)
+	require.NoError(t, err)
+	assert.Empty(t, appliedVersions(t, db))
+	assert.False(t, db.Migrator().HasTable(&models.User{}))
+
+	_, err = database.MigrateTo(db, latest+1)
+	assert.ErrorIs(t, err, database.ErrUnknownMigration)
+
+	_, err = database.MigrateTo(db, latest)
+	require.NoError(t, err)
+	assert.Len(t, appliedVersions(t, db), latest)
+}
+
+// TestMigrationsMatchModels tests that the migrated schema has a table and a column for every
+// model field, so a model change without a migration is caught
+func TestMigrationsMatchModels(t *testing.T) {
+	db := openMigrationTestDB(t)
+	_, err := database.MigrateUp(db)
+	require.NoError(t, err)
+
+	for _, model := range []interface{}{
+		&models.User{}, &models.Page{}, &models.JWT{}, &models.SearchLog{}, &models.PasswordResetToken{},
+		&models.RecoveryCode{}, &models.LoginAttempt{}, &models.PasswordHistory{}, &models.DataExport{},
+		&models.AuditEvent{}, &models.APIKey{}, &models.OIDCIdentity{}, &models.OIDCState{},
+		&models.WebAuthnCredential{}, &models.WebAuthnChallenge{}, &models.RateLimitBucket{},
+	} {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(model))
+		require.True(t, db.Migrator().HasTable(model), stmt.Schema.Table)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, db.Migrator().HasColumn(model, field.DBName), "%s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
+
+// TestMigrationsAreImmutable tests that a migration recorded under another name is refused
+func TestMigrationsAreImmutable(t *testing.T) {
+	db := openMigrationTestDB(t)
+	_, err := database.MigrateTo(db, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Model(&models.SchemaMigration{}).Where("version = ?", 2).Update("name", "renamed").Error)
+	_, err = database.MigrateUp(db)
+	assert.ErrorContains(t, err, "must not be changed")
+	assert.Equal(t, []int{1, 2}, appliedVersions(t, db), "nothing should be applied")
+}
+
+// TestMigrateAdoptsLegacyMigrations tests that a database migrated by gormigrate keeps its
+// data, and that its data migrations are not run again
+func TestMigrateAdoptsLegacyMigrations(t *testing.T) {
+	db := openMigrationTestDB(t)
+
+	// An older release created the users table and recorded its migrations in gormigrate's table
+	require.NoError(t, db.Exec("CREATE TABLE migrations (id VARCHAR(255) PRIMARY KEY)").Error)
+	require.NoError(t, db.Exec("INSERT INTO migrations (id) VALUES ('20241120093000'), ('20241031000000_must_change_password'), ('20241101000000_audit_events_append_only')").Error)
+	require.NoError(t, db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, username VARCHAR(100), email VARCHAR(100), password_hash TEXT, last_login DATETIME DEFAULT CURRENT_TIMESTAMP, created_at DATETIME, updated_at DATETIME)").Error)
+	require.NoError(t, db.Exec("INSERT INTO users (id, username, email, password_hash, created_at, updated_at) VALUES (1, 'legacy', 'legacy@example.com', 'hash', '2024-01-01', '2024-01-01')").Error)
+
+	applied, err := database.MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, 3, applied, "only the baseline and the migrations released after gormigrate should run")
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, appliedVersions(t, db))
+
+	var user models.User
+	require.NoError(t, db.First(&user, 1).Error)
+	assert.Equal(t, "legacy", user.Username)
+	assert.False(t, user.MustChangePassword, "the data migration should not run again")
+	assert.True(t, db.Migrator().HasTable(&models.WebAuthnCredential{}))
+}
+
+// TestMigratePasskeySecondFactor tests that users who had a password and a passkey before
+// the second factor became opt-in keep needing the passkey
+func TestMigratePasskeySecondFactor(t *testing.T) {
+	db := openMigrationTestDB(t)
+	_, err := database.MigrateTo(db, 4)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Exec("INSERT INTO users (id, username, email, password_hash, created_at, updated_at) VALUES (1, 'password', 'password@example.com', 'hash', '2024-01-01', '2024-01-01'), (2, 'both', 'both@example.com', 'hash', '2024-01-01', '2024-01-01'), (3, 'passkey', 'passkey@example.com', '', '2024-01-01', '2024-01-01')").Error)
+	require.NoError(t, db.Exec("INSERT INTO web_authn_credentials (user_id, name, credential_id, public_key, algorithm) VALUES (2, 'Phone', 'a', x'00', -7), (3, 'Phone', 'b', x'00', -7)").Error)
+
+	_, err = database.MigrateUp(db)
+	require.NoError(t, err)
+
+	var enabled []string
+	require.NoError(t, db.Model(&models.User{}).Where("passkey_second_factor").Order("id").Pluck("username", &enabled).Error)
+	assert.Equal(t, []string{"both"}, enabled)
+}
@@ -0,0 +1,56 @@
+package unit_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/oidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCodeChallenge checks the S256 code challenge against the example in RFC 7636, appendix B
+func TestCodeChallenge(t *testing.T) {
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+// TestLoadEnvOIDCProviders tests that identity providers are read from their prefixed variables
+func TestLoadEnvOIDCProviders(t *testing.T) {
+	t.Setenv("ENV_FILE_PATH", "../test.env")
+	t.Setenv("API_OIDC_PROVIDERS", "google, corp-sso")
+	t.Setenv("API_OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("API_OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("API_OIDC_GOOGLE_CLIENT_SECRET", "google-secret")
+	t.Setenv("API_OIDC_GOOGLE_DISPLAY_NAME", "Google")
+	t.Setenv("API_OIDC_CORP_SSO_ISSUER", "https://sso.example.com")
+	t.Setenv("API_OIDC_CORP_SSO_CLIENT_ID", "corp-client")
+	t.Setenv("API_OIDC_CORP_SSO_CLIENT_SECRET", "corp-secret")
+	t.Setenv("API_OIDC_CORP_SSO_SCOPES", "email")
+
+	cfg, err := config.LoadEnv(context.Background())
+	require.NoError(t, err)
+	providers := cfg.OIDC.Providers
+	require.Len(t, providers, 2)
+	assert.Equal(t, config.OIDCProviderConfig{
+		Name:         "google",
+		DisplayName:  "Google",
+		Issuer:       "https://accounts.google.com",
+		ClientID:     "google-client",
+		ClientSecret: "google-secret",
+		Scopes:       []string{"openid", "email", "profile"},
+	}, providers[0])
+	assert.Equal(t, "corp-sso", providers[1].DisplayName)
+
+	// Providers send users back to the callback page of the frontend
+	configured, err := oidc.NewProviders(cfg.OIDC, "https://example.com/")
+	require.NoError(t, err)
+	provider, ok := configured.Get("corp-sso")
+	require.True(t, ok)
+	assert.Equal(t, "https://example.com/oidc/callback", provider.RedirectURL)
+
+	os.Unsetenv("API_OIDC_CORP_SSO_CLIENT_SECRET")
+	_, err = config.LoadEnv(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package unit_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// violatedRules returns the rule names of the violations
+func violatedRules(violations []security.PasswordViolation) []string {
+	rules := []string{}
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+// TestPasswordPolicyCheck tests the individual password policy rules
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := &security.PasswordPolicy{MinLength: 8, MinEntropyBits: 40}
+
+	assert.Empty(t, policy.Check(context.Background(), "correct horse battery", "alice", "alice@example.com"))
+	assert.ElementsMatch(t, []string{"min_length", "entropy"}, violatedRules(policy.Check(context.Background(), "abc", "alice", "alice@example.com")))
+	assert.Equal(t, []string{"entropy"}, violatedRules(policy.Check(context.Background(), "aaaaaaaaaaaaaaaa", "alice", "alice@example.com")))
+	assert.Equal(t, []string{"entropy"}, violatedRules(policy.Check(context.Background(), "abcdefgh12345678", "alice", "alice@example.com")))
+	assert.Equal(t, []string{"contains_username"}, violatedRules(policy.Check(context.Background(), "secret-Alice-2024!", "alice", "bob@example.com")))
+	assert.Equal(t, []string{"contains_email"}, violatedRules(policy.Check(context.Background(), "secret-bob.smith-2024", "alice", "bob.smith@example.com")))
+	assert.Equal(t, []string{"max_length"}, violatedRules(policy.Check(context.Background(), strings.Repeat("ab1", 50), "alice", "alice@example.com")))
+}
+
+// TestBreachedPasswordFile tests the breached password list with plain text and hashed entries
+func TestBreachedPasswordFile(t *testing.T) {
+	sum := sha1.Sum([]byte("hunter2hunter2"))
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "# breached passwords\nsummer2024!\n" + strings.ToLower(hex.EncodeToString(sum[:])) + ":42\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	list, err := security.LoadBreachedPasswordFile(path)
+	require.NoError(t, err)
+
+	for password, expected := range map[string]bool{"summer2024!": true, "hunter2hunter2": true, "correct horse battery": false} {
+		breached, err := list.Contains(password)
+		require.NoError(t, err)
+		assert.Equal(t, expected, breached, password)
+	}
+
+	policy := &security.PasswordPolicy{MinLength: 8, Breached: list}
+	assert.Equal(t, []string{"breached"}, violatedRules(policy.Check(context.Background(), "summer2024!", "alice", "alice@example.com")))
+}
+
+// TestBreachedPasswordPrefixDir tests k-anonymity range file lookups
+func TestBreachedPasswordPrefixDir(t *testing.T) {
+	sum := sha1.Sum([]byte("password1234"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	dir := t.TempDir()
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + hash[5:] + ":2413945\r\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0o600))
+
+	list, err := security.NewBreachedPasswordPrefixDir(dir)
+	require.NoError(t, err)
+
+	breached, err := list.Contains("password1234")
+	require.NoError(t, err)
+	assert.True(t, breached)
+
+	breached, err = list.Contains("correct horse battery")
+	require.NoError(t, err)
+	assert.False(t, breached)
+
+	_, err = security.NewBreachedPasswordPrefixDir(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
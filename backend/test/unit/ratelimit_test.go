@@ -0,0 +1,88 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/ratelimit"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTokenBucket runs the token bucket scenario against a store
+func testTokenBucket(t *testing.T, store ratelimit.Store) {
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	limiter := ratelimit.NewLimiter(store)
+	limiter.SetClock(func() time.Time { return now })
+	rate := config.Rate{Requests: 3, Period: time.Minute}
+
+	// A new bucket is full
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := limiter.Allow(context.Background(), "search:ip:192.0.2.1", rate)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, remaining, result.Remaining)
+	}
+
+	// An empty bucket refuses the request until the next token, one every 20 seconds
+	result, err := limiter.Allow(context.Background(), "search:ip:192.0.2.1", rate)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 20*time.Second, result.RetryAfter)
+	assert.Equal(t, time.Minute, result.Reset)
+
+	// Other keys have their own bucket
+	result, err = limiter.Allow(context.Background(), "search:ip:198.51.100.2", rate)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// The bucket refills evenly
+	now = now.Add(20 * time.Second)
+	result, err = limiter.Allow(context.Background(), "search:ip:192.0.2.1", rate)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	result, err = limiter.Allow(context.Background(), "search:ip:192.0.2.1", rate)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// A bucket never holds more than the limit
+	now = now.Add(time.Hour)
+	result, err = limiter.Allow(context.Background(), "search:ip:192.0.2.1", rate)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, 20*time.Second, result.Reset)
+}
+
+// TestLimiterMemoryStore tests the token bucket with the in-memory store
+func TestLimiterMemoryStore(t *testing.T) {
+	testTokenBucket(t, ratelimit.NewMemoryStore())
+}
+
+// TestLimiterDBStore tests the token bucket with the database store, and that buckets that
+// have filled up again are pruned
+func TestLimiterDBStore(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	testTokenBucket(t, ratelimit.NewDBStore(testApp.DB))
+
+	now := time.Date(2024, 11, 2, 12, 0, 0, 0, time.UTC)
+	limiter := ratelimit.NewLimiter(ratelimit.NewDBStore(testApp.DB))
+	limiter.SetClock(func() time.Time { return now })
+	_, err := limiter.Allow(context.Background(), "register:ip:192.0.2.1", config.Rate{Requests: 5, Period: time.Hour})
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour + time.Minute)
+	_, err = limiter.Allow(context.Background(), "register:ip:198.51.100.2", config.Rate{Requests: 5, Period: time.Hour})
+	require.NoError(t, err)
+
+	var keys []string
+	require.NoError(t, testApp.DB.Model(&models.RateLimitBucket{}).Order("key").Pluck("key", &keys).Error)
+	assert.Equal(t, []string{"register:ip:198.51.100.2"}, keys)
+}
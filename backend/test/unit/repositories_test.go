@@ -0,0 +1,148 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/handlers"
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type repositorySet struct {
+	users      repositories.UserRepo
+	pages      repositories.PageRepo
+	tokens     repositories.TokenRepo
+	searchLogs repositories.SearchLogRepo
+}
+
+var testPages = []models.Page{
+	{Title: "Python Programming", Content: "Learn Python with examples.", Language: "en", Url: "/python-programming"},
+	{Title: "Go Programming", Content: "A comprehensive guide to Go programming.", Language: "en", Url: "/go-programming"},
+	{Title: "Danish Guide", Content: "Guide to Danish culture and language.", Language: "da", Url: "/danish-guide"},
+}
+
+// repositorySets returns the GORM and the in-memory repositories, holding the same pages
+func repositorySets(t *testing.T) map[string]repositorySet {
+	db, err := database.InitTestDatabase(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() { database.CloseDatabase(context.Background(), db) })
+	pages := append([]models.Page(nil), testPages...)
+	require.NoError(t, db.Create(&pages).Error)
+
+	return map[string]repositorySet{
+		"gorm": {
+			users:      repositories.NewUserRepo(db),
+			pages:      repositories.NewPageRepo(db),
+			tokens:     repositories.NewTokenRepo(db),
+			searchLogs: repositories.NewSearchLogRepo(db),
+		},
+		"memory": {
+			users:      repositories.NewMemoryUserRepo(),
+			pages:      repositories.NewMemoryPageRepo(testPages...),
+			tokens:     repositories.NewMemoryTokenRepo(),
+			searchLogs: repositories.NewMemorySearchLogRepo(),
+		},
+	}
+}
+
+// TestRepositories checks that the GORM repositories and the in-memory fakes behave the same
+func TestRepositories(t *testing.T) {
+	for name, repos := range repositorySets(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+			require.NoError(t, repos.users.Create(ctx, user))
+			assert.NotZero(t, user.ID)
+
+			found, err := repos.users.FindByUsername(ctx, "alice")
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, found.ID)
+			found, err = repos.users.FindByEmail(ctx, "alice@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, "alice", found.Username)
+			_, err = repos.users.FindByID(ctx, user.ID+100)
+			assert.ErrorIs(t, err, repositories.ErrNotFound)
+			err = repos.users.Create(ctx, &models.User{Username: "alice", Email: "other@example.com", PasswordHash: "hash"})
+			assert.ErrorIs(t, err, repositories.ErrDuplicate)
+
+			pages, err := repos.pages.Search(ctx, "examples", "")
+			require.NoError(t, err)
+			require.Len(t, pages, 1)
+			assert.Equal(t, "Python Programming", pages[0].Title)
+			pages, err = repos.pages.Search(ctx, "examples", "da")
+			require.NoError(t, err)
+			assert.Empty(t, pages)
+			pages, err = repos.pages.Search(ctx, "Guide", "da")
+			require.NoError(t, err)
+			require.Len(t, pages, 1)
+			assert.Equal(t, "Danish Guide", pages[0].Title)
+			pages, err = repos.pages.Search(ctx, "o", "en")
+			require.NoError(t, err)
+			require.Len(t, pages, 2)
+			assert.Equal(t, "Go Programming", pages[0].Title)
+
+			require.NoError(t, repos.tokens.Create(ctx, &models.JWT{UserID: user.ID, Token: "first"}))
+			require.NoError(t, repos.tokens.Create(ctx, &models.JWT{UserID: user.ID, Token: "second"}))
+			require.NoError(t, repos.tokens.Revoke(ctx, "first"))
+			assert.ErrorIs(t, repos.tokens.Revoke(ctx, "unknown"), repositories.ErrNotFound)
+			token, err := repos.tokens.FindByToken(ctx, "first")
+			require.NoError(t, err)
+			assert.NotNil(t, token.RevokedAt)
+			token, err = repos.tokens.FindByToken(ctx, "second")
+			require.NoError(t, err)
+			assert.Nil(t, token.RevokedAt)
+			require.NoError(t, repos.tokens.RevokeAllForUser(ctx, user.ID))
+			token, err = repos.tokens.FindByToken(ctx, "second")
+			require.NoError(t, err)
+			assert.NotNil(t, token.RevokedAt)
+			_, err = repos.tokens.FindByToken(ctx, "unknown")
+			assert.ErrorIs(t, err, repositories.ErrNotFound)
+
+			require.NoError(t, repos.searchLogs.Create(ctx, &models.SearchLog{Query: "go", UserID: &user.ID}))
+			require.NoError(t, repos.searchLogs.Create(ctx, &models.SearchLog{Query: "anonymous"}))
+			require.NoError(t, repos.searchLogs.Create(ctx, &models.SearchLog{Query: "python", UserID: &user.ID}))
+			history, err := repos.searchLogs.ListByUser(ctx, user.ID)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+			assert.Equal(t, "go", history[0].Query)
+			assert.Equal(t, "python", history[1].Query)
+		})
+	}
+}
+
+// TestSearchHandlerWithMemoryRepositories runs the search handler without a database
+func TestSearchHandlerWithMemoryRepositories(t *testing.T) {
+	searchLogs := repositories.NewMemorySearchLogRepo()
+	h := handlers.New(&app.App{
+		Config:     &config.Config{},
+		Users:      repositories.NewMemoryUserRepo(),
+		Pages:      repositories.NewMemoryPageRepo(testPages...),
+		Tokens:     repositories.NewMemoryTokenRepo(),
+		SearchLogs: searchLogs,
+	})
+
+	rr := httptest.NewRecorder()
+	h.Search(rr, httptest.NewRequest(http.MethodGet, "/api/search?q=Guide&language=da", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	assert.Equal(t, "Danish Guide", response.Data[0]["title"])
+
+	// Anonymous searches are logged, but are not part of a search history
+	history, err := searchLogs.ListByUser(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
@@ -0,0 +1,74 @@
+package unit_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/jobs"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRunTokenCleanup tests that only tokens expired or revoked beyond the grace period are
+// purged, along with used password reset tokens
+func TestRunTokenCleanup(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	now := time.Now()
+	longAgo := now.Add(-48 * time.Hour)
+	recently := now.Add(-time.Hour)
+
+	tokens := []models.JWT{
+		{UserID: 1, Token: "active", ExpiresAt: now.Add(time.Hour)},
+		{UserID: 1, Token: "expired-within-grace", ExpiresAt: recently},
+		{UserID: 1, Token: "expired-beyond-grace", ExpiresAt: longAgo},
+		{UserID: 1, Token: "revoked-within-grace", ExpiresAt: now.Add(time.Hour), RevokedAt: &recently},
+		{UserID: 1, Token: "revoked-beyond-grace", ExpiresAt: now.Add(time.Hour), RevokedAt: &longAgo},
+	}
+	require.NoError(t, testApp.DB.Create(&tokens).Error)
+	resetTokens := []models.PasswordResetToken{
+		{UserID: 1, TokenHash: "unused", ExpiresAt: now.Add(time.Hour)},
+		{UserID: 1, TokenHash: "used", ExpiresAt: now.Add(time.Hour), UsedAt: &recently},
+		{UserID: 1, TokenHash: "expired-within-grace", ExpiresAt: recently},
+		{UserID: 1, TokenHash: "expired-beyond-grace", ExpiresAt: longAgo},
+	}
+	require.NoError(t, testApp.DB.Create(&resetTokens).Error)
+
+	purged, err := jobs.RunTokenCleanup(testApp.DB, 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	var remaining []string
+	testApp.DB.Model(&models.JWT{}).Order("token").Pluck("token", &remaining)
+	assert.Equal(t, []string{"active", "expired-within-grace", "revoked-within-grace"}, remaining)
+
+	var remainingResetTokens []string
+	testApp.DB.Model(&models.PasswordResetToken{}).Order("token_hash").Pluck("token_hash", &remainingResetTokens)
+	assert.Equal(t, []string{"expired-within-grace", "unused"}, remainingResetTokens)
+}
+
+// TestStartTokenCleanupStops tests that the cleanup job stops when its context is cancelled,
+// so a shutdown can wait for it before closing the database
+func TestStartTokenCleanupStops(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	jobs.StartTokenCleanup(ctx, &wg, testApp.DB, testApp.Guard, time.Hour, time.Hour)
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cleanup job did not stop after its context was cancelled")
+	}
+}
@@ -0,0 +1,53 @@
+package unit_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/security"
+	"github.com/stretchr/testify/assert"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238, appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// TestGenerateTOTPCode tests TOTP codes against the RFC 6238 test vectors (last 6 digits)
+func TestGenerateTOTPCode(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := security.GenerateTOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, "unix time %d", unix)
+	}
+}
+
+// TestValidateTOTPCode tests clock drift tolerance and replay protection
+func TestValidateTOTPCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := security.GenerateTOTPCode(rfc6238Secret, now)
+	previousCode, _ := security.GenerateTOTPCode(rfc6238Secret, now.Add(-30*time.Second))
+	staleCode, _ := security.GenerateTOTPCode(rfc6238Secret, now.Add(-5*time.Minute))
+
+	step, ok := security.ValidateTOTPCode(rfc6238Secret, code, now, 0)
+	assert.True(t, ok)
+
+	_, ok = security.ValidateTOTPCode(rfc6238Secret, previousCode, now, 0)
+	assert.True(t, ok, "a code from the previous period should be accepted")
+
+	_, ok = security.ValidateTOTPCode(rfc6238Secret, staleCode, now, 0)
+	assert.False(t, ok, "a code from minutes ago should be rejected")
+
+	_, ok = security.ValidateTOTPCode(rfc6238Secret, code, now, step)
+	assert.False(t, ok, "an already used code should be rejected")
+
+	_, ok = security.ValidateTOTPCode(rfc6238Secret, "12345", now, 0)
+	assert.False(t, ok)
+}
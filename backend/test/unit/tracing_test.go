@@ -0,0 +1,96 @@
+package unit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/api/middlewares"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/database"
+	"github.com/CEM-KEA/whoknows/backend/internal/models"
+	"github.com/CEM-KEA/whoknows/backend/internal/tracing"
+	"github.com/CEM-KEA/whoknows/backend/internal/utils"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/gorilla/mux"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTracingContinuesIncomingTrace tests that a request with a traceparent header is traced
+// in a server span of the same trace, named by its route, that its database operations are
+// child spans of it, that outbound calls carry the trace on, and that its logs get the trace ID
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	logger := helpers.SetupLogger()
+	hook := logtest.NewLocal(logger)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := tracing.Init(config.TracingConfig{Exporter: "none"}, "test")
+	require.NoError(t, err)
+
+	db, err := database.InitTestDatabase(context.Background())
+	require.NoError(t, err)
+	database.RegisterCallbacks(db, 0)
+
+	var outboundTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outboundTraceparent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: tracing.Transport(nil)}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/pages/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		db.WithContext(r.Context()).Where("username = ?", "nobody").First(&models.User{})
+
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+
+		utils.LogInfoContext(r.Context(), "Serving page", nil)
+	}).Methods("GET")
+	handler := middlewares.LoggerMiddleware(logger)(tracing.Handler(router, func(r *http.Request) string {
+		return middlewares.RouteTemplate(router, r)
+	}))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/api/pages/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String(), span.Name())
+		spans[span.Name()] = span
+	}
+
+	server := spans["GET /api/pages/{id:[0-9]+}"]
+	require.NotNil(t, server, "the server span is named by the route")
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+
+	query := spans["gorm.query"]
+	require.NotNil(t, query)
+	assert.Equal(t, server.SpanContext().SpanID(), query.Parent().SpanID())
+	for _, attribute := range query.Attributes() {
+		assert.NotContains(t, attribute.Value.Emit(), "nobody", "no values in the query text")
+	}
+
+	clientSpan := spans["HTTP GET"]
+	require.NotNil(t, clientSpan)
+	assert.Equal(t, server.SpanContext().SpanID(), clientSpan.Parent().SpanID())
+	assert.Contains(t, outboundTraceparent, traceID)
+
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, traceID, hook.LastEntry().Data["trace_id"])
+	assert.Equal(t, server.SpanContext().SpanID().String(), hook.LastEntry().Data["span_id"])
+}
@@ -1,46 +1,71 @@
 package unit_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/CEM-KEA/whoknows/backend/internal/database"
 	"github.com/CEM-KEA/whoknows/backend/internal/models"
 	"github.com/CEM-KEA/whoknows/backend/internal/services"
 	"github.com/CEM-KEA/whoknows/backend/test/helpers"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestCreateUser(t *testing.T) {
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
 	user := &models.User{
 		Username: "test_user",
 		Email:    "test_user@example.com",
 	}
 
-	err := services.CreateUser(database.DB, user)
+	err := services.CreateUser(testApp.DB, user)
 	assert.NoError(t, err)
 
 	var result models.User
-	database.DB.Where("username = ?", user.Username).First(&result)
+	testApp.DB.Where("username = ?", user.Username).First(&result)
 
 	assert.Equal(t, user.Username, result.Username)
 	assert.Equal(t, user.Email, result.Email)
 }
 
 func TestGetUserByUsername(t *testing.T) {
-	helpers.SetupTestDB(t)
+	testApp := helpers.SetupTestDB(t)
 
 	username := "test_user"
 	expectedUser := &models.User{
 		Username: "test_user",
 		Email:    "test_user@example.com",
 	}
-	database.DB.Create(expectedUser)
+	testApp.DB.Create(expectedUser)
 
-	result, err := services.GetUserByUsername(database.DB, username)
+	result, err := services.GetUserByUsername(testApp.DB, username)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedUser.Username, result.Username)
 	assert.Equal(t, expectedUser.Email, result.Email)
 }
+
+func TestCheckUserPasswordRehash(t *testing.T) {
+	testApp := helpers.SetupTestDB(t)
+
+	// A user whose password was hashed with bcrypt before the switch to argon2id
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("legacypassword"), bcrypt.MinCost)
+	updatedAt := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	user := &models.User{Username: "legacy_user", Email: "legacy@example.com", PasswordHash: string(bcryptHash)}
+	testApp.DB.Create(user)
+	testApp.DB.Model(user).UpdateColumn("updated_at", updatedAt)
+
+	_, valid, err := services.CheckUserPassword(testApp.DB, "legacypassword", "legacy_user")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	var result models.User
+	testApp.DB.First(&result, user.ID)
+	assert.True(t, strings.HasPrefix(result.PasswordHash, "$argon2id$"))
+	assert.True(t, result.UpdatedAt.Equal(updatedAt), "rehashing must not touch UpdatedAt")
+
+	_, valid, _ = services.CheckUserPassword(testApp.DB, "legacypassword", "legacy_user")
+	assert.True(t, valid)
+}
@@ -1,12 +1,16 @@
 package unit_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/CEM-KEA/whoknows/backend/internal/api/handlers"
+	"github.com/CEM-KEA/whoknows/backend/internal/app"
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -51,17 +55,18 @@ func TestGetWeatherData(t *testing.T) {
     }
     defer func() { httpGet = http.Get }() // restore original http.Get
 
-    data, err := handlers.GetWeatherData()
+    h := handlers.New(app.New(&config.Config{}, nil, helpers.SetupLogger()))
+    data, err := h.GetWeatherData()
     assert.NoError(t, err)
     assert.NotNil(t, data)
 
     // verify data is cached
-    cachedData, found := handlers.WeatherCache.Get("weatherData")
+    cachedData, found := h.Cache.Get(context.Background(), "weatherData")
     assert.True(t, found)
     assert.Equal(t, data, cachedData)
 
     // call the function again to ensure it returns the cached data
-    cachedData, err = handlers.GetWeatherData()
+    cachedData, err = h.GetWeatherData()
     assert.NoError(t, err)
     assert.Equal(t, data, cachedData)
 }
@@ -0,0 +1,95 @@
+package unit_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CEM-KEA/whoknows/backend/internal/config"
+	"github.com/CEM-KEA/whoknows/backend/internal/webauthn"
+	"github.com/CEM-KEA/whoknows/backend/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webauthnRoundTrip converts options and responses through JSON, as they travel between the API and the browser
+func webauthnRoundTrip(t *testing.T, from, to interface{}) {
+	raw, err := json.Marshal(from)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, to))
+}
+
+// TestNewRelyingPartyDefaults tests that the relying party defaults to the public URL
+func TestNewRelyingPartyDefaults(t *testing.T) {
+	rp, err := webauthn.NewRelyingParty(config.WebAuthnConfig{ChallengeTTL: time.Minute}, "https://whoknows.example.com:8443/app")
+	require.NoError(t, err)
+	assert.Equal(t, "whoknows.example.com", rp.ID)
+	assert.Equal(t, "whoknows.example.com", rp.Name)
+	assert.Equal(t, []string{"https://whoknows.example.com:8443"}, rp.Origins)
+
+	rp, err = webauthn.NewRelyingParty(config.WebAuthnConfig{RPID: "example.com", RPName: "WhoKnows", Origins: []string{"https://a.example.com"}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", rp.ID)
+	assert.Equal(t, []string{"https://a.example.com"}, rp.Origins)
+
+	_, err = webauthn.NewRelyingParty(config.WebAuthnConfig{}, "")
+	assert.Error(t, err)
+}
+
+// TestVerifyPasskeyCeremonies tests registration and login with a software authenticator
+func TestVerifyPasskeyCeremonies(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "localhost", Name: "WhoKnows", Origins: []string{"http://localhost"}, Timeout: time.Minute}
+	authenticator := helpers.NewSoftwareAuthenticator("http://localhost")
+
+	var creation map[string]interface{}
+	webauthnRoundTrip(t, rp.CreationOptions("register-challenge", webauthn.UserEntity{Handle: []byte("1"), Name: "alice", DisplayName: "alice"}, nil), &creation)
+	var registration webauthn.RegistrationResponse
+	webauthnRoundTrip(t, authenticator.Register(t, creation), &registration)
+
+	challenge, err := registration.Challenge()
+	require.NoError(t, err)
+	assert.Equal(t, "register-challenge", challenge)
+
+	_, err = rp.VerifyRegistration(&registration, "another-challenge", false)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	otherRP := *rp
+	otherRP.ID = "example.com"
+	_, err = otherRP.VerifyRegistration(&registration, "register-challenge", false)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+
+	truncated := registration
+	object, _ := base64.RawURLEncoding.DecodeString(registration.Response.AttestationObject)
+	truncated.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(object[:len(object)-10])
+	_, err = rp.VerifyRegistration(&truncated, "register-challenge", false)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+
+	credential, err := rp.VerifyRegistration(&registration, "register-challenge", true)
+	require.NoError(t, err)
+	assert.Equal(t, webauthn.AlgorithmES256, credential.Algorithm)
+	assert.Equal(t, uint32(0), credential.SignCount)
+	assert.Equal(t, []string{"internal"}, credential.Transports)
+
+	var request map[string]interface{}
+	webauthnRoundTrip(t, rp.RequestOptions("login-challenge", nil, webauthn.UserVerificationRequired), &request)
+	var assertion webauthn.AssertionResponse
+	webauthnRoundTrip(t, authenticator.Login(t, request), &assertion)
+
+	result, err := rp.VerifyAssertion(&assertion, "login-challenge", credential.PublicKey, 0, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), result.SignCount)
+	assert.True(t, result.UserVerified)
+
+	// The challenge must match, and the counter must increase
+	_, err = rp.VerifyAssertion(&assertion, "register-challenge", credential.PublicKey, 0, true)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	_, err = rp.VerifyAssertion(&assertion, "login-challenge", credential.PublicKey, 1, true)
+	assert.ErrorIs(t, err, webauthn.ErrSignCountRegressed)
+
+	authenticator.UserVerified = false
+	webauthnRoundTrip(t, authenticator.Login(t, request), &assertion)
+	_, err = rp.VerifyAssertion(&assertion, "login-challenge", credential.PublicKey, 1, true)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	_, err = rp.VerifyAssertion(&assertion, "login-challenge", credential.PublicKey, 1, false)
+	assert.NoError(t, err)
+}
@@ -4,6 +4,7 @@ import Search from "./views/Search";
 import Login from "./views/Login";
 import { useEffect, useState } from "react";
 import {
+  getCSRFTokenFromCookies,
   getJWTTokenFromCookies,
   removeJWTTokenFromCookies,
   setJWTTokenInCookies
@@ -11,19 +12,22 @@ import {
 import Weather from "./views/Weather";
 import Register from "./views/Register";
 import toast, { Toaster } from "react-hot-toast";
-import { apiGetVoid } from "./utils/apiUtils";
+import { apiPost } from "./utils/apiUtils";
 import ChangePassword from "./views/ChangePassword";
 import DataLeakBanner from "./components/DataLeakBanner";
+import OIDCCallback from "./views/OIDCCallback";
 
 function App() {
   const [loggedIn, setLoggedIn] = useState<boolean>(false);
   const [showBanner, setShowBanner] = useState<boolean>(true);
 
   // right now, as the jwt token is not really used, we just check if it exists to see if the user is logged in
-  // ideally, we would also check if the token is still valid with the backend
+  // ideally, we would also check if the token is still valid with the backend.
+  // With cookie sessions the token is HttpOnly, so the CSRF cookie set next to it is checked instead
   useEffect(() => {
     const jwt_token = getJWTTokenFromCookies();
-    if (!jwt_token || jwt_token === "") {
+    const csrf_token = getCSRFTokenFromCookies();
+    if ((!jwt_token || jwt_token === "") && (!csrf_token || csrf_token === "")) {
       setLoggedIn(false);
     } else {
       setLoggedIn(true);
@@ -32,13 +36,16 @@ function App() {
 
   function logOut() {
     setLoggedIn(false);
-    void apiGetVoid("/logout", true)
+    void apiPost("/logout", {}, true)
       .catch((e) => toast.error(e.message))
       .finally(() => removeJWTTokenFromCookies());
   }
 
-  function logIn(jwt_token: string) {
-    setJWTTokenInCookies(jwt_token);
+  // jwt_token is missing when the backend keeps the session in a cookie
+  function logIn(jwt_token?: string) {
+    if (jwt_token) {
+      setJWTTokenInCookies(jwt_token);
+    }
     setLoggedIn(true);
   }
 
@@ -68,6 +75,10 @@ function App() {
               path="/login"
               element={<Login onLogIn={logIn} />}
             />
+            <Route
+              path="/oidc/callback"
+              element={<OIDCCallback onLogIn={logIn} />}
+            />
           </>
         )}
         <Route
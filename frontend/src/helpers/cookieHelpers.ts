@@ -13,3 +13,11 @@ export function setJWTTokenInCookies(token: string): void {
 export function removeJWTTokenFromCookies(): void {
   cookies.remove("jwt_authorization");
 }
+
+/**
+ * Returns the CSRF token of a cookie session. The session token itself is kept in an
+ * HttpOnly cookie by the backend and can not be read here.
+ */
+export function getCSRFTokenFromCookies(): string {
+  return cookies.get("whoknows_csrf");
+}
@@ -0,0 +1,53 @@
+import type { IPasskeyRequestOptions } from "../types/auth.types";
+
+function base64urlToBuffer(value: string): ArrayBuffer {
+  const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+  const binary = atob(base64.padEnd(base64.length + ((4 - (base64.length % 4)) % 4), "="));
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes.buffer;
+}
+
+function bufferToBase64url(buffer: ArrayBuffer): string {
+  let binary = "";
+  new Uint8Array(buffer).forEach((byte) => (binary += String.fromCharCode(byte)));
+  return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+/**
+ * Asks the browser for a passkey with the request options from the API, and returns the
+ * credential in the JSON form the API expects.
+ */
+export async function getPasskey(options: IPasskeyRequestOptions): Promise<object> {
+  const credential = (await navigator.credentials.get({
+    publicKey: {
+      challenge: base64urlToBuffer(options.challenge),
+      timeout: options.timeout,
+      rpId: options.rpId,
+      allowCredentials: options.allowCredentials.map((descriptor) => ({
+        type: "public-key",
+        id: base64urlToBuffer(descriptor.id),
+        transports: descriptor.transports as AuthenticatorTransport[] | undefined
+      })),
+      userVerification: options.userVerification
+    }
+  })) as PublicKeyCredential | null;
+  if (!credential) {
+    throw new Error("No passkey was selected.");
+  }
+
+  const response = credential.response as AuthenticatorAssertionResponse;
+  return {
+    id: credential.id,
+    rawId: bufferToBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: bufferToBase64url(response.clientDataJSON),
+      authenticatorData: bufferToBase64url(response.authenticatorData),
+      signature: bufferToBase64url(response.signature),
+      userHandle: response.userHandle ? bufferToBase64url(response.userHandle) : ""
+    }
+  };
+}
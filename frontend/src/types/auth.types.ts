@@ -13,8 +13,14 @@ export interface ILoginSession {
 }
 
 export interface ILoginResponse {
-  token: string;
+  // token is left out when the backend uses cookie sessions
+  token?: string;
+  csrf_token?: string;
   require_password_change: boolean;
+  // set when a second factor is required, see IMFAChallengeResponse
+  status?: string;
+  mfa_token?: string;
+  methods?: string[];
 }
 
 export interface IRegisterRequest {
@@ -30,3 +36,35 @@ export interface IChangePasswordRequest {
   new_password: string;
   repeat_new_password: string;
 }
+
+export interface IOIDCProvider {
+  name: string;
+  display_name: string;
+}
+
+export interface IOIDCAuthorizeResponse {
+  authorization_url: string;
+}
+
+export interface IOIDCCallbackRequest {
+  code: string;
+  state: string;
+}
+
+export interface IPasskeyCredentialDescriptor {
+  type: string;
+  id: string;
+  transports?: string[];
+}
+
+export interface IPasskeyRequestOptions {
+  challenge: string;
+  timeout: number;
+  rpId: string;
+  allowCredentials: IPasskeyCredentialDescriptor[];
+  userVerification: UserVerificationRequirement;
+}
+
+export interface IPasskeyRequestOptionsResponse {
+  publicKey: IPasskeyRequestOptions;
+}
@@ -1,7 +1,28 @@
-import { getJWTTokenFromCookies } from "../helpers/cookieHelpers";
+import { getCSRFTokenFromCookies, getJWTTokenFromCookies } from "../helpers/cookieHelpers";
 
 const apiUrl = import.meta.env.VITE_API_URL;
 
+/**
+ * Returns the authentication headers of a request. A stored JWT is sent as a Bearer token;
+ * with a cookie session the browser sends the session cookie, and state-changing requests
+ * also need the CSRF token.
+ */
+function authHeaders(requireAuth?: boolean, method: string = "GET"): Record<string, string> {
+  const headers: Record<string, string> = {};
+  if (!requireAuth) {
+    return headers;
+  }
+  const jwtToken = getJWTTokenFromCookies();
+  if (jwtToken) {
+    headers.Authorization = `Bearer ${jwtToken}`;
+  }
+  const csrfToken = getCSRFTokenFromCookies();
+  if (csrfToken && method !== "GET") {
+    headers["X-CSRF-Token"] = csrfToken;
+  }
+  return headers;
+}
+
 /**
  * Sends a GET request to the API, url is the path to the endpoint and should start with a /.
  *
@@ -9,9 +30,8 @@ const apiUrl = import.meta.env.VITE_API_URL;
  */
 export async function apiGet<TResBody>(url: string, requireAuth?: boolean): Promise<TResBody> {
   const res = await fetch(apiUrl + url, {
-    headers: {
-      Authorization: requireAuth ? `Bearer ${getJWTTokenFromCookies()}` : ""
-    }
+    credentials: "include",
+    headers: authHeaders(requireAuth)
   });
   if (!res.ok) {
     throw new Error(res.statusText);
@@ -23,13 +43,12 @@ export async function apiGet<TResBody>(url: string, requireAuth?: boolean): Prom
  * Sends a GET request to the API, url is the path to the endpoint and should start with a /.
  * This function does not expect a response body, but will not fail if there is one.
  *
- * Example: apiGetVoid("/logout", true) will send a GET request to /api/logout with the Authorization header set.
+ * Example: apiGetVoid("/validate-login", true) will send an authenticated GET request to /api/validate-login.
  */
 export async function apiGetVoid(url: string, requireAuth?: boolean): Promise<void> {
   const res = await fetch(apiUrl + url, {
-    headers: {
-      Authorization: requireAuth ? `Bearer ${getJWTTokenFromCookies()}` : ""
-    }
+    credentials: "include",
+    headers: authHeaders(requireAuth)
   });
   if (!res.ok) {
     throw new Error(res.statusText);
@@ -48,9 +67,10 @@ export async function apiPost<TReqBody, TResBody>(
 ): Promise<TResBody> {
   const res = await fetch(apiUrl + url, {
     method: "POST",
+    credentials: "include",
     headers: {
       "Content-Type": "application/json",
-      Authorization: requireAuth ? `Bearer ${getJWTTokenFromCookies()}` : ""
+      ...authHeaders(requireAuth, "POST")
     },
     body: JSON.stringify(data)
   });
@@ -75,9 +95,10 @@ export async function apiPut<TReqBody, TResBody>(
 ): Promise<TResBody> {
   const res = await fetch(apiUrl + url, {
     method: "PUT",
+    credentials: "include",
     headers: {
       "Content-Type": "application/json",
-      Authorization: requireAuth ? `Bearer ${getJWTTokenFromCookies()}` : ""
+      ...authHeaders(requireAuth, "PUT")
     },
     body: JSON.stringify(data)
   });
@@ -95,9 +116,8 @@ export async function apiPut<TReqBody, TResBody>(
 export async function apiDelete<TResBody>(url: string, requireAuth?: boolean): Promise<TResBody> {
   const res = await fetch(apiUrl + url, {
     method: "DELETE",
-    headers: {
-      Authorization: requireAuth ? `Bearer ${getJWTTokenFromCookies()}` : ""
-    }
+    credentials: "include",
+    headers: authHeaders(requireAuth)
   });
   if (!res.ok) {
     throw new Error(res.statusText);
@@ -30,7 +30,7 @@ function ChangePassword(props: Readonly<ChangePasswordProps>) {
     [username, oldPassword, newPassword, passwordMatch]
   );
   function validatePassword(password: string) {
-    return password.length >= 6;
+    return password.length >= 8;
   }
 
   function validateRepeatPassword(repeatPassword: string) {
@@ -94,7 +94,7 @@ function ChangePassword(props: Readonly<ChangePasswordProps>) {
                 type="password"
                 placeholder="Old Password"
                 value={oldPassword}
-                minLength={6}
+                minLength={8}
                 onChange={(e) => setOldPassword(e.target.value)}
                 required
                 name="oldpassword"
@@ -107,13 +107,13 @@ function ChangePassword(props: Readonly<ChangePasswordProps>) {
                 type="password"
                 placeholder="New Password"
                 value={newPassword}
-                minLength={6}
+                minLength={8}
                 onChange={(e) => setNewPassword(e.target.value)}
                 required
                 name="newpassword"
               />
-              {newPassword.length > 0 && newPassword.length < 6 && (
-                <span className="text-red-500 text-xs">Password must be at least 6 characters</span>
+              {newPassword.length > 0 && newPassword.length < 8 && (
+                <span className="text-red-500 text-xs">Password must be at least 8 characters</span>
               )}
             </label>
             <label>
@@ -124,7 +124,7 @@ function ChangePassword(props: Readonly<ChangePasswordProps>) {
                 type="password"
                 placeholder="Repeat new password"
                 value={repeatNewPassword}
-                minLength={6}
+                minLength={8}
                 onChange={(e) => setRepeatNewPassword(e.target.value)}
                 required
                 name="repeat-newpassword"
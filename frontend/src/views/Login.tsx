@@ -1,35 +1,94 @@
-import { FormEventHandler, useState } from "react";
+import { FormEventHandler, useEffect, useState } from "react";
 import PageLayout from "../components/PageLayout";
-import { ILoginRequest, ILoginResponse } from "../types/auth.types";
-import { apiPost } from "../utils/apiUtils";
+import {
+  ILoginRequest,
+  ILoginResponse,
+  IOIDCAuthorizeResponse,
+  IOIDCProvider,
+  IPasskeyRequestOptionsResponse
+} from "../types/auth.types";
+import { apiGet, apiPost } from "../utils/apiUtils";
 import { Link, useNavigate } from "react-router-dom";
 import LoadingSpinner from "../components/LoadingSpinner";
 import toast from "react-hot-toast";
+import { OIDC_PROVIDER_KEY } from "./OIDCCallback";
+import { getPasskey } from "../helpers/webauthnHelpers";
 
 interface LoginProps {
-  onLogIn: (token: string) => void;
+  onLogIn: (token?: string) => void;
 }
 
 function Login(props: Readonly<LoginProps>) {
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
   const [loading, setLoading] = useState(false);
+  const [providers, setProviders] = useState<IOIDCProvider[]>([]);
   const navigate = useNavigate();
 
+  useEffect(() => {
+    apiGet<IOIDCProvider[]>("/oidc/providers")
+      .then(setProviders)
+      .catch(() => setProviders([]));
+  }, []);
+
+  function logInWith(provider: IOIDCProvider) {
+    setLoading(true);
+    apiPost<object, IOIDCAuthorizeResponse>(`/oidc/${provider.name}/authorize`, {})
+      .then((data) => {
+        sessionStorage.setItem(OIDC_PROVIDER_KEY, provider.name);
+        window.location.href = data.authorization_url;
+      })
+      .catch((error) => {
+        toast.error(error.message);
+        setLoading(false);
+      });
+  }
+
+  function finishLogin(data: ILoginResponse) {
+    setLoading(false);
+    if (data.require_password_change) {
+      toast.error("You need to change your password.");
+      navigate("/change-password");
+      return;
+    }
+    props.onLogIn(data.token);
+    toast.success("Logged in successfully.");
+    navigate("/");
+  }
+
+  async function logInWithPasskey() {
+    setLoading(true);
+    try {
+      const { publicKey } = await apiPost<object, IPasskeyRequestOptionsResponse>("/login/passkey/begin", {});
+      const credential = await getPasskey(publicKey);
+      finishLogin(await apiPost<object, ILoginResponse>("/login/passkey/finish", { credential }));
+    } catch (error) {
+      toast.error((error as Error).message);
+      setLoading(false);
+    }
+  }
+
+  // Users with passkeys confirm a password login with one of them
+  async function completeWithPasskey(mfaToken: string) {
+    const { publicKey } = await apiPost<object, IPasskeyRequestOptionsResponse>("/login/2fa/passkey/begin", {
+      mfa_token: mfaToken
+    });
+    const credential = await getPasskey(publicKey);
+    return apiPost<object, ILoginResponse>("/login/2fa/passkey/finish", { mfa_token: mfaToken, credential });
+  }
+
   const handleLogin: FormEventHandler = (e) => {
     e.preventDefault();
     setLoading(true);
     apiPost<ILoginRequest, ILoginResponse>("/login", { username: username, password })
       .then((data) => {
-        setLoading(false);
-        if (data.require_password_change) {
-          toast.error("You need to change your password.");
-          navigate("/change-password");
-          return;
+        if (data.status === "mfa_required") {
+          if (!data.mfa_token || !data.methods?.includes("passkey")) {
+            throw new Error("Two-factor login is not supported here yet.");
+          }
+          return completeWithPasskey(data.mfa_token).then(finishLogin);
         }
-        props.onLogIn(data.token);
-        toast.success("Logged in successfully.");
-        navigate("/");
+        finishLogin(data);
       })
       .catch((error) => {
         toast.error(error.message);
@@ -73,6 +132,31 @@ function Login(props: Readonly<LoginProps>) {
                 Log in
               </button>
             </div>
+            <div className="flex justify-center">
+              <button
+                type="button"
+                id="login-passkey"
+                className="border-2 rounded w-1/2 p-2 font-semibold hover:brightness-90 text-xl"
+                onClick={() => void logInWithPasskey()}
+              >
+                Log in with a passkey
+              </button>
+            </div>
+            {providers.map((provider) => (
+              <div
+                key={provider.name}
+                className="flex justify-center"
+              >
+                <button
+                  type="button"
+                  id={`login-oidc-${provider.name}`}
+                  className="border-2 rounded w-1/2 p-2 font-semibold hover:brightness-90 text-xl"
+                  onClick={() => logInWith(provider)}
+                >
+                  Log in with {provider.display_name}
+                </button>
+              </div>
+            ))}
             <Link
               to="/change-password"
               className="text-blue-500 underline text-center"
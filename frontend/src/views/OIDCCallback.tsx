@@ -0,0 +1,63 @@
+import { useEffect, useRef } from "react";
+import { useNavigate, useSearchParams } from "react-router-dom";
+import toast from "react-hot-toast";
+import PageLayout from "../components/PageLayout";
+import LoadingSpinner from "../components/LoadingSpinner";
+import { IOIDCCallbackRequest, ILoginResponse } from "../types/auth.types";
+import { apiPost } from "../utils/apiUtils";
+
+export const OIDC_PROVIDER_KEY = "oidc_provider";
+
+interface OIDCCallbackProps {
+  onLogIn: (token?: string) => void;
+}
+
+// The identity provider redirects back here after "Log in with ...", with the code and state
+// to complete the login with at the backend
+function OIDCCallback(props: Readonly<OIDCCallbackProps>) {
+  const [searchParams] = useSearchParams();
+  const navigate = useNavigate();
+  // the code can only be used once, so make sure it is not sent twice in strict mode
+  const handled = useRef(false);
+
+  useEffect(() => {
+    if (handled.current) return;
+    handled.current = true;
+
+    const provider = sessionStorage.getItem(OIDC_PROVIDER_KEY);
+    sessionStorage.removeItem(OIDC_PROVIDER_KEY);
+    const code = searchParams.get("code");
+    const state = searchParams.get("state");
+    if (!provider || !code || !state) {
+      toast.error(searchParams.get("error_description") ?? "Login was cancelled.");
+      navigate("/login");
+      return;
+    }
+
+    apiPost<IOIDCCallbackRequest, ILoginResponse>(`/oidc/${provider}/callback`, { code, state })
+      .then((data) => {
+        if (data.require_password_change) {
+          toast.error("You need to change your password.");
+          navigate("/change-password");
+          return;
+        }
+        props.onLogIn(data.token);
+        toast.success("Logged in successfully.");
+        navigate("/");
+      })
+      .catch((error) => {
+        toast.error(error.message);
+        navigate("/login");
+      });
+  }, [searchParams, navigate, props]);
+
+  return (
+    <PageLayout>
+      <div className="flex justify-center items-center h-[calc(100vh-180px)]">
+        <LoadingSpinner size={100} />
+      </div>
+    </PageLayout>
+  );
+}
+
+export default OIDCCallback;
@@ -9,7 +9,7 @@ import validator from "validator";
 import { getInputClassName } from "../helpers/styleHelpers";
 
 interface RegisterProps {
-  logIn: (jwt_token: string) => void;
+  logIn: (jwt_token?: string) => void;
 }
 
 function Register(props: Readonly<RegisterProps>) {
@@ -40,7 +40,7 @@ function Register(props: Readonly<RegisterProps>) {
   }
 
   function validatePassword(password: string) {
-    return password.length >= 6;
+    return password.length >= 8;
   }
 
   function validateRepeatPassword(repeatPassword: string) {
@@ -133,13 +133,13 @@ function Register(props: Readonly<RegisterProps>) {
                 type="password"
                 placeholder="Password"
                 value={password}
-                minLength={6}
+                minLength={8}
                 onChange={(e) => setPassword(e.target.value)}
                 required
                 name="password"
               />
-              {password.length > 0 && password.length < 6 && (
-                <span className="text-red-500 text-xs">Password must be at least 6 characters</span>
+              {password.length > 0 && password.length < 8 && (
+                <span className="text-red-500 text-xs">Password must be at least 8 characters</span>
               )}
             </label>
             <label>
@@ -150,7 +150,7 @@ function Register(props: Readonly<RegisterProps>) {
                 type="password"
                 placeholder="Repeat password"
                 value={repeatPassword}
-                minLength={6}
+                minLength={8}
                 onChange={(e) => setRepeatPassword(e.target.value)}
                 required
                 name="repeat-password"
@@ -8,10 +8,15 @@ import (
 	"time"
 
 	"github.com/CEM-KEA/whoknows/backend/scraper/internal/db"
+	"github.com/CEM-KEA/whoknows/backend/scraper/internal/tracing"
 	"github.com/CEM-KEA/whoknows/backend/scraper/internal/wiki"
 	"github.com/aws/aws-lambda-go/lambda"
+	"go.opentelemetry.io/otel"
 )
 
+// flushTraces exports the spans of an invocation before the Lambda environment is frozen
+var flushTraces = func(context.Context) error { return nil }
+
 // getScraperConfig initializes and returns a pointer to a wiki.ScraperConfig struct.
 // It reads configuration values from environment variables and provides default values
 // if the environment variables are not set.
@@ -51,7 +56,8 @@ func getScraperConfig() *wiki.ScraperConfig {
 
 // handleRequest is the main entry point for processing the scraping task.
 // It initializes metrics, connects to the database, fetches queries, and processes each row using the scraper.
-// The function logs metrics and handles errors appropriately.
+// The function logs metrics and handles errors appropriately. Every invocation is traced in a
+// span, and its spans are exported before it returns.
 //
 // Parameters:
 // - ctx: The context for managing request-scoped values, cancellation, and deadlines.
@@ -59,6 +65,14 @@ func getScraperConfig() *wiki.ScraperConfig {
 // Returns:
 // - error: An error if any step in the process fails, otherwise nil.
 func handleRequest(ctx context.Context) error {
+    ctx, span := otel.Tracer("github.com/CEM-KEA/whoknows/backend/scraper/cmd/lambda").Start(ctx, "scraper.run")
+    defer func() {
+        span.End()
+        if err := flushTraces(ctx); err != nil {
+            log.Printf("Failed to export traces: %v", err)
+        }
+    }()
+
     metrics := wiki.NewMetrics()
     defer metrics.Log()
 
@@ -96,7 +110,13 @@ func handleRequest(ctx context.Context) error {
 
 // main is the entry point for the AWS Lambda function. It starts the Lambda
 // function by calling lambda.Start with the handleRequest function as the
-// handler.
+// handler, once tracing is initialized.
 func main() {
+    flush, err := tracing.Init()
+    if err != nil {
+        log.Fatalf("Failed to initialize tracing: %v", err)
+    }
+    flushTraces = flush
+
     lambda.Start(handleRequest)
 }
\ No newline at end of file
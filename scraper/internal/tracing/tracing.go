@@ -0,0 +1,82 @@
+// Package tracing sets up OpenTelemetry tracing for the scraper, so that every invocation and
+// the Wikipedia requests it makes are traced, and exported like the traces of the backend.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Init installs the W3C trace context propagator and, unless the exporter is "none", a tracer
+// provider exporting the sampled spans. It is configured from environment variables.
+//
+// Environment Variables:
+// - SCRAPER_TRACING_EXPORTER: none (default), otlp or stdout.
+// - SCRAPER_TRACING_ENDPOINT: The OTLP/HTTP endpoint, defaults to the OTEL_EXPORTER_OTLP_* variables.
+// - SCRAPER_TRACING_SAMPLE_RATIO: The share of traces that are recorded (default: 1).
+// - SCRAPER_TRACING_SERVICE_NAME: The service name on the spans (default: whoknows-scraper).
+//
+// Returns:
+//   - func(context.Context) error: Exports the spans that have not been exported yet. The
+//     Lambda environment may be frozen between invocations, so it is called after each one.
+//   - error: An error if the exporter cannot be created.
+func Init() (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName := os.Getenv("SCRAPER_TRACING_EXPORTER"); exporterName {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var options []otlptracehttp.Option
+		if endpoint := os.Getenv("SCRAPER_TRACING_ENDPOINT"); endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating the trace exporter: %v", err)
+	}
+
+	serviceName := os.Getenv("SCRAPER_TRACING_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "whoknows-scraper"
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error describing the tracing resource: %v", err)
+	}
+
+	sampleRatio := 1.0
+	if value, err := strconv.ParseFloat(os.Getenv("SCRAPER_TRACING_SAMPLE_RATIO"), 64); err == nil {
+		sampleRatio = value
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.ForceFlush, nil
+}
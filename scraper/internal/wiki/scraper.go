@@ -10,11 +10,18 @@ import (
 
 	"github.com/CEM-KEA/whoknows/backend/scraper/internal/db"
 	"github.com/gocolly/colly/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// tracer creates the span of every processed query, which the Wikipedia requests made for it are children of
+var tracer = otel.Tracer("github.com/CEM-KEA/whoknows/backend/scraper/internal/wiki")
+
 type Scraper struct {
 	collector *colly.Collector
 	config    *ScraperConfig
+	transport *tracedTransport
 }
 
 type ScraperConfig struct {
@@ -63,16 +70,21 @@ func NewScraper(config *ScraperConfig) *Scraper {
 		Delay:       config.RateLimit,
 	})
 
+	transport := newTracedTransport()
+	c.WithTransport(transport)
+
 	return &Scraper{
 		collector: c,
 		config:    config,
+		transport: transport,
 	}
 }
 
 // ProcessRow processes a single row from the database, performing web scraping
 // based on the query contained in the row. It scans the row to extract the query,
 // preprocesses the query, and then uses a web scraper to search for relevant pages.
-// If a valid page is found, it stores the page in the database.
+// If a valid page is found, it stores the page in the database. The query is traced in a span,
+// with the requests made for it as children.
 //
 // Parameters:
 //   - ctx: The context for managing request deadlines and cancellation signals.
@@ -81,11 +93,22 @@ func NewScraper(config *ScraperConfig) *Scraper {
 //
 // Returns:
 //   - error: An error if any issues occur during processing, or nil if successful.
-func (s *Scraper) ProcessRow(ctx context.Context, database *sql.DB, rows *sql.Rows) error {
+func (s *Scraper) ProcessRow(ctx context.Context, database *sql.DB, rows *sql.Rows) (err error) {
+	ctx, span := tracer.Start(ctx, "scraper.process_query")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	s.transport.setContext(ctx)
+
 	queryID, query, err := s.scanRow(rows)
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(attribute.Int64("scraper.query_id", int64(queryID)))
 
 	processedQuery, valid := preprocessQuery(query)
 	if !valid {
@@ -0,0 +1,41 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// tracedTransport traces the requests of the collector in client spans. The collector does not
+// pass a context to its requests, so they are made with the context of the query being
+// processed, which makes them children of its span.
+type tracedTransport struct {
+	mu   sync.RWMutex
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+// newTracedTransport creates a tracedTransport on top of http.DefaultTransport
+func newTracedTransport() *tracedTransport {
+	return &tracedTransport{
+		ctx:  context.Background(),
+		next: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+// setContext sets the context the following requests are made with
+func (t *tracedTransport) setContext(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx = ctx
+}
+
+// RoundTrip makes the request with the context of the query being processed
+func (t *tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	ctx := t.ctx
+	t.mu.RUnlock()
+	return t.next.RoundTrip(req.WithContext(ctx))
+}